@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Operations recorded in the audit trail.
+const (
+	OpCreate  = "create"
+	OpEdit    = "edit"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+	OpPurge   = "purge"
+	//OpRestoreBackup replaces every user with those in a backup, it's recorded once without a user or changes.
+	OpRestoreBackup = "restore-backup"
+)
+
+//Entry is a single change to a user record.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Operation string    `json:"operation"`
+	UserID    int       `json:"userId"`
+	RequestID string    `json:"requestId,omitempty"`
+	Changes   []Change  `json:"changes"`
+}
+
+//Change is the before and after value of a single field.
+type Change struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+//Filter selects audit entries. Zero values match everything.
+type Filter struct {
+	Actor  string
+	UserID int
+	Since  time.Time
+	Until  time.Time
+}
+
+//Matches reports whether e is selected by the filter.
+func (f Filter) Matches(e Entry) bool {
+	if f.Actor != "" && f.Actor != e.Actor {
+		return false
+	}
+	if f.UserID != 0 && f.UserID != e.UserID {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+//Sink stores audit entries and retrieves them in the order they were recorded.
+type Sink interface {
+	Record(ctx context.Context, e Entry) error
+	Query(ctx context.Context, f Filter) ([]Entry, error)
+}
+
+//bookkeepingFields change on every edit and duplicate what the entry itself records (time and actor), so they aren't diffed.
+var bookkeepingFields = map[string]struct{}{"updatedAt": {}, "updatedBy": {}}
+
+//Diff returns the fields that differ between before and after, named by their JSON names.
+//Map fields (such as custom attributes) are compared key by key.
+func Diff(before, after model.User) []Change {
+	changes := make([]Change, 0)
+	bv, av := reflect.ValueOf(before), reflect.ValueOf(after)
+	t := bv.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := jsonName(f)
+		if _, skip := bookkeepingFields[name]; skip || name == "-" {
+			continue
+		}
+
+		if f.Type.Kind() == reflect.Map {
+			changes = append(changes, diffMap(name, bv.Field(i), av.Field(i))...)
+			continue
+		}
+
+		b, a := formatValue(bv.Field(i)), formatValue(av.Field(i))
+		if b != a {
+			changes = append(changes, Change{Field: name, Before: b, After: a})
+		}
+	}
+	return changes
+}
+
+func diffMap(name string, before, after reflect.Value) []Change {
+	keys := make(map[string]struct{})
+	for _, k := range before.MapKeys() {
+		keys[fmt.Sprint(k.Interface())] = struct{}{}
+	}
+	for _, k := range after.MapKeys() {
+		keys[fmt.Sprint(k.Interface())] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	changes := make([]Change, 0)
+	for _, k := range sorted {
+		b, a := formatValue(mapIndex(before, k)), formatValue(mapIndex(after, k))
+		if b != a {
+			changes = append(changes, Change{Field: name + "." + k, Before: b, After: a})
+		}
+	}
+	return changes
+}
+
+func mapIndex(m reflect.Value, key string) reflect.Value {
+	if m.IsNil() {
+		return reflect.Value{}
+	}
+	return m.MapIndex(reflect.ValueOf(key).Convert(m.Type().Key()))
+}
+
+//formatValue renders a field value for the audit trail. Zero values (including zero times and nil pointers) are empty strings.
+func formatValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	if v.IsZero() {
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func jsonName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "" {
+		return f.Name
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
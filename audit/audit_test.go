@@ -0,0 +1,250 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/sensitivity"
+)
+
+//mapStore is a minimal in-memory UserDataStore for exercising the audit decorator.
+type mapStore struct {
+	users map[int]model.User
+}
+
+func (m *mapStore) list(deleted bool) []model.User {
+	list := make([]model.User, 0, len(m.users))
+	for _, u := range m.users {
+		if u.IsDeleted() == deleted {
+			list = append(list, u)
+		}
+	}
+	return list
+}
+
+func (m *mapStore) GetAll(ctx context.Context) ([]model.User, error) {
+	return m.list(false), nil
+}
+
+func (m *mapStore) GetDeleted(ctx context.Context) ([]model.User, error) {
+	return m.list(true), nil
+}
+
+func (m *mapStore) Create(ctx context.Context, u *model.User) error {
+	u.ID = len(m.users) + 1
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *mapStore) Edit(ctx context.Context, u model.User, id int) error {
+	saved := m.users[id]
+	if u.Email != "" {
+		saved.Email = u.Email
+	}
+	m.users[id] = saved
+	return nil
+}
+
+func (m *mapStore) Delete(ctx context.Context, id int) error {
+	saved := m.users[id]
+	now := time.Now()
+	saved.DeletedAt = &now
+	m.users[id] = saved
+	return nil
+}
+
+func (m *mapStore) Restore(ctx context.Context, id int) error {
+	saved := m.users[id]
+	saved.DeletedAt = nil
+	m.users[id] = saved
+	return nil
+}
+
+func (m *mapStore) Purge(ctx context.Context, deletedBefore time.Time) ([]model.User, error) {
+	purged := make([]model.User, 0)
+	for id, u := range m.users {
+		if u.IsDeleted() && u.DeletedAt.Before(deletedBefore) {
+			purged = append(purged, u)
+			delete(m.users, id)
+		}
+	}
+	return purged, nil
+}
+
+func (m *mapStore) Reports(ctx context.Context, id int, transitive bool) ([]model.User, error) {
+	return model.FindReports(m.list(false), id, transitive)
+}
+
+func (m *mapStore) Chain(ctx context.Context, id int) ([]model.User, error) {
+	return model.ManagementChain(m.list(false), id)
+}
+
+func (m *mapStore) Search(ctx context.Context, query string) ([]model.User, error) {
+	return model.SearchUsers(m.list(false), query), nil
+}
+
+func TestDiff(t *testing.T) {
+	before := model.User{ID: 1, FirstName: "Ann", Email: "ann@a.com", Organization: "sales"}
+	after := model.User{ID: 1, FirstName: "Ann", Email: "ann@b.com", Organization: "sales"}
+
+	changes := Diff(before, after)
+	if len(changes) != 1 {
+		t.Fatalf("got %v changes want 1: %v", len(changes), changes)
+	}
+	want := Change{Field: "email", Before: "ann@a.com", After: "ann@b.com"}
+	if changes[0] != want {
+		t.Errorf("got %v want %v", changes[0], want)
+	}
+
+	if created := Diff(model.User{}, after); len(created) != 4 {
+		t.Errorf("a new user should have a change for every set field, got %v", created)
+	}
+}
+
+func TestStoreRecordsChanges(t *testing.T) {
+	sink := &MemorySink{}
+	store := NewStore(&mapStore{users: make(map[int]model.User)}, sink, nil)
+	ctx := model.WithActor(context.Background(), "admin")
+
+	u := model.User{FirstName: "Ann", LastName: "Lee", Email: "ann@a.com", Organization: "sales"}
+	if err := store.Create(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Edit(ctx, model.User{Email: "ann@b.com"}, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(model.WithActor(context.Background(), "other"), u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Restore(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	store.Delete(ctx, u.ID)
+	if purged, _ := store.Purge(ctx, time.Now().Add(time.Second)); len(purged) != 1 {
+		t.Fatalf("got %v purged users want 1", len(purged))
+	}
+
+	entries, _ := sink.Query(context.Background(), Filter{UserID: u.ID})
+	if len(entries) != 6 {
+		t.Fatalf("got %v entries want 6", len(entries))
+	}
+	ops := []string{OpCreate, OpEdit, OpDelete, OpRestore, OpDelete, OpPurge}
+	for i, e := range entries {
+		if e.Operation != ops[i] {
+			t.Errorf("entry %v: got operation %v want %v", i, e.Operation, ops[i])
+		}
+	}
+	if edit := entries[1]; edit.Actor != "admin" || len(edit.Changes) != 1 || edit.Changes[0].After != "ann@b.com" {
+		t.Errorf("unexpected edit entry %+v", edit)
+	}
+
+	byActor, _ := sink.Query(context.Background(), Filter{Actor: "other"})
+	if len(byActor) != 1 || byActor[0].Operation != OpDelete {
+		t.Errorf("actor filter returned %v", byActor)
+	}
+	if del := byActor[0]; len(del.Changes) != 1 || del.Changes[0].Field != "deletedAt" {
+		t.Errorf("a soft delete should only change deletedAt, got %v", del.Changes)
+	}
+}
+
+//gateStore holds its first edit until release is closed, so a second edit can be attempted while it's in progress.
+type gateStore struct {
+	*mapStore
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (g *gateStore) Edit(ctx context.Context, u model.User, id int) error {
+	first := false
+	g.once.Do(func() { first = true })
+	if first {
+		close(g.entered)
+		<-g.release
+	}
+	return g.mapStore.Edit(ctx, u, id)
+}
+
+func TestStoreSerializesEdits(t *testing.T) {
+	sink := &MemorySink{}
+	gate := &gateStore{mapStore: &mapStore{users: map[int]model.User{1: {ID: 1, Email: "ann@a.com"}}}, entered: make(chan struct{}), release: make(chan struct{})}
+	store := NewStore(gate, sink, nil)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		store.Edit(ctx, model.User{Email: "ann@b.com"}, 1)
+	}()
+	<-gate.entered
+	go func() {
+		defer wg.Done()
+		store.Edit(ctx, model.User{Email: "ann@c.com"}, 1)
+	}()
+	//give the second edit time to read the user, were it not held back until the first is recorded.
+	time.Sleep(20 * time.Millisecond)
+	close(gate.release)
+	wg.Wait()
+
+	entries, _ := sink.Query(ctx, Filter{})
+	if len(entries) != 2 {
+		t.Fatalf("got %v entries want 2", len(entries))
+	}
+	first, second := entries[0].Changes[0], entries[1].Changes[0]
+	if first.Before != "ann@a.com" || first.After != "ann@b.com" || second.Before != "ann@b.com" || second.After != "ann@c.com" {
+		t.Errorf("each entry should hold its own edit, got %+v then %+v", first, second)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &FileSink{Path: filepath.Join(dir, "audit.jsonl")}
+	ctx := context.Background()
+
+	if entries, err := sink.Query(ctx, Filter{}); err != nil || len(entries) != 0 {
+		t.Errorf("missing file should have no entries, got %v, %v", entries, err)
+	}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		e := Entry{Time: start.Add(time.Duration(i) * time.Hour), Actor: "admin", Operation: OpEdit, UserID: i + 1}
+		if err := sink.Record(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := sink.Query(ctx, Filter{Since: start.Add(30 * time.Minute), Until: start.Add(90 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].UserID != 2 {
+		t.Errorf("time range filter returned %v", entries)
+	}
+
+	//the values of sensitive fields are redacted, the file isn't encrypted.
+	sink.Sensitivity = sensitivity.Default()
+	e := Entry{Time: start, Actor: "admin", Operation: OpEdit, UserID: 4, Changes: []Change{{Field: "email", Before: "old@email.com", After: "new@email.com"}}}
+	if err := sink.Record(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(sink.Path)
+	if strings.Contains(string(data), "@email.com") {
+		t.Errorf("the email should be redacted, got %s", data)
+	}
+	if entries, _ := sink.Query(ctx, Filter{UserID: 4}); len(entries) != 1 || entries[0].Changes[0].After != sensitivity.Redacted {
+		t.Errorf("got %+v want the email change redacted", entries)
+	}
+}
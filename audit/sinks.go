@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/sensitivity"
+)
+
+//FileSink appends audit entries to a file as JSON lines. The file isn't encrypted, so the values of the fields
+//Sensitivity classifies as sensitive are redacted before they're written.
+type FileSink struct {
+	Path string
+	//Sensitivity, if nil, leaves every value as it is.
+	Sensitivity *sensitivity.Policy
+
+	mu sync.Mutex
+}
+
+//Record appends e to the file, creating it if it doesn't exist.
+func (s *FileSink) Record(ctx context.Context, e Entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.Sensitivity != nil {
+		changes := make([]Change, len(e.Changes))
+		for i, c := range e.Changes {
+			changes[i] = Change{Field: c.Field, Before: s.Sensitivity.Redact(c.Field, c.Before), After: s.Sensitivity.Redact(c.Field, c.After)}
+		}
+		e.Changes = changes
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//Query reads the file and returns the entries matching f.
+func (s *FileSink) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		if f.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+//DatastoreSink keeps audit entries in the same database as the users, through a datastore that implements
+//model.AuditLog.
+type DatastoreSink struct {
+	DB model.AuditLog
+}
+
+//Record appends e to the datastore's audit trail.
+func (s *DatastoreSink) Record(ctx context.Context, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.DB.AppendAudit(ctx, data)
+}
+
+//Query reads the datastore's audit trail and returns the entries matching f.
+func (s *DatastoreSink) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	records, err := s.DB.ReadAudit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0)
+	for _, r := range records {
+		var e Entry
+		if err := json.Unmarshal(r, &e); err != nil {
+			return nil, err
+		}
+		if f.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+//MemorySink keeps audit entries in memory, useful for tests and throwaway environments.
+type MemorySink struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+//Record stores e.
+func (s *MemorySink) Record(ctx context.Context, e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+//Query returns the stored entries matching f.
+func (s *MemorySink) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]Entry, 0)
+	for _, e := range s.entries {
+		if f.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Store is a UserDataStore that records every successful change made through it to an audit sink.
+//A change that succeeds but fails to be recorded is logged rather than reported, since the change can't be undone.
+//Changes made through the store are serialized, so the values read before and after a change are those of that
+//change alone.
+type Store struct {
+	Next     model.UserDataStore
+	Sink     Sink
+	ErrorLog *log.Logger
+
+	//mu is held from reading the values before a change until the change is recorded.
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+//NewStore wraps next so changes made through it are recorded to sink.
+func NewStore(next model.UserDataStore, sink Sink, errorLog *log.Logger) *Store {
+	return &Store{Next: next, Sink: sink, ErrorLog: errorLog, now: time.Now}
+}
+
+//GetAll retrieves all saved users.
+func (s *Store) GetAll(ctx context.Context) ([]model.User, error) {
+	return s.Next.GetAll(ctx)
+}
+
+//GetDeleted retrieves all deleted users.
+func (s *Store) GetDeleted(ctx context.Context) ([]model.User, error) {
+	return s.Next.GetDeleted(ctx)
+}
+
+//Create creates the user, then records the new values.
+func (s *Store) Create(ctx context.Context, u *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Next.Create(ctx, u); err != nil {
+		return err
+	}
+	s.record(ctx, OpCreate, u.ID, model.User{}, *u)
+	return nil
+}
+
+//Edit modifies the user, then records which fields changed.
+func (s *Store) Edit(ctx context.Context, u model.User, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.Next.Edit(ctx, u, id); err != nil {
+		return err
+	}
+
+	//read back the stored result, the datastore decides how a partial edit is merged.
+	after, err := s.find(model.Detach(ctx), id)
+	if err != nil {
+		s.logf("audit: could not read user %v after edit: %v", id, err)
+		after = before
+	}
+	s.record(ctx, OpEdit, id, before, after)
+	return nil
+}
+
+//Delete deletes the user, then records the deletion.
+func (s *Store) Delete(ctx context.Context, id int) error {
+	return s.changeDeletion(ctx, OpDelete, id, s.Next.Delete)
+}
+
+//Restore restores the deleted user, then records the restoration.
+func (s *Store) Restore(ctx context.Context, id int) error {
+	return s.changeDeletion(ctx, OpRestore, id, s.Next.Restore)
+}
+
+func (s *Store) changeDeletion(ctx context.Context, op string, id int, change func(context.Context, int) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before, err := s.findAny(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := change(ctx, id); err != nil {
+		return err
+	}
+
+	after, err := s.findAny(model.Detach(ctx), id)
+	if err != nil {
+		s.logf("audit: could not read user %v after %v: %v", id, op, err)
+		after = before
+	}
+	s.record(ctx, op, id, before, after)
+	return nil
+}
+
+//Purge permanently removes users deleted before the given time, then records the values each of them had.
+func (s *Store) Purge(ctx context.Context, deletedBefore time.Time) ([]model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged, err := s.Next.Purge(ctx, deletedBefore)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range purged {
+		s.record(ctx, OpPurge, u.ID, u, model.User{})
+	}
+	return purged, nil
+}
+
+//Backup writes a backup of the users to w, if the next datastore supports backups.
+func (s *Store) Backup(ctx context.Context, w io.Writer) error {
+	return backup.Backup(ctx, s.Next, w)
+}
+
+//RestoreBackup replaces every user with those in the backup read from r, then records it as a single
+//OpRestoreBackup entry.
+func (s *Store) RestoreBackup(ctx context.Context, r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := backup.Restore(ctx, s.Next, r); err != nil {
+		return err
+	}
+	s.record(ctx, OpRestoreBackup, 0, model.User{}, model.User{})
+	return nil
+}
+
+//Reports retrieves the users below the given user.
+func (s *Store) Reports(ctx context.Context, id int, transitive bool) ([]model.User, error) {
+	return s.Next.Reports(ctx, id, transitive)
+}
+
+//Chain retrieves the managers above the given user.
+func (s *Store) Chain(ctx context.Context, id int) ([]model.User, error) {
+	return s.Next.Chain(ctx, id)
+}
+
+//Search retrieves the users matching query.
+func (s *Store) Search(ctx context.Context, query string) ([]model.User, error) {
+	return s.Next.Search(ctx, query)
+}
+
+func (s *Store) record(ctx context.Context, op string, id int, before, after model.User) {
+	e := Entry{
+		Time:      s.now().UTC(),
+		Actor:     model.ActorFromContext(ctx),
+		Operation: op,
+		UserID:    id,
+		RequestID: model.RequestIDFromContext(ctx),
+		Changes:   Diff(before, after),
+	}
+	//the change has been made, so record it even if the request has since been cancelled.
+	if err := s.Sink.Record(model.Detach(ctx), e); err != nil {
+		s.logf("audit: could not record %v of user %v by %q: %v", op, id, e.Actor, err)
+	}
+}
+
+func (s *Store) find(ctx context.Context, id int) (model.User, error) {
+	users, err := s.Next.GetAll(ctx)
+	if err != nil {
+		return model.User{}, err
+	}
+	for _, u := range users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return model.User{}, errors.New(model.CouldNotFind)
+}
+
+//findAny looks for the user among both current and deleted users.
+func (s *Store) findAny(ctx context.Context, id int) (model.User, error) {
+	u, err := s.find(ctx, id)
+	if err == nil || err.Error() != model.CouldNotFind {
+		return u, err
+	}
+
+	deleted, err := s.Next.GetDeleted(ctx)
+	if err != nil {
+		return model.User{}, err
+	}
+	for _, u := range deleted {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return model.User{}, errors.New(model.CouldNotFind)
+}
+
+func (s *Store) logf(format string, v ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, v...)
+	}
+}
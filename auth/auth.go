@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//Authentication error messages.
+const (
+	Unauthorized  = "Authentication is required to access this resource."
+	InvalidAPIKey = "The API key provided is not valid."
+	InvalidToken  = "The bearer token provided is not valid."
+)
+
+//APIKeyHeader is the header API keys are sent in. Keys may also be sent as "Authorization: ApiKey <key>".
+const APIKeyHeader = "X-API-Key"
+
+//ErrNoCredentials is returned when a request carries neither an API key nor a bearer token.
+var ErrNoCredentials = errors.New("no credentials provided")
+
+//Config is the authentication configuration file format.
+type Config struct {
+	Realm    string   `json:"realm"`
+	APIKeys  []APIKey `json:"apiKeys"`
+	JWKSFile string   `json:"jwksFile"`
+	Issuer   string   `json:"issuer"`
+	Audience string   `json:"audience"`
+}
+
+//APIKey is a static key and the identity it grants. Only the key's SHA-256 hash (hex encoded, see HashAPIKey) is stored.
+type APIKey struct {
+	Name         string   `json:"name"`
+	Hash         string   `json:"hash"`
+	Subject      string   `json:"subject"`
+	Organization string   `json:"organization"`
+	Roles        []string `json:"roles"`
+}
+
+//SessionLookup finds the identity behind a browser session, typically from a cookie set after an SSO login.
+type SessionLookup interface {
+	Lookup(r *http.Request) (Identity, bool)
+}
+
+//Authenticator identifies callers from their API key, bearer token or browser session.
+//If LoginURL is set, unauthenticated browser page requests are redirected there instead of receiving a 401.
+type Authenticator struct {
+	Realm    string
+	APIKeys  []APIKey
+	Verifier *TokenVerifier
+	Sessions SessionLookup
+	LoginURL string
+}
+
+//HashAPIKey returns the hex encoded SHA-256 hash of key, the form API keys are stored in.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+//LoadConfig reads the authentication configuration file at path and builds an Authenticator from it.
+func LoadConfig(path string) (*Authenticator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConfig: %w", err)
+	}
+
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("LoadConfig: %w", err)
+	}
+	return New(c)
+}
+
+//New builds an Authenticator from c, loading its JWKS file if one is configured.
+func New(c Config) (*Authenticator, error) {
+	a := &Authenticator{Realm: c.Realm, APIKeys: c.APIKeys}
+	if a.Realm == "" {
+		a.Realm = "users"
+	}
+
+	for _, k := range a.APIKeys {
+		if b, err := hex.DecodeString(k.Hash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("New: API key %q does not have a valid SHA-256 hash", k.Name)
+		}
+	}
+
+	if c.JWKSFile != "" {
+		keys, err := LoadKeySet(c.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.Verifier = &TokenVerifier{Keys: keys, Issuer: c.Issuer, Audience: c.Audience}
+	}
+	return a, nil
+}
+
+//Authenticate identifies the caller of r.
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	if key := apiKeyFromRequest(r); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+	if token := bearerFromRequest(r); token != "" {
+		return a.authenticateBearer(token)
+	}
+	if a.Sessions != nil {
+		if id, ok := a.Sessions.Lookup(r); ok {
+			return id, nil
+		}
+	}
+	return Identity{}, ErrNoCredentials
+}
+
+func (a *Authenticator) authenticateAPIKey(key string) (Identity, error) {
+	presented, _ := hex.DecodeString(HashAPIKey(key))
+
+	//compare against every key so the time taken doesn't reveal which keys exist.
+	var match *APIKey
+	for i := range a.APIKeys {
+		stored, _ := hex.DecodeString(a.APIKeys[i].Hash)
+		if subtle.ConstantTimeCompare(presented, stored) == 1 {
+			match = &a.APIKeys[i]
+		}
+	}
+	if match == nil {
+		return Identity{}, errors.New(InvalidAPIKey)
+	}
+
+	subject := match.Subject
+	if subject == "" {
+		subject = match.Name
+	}
+	return Identity{Subject: subject, Organization: match.Organization, Roles: match.Roles, Method: MethodAPIKey}, nil
+}
+
+func (a *Authenticator) authenticateBearer(token string) (Identity, error) {
+	if a.Verifier == nil {
+		return Identity{}, errors.New(InvalidToken)
+	}
+
+	claims, err := a.Verifier.Verify(token)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%v: %w", InvalidToken, err)
+	}
+
+	subject := claims.Subject
+	if subject == "" {
+		subject = claims.Email
+	}
+	return Identity{Subject: subject, Organization: claims.Organization, Roles: claims.Roles, Method: MethodBearer}, nil
+}
+
+//Wrap returns a handler that authenticates each request before passing it to next with the caller's
+//identity attached to the request context. Unauthenticated requests receive a 401 response.
+func (a *Authenticator) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.Authenticate(r)
+		if err == ErrNoCredentials && a.LoginURL != "" && wantsPage(r) {
+			http.Redirect(w, r, a.LoginURL+"?returnTo="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		if err != nil {
+			a.unauthorized(w, r, err)
+			return
+		}
+		next(w, r.WithContext(WithIdentity(r.Context(), id)))
+	}
+}
+
+//unauthorized writes a 401 response with a WWW-Authenticate challenge and the standard error body.
+func (a *Authenticator) unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	challenge := fmt.Sprintf("Bearer realm=%q", a.Realm)
+	msg := Unauthorized
+	if err != ErrNoCredentials {
+		msg = err.Error()
+		if bearerFromRequest(r) != "" {
+			challenge += `, error="invalid_token"`
+		}
+	}
+	w.Header().Add("WWW-Authenticate", challenge)
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf("ApiKey realm=%q", a.Realm))
+
+	body, _ := json.Marshal(validation.UserErrors{Message: msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(body)
+}
+
+//wantsPage reports whether r looks like a browser navigating to a page rather than an API call.
+func wantsPage(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	return credentialsWithScheme(r, "ApiKey")
+}
+
+func bearerFromRequest(r *http.Request) string {
+	return credentialsWithScheme(r, "Bearer")
+}
+
+//credentialsWithScheme returns the Authorization header's credentials if the header uses the given scheme.
+func credentialsWithScheme(r *http.Request, scheme string) string {
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(scheme)+1 || !strings.EqualFold(h[:len(scheme)], scheme) || h[len(scheme)] != ' ' {
+		return ""
+	}
+	return strings.TrimSpace(h[len(scheme)+1:])
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+const (
+	testAPIKey = "s3cret-key"
+	testSecret = "hmac-test-secret"
+	testIssuer = "https://idp.example.com"
+)
+
+var testRSAKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func testKeySet(t *testing.T) *KeySet {
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"oct","kid":"hs","k":%q},
+		{"kty":"RSA","kid":"rs","use":"sig","n":%q,"e":%q}]}`,
+		b64([]byte(testSecret)), b64(testRSAKey.N.Bytes()), b64(big.NewInt(int64(testRSAKey.E)).Bytes()))
+	ks, err := ParseKeySet([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func signToken(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, []byte(testSecret))
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS256":
+		sum := sha256.Sum256([]byte(signed))
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, testRSAKey, crypto.SHA256, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + b64(sig)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "jdoe",
+		"iss":   testIssuer,
+		"aud":   []string{"users-api"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"org":   "sales",
+		"roles": []string{"editor"},
+	}
+}
+
+func testAuthenticator(t *testing.T) *Authenticator {
+	return &Authenticator{
+		Realm:    "users",
+		APIKeys:  []APIKey{{Name: "ci", Hash: HashAPIKey(testAPIKey), Organization: "it", Roles: []string{"admin"}}},
+		Verifier: &TokenVerifier{Keys: testKeySet(t), Issuer: testIssuer, Audience: "users-api"},
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	a := testAuthenticator(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/", nil)
+	req.Header.Set(APIKeyHeader, testAPIKey)
+	id, err := a.Authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "ci" || id.Organization != "it" || id.Method != MethodAPIKey {
+		t.Errorf("unexpected identity %+v", id)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/users/", nil)
+	req.Header.Set("Authorization", "ApiKey wrong-key")
+	if _, err := a.Authenticate(req); err == nil {
+		t.Errorf("expected error for unknown API key, got none")
+	}
+}
+
+func TestBearerTokens(t *testing.T) {
+	a := testAuthenticator(t)
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAud := validClaims()
+	wrongAud["aud"] = "someone-else"
+
+	cases := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"HS256", signToken(t, "HS256", "hs", validClaims()), false},
+		{"RS256", signToken(t, "RS256", "rs", validClaims()), false},
+		{"expired", signToken(t, "HS256", "hs", expired), true},
+		{"wrong audience", signToken(t, "RS256", "rs", wrongAud), true},
+		{"unknown key", signToken(t, "RS256", "missing", validClaims()), true},
+		{"none alg", signToken(t, "none", "", validClaims()), true},
+		{"tampered", strings.Replace(signToken(t, "HS256", "hs", validClaims()), ".", ".e30", 1), true},
+		{"malformed", "not-a-token", true},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/users/", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		id, err := a.Authenticate(req)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected error, got none", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", c.name, err)
+			continue
+		}
+		if id.Subject != "jdoe" || id.Organization != "sales" || len(id.Roles) != 1 || id.Roles[0] != "editor" {
+			t.Errorf("%v: unexpected identity %+v", c.name, id)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	a := testAuthenticator(t)
+
+	var gotActor string
+	handler := a.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		gotActor = model.ActorFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v want %v", rec.Code, http.StatusUnauthorized)
+	}
+	if challenges := rec.Header()["Www-Authenticate"]; len(challenges) != 2 || !strings.HasPrefix(challenges[0], "Bearer") {
+		t.Errorf("unexpected WWW-Authenticate challenges %v", challenges)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/", nil)
+	req.Header.Set("Authorization", "Bearer garbage")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("WWW-Authenticate"); !strings.Contains(got, `error="invalid_token"`) {
+		t.Errorf("expected invalid_token challenge, got %v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/users/", nil)
+	req.Header.Set(APIKeyHeader, testAPIKey)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %v want %v", rec.Code, http.StatusOK)
+	}
+	if gotActor != "ci" {
+		t.Errorf("actor = %q, want %q", gotActor, "ci")
+	}
+}
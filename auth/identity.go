@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Authentication methods recorded on an Identity.
+const (
+	MethodAPIKey  = "apikey"
+	MethodBearer  = "bearer"
+	MethodSession = "session"
+)
+
+//Identity describes an authenticated caller.
+type Identity struct {
+	Subject      string   `json:"subject"`
+	Organization string   `json:"organization"`
+	Roles        []string `json:"roles"`
+	Method       string   `json:"method"`
+}
+
+type contextKey int
+
+const identityKey contextKey = 0
+
+//WithIdentity returns a copy of ctx carrying the caller's identity. The identity's subject is also
+//recorded as the acting user so datastores can see who is making a change.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	ctx = model.WithActor(ctx, id.Subject)
+	return context.WithValue(ctx, identityKey, id)
+}
+
+//FromContext returns the identity stored in ctx and whether there was one.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey).(Identity)
+	return id, ok
+}
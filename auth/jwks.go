@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+//jsonWebKey is a single entry of a JWKS document (RFC 7517). Only the fields needed for RSA and
+//symmetric (HMAC) keys are read.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+//KeySet holds the keys bearer tokens may be signed with, keyed on key ID.
+type KeySet struct {
+	rsaKeys  map[string]*rsa.PublicKey
+	hmacKeys map[string][]byte
+}
+
+//LoadKeySet reads a JWKS document from the file at path.
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadKeySet: %w", err)
+	}
+	return ParseKeySet(data)
+}
+
+//ParseKeySet parses a JWKS document. Keys that aren't meant for signatures or that use an unsupported key type are skipped.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("ParseKeySet: %w", err)
+	}
+
+	ks := &KeySet{rsaKeys: make(map[string]*rsa.PublicKey), hmacKeys: make(map[string][]byte)}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			pub, err := k.rsaPublicKey()
+			if err != nil {
+				return nil, fmt.Errorf("ParseKeySet: key %q: %w", k.Kid, err)
+			}
+			ks.rsaKeys[k.Kid] = pub
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("ParseKeySet: key %q: invalid symmetric key", k.Kid)
+			}
+			ks.hmacKeys[k.Kid] = secret
+		}
+	}
+	return ks, nil
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil || len(n) == 0 {
+		return nil, errors.New("invalid RSA modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("RSA exponent too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+//rsaKey returns the RSA key with the given ID. An empty ID is accepted if the set only has one RSA key.
+func (ks *KeySet) rsaKey(kid string) (*rsa.PublicKey, bool) {
+	if k, ok := ks.rsaKeys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(ks.rsaKeys) == 1 {
+		for _, k := range ks.rsaKeys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+//hmacKey returns the symmetric key with the given ID. An empty ID is accepted if the set only has one symmetric key.
+func (ks *KeySet) hmacKey(kid string) ([]byte, bool) {
+	if k, ok := ks.hmacKeys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(ks.hmacKeys) == 1 {
+		for _, k := range ks.hmacKeys {
+			return k, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash"
+	"strings"
+	"time"
+)
+
+//Token validation errors.
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrUnknownKey       = errors.New("token signed with an unknown key")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrWrongIssuer      = errors.New("token issued by an untrusted issuer")
+	ErrWrongAudience    = errors.New("token is not intended for this audience")
+)
+
+//clockSkew is how far token timestamps may disagree with the server's clock.
+const clockSkew = time.Minute
+
+//Claims are the JWT claims the server understands. Organization and Roles are private claims
+//("org" and "roles") the identity provider is expected to add.
+type Claims struct {
+	Subject      string   `json:"sub"`
+	Issuer       string   `json:"iss"`
+	Audience     audience `json:"aud"`
+	ExpiresAt    int64    `json:"exp"`
+	NotBefore    int64    `json:"nbf"`
+	IssuedAt     int64    `json:"iat"`
+	Nonce        string   `json:"nonce"`
+	Email        string   `json:"email"`
+	Organization string   `json:"org"`
+	Roles        []string `json:"roles"`
+}
+
+//audience is the "aud" claim, which may be a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//TokenVerifier checks the signature and standard claims of JWTs.
+type TokenVerifier struct {
+	Keys     *KeySet
+	Issuer   string
+	Audience string
+	now      func() time.Time
+}
+
+//Verify validates the token's signature against the key set and checks its issuer, audience and validity period.
+//The token's claims are returned if it is valid.
+func (v *TokenVerifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if err := v.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if err := v.checkClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (v *TokenVerifier) verifySignature(alg, kid, signed string, sig []byte) error {
+	if v.Keys == nil {
+		return ErrUnknownKey
+	}
+
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		secret, ok := v.Keys.hmacKey(kid)
+		if !ok {
+			return ErrUnknownKey
+		}
+		mac := hmac.New(hashFor(alg), secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case "RS256", "RS384", "RS512":
+		pub, ok := v.Keys.rsaKey(kid)
+		if !ok {
+			return ErrUnknownKey
+		}
+		h, cryptoHash := hashFor(alg)(), cryptoHashFor(alg)
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(pub, cryptoHash, h.Sum(nil), sig); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		//includes "none", which must never be accepted.
+		return ErrUnsupportedAlg
+	}
+}
+
+func (v *TokenVerifier) checkClaims(c *Claims) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return ErrWrongIssuer
+	}
+	if v.Audience != "" && !c.Audience.contains(v.Audience) {
+		return ErrWrongAudience
+	}
+	return nil
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func hashFor(alg string) func() hash.Hash {
+	switch alg[2:] {
+	case "384":
+		return sha512.New384
+	case "512":
+		return sha512.New
+	default:
+		return sha256.New
+	}
+}
+
+func cryptoHashFor(alg string) crypto.Hash {
+	switch alg[2:] {
+	case "384":
+		return crypto.SHA384
+	case "512":
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
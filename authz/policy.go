@@ -0,0 +1,160 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/auth"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Actions that can be granted to a role.
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionEdit   = "edit"
+	ActionDelete = "delete"
+	ActionAudit  = "audit"
+	//ActionManageSchema allows changing the custom attribute schema.
+	ActionManageSchema = "schema"
+	//ActionManageOrganizations allows creating, renaming and deleting organizations.
+	ActionManageOrganizations = "organizations"
+	//ActionManageWebhooks allows registering, changing and removing webhooks and viewing their deliveries.
+	ActionManageWebhooks = "webhooks"
+	//ActionBackup allows downloading a backup of every user.
+	ActionBackup = "backup"
+	//ActionUnmask allows seeing the sensitive fields of users unmasked, see the sensitivity package.
+	ActionUnmask = "unmask"
+)
+
+//Scopes an action can be granted with. ScopeAll applies to every user, ScopeOrganization
+//only to users in the same organization as the caller.
+const (
+	ScopeAll          = "all"
+	ScopeOrganization = "organization"
+)
+
+//ErrForbidden is returned when the caller isn't allowed to perform an action.
+var ErrForbidden = errors.New("forbidden")
+
+//Role maps each action the role is granted to the scope it's granted with.
+type Role map[string]string
+
+//Policy is the set of roles callers can hold, keyed on role name.
+type Policy struct {
+	Roles map[string]Role `json:"roles"`
+}
+
+//DefaultPolicy returns the built-in policy: viewers may read users in their organization with their sensitive fields
+//masked, editors may also see them unmasked and create and edit users in their organization, and admins may do
+//anything to any user, including reading the audit trail and managing the custom attribute schema, organizations
+//and webhooks, and taking backups.
+func DefaultPolicy() *Policy {
+	return &Policy{Roles: map[string]Role{
+		"viewer": {ActionRead: ScopeOrganization},
+		"editor": {ActionRead: ScopeOrganization, ActionUnmask: ScopeOrganization, ActionCreate: ScopeOrganization, ActionEdit: ScopeOrganization},
+		"admin":  {ActionRead: ScopeAll, ActionCreate: ScopeAll, ActionEdit: ScopeAll, ActionDelete: ScopeAll, ActionAudit: ScopeAll, ActionManageSchema: ScopeAll, ActionManageOrganizations: ScopeAll, ActionManageWebhooks: ScopeAll, ActionBackup: ScopeAll, ActionUnmask: ScopeAll},
+	}}
+}
+
+//LoadPolicy reads a policy file in the same JSON format as Policy.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadPolicy: %w", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("LoadPolicy: %w", err)
+	}
+
+	for name, role := range p.Roles {
+		for action, scope := range role {
+			if scope != ScopeAll && scope != ScopeOrganization {
+				return nil, fmt.Errorf("LoadPolicy: role %q has unknown scope %q for %v", name, scope, action)
+			}
+		}
+	}
+	return &p, nil
+}
+
+//Allowed reports whether the caller in ctx may perform action on at least some users.
+//A nil policy allows everything.
+func (p *Policy) Allowed(ctx context.Context, action string) bool {
+	if p == nil {
+		return true
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, scope := range p.scopes(id, action) {
+		if scope != "" {
+			return true
+		}
+	}
+	return false
+}
+
+//Authorize returns ErrForbidden unless the caller in ctx may perform action on a user belonging to org.
+//A nil policy allows everything.
+func (p *Policy) Authorize(ctx context.Context, action string, org string) error {
+	if p == nil {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return ErrForbidden
+	}
+	if !p.permits(id, action, org) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+//Filter returns the users the caller in ctx may read. A nil policy returns users unchanged.
+func (p *Policy) Filter(ctx context.Context, users []model.User) []model.User {
+	if p == nil {
+		return users
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return []model.User{}
+	}
+
+	visible := make([]model.User, 0, len(users))
+	for _, u := range users {
+		if p.permits(id, ActionRead, u.Organization) {
+			visible = append(visible, u)
+		}
+	}
+	return visible
+}
+
+func (p *Policy) permits(id auth.Identity, action string, org string) bool {
+	for _, scope := range p.scopes(id, action) {
+		switch scope {
+		case ScopeAll:
+			return true
+		case ScopeOrganization:
+			if id.Organization != "" && strings.EqualFold(id.Organization, org) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//scopes returns the scope each of the caller's roles grants for action (empty if the role doesn't grant it).
+func (p *Policy) scopes(id auth.Identity, action string) []string {
+	s := make([]string, 0, len(id.Roles))
+	for _, name := range id.Roles {
+		s = append(s, p.Roles[name][action])
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package authz
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nmalensek/go-user-form/auth"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+func asCaller(org string, roles ...string) context.Context {
+	return auth.WithIdentity(context.Background(), auth.Identity{Subject: "caller", Organization: org, Roles: roles})
+}
+
+func TestAuthorize(t *testing.T) {
+	p := DefaultPolicy()
+
+	cases := []struct {
+		name   string
+		ctx    context.Context
+		action string
+		org    string
+		want   error
+	}{
+		{"viewer reads own org", asCaller("sales", "viewer"), ActionRead, "sales", nil},
+		{"viewer cannot create", asCaller("sales", "viewer"), ActionCreate, "sales", ErrForbidden},
+		{"editor edits own org", asCaller("sales", "editor"), ActionEdit, "Sales", nil},
+		{"editor cannot edit other org", asCaller("sales", "editor"), ActionEdit, "marketing", ErrForbidden},
+		{"editor cannot delete", asCaller("sales", "editor"), ActionDelete, "sales", ErrForbidden},
+		{"admin deletes anyone", asCaller("it", "admin"), ActionDelete, "sales", nil},
+		{"multiple roles combine", asCaller("sales", "viewer", "admin"), ActionDelete, "marketing", nil},
+		{"unknown role", asCaller("sales", "superuser"), ActionRead, "sales", ErrForbidden},
+		{"anonymous", context.Background(), ActionRead, "sales", ErrForbidden},
+	}
+
+	for _, c := range cases {
+		if got := p.Authorize(c.ctx, c.action, c.org); got != c.want {
+			t.Errorf("%v: got %v want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestNilPolicyAllowsAll(t *testing.T) {
+	var p *Policy
+	if err := p.Authorize(context.Background(), ActionDelete, "sales"); err != nil {
+		t.Errorf("nil policy should allow everything, got %v", err)
+	}
+	if !p.Allowed(context.Background(), ActionDelete) {
+		t.Errorf("nil policy should allow everything")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	users := []model.User{{ID: 1, Organization: "sales"}, {ID: 2, Organization: "marketing"}, {ID: 3, Organization: "SALES"}}
+	p := DefaultPolicy()
+
+	scoped := p.Filter(asCaller("sales", "viewer"), users)
+	if len(scoped) != 2 || scoped[0].ID != 1 || scoped[1].ID != 3 {
+		t.Errorf("scoped caller got %v, want users 1 and 3", scoped)
+	}
+
+	if all := p.Filter(asCaller("it", "admin"), users); len(all) != 3 {
+		t.Errorf("admin got %v users, want 3", len(all))
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	f, err := ioutil.TempFile("", "policy*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"roles":{"auditor":{"read":"all"},"bad":{"read":"everywhere"}}}`)
+	f.Close()
+
+	if _, err := LoadPolicy(f.Name()); err == nil {
+		t.Errorf("expected unknown scope to be rejected")
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"roles":{"auditor":{"read":"all"}}}`), 0600)
+	p, err := LoadPolicy(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Authorize(asCaller("", "auditor"), ActionRead, "sales"); err != nil {
+		t.Errorf("auditor should be able to read, got %v", err)
+	}
+}
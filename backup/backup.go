@@ -0,0 +1,136 @@
+//Package backup defines the archive format for backups of the stored users and keeps a directory of scheduled
+//backups. An archive is a gzip-compressed JSON document naming its format and version, so it can be read by other
+//tools and by later versions of the server. The archives of an encrypted datastore are sealed with the same keys
+//(see the encryption package), so a backup holds the users no less safely than the datastore.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Format identifies the archive format.
+const Format = "go-user-form-backup"
+
+//Version is the version of the archive format written by this package. Archives of later versions are refused.
+const Version = 1
+
+//Archive errors.
+var (
+	ErrNotArchive      = errors.New("backup: not a user backup archive")
+	ErrChecksum        = errors.New("backup: the archive is damaged, its users don't match its checksum")
+	ErrKeysMissing     = errors.New("backup: the archive is encrypted, but no encryption keys were given")
+	ErrUnsupported     = errors.New("backup: the datastore doesn't support backups")
+	ErrNoBackupInRange = errors.New("backup: no backup was taken at or before the requested time")
+)
+
+//Archive is the content of a backup: every user, deleted or not, ordered by ID.
+type Archive struct {
+	Format    string       `json:"format"`
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Users     []model.User `json:"users"`
+	//Checksum is the hex SHA-256 of the JSON encoding of Users.
+	Checksum string `json:"checksum"`
+}
+
+//Write writes an archive of users taken at now to w, sealed with keys unless they're nil.
+func Write(w io.Writer, users []model.User, now time.Time, keys *encryption.Keyring) error {
+	sum, err := checksum(users)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	a := Archive{Format: Format, Version: Version, CreatedAt: now.UTC(), Users: users, Checksum: sum}
+	if err := json.NewEncoder(zw).Encode(a); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if keys != nil {
+		if data, err = keys.Seal(data); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+//Read reads an archive from r and checks its format, version and checksum. A sealed archive is opened with keys,
+//an archive that isn't sealed is read whether keys are given or not.
+func Read(r io.Reader, keys *encryption.Keyring) (Archive, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Archive{}, err
+	}
+	if encryption.IsSealed(data) {
+		if keys == nil {
+			return Archive{}, ErrKeysMissing
+		}
+		if data, err = keys.Open(data); err != nil {
+			return Archive{}, err
+		}
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return Archive{}, ErrNotArchive
+	}
+	defer zr.Close()
+
+	var a Archive
+	if err := json.NewDecoder(zr).Decode(&a); err != nil || a.Format != Format {
+		return Archive{}, ErrNotArchive
+	}
+	if a.Version > Version {
+		return Archive{}, fmt.Errorf("backup: the archive is version %v, this server reads up to version %v", a.Version, Version)
+	}
+	if sum, err := checksum(a.Users); err != nil || sum != a.Checksum {
+		return Archive{}, ErrChecksum
+	}
+	return a, nil
+}
+
+func checksum(users []model.User) (string, error) {
+	data, err := json.Marshal(users)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+//Backup writes a backup of db to w, if db supports backups.
+func Backup(ctx context.Context, db model.UserDataStore, w io.Writer) error {
+	b, ok := db.(model.Backuper)
+	if !ok {
+		return ErrUnsupported
+	}
+	return b.Backup(ctx, w)
+}
+
+//Restore replaces the users in db with those of the archive in r, if db supports backups.
+func Restore(ctx context.Context, db model.UserDataStore, r io.Reader) error {
+	b, ok := db.(model.Backuper)
+	if !ok {
+		return ErrUnsupported
+	}
+	return b.RestoreBackup(ctx, r)
+}
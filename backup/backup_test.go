@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+var testUsers = []model.User{
+	{ID: 1, FirstName: "Ann", LastName: "Lee", Email: "ann@example.com", Organization: "sales"},
+	{ID: 3, FirstName: "Bob", LastName: "Ray", Email: "bob@example.com", Organization: "sales", ManagerID: 1, Attributes: map[string]string{"floor": "3"}},
+}
+
+//memoryStore backs up and restores users kept in memory.
+type memoryStore struct {
+	model.UserDataStore
+	users []model.User
+}
+
+func (m *memoryStore) Backup(ctx context.Context, w io.Writer) error {
+	return Write(w, m.users, time.Now(), nil)
+}
+
+func (m *memoryStore) RestoreBackup(ctx context.Context, r io.Reader) error {
+	a, err := Read(r, nil)
+	if err != nil {
+		return err
+	}
+	m.users = a.Users
+	return nil
+}
+
+func TestWriteRead(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	if err := Write(&buf, testUsers, now, nil); err != nil {
+		t.Fatal(err)
+	}
+	a, err := Read(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Format != Format || a.Version != Version || !a.CreatedAt.Equal(now) || !reflect.DeepEqual(a.Users, testUsers) {
+		t.Errorf("got %+v want the users written at %v", a, now)
+	}
+
+	if _, err := Read(bytes.NewReader([]byte(`{"users":[]}`)), nil); err != ErrNotArchive {
+		t.Errorf("got %v want %v", err, ErrNotArchive)
+	}
+
+	write := func(body string) []byte {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write([]byte(body))
+		zw.Close()
+		return b.Bytes()
+	}
+	if _, err := Read(bytes.NewReader(write(`{"format":"something-else","version":1}`)), nil); err != ErrNotArchive {
+		t.Errorf("got %v want %v", err, ErrNotArchive)
+	}
+	if _, err := Read(bytes.NewReader(write(`{"format":"go-user-form-backup","version":99}`)), nil); err == nil {
+		t.Errorf("an archive from a later version should be refused")
+	}
+	tampered := `{"format":"go-user-form-backup","version":1,"users":[{"id":1,"firstName":"Eve"}],"checksum":"00"}`
+	if _, err := Read(bytes.NewReader(write(tampered)), nil); err != ErrChecksum {
+		t.Errorf("got %v want %v", err, ErrChecksum)
+	}
+}
+
+func TestSealed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldKey, _ := encryption.GenerateKey()
+	newKey, _ := encryption.GenerateKey()
+	keys, _ := encryption.NewKeyring("old", map[string][]byte{"old": oldKey})
+
+	var buf bytes.Buffer
+	if err := Write(&buf, testUsers, time.Now(), keys); err != nil {
+		t.Fatal(err)
+	}
+	if !encryption.IsSealed(buf.Bytes()) {
+		t.Fatal("the archive should be sealed")
+	}
+	if _, err := Read(bytes.NewReader(buf.Bytes()), nil); err != ErrKeysMissing {
+		t.Errorf("got %v want %v", err, ErrKeysMissing)
+	}
+	if a, err := Read(bytes.NewReader(buf.Bytes()), keys); err != nil || !reflect.DeepEqual(a.Users, testUsers) {
+		t.Errorf("got %+v, %v want the users", a, err)
+	}
+
+	//a sealed backup is re-wrapped with the new primary key and one that isn't sealed yet is sealed.
+	sealed := filepath.Join(dir, FileName(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ioutil.WriteFile(sealed, buf.Bytes(), 0600)
+	var plain bytes.Buffer
+	Write(&plain, testUsers, time.Now(), nil)
+	unsealed := filepath.Join(dir, FileName(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+	ioutil.WriteFile(unsealed, plain.Bytes(), 0600)
+
+	keys, _ = encryption.NewKeyring("new", map[string][]byte{"old": oldKey, "new": newKey})
+	if changed, err := RotateKey(dir, keys); err != nil || changed != 2 {
+		t.Fatalf("got %v, %v want both backups changed", changed, err)
+	}
+	retired, _ := encryption.NewKeyring("new", map[string][]byte{"new": newKey})
+	for _, path := range []string{sealed, unsealed} {
+		data, _ := ioutil.ReadFile(path)
+		if a, err := Read(bytes.NewReader(data), retired); err != nil || len(a.Users) != len(testUsers) {
+			t.Errorf("%v: got %+v, %v want it readable with the new key alone", filepath.Base(path), a, err)
+		}
+	}
+	if changed, _ := RotateKey(dir, keys); changed != 0 {
+		t.Errorf("got %v changed want none once every backup uses the primary key", changed)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	source, target := &memoryStore{users: testUsers}, &memoryStore{}
+	var buf bytes.Buffer
+	if err := Backup(ctx, source, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(ctx, target, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(target.users, testUsers) {
+		t.Errorf("got %+v want %+v", target.users, testUsers)
+	}
+
+	if err := Backup(ctx, struct{ model.UserDataStore }{}, &buf); err != ErrUnsupported {
+		t.Errorf("got %v want %v", err, ErrUnsupported)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a backup"), 0600)
+
+	store := &memoryStore{}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Schedule{DB: store, Dir: dir, Interval: time.Hour, Keep: 3, ErrorLog: log.New(ioutil.Discard, "", 0),
+		now: func() time.Time { return now }}
+	for i := 1; i <= 5; i++ {
+		store.users = testUsers[:i%2+1]
+		if _, err := s.Save(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Hour)
+	}
+
+	files, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var times []time.Time
+	for _, f := range files {
+		times = append(times, f.Time)
+	}
+	want := []time.Time{
+		time.Date(2020, 1, 1, 2, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 1, 3, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 1, 4, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(times, want) {
+		t.Errorf("got backups at %v want the 3 most recent %v", times, want)
+	}
+
+	//the backup at 03:00, of user 1 only, is the latest at 03:30.
+	f, err := Find(dir, time.Date(2020, 1, 1, 3, 30, 0, 0, time.UTC))
+	if err != nil || !f.Time.Equal(want[1]) {
+		t.Fatalf("got %+v, %v want the backup taken at %v", f, err, want[1])
+	}
+	r, _ := os.Open(f.Path)
+	defer r.Close()
+	a, err := Read(r, nil)
+	if err != nil || len(a.Users) != 1 {
+		t.Errorf("got %+v, %v want the backup of 1 user", a, err)
+	}
+	if _, err := Find(dir, time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)); err != ErrNoBackupInRange {
+		t.Errorf("got %v want %v", err, ErrNoBackupInRange)
+	}
+}
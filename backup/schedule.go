@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//File name parts of the backups in a directory, the time in the name is when the backup was taken.
+const (
+	filePrefix = "users-"
+	fileSuffix = ".backup.gz"
+	fileTime   = "20060102T150405.000000000Z"
+)
+
+//File is a backup saved in a directory.
+type File struct {
+	Path string
+	Time time.Time
+}
+
+//Schedule takes a backup of DB into Dir when it starts and every Interval after that, keeping the Keep most recent.
+type Schedule struct {
+	DB       model.UserDataStore
+	Dir      string
+	Interval time.Duration
+	Keep     int
+	ErrorLog *log.Logger
+
+	now func() time.Time
+}
+
+//Run takes backups until ctx is cancelled. Failures are logged and the next backup is still attempted.
+func (s *Schedule) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Save(ctx); err != nil {
+			s.ErrorLog.Printf("backup: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//Save takes a backup into the directory now and removes the backups past the Keep most recent.
+func (s *Schedule) Save(ctx context.Context) (File, error) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return File{}, err
+	}
+
+	taken := now().UTC()
+	f := File{Path: filepath.Join(s.Dir, FileName(taken)), Time: taken}
+	//written under a temporary name, so an interrupted backup isn't mistaken for a complete one.
+	tmp, err := ioutil.TempFile(s.Dir, "tmp-")
+	if err != nil {
+		return File{}, err
+	}
+	if err := Backup(ctx, s.DB, tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return File{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return File{}, err
+	}
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		os.Remove(tmp.Name())
+		return File{}, err
+	}
+	return f, Prune(s.Dir, s.Keep)
+}
+
+//FileName returns the name of a backup taken at t, List reads the time back from it.
+func FileName(t time.Time) string {
+	return filePrefix + t.UTC().Format(fileTime) + fileSuffix
+}
+
+//List returns the backups in dir, oldest first.
+func List(dir string) ([]File, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]File, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		t, err := time.Parse(fileTime, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		if err != nil {
+			continue
+		}
+		files = append(files, File{Path: filepath.Join(dir, name), Time: t})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+	return files, nil
+}
+
+//Find returns the most recent backup in dir taken at or before at, the point in time to restore to.
+func Find(dir string, at time.Time) (File, error) {
+	files, err := List(dir)
+	if err != nil {
+		return File{}, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		if !files[i].Time.After(at) {
+			return files[i], nil
+		}
+	}
+	return File{}, ErrNoBackupInRange
+}
+
+//RotateKey brings the encryption of the backups in dir up to date with keys, like the datastore's: backups that
+//aren't encrypted yet are encrypted, and the data key of those encrypted with an older master key is re-wrapped
+//with the primary key. It returns how many backups changed.
+func RotateKey(dir string, keys *encryption.Keyring) (int, error) {
+	files, err := List(dir)
+	if os.IsNotExist(err) {
+		//no backup has been taken yet.
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			return changed, err
+		}
+		var updated []byte
+		if encryption.IsSealed(data) {
+			var rewrapped bool
+			if updated, rewrapped, err = keys.Rewrap(data); err != nil {
+				return changed, fmt.Errorf("backup: %v: %w", filepath.Base(f.Path), err)
+			}
+			if !rewrapped {
+				continue
+			}
+		} else if updated, err = keys.Seal(data); err != nil {
+			return changed, err
+		}
+
+		//replaced through a temporary file, so an interrupted rotation doesn't damage the backup.
+		tmp := filepath.Join(dir, "tmp-"+filepath.Base(f.Path))
+		if err := ioutil.WriteFile(tmp, updated, 0600); err != nil {
+			return changed, err
+		}
+		if err := os.Rename(tmp, f.Path); err != nil {
+			os.Remove(tmp)
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+//Prune removes all but the keep most recent backups in dir. A keep of zero or less keeps everything.
+func Prune(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	files, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > keep {
+		if err := os.Remove(files[0].Path); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/config"
+)
+
+const restoreUsage = `Usage: userctl [flags] restore [-at time] [file]
+
+Replaces every stored user, deleted or not, with the users in a backup archive read from the file (standard input
+if omitted). With -at, the most recent scheduled backup in -backup-dir taken at or before that time is restored
+instead. The restore is recorded in the audit trail as a single entry. Stop the server first, userctl refuses
+to run while it has the users file open.
+
+Restore flags:
+`
+
+//runBackup writes a backup archive of the tool's datastore to the file named in args, or standard output.
+func runBackup(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	db, _, err := config.Backends()
+	if err != nil {
+		return err
+	}
+	w, err := openOut(optional(args, 0), stdout)
+	if err != nil {
+		return err
+	}
+	if err := backup.Backup(ctx, db, w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+//runRestore parses the restore command's own flags and replaces the users in the tool's datastore with a backup.
+func runRestore(ctx context.Context, args []string, stdin io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(out)
+	at := fs.String("at", "", "Restore the most recent scheduled backup taken at or before this time (RFC 3339, e.g. 2020-01-02T15:04:05Z).")
+	fs.Usage = func() {
+		fmt.Fprint(out, restoreUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || (*at != "" && fs.NArg() > 0) {
+		fs.Usage()
+		return errors.New("userctl: restore takes either a file or -at, not both")
+	}
+
+	path := fs.Arg(0)
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return fmt.Errorf("userctl: -at: %w", err)
+		}
+		if config.BackupDir() == "" {
+			return errors.New("userctl: restore -at needs -backup-dir")
+		}
+		f, err := backup.Find(config.BackupDir(), t)
+		if err != nil {
+			return err
+		}
+		path = f.Path
+		fmt.Fprintf(out, "restoring the backup taken at %v\n", f.Time.Format(time.RFC3339))
+	}
+
+	db, err := config.OpenDatastore()
+	if err != nil {
+		return err
+	}
+	r, err := openIn(path, stdin)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := backup.Restore(ctx, db, r); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "restored every user from the backup")
+	return nil
+}
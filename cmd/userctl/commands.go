@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//ctl runs the commands against a datastore.
+type ctl struct {
+	db      model.UserDataStore
+	rules   *validation.Ruleset
+	format  string
+	deleted bool
+	//managerDeletion is how the reports of a deleted manager are handled, see model.DeleteUser.
+	managerDeletion string
+	in              io.Reader
+	out             io.Writer
+}
+
+//run dispatches args[0] to its command.
+func (c *ctl) run(ctx context.Context, args []string) error {
+	cmd, args := args[0], args[1:]
+	switch {
+	case cmd == "list" && len(args) == 0:
+		return c.list(ctx, c.out)
+	case cmd == "get" && len(args) == 1:
+		return c.get(ctx, args[0])
+	case cmd == "create" && len(args) <= 1:
+		return c.create(ctx, optional(args, 0))
+	case cmd == "edit" && (len(args) == 1 || len(args) == 2):
+		return c.edit(ctx, args[0], optional(args, 1))
+	case cmd == "delete" && len(args) == 1:
+		return c.delete(ctx, args[0])
+	case cmd == "import" && len(args) <= 1:
+		return c.importUsers(ctx, optional(args, 0))
+	case cmd == "export" && len(args) <= 1:
+		return c.export(ctx, optional(args, 0))
+	case cmd == "validate" && len(args) == 0:
+		return c.validate(ctx)
+	case cmd == "stats" && len(args) == 0:
+		return c.stats(ctx)
+	}
+	return errUsage
+}
+
+func optional(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+func (c *ctl) list(ctx context.Context, w io.Writer) error {
+	var users []model.User
+	var err error
+	if c.deleted {
+		users, err = c.db.GetDeleted(ctx)
+	} else {
+		users, err = c.db.GetAll(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return writeUsers(w, c.format, users)
+}
+
+func (c *ctl) get(ctx context.Context, arg string) error {
+	id, err := parseID(arg)
+	if err != nil {
+		return err
+	}
+	u, err := c.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeUser(c.out, c.format, u)
+}
+
+//find returns the user with the given ID, whether or not they're deleted.
+func (c *ctl) find(ctx context.Context, id int) (model.User, error) {
+	for _, list := range []func(context.Context) ([]model.User, error){c.db.GetAll, c.db.GetDeleted} {
+		users, err := list(ctx)
+		if err != nil {
+			return model.User{}, err
+		}
+		for _, u := range users {
+			if u.ID == id {
+				return u, nil
+			}
+		}
+	}
+	return model.User{}, errors.New(model.CouldNotFind)
+}
+
+func (c *ctl) create(ctx context.Context, arg string) error {
+	u, err := c.readUser(arg, validation.Create)
+	if err != nil {
+		return err
+	}
+	if err := c.db.Create(ctx, &u); err != nil {
+		return err
+	}
+	created, err := c.find(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	return writeUser(c.out, c.format, created)
+}
+
+func (c *ctl) edit(ctx context.Context, idArg, arg string) error {
+	id, err := parseID(idArg)
+	if err != nil {
+		return err
+	}
+	u, err := c.readUser(arg, validation.Update)
+	if err != nil {
+		return err
+	}
+	if err := c.db.Edit(ctx, u, id); err != nil {
+		return err
+	}
+	edited, err := c.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	return writeUser(c.out, c.format, edited)
+}
+
+func (c *ctl) delete(ctx context.Context, arg string) error {
+	id, err := parseID(arg)
+	if err != nil {
+		return err
+	}
+	return model.DeleteUser(ctx, c.db, id, c.managerDeletion)
+}
+
+//readUser reads a user from the JSON argument, or standard input if there isn't one, and validates it for op
+//the way the server does.
+func (c *ctl) readUser(arg string, op validation.Operation) (model.User, error) {
+	var data []byte
+	if arg == "" || arg == "-" {
+		var err error
+		if data, err = ioutil.ReadAll(c.in); err != nil {
+			return model.User{}, err
+		}
+	} else {
+		data = []byte(arg)
+	}
+
+	var u model.User
+	if err := json.Unmarshal(data, &u); err != nil {
+		return model.User{}, fmt.Errorf("reading user: %w", err)
+	}
+	u.ClearServerManaged()
+	if errs := c.rules.Apply(&u, op); len(errs) > 0 {
+		return model.User{}, problems(errs)
+	}
+	return u, nil
+}
+
+//importUsers creates every user in the file. The users get new IDs, managers that are imported too are linked up
+//under their new IDs once everyone has been created, other manager IDs are kept as they are. Every user is attempted
+//and the failures are reported together.
+func (c *ctl) importUsers(ctx context.Context, path string) error {
+	f, err := openIn(path, c.in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var users []model.User
+	if c.format == formatCSV {
+		users, err = readCSV(f)
+	} else {
+		err = json.NewDecoder(f).Decode(&users)
+	}
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	newIDs := make(map[int]int, len(users))
+	managers := make(map[int]int)
+	var failures []string
+	imported := 0
+	for i, u := range users {
+		oldID, managerID := u.ID, u.ManagerID
+		u.ID, u.ManagerID = 0, 0
+		u.ClearServerManaged()
+		if errs := c.rules.Apply(&u, validation.Create); len(errs) > 0 {
+			failures = append(failures, fmt.Sprintf("record %v: %v", i+1, problems(errs)))
+			continue
+		}
+		if err := c.db.Create(ctx, &u); err != nil {
+			failures = append(failures, fmt.Sprintf("record %v: %v", i+1, err))
+			continue
+		}
+		imported++
+		if oldID != 0 {
+			newIDs[oldID] = u.ID
+		}
+		if managerID != 0 {
+			managers[u.ID] = managerID
+		}
+	}
+
+	for id, managerID := range managers {
+		if newID, ok := newIDs[managerID]; ok {
+			managerID = newID
+		}
+		if err := c.db.Edit(ctx, model.User{ManagerID: managerID}, id); err != nil {
+			failures = append(failures, fmt.Sprintf("user %v: setting manager %v: %v", id, managerID, err))
+		}
+	}
+
+	fmt.Fprintf(c.out, "imported %v of %v users\n", imported, len(users))
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return errors.New(strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+func (c *ctl) export(ctx context.Context, path string) error {
+	f, err := openOut(path, c.out)
+	if err != nil {
+		return err
+	}
+	if err := c.list(ctx, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//validate checks every stored user, active or deleted, as a complete user and reports each problem on its own line.
+func (c *ctl) validate(ctx context.Context) error {
+	active, err := c.db.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	deletedUsers, err := c.db.GetDeleted(ctx)
+	if err != nil {
+		return err
+	}
+	users := append(active, deletedUsers...)
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	invalid := 0
+	for _, u := range users {
+		errs := c.rules.Validate(u, validation.Create)
+		if len(errs) == 0 {
+			continue
+		}
+		invalid++
+		for _, e := range errs {
+			fmt.Fprintf(c.out, "%v\t%v\t%v\n", u.ID, e.PropName, e.Message)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("validate: %v of %v users have problems", invalid, len(users))
+	}
+	fmt.Fprintf(c.out, "all %v users are valid\n", len(users))
+	return nil
+}
+
+//Stats summarizes the stored users.
+type Stats struct {
+	Active  int `json:"active"`
+	Deleted int `json:"deleted"`
+	//Organizations counts the active users in each organization.
+	Organizations map[string]int `json:"organizations"`
+	//Managers is how many active users have reports, WithManager how many report to someone.
+	Managers    int `json:"managers"`
+	WithManager int `json:"withManager"`
+	//Attributes counts the active users who have a value for each custom attribute.
+	Attributes map[string]int `json:"attributes"`
+}
+
+func (c *ctl) stats(ctx context.Context) error {
+	active, err := c.db.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	deletedUsers, err := c.db.GetDeleted(ctx)
+	if err != nil {
+		return err
+	}
+
+	s := Stats{Active: len(active), Deleted: len(deletedUsers), Organizations: map[string]int{}, Attributes: map[string]int{}}
+	managers := make(map[int]bool)
+	for _, u := range active {
+		s.Organizations[u.Organization]++
+		if u.ManagerID != 0 {
+			s.WithManager++
+			managers[u.ManagerID] = true
+		}
+		for name, v := range u.Attributes {
+			if v != "" {
+				s.Attributes[name]++
+			}
+		}
+	}
+	s.Managers = len(managers)
+	return writeStats(c.out, c.format, s)
+}
+
+func parseID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%q is not a user ID", arg)
+	}
+	return id, nil
+}
+
+//problems joins validation errors into one error, one problem per line.
+func problems(errs []validation.UserError) error {
+	lines := make([]string, 0, len(errs))
+	for _, e := range errs {
+		lines = append(lines, fmt.Sprintf("%v: %v", e.PropName, e.Message))
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/webhook"
+)
+
+const rotateKeyUsage = `Usage: userctl [flags] rotate-key [rotate-key flags]
+
+Adds a new key to the keyfile given by -keyfile, creating it if it doesn't exist, makes it the primary key and
+re-encrypts the data keys of the users file, its audit trail, the backups in -backup-dir and the webhooks in
+-webhooks with it. Older keys stay in the keyfile so backups downloaded from the server can still be restored, run
+it again with -retire once they're no longer needed.
+
+Rotate-key rewrites the users file, so stop the server first: it refuses to run while the server has the file
+open.
+
+Rotate-key flags:
+`
+
+//keyRotator is implemented by datastores that encrypt their data, e.g. the file datastore given a keyfile.
+type keyRotator interface {
+	RotateKey(ctx context.Context) (bool, error)
+}
+
+//runRotateKey parses the rotate-key command's own flags and rotates the users file's encryption key.
+func runRotateKey(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	fs.SetOutput(out)
+	id := fs.String("id", time.Now().UTC().Format("20060102T150405Z"), "The ID of the new key.")
+	retire := fs.Bool("retire", false, "Don't add a key, remove every key but the primary one once the file uses it.")
+	fs.Usage = func() {
+		fmt.Fprint(out, rotateKeyUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if config.Keyfile() == "" {
+		return fmt.Errorf("userctl: rotate-key needs -keyfile, keys in %v are rotated by changing the variable and restarting", config.KeysEnv)
+	}
+	//taken before the keyfile changes, so nothing is changed while a server is running.
+	if err := config.LockDatastore(); err != nil {
+		return err
+	}
+
+	if !*retire {
+		key, err := encryption.GenerateKey()
+		if err != nil {
+			return err
+		}
+		if err := encryption.AddKey(config.Keyfile(), *id, key); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "added key %v as the primary key\n", *id)
+	}
+
+	db, _, err := config.Backends()
+	if err != nil {
+		return err
+	}
+	rotator, ok := db.(keyRotator)
+	if !ok {
+		return errors.New("userctl: the datastore isn't encrypted")
+	}
+	//opening the datastore may already have re-encrypted the file.
+	if _, err := rotator.RotateKey(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "the users file is encrypted with the primary key")
+
+	keys, err := encryption.LoadKeyfile(config.Keyfile())
+	if err != nil {
+		return err
+	}
+	if dir := config.BackupDir(); dir != "" {
+		changed, err := backup.RotateKey(dir, keys)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "re-encrypted %v backups with the primary key\n", changed)
+	}
+	if dir := config.WebhookDir(); dir != "" {
+		//opening the webhooks seals their files with the primary key.
+		if _, err := webhook.Open(dir, keys, nil); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "the webhooks are encrypted with the primary key")
+	}
+
+	if *retire {
+		retired, err := encryption.RetireKeys(config.Keyfile())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "retired %v older keys\n", len(retired))
+	}
+	return nil
+}
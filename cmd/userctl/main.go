@@ -0,0 +1,136 @@
+//Command userctl manages the stored users without going through the HTTP API. It opens the datastore with the same
+//flags as the server, e.g.
+//
+//	userctl -db file -conn users.json -format csv export users.csv
+//
+//It refuses to run while a server has the users file open, see config.OpenDatastore.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+var format = flag.String("format", formatTable, "Output format: table, json or csv. Import reads json or csv.")
+var deleted = flag.Bool("deleted", false, "List or export the deleted users instead of the active ones.")
+
+const usage = `Usage: userctl [flags] <command> [arguments]
+
+Commands:
+  list                 list the users
+  get <id>             show one user, including deleted users
+  create [json]        create a user from the JSON argument or standard input
+  edit <id> [json]     change the fields given in the JSON argument or standard input
+  delete <id>          delete a user, handling their reports as -manager-delete says
+  import [file]        create every user in a JSON array or CSV file (standard input if omitted)
+  export [file]        write every user to the file (standard output if omitted)
+  validate             check every stored user against the validation rules
+  stats                count users by organization, manager and attribute
+  migrate [flags]      copy every user to another datastore (see userctl migrate -h)
+  backup [file]        write a backup of every user, deleted or not (standard output if omitted)
+  restore [flags] [file]
+                       replace every user with those in a backup (see userctl restore -h)
+  rotate-key [flags]   encrypt the users file with a new key (see userctl rotate-key -h)
+
+Userctl opens the users file itself, so it refuses to run while a server has the file open: the server's search
+and suggestion indexes wouldn't see its changes, and no events or webhooks would be sent for them. Stop the server
+first, or use the HTTP API instead.
+
+Flags:
+`
+
+//errUsage is returned for a command line that doesn't name a command or has the wrong arguments.
+var errUsage = errors.New("userctl: invalid command line")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		if err == errUsage {
+			flag.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if *format != formatTable && *format != formatJSON && *format != formatCSV {
+		return fmt.Errorf("userctl: unknown format %q", *format)
+	}
+
+	ctx := model.WithActor(context.Background(), actor())
+	switch args[0] {
+	case "migrate":
+		return runMigrate(ctx, args[1:], os.Stdout)
+	case "backup":
+		return runBackup(ctx, args[1:], os.Stdout)
+	case "restore":
+		return runRestore(ctx, args[1:], os.Stdin, os.Stdout)
+	case "rotate-key":
+		return runRotateKey(ctx, args[1:], os.Stdout)
+	}
+
+	db, err := config.OpenDatastore()
+	if err != nil {
+		return err
+	}
+	rules, err := config.ValidationRules(ctx)
+	if err != nil {
+		return err
+	}
+	managerDeletion, err := config.ManagerDeletion()
+	if err != nil {
+		return err
+	}
+
+	c := &ctl{db: db, rules: rules, format: *format, deleted: *deleted, managerDeletion: managerDeletion, in: os.Stdin, out: os.Stdout}
+	return c.run(ctx, args)
+}
+
+//actor names the person running the tool in the audit trail.
+func actor() string {
+	if u, err := user.Current(); err == nil {
+		return "userctl:" + u.Username
+	}
+	return "userctl"
+}
+
+//openIn returns standard input for an empty or "-" path, and the named file otherwise.
+func openIn(path string, stdin io.Reader) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return ioutil.NopCloser(stdin), nil
+	}
+	return os.Open(path)
+}
+
+//openOut returns standard output for an empty or "-" path, and creates the named file otherwise.
+func openOut(path string, stdout io.Writer) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopWriteCloser{stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/migrate"
+)
+
+const migrateUsage = `Usage: userctl [flags] migrate [migrate flags]
+
+Copies every user, and the audit trail if -to-audit is given, from the datastore chosen by -db and -conn to
+another one. Users keep their IDs. The copy is verified once it's done, and an interrupted migration carries on
+where it left off when it's run again.
+
+Migrate flags:
+`
+
+//runMigrate parses the migrate command's own flags and runs the migration from the tool's datastore.
+func runMigrate(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	toDb := fs.String("to-db", "", "The type of the target datastore, one of the types -db accepts.")
+	toConn := fs.String("to-conn", "", "The target datastore's connection string.")
+	toAudit := fs.String("to-audit", "none", "The type of the target audit sink, one of the types -audit accepts. \"none\" leaves the audit trail where it is.")
+	toAuditConn := fs.String("to-audit-conn", "", "The target audit sink's connection string.")
+	batchSize := fs.Int("batch-size", migrate.DefaultBatchSize, "How many users or audit entries to write before saving progress.")
+	statePath := fs.String("state", "migration-state.json", "Where progress is saved so an interrupted migration can be resumed.")
+	fs.Usage = func() {
+		fmt.Fprint(out, migrateUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || *toDb == "" {
+		fs.Usage()
+		return errors.New("userctl: migrate needs -to-db and no other arguments")
+	}
+
+	source, sourceAudit, err := config.Backends()
+	if err != nil {
+		return err
+	}
+	target, err := config.OpenBackend(*toDb, *toConn)
+	if err != nil {
+		return err
+	}
+	targetAudit, err := config.OpenAuditSink(*toAudit, *toAuditConn, target)
+	if err != nil {
+		return err
+	}
+	rules, err := config.ValidationRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	report, err := migrate.Run(ctx, source, target, migrate.Options{
+		BatchSize:   *batchSize,
+		Rules:       rules,
+		StatePath:   *statePath,
+		SourceAudit: sourceAudit,
+		TargetAudit: targetAudit,
+		Progress: func(p migrate.Progress) {
+			fmt.Fprintf(out, "%v: %v/%v\n", p.Stage, p.Done, p.Total)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if report.Resumed {
+		fmt.Fprintln(out, "resumed an interrupted migration")
+	}
+	fmt.Fprintf(out, "migrated and verified %v users and %v audit entries, checksum %v\n", report.Users, report.AuditEntries, report.Checksum)
+	return nil
+}
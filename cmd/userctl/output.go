@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Output formats.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+//tableHeader names the columns of model.User.String().
+const tableHeader = "ID\tFIRST NAME\tLAST NAME\tEMAIL\tORGANIZATION"
+
+//csvColumns are the fixed CSV columns, followed by one attributePrefix column per custom attribute.
+var csvColumns = []string{"id", "firstName", "lastName", "email", "organization", "organizationId", "managerId",
+	"createdAt", "createdBy", "updatedAt", "updatedBy", "deletedAt"}
+
+const attributePrefix = "attributes."
+
+func writeUsers(w io.Writer, format string, users []model.User) error {
+	switch format {
+	case formatJSON:
+		return writeJSON(w, users)
+	case formatCSV:
+		return writeCSV(w, users)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, tableHeader)
+	for _, u := range users {
+		fmt.Fprintln(tw, u.String())
+	}
+	return tw.Flush()
+}
+
+func writeUser(w io.Writer, format string, u model.User) error {
+	if format == formatJSON {
+		return writeJSON(w, u)
+	}
+	return writeUsers(w, format, []model.User{u})
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeCSV(w io.Writer, users []model.User) error {
+	names := make(map[string]bool)
+	for _, u := range users {
+		for name := range u.Attributes {
+			names[name] = true
+		}
+	}
+	attrs := make([]string, 0, len(names))
+	for name := range names {
+		attrs = append(attrs, name)
+	}
+	sort.Strings(attrs)
+
+	cw := csv.NewWriter(w)
+	header := append([]string{}, csvColumns...)
+	for _, name := range attrs {
+		header = append(header, attributePrefix+name)
+	}
+	cw.Write(header)
+	for _, u := range users {
+		deletedAt := ""
+		if u.DeletedAt != nil {
+			deletedAt = u.DeletedAt.Format(time.RFC3339Nano)
+		}
+		record := []string{strconv.Itoa(u.ID), u.FirstName, u.LastName, u.Email, u.Organization,
+			optionalInt(u.OrganizationID), optionalInt(u.ManagerID),
+			u.CreatedAt.Format(time.RFC3339Nano), u.CreatedBy, u.UpdatedAt.Format(time.RFC3339Nano), u.UpdatedBy, deletedAt}
+		for _, name := range attrs {
+			record = append(record, u.Attributes[name])
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func optionalInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+//readCSV reads users written by writeCSV. Only the header's columns need to be present, in any order, so
+//hand-made files can leave out the IDs and metadata.
+func readCSV(r io.Reader) ([]model.User, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	known := make(map[string]bool, len(csvColumns))
+	for _, c := range csvColumns {
+		known[c] = true
+	}
+	for _, c := range header {
+		if !known[c] && !strings.HasPrefix(c, attributePrefix) {
+			return nil, fmt.Errorf("unknown column %q", c)
+		}
+	}
+
+	users := make([]model.User, 0, len(records)-1)
+	for line, record := range records[1:] {
+		var u model.User
+		for i, v := range record {
+			if err := setColumn(&u, header[i], v); err != nil {
+				return nil, fmt.Errorf("line %v: %v: %w", line+2, header[i], err)
+			}
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+//setColumn sets the field of u the column is for. Timestamps are read so a file round-trips, though the datastore
+//replaces them when the users are created.
+func setColumn(u *model.User, column, v string) error {
+	var err error
+	switch column {
+	case "id":
+		u.ID, err = atoiOptional(v)
+	case "firstName":
+		u.FirstName = v
+	case "lastName":
+		u.LastName = v
+	case "email":
+		u.Email = v
+	case "organization":
+		u.Organization = v
+	case "organizationId":
+		u.OrganizationID, err = atoiOptional(v)
+	case "managerId":
+		u.ManagerID, err = atoiOptional(v)
+	case "createdAt":
+		u.CreatedAt, err = timeOptional(v)
+	case "createdBy":
+		u.CreatedBy = v
+	case "updatedAt":
+		u.UpdatedAt, err = timeOptional(v)
+	case "updatedBy":
+		u.UpdatedBy = v
+	case "deletedAt":
+		if v != "" {
+			var t time.Time
+			t, err = timeOptional(v)
+			u.DeletedAt = &t
+		}
+	default:
+		if v != "" {
+			if u.Attributes == nil {
+				u.Attributes = make(map[string]string)
+			}
+			u.Attributes[strings.TrimPrefix(column, attributePrefix)] = v
+		}
+	}
+	return err
+}
+
+func atoiOptional(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func timeOptional(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
+
+func writeStats(w io.Writer, format string, s Stats) error {
+	if format == formatJSON {
+		return writeJSON(w, s)
+	}
+
+	rows := [][]string{
+		{"active", strconv.Itoa(s.Active)},
+		{"deleted", strconv.Itoa(s.Deleted)},
+		{"managers", strconv.Itoa(s.Managers)},
+		{"withManager", strconv.Itoa(s.WithManager)},
+	}
+	rows = append(rows, countRows("organization", s.Organizations)...)
+	rows = append(rows, countRows("attribute", s.Attributes)...)
+
+	if format == formatCSV {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"stat", "count"})
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+//countRows returns a row per key, ordered by key, labelled "<kind>:<key>".
+func countRows(kind string, counts map[string]int) [][]string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, []string{kind + ":" + k, strconv.Itoa(counts[k])})
+	}
+	return rows
+}
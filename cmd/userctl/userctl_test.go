@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nmalensek/go-user-form/fileusermodel"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+func newCtl(t *testing.T, data string) (*ctl, *bytes.Buffer, func()) {
+	dir, err := ioutil.TempDir("", "userctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(path, []byte(data), 0600)
+	out := &bytes.Buffer{}
+	c := &ctl{db: &fileusermodel.FileUserModel{Filepath: path}, rules: validation.DefaultRules(), format: formatTable, in: strings.NewReader(""), out: out}
+	return c, out, func() { os.RemoveAll(dir) }
+}
+
+func TestCommands(t *testing.T) {
+	c, out, cleanup := newCtl(t, "{}")
+	defer cleanup()
+	ctx := model.WithActor(context.Background(), "userctl:test")
+
+	if err := c.run(ctx, []string{"create", `{"firstName":"Ann","lastName":"Lee","email":"ann@example.com","organization":"sales"}`}); err != nil {
+		t.Fatal(err)
+	}
+	c.in = strings.NewReader(`{"firstName":"Bob","lastName":"Ray","email":"bob@example.com","organization":"sales","managerId":1}`)
+	if err := c.run(ctx, []string{"create"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.run(ctx, []string{"create", `{"firstName":"No","email":"nope"}`}); err == nil {
+		t.Errorf("creating an invalid user should fail")
+	}
+	if err := c.run(ctx, []string{"edit", "2", `{"organization":"marketing"}`}); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	c.run(ctx, []string{"list"})
+	want := "ID  FIRST NAME  LAST NAME  EMAIL            ORGANIZATION\n" +
+		"1   Ann         Lee        ann@example.com  sales\n" +
+		"2   Bob         Ray        bob@example.com  marketing\n"
+	if out.String() != want {
+		t.Errorf("got table\n%v\nwant\n%v", out.String(), want)
+	}
+
+	out.Reset()
+	c.format = formatJSON
+	c.run(ctx, []string{"get", "2"})
+	var got model.User
+	json.Unmarshal(out.Bytes(), &got)
+	if got.ManagerID != 1 || got.UpdatedBy != "userctl:test" {
+		t.Errorf("got %+v want Bob reporting to Ann, updated by the tool", got)
+	}
+	if err := c.run(ctx, []string{"get", "x"}); err == nil {
+		t.Errorf("a bad ID should fail")
+	}
+	if err := c.run(ctx, []string{"frobnicate"}); err != errUsage {
+		t.Errorf("got %v want %v", err, errUsage)
+	}
+
+	out.Reset()
+	c.run(ctx, []string{"stats"})
+	var stats Stats
+	json.Unmarshal(out.Bytes(), &stats)
+	if stats.Active != 2 || stats.Managers != 1 || stats.WithManager != 1 || stats.Organizations["marketing"] != 1 {
+		t.Errorf("got %+v", stats)
+	}
+
+	//a CSV export imports into another store, with the manager linked to its new ID.
+	out.Reset()
+	c.format = formatCSV
+	if err := c.run(ctx, []string{"export"}); err != nil {
+		t.Fatal(err)
+	}
+	exported := out.String()
+	if !strings.HasPrefix(exported, strings.Join(csvColumns, ",")+"\n") {
+		t.Errorf("got CSV\n%v", exported)
+	}
+
+	other, otherOut, cleanupOther := newCtl(t, `{"7":{"id":7,"firstName":"Old","lastName":"Timer","email":"old@example.com","organization":"sales"}}`)
+	defer cleanupOther()
+	other.format = formatCSV
+	other.in = strings.NewReader(exported)
+	if err := other.run(ctx, []string{"import"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(otherOut.String(), "imported 2 of 2 users") {
+		t.Errorf("got %q", otherOut.String())
+	}
+	users, _ := other.db.GetAll(ctx)
+	byEmail := make(map[string]model.User)
+	for _, u := range users {
+		byEmail[u.Email] = u
+	}
+	if byEmail["bob@example.com"].ManagerID != byEmail["ann@example.com"].ID || byEmail["ann@example.com"].ID == 1 {
+		t.Errorf("got %+v want Bob reporting to Ann under her new ID", users)
+	}
+
+	other.in = strings.NewReader(exported)
+	if err := other.run(ctx, []string{"import"}); err == nil || !strings.Contains(err.Error(), "record 1") {
+		t.Errorf("got %v want the duplicate emails reported", err)
+	}
+}
+
+func TestDeleteManager(t *testing.T) {
+	c, _, cleanup := newCtl(t, `{"1":{"id":1,"firstName":"Ann","lastName":"Lee","email":"ann@example.com","organization":"sales"},`+
+		`"2":{"id":2,"firstName":"Bob","lastName":"Ray","email":"bob@example.com","organization":"sales","managerId":1},`+
+		`"3":{"id":3,"firstName":"Cy","lastName":"Day","email":"cy@example.com","organization":"sales","managerId":2}}`)
+	defer cleanup()
+	ctx := context.Background()
+
+	//the datastore refuses to leave reports with a deleted manager, whatever deletes the user.
+	if err := c.db.Delete(ctx, 2); err == nil || err.Error() != model.HasReports {
+		t.Errorf("got %v want %v", err, model.HasReports)
+	}
+	if err := c.run(ctx, []string{"delete", "2"}); err == nil || err.Error() != model.HasReports {
+		t.Errorf("got %v want %v", err, model.HasReports)
+	}
+
+	c.managerDeletion = model.ManagerDeleteReassign
+	if err := c.run(ctx, []string{"delete", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	users, _ := c.db.GetAll(ctx)
+	if len(users) != 2 {
+		t.Fatalf("got %+v want Bob deleted", users)
+	}
+	for _, u := range users {
+		if u.ID == 3 && u.ManagerID != 1 {
+			t.Errorf("got %+v want Cy moved up to Ann", u)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c, out, cleanup := newCtl(t, `{"1":{"id":1,"firstName":"Ann","lastName":"Lee","email":"ann@example.com","organization":"sales"},`+
+		`"2":{"id":2,"firstName":"","lastName":"Ray","email":"not an email","organization":"sales"}}`)
+	defer cleanup()
+
+	err := c.run(context.Background(), []string{"validate"})
+	if err == nil || err.Error() != "validate: 1 of 2 users have problems" {
+		t.Errorf("got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "2\t") || !strings.HasPrefix(lines[1], "2\t") {
+		t.Errorf("got\n%v\nwant the first name and email problems of user 2", out.String())
+	}
+}
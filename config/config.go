@@ -1,32 +1,117 @@
 package config
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/nmalensek/go-user-form/audit"
+	"github.com/nmalensek/go-user-form/auth"
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/events"
 	"github.com/nmalensek/go-user-form/fileusermodel"
+	"github.com/nmalensek/go-user-form/i18n"
 	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/oidc"
+	"github.com/nmalensek/go-user-form/presence"
+	"github.com/nmalensek/go-user-form/schema"
+	"github.com/nmalensek/go-user-form/sensitivity"
+	"github.com/nmalensek/go-user-form/suggest"
+	"github.com/nmalensek/go-user-form/validation"
+	"github.com/nmalensek/go-user-form/webhook"
 )
 
 const (
-	connFlag = "conn"
-	fileDb   = "file"
+	connFlag      = "conn"
+	fileDb        = "file"
+	auditConnFlag = "audit-conn"
+	jsonlAudit    = "jsonl"
+	dbAudit       = "datastore"
+	memoryAudit   = "memory"
+	noAudit       = "none"
 )
 
+//KeysEnv is the environment variable the users file's encryption keys are read from when no keyfile is given, as pairs
+//of a key ID and base64 key joined by a colon, separated by commas. The first key is the primary key.
+const KeysEnv = "USER_FORM_KEYS"
+
+//eventBufferSize is how many messages a /users/events or /users/presence client may fall behind before it's disconnected.
+const eventBufferSize = 64
+
 var connString = flag.String(connFlag, "", "The database connection string (absolute file path if using a file as a database).")
 var dbType = flag.String("db", "", fmt.Sprintf("The type of database to use, options follow:\n %v", dbOptionsToString()))
+var keyFile = flag.String("keyfile", "", fmt.Sprintf("Path to the keyfile the users file is encrypted with, created by userctl rotate-key. If omitted, keys are read from the %v environment variable, and the file isn't encrypted if neither is set.", KeysEnv))
+
+var auditType = flag.String("audit", noAudit, fmt.Sprintf("Where to record the audit trail of user changes, options follow:\n %v", auditOptionsToString()))
+var auditConn = flag.String(auditConnFlag, "", "The audit sink connection string (file path for the jsonl sink).")
+
+var rulesFile = flag.String("rules", "", "Path to a validation rule file that adds field rules, organization email domains and email verification to the built-in rules.")
+var localesDir = flag.String("locales", "", "Directory of <language>.json message files that add to or override the built-in translations.")
+var orgConn = flag.String("org-conn", "", "Path to the organizations file, existing users' organizations are migrated into it on startup (empty to keep free-text organizations).")
+var suggestFile = flag.String("suggest", "", "Path to a JSON file configuring which fields /users/suggest may suggest, the built-in configuration suggests names, organizations and users.")
+var webhookDir = flag.String("webhooks", "", "Directory for the registered webhooks and their delivery queue (empty to turn webhooks off). Its files are encrypted with the users file's keys, if it has any.")
+var eventLogSize = flag.Int("event-log-size", 1000, "How many recent user events are kept so /users/events subscribers can resume after reconnecting.")
+var schemaFile = flag.String("schema", "", "Path to the custom attribute schema file, created when the schema is first changed (empty to turn custom attributes off).")
+
+var authConfig = flag.String("auth", "", "Path to the authentication configuration file (API keys and JWKS). If omitted, requests are not authenticated.")
+
+var oidcConfig = flag.String("oidc", "", "Path to the OpenID Connect configuration file. If given, browsers sign in through the identity provider.")
+var staticDir = flag.String("static", "", "Directory of form pages (e.g. the built React client) to serve at /, protected like the API.")
 
-var validPath = regexp.MustCompile("^/(users)/([a-zA-Z0-9]*)$")
+var policyFile = flag.String("policy", "", "Path to the authorization policy file. If omitted while authentication is enabled, the built-in viewer/editor/admin policy is used.")
+var sensitivityFile = flag.String("sensitivity", "", "Path to a JSON file mapping user fields to how they're masked for callers without the unmask permission (none, email, partial or full), the built-in classification masks names and email addresses.")
+
+var retention = flag.Duration("retention", 0, "How long deleted users are kept before being permanently purged, e.g. 720h for 30 days. Defaults to 0, which keeps them forever.")
+var purgeInterval = flag.Duration("purge-interval", time.Hour, "How often to check for deleted users past the retention window.")
+
+var backupDir = flag.String("backup-dir", "", "Directory to save scheduled backups of the users in (empty to turn scheduled backups off).")
+var backupInterval = flag.Duration("backup-interval", 24*time.Hour, "How often to take a scheduled backup, the first is taken on startup.")
+var backupKeep = flag.Int("backup-keep", 7, "How many scheduled backups to keep, older ones are removed (0 to keep them all).")
+
+var managerDelete = flag.String("manager-delete", model.ManagerDeleteBlock, fmt.Sprintf("What happens to the reports of a deleted manager: %q refuses the deletion, %q moves them up to the deleted manager's manager.", model.ManagerDeleteBlock, model.ManagerDeleteReassign))
+
+var getTimeout = flag.Duration("get-timeout", 5*time.Second, "The maximum time a datastore read may take (0 for no limit).")
+var createTimeout = flag.Duration("create-timeout", 5*time.Second, "The maximum time a datastore create may take (0 for no limit).")
+var editTimeout = flag.Duration("edit-timeout", 5*time.Second, "The maximum time a datastore edit may take (0 for no limit).")
+var deleteTimeout = flag.Duration("delete-timeout", 5*time.Second, "The maximum time a datastore delete may take (0 for no limit).")
+
+//validPaths are the request paths the API serves.
+var validPaths = []*regexp.Regexp{
+	regexp.MustCompile("^/(users)/([a-zA-Z0-9]*)$"),
+	regexp.MustCompile("^/(users)/([0-9]+)/(history|restore|reports|chain)$"),
+	regexp.MustCompile("^/(audit)$"),
+	regexp.MustCompile("^/(webhooks)/?([0-9]*)$"),
+	regexp.MustCompile("^/(webhooks)/([0-9]+)/(deliveries)$"),
+	regexp.MustCompile("^/(organizations)/([0-9]*)$"),
+	regexp.MustCompile("^/(organizations)/([0-9]+)/(users)$"),
+	regexp.MustCompile("^/(schema)$"),
+	regexp.MustCompile("^/(schema)/([a-zA-Z][a-zA-Z0-9_]*)$"),
+	regexp.MustCompile("^/(admin)/(backup)$"),
+}
 
 var databaseTypes = map[string]dataBaseType{
 	fileDb: {Name: fileDb, Description: fmt.Sprintf("Use a JSON file as a pseudo-database (provide the absolute filepath as the \"%v\" flag).", connFlag), InitFunc: registerFileDb},
 }
 
+var auditTypes = map[string]auditSinkType{
+	jsonlAudit:  {Name: jsonlAudit, Description: fmt.Sprintf("Append entries as JSON lines to the file given by the \"%v\" flag, the values of sensitive fields are redacted since the file isn't encrypted.", auditConnFlag), InitFunc: registerJSONLAudit},
+	dbAudit:     {Name: dbAudit, Description: "Keep entries in the same database as the users, the file database appends them to a file next to its own, encrypted with the same keys.", InitFunc: registerDatastoreAudit},
+	memoryAudit: {Name: memoryAudit, Description: "Keep entries in memory, they are lost when the server stops.", InitFunc: registerMemoryAudit},
+	noAudit:     {Name: noAudit, Description: "Don't record an audit trail.", InitFunc: func(string, model.UserDataStore) (audit.Sink, error) { return nil, nil }},
+}
+
 func dbOptionsToString() string {
 	b := strings.Builder{}
 	for _, v := range databaseTypes {
@@ -39,17 +124,79 @@ func dbOptionsToString() string {
 type dataBaseType struct {
 	Name        string
 	Description string
-	InitFunc    func() (model.UserDataStore, error)
+	//InitFunc opens the datastore at the connection string, e.g. the file path for the file database.
+	InitFunc func(conn string) (model.UserDataStore, error)
 }
 
 func (d *dataBaseType) String() string {
 	return fmt.Sprintf("%v:\t%v", d.Name, d.Description)
 }
 
+type auditSinkType struct {
+	Name        string
+	Description string
+	//InitFunc opens the sink at the connection string for the users in db, sinks that don't need them ignore them.
+	InitFunc func(conn string, db model.UserDataStore) (audit.Sink, error)
+}
+
+func (a *auditSinkType) String() string {
+	return fmt.Sprintf("%v:\t%v", a.Name, a.Description)
+}
+
+func auditOptionsToString() string {
+	b := strings.Builder{}
+	for _, v := range auditTypes {
+		b.WriteString(v.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
 //Env contains all environment variables that the app needs to run (database info, loggers, etc.)
 type Env struct {
 	Datastore model.UserDataStore
 	ErrorLog  *log.Logger
+	Timeouts  Timeouts
+	//Retention is how long deleted users are kept before they're purged, zero keeps them forever.
+	Retention     time.Duration
+	PurgeInterval time.Duration
+	//ManagerDeletion is model.ManagerDeleteBlock or model.ManagerDeleteReassign, an empty value blocks.
+	ManagerDeletion string
+	Auth            *auth.Authenticator
+	Policy          *authz.Policy
+	//Sensitivity classifies the fields masked for callers the policy doesn't allow to unmask them and redacted in logs.
+	Sensitivity *sensitivity.Policy
+	Audit       audit.Sink
+	Schema      *schema.Store
+	//Backups is the Datastore, so a restore is recorded in the audit trail, published and reflected in the
+	//suggestions like any other change, or nil if the datastore as it was opened doesn't support backups.
+	Backups model.Backuper
+	//BackupSchedule is nil if scheduled backups are turned off.
+	BackupSchedule *backup.Schedule
+	//Events receives every user change and streams it to /users/events subscribers, it's nil in environments without a feed, e.g. tests.
+	Events *events.Broker
+	//Presence tracks who is editing which user, it's nil in environments without it, e.g. tests.
+	Presence *presence.Hub
+	//Webhooks is nil if webhooks are turned off, otherwise user events are published to it.
+	Webhooks *webhook.Dispatcher
+	//Suggestions is nil in environments without a suggestion index, e.g. tests.
+	Suggestions *suggest.Index
+	//Organizations is nil if organizations aren't managed, users' organizations are then free text.
+	Organizations model.OrganizationDataStore
+	Rules         *validation.Ruleset
+	//EmailVerifier is nil unless the rule file configures it. Its lookups run in the handlers' validation, not in the
+	//datastore's.
+	EmailVerifier *validation.EmailVerifier
+	OIDC          *oidc.RelyingParty
+	StaticDir     string
+}
+
+//Timeouts holds the deadline applied to each type of datastore operation. A zero value means no deadline.
+type Timeouts struct {
+	Get    time.Duration
+	Create time.Duration
+	Edit   time.Duration
+	Delete time.Duration
 }
 
 //Start initializes all environment dependencies for use in the application.
@@ -58,7 +205,19 @@ func Start() (*Env, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
-	env := Env{Datastore: db}
+	env := Env{Datastore: db, Timeouts: Timeouts{
+		Get:    *getTimeout,
+		Create: *createTimeout,
+		Edit:   *editTimeout,
+		Delete: *deleteTimeout,
+	}, Retention: *retention, PurgeInterval: *purgeInterval, ManagerDeletion: *managerDelete}
+
+	if env.Retention > 0 && env.PurgeInterval <= 0 {
+		return nil, errors.New("Start: purge-interval must be greater than zero when a retention window is set")
+	}
+	if err := checkManagerDeletion(); err != nil {
+		return nil, fmt.Errorf("Start: %w", err)
+	}
 
 	fileLog, err := initLogger()
 	if err != nil {
@@ -66,20 +225,293 @@ func Start() (*Env, error) {
 	}
 	env.ErrorLog = fileLog
 
+	if err := initBackups(&env, db); err != nil {
+		return nil, err
+	}
+
+	sink, err := initAudit(db)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if sink != nil {
+		env.Audit = sink
+		env.Datastore = audit.NewStore(env.Datastore, sink, env.ErrorLog)
+	}
+
+	if *schemaFile != "" {
+		attrSchema, err := schema.Open(*schemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("Start: loading schema: %w", err)
+		}
+		env.Schema = attrSchema
+	}
+
+	orgs, err := initOrganizations(db)
+	if err != nil {
+		return nil, fmt.Errorf("Start: loading organizations: %w", err)
+	}
+	env.Organizations = orgs
+
+	if *eventLogSize < 1 {
+		return nil, errors.New("Start: event-log-size must be greater than zero")
+	}
+	env.Events = events.NewBroker(*eventLogSize, eventBufferSize)
+	env.Presence = presence.NewHub(eventBufferSize)
+	publishers := events.Publishers{env.Events, env.Presence}
+	if *webhookDir != "" {
+		//the queue holds users' details, so it's encrypted like the users file.
+		keys, err := initKeys()
+		if err != nil {
+			return nil, fmt.Errorf("Start: loading webhooks: %w", err)
+		}
+		dispatcher, err := webhook.Open(*webhookDir, keys, env.ErrorLog)
+		if err != nil {
+			return nil, fmt.Errorf("Start: loading webhooks: %w", err)
+		}
+		env.Webhooks = dispatcher
+		publishers = append(publishers, dispatcher)
+	}
+	env.Datastore = events.NewStore(env.Datastore, publishers, env.ErrorLog)
+
+	//built after the organizations migration, which may rename users' organizations.
+	if err := initSuggestions(&env); err != nil {
+		return nil, fmt.Errorf("Start: loading suggestions: %w", err)
+	}
+
+	//every layer passes backups through, but only to a datastore that supports them.
+	if _, ok := db.(model.Backuper); ok {
+		env.Backups, _ = env.Datastore.(model.Backuper)
+	}
+
+	if *localesDir != "" {
+		if err := i18n.Default.LoadDir(*localesDir); err != nil {
+			return nil, fmt.Errorf("Start: loading translations: %w", err)
+		}
+	}
+
+	if err := initRules(&env); err != nil {
+		return nil, fmt.Errorf("Start: loading validation rules: %w", err)
+	}
+
+	if err := initAuth(&env); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if env.Sensitivity, err = initSensitivity(); err != nil {
+		return nil, fmt.Errorf("Start: %w", err)
+	}
+	env.StaticDir = *staticDir
+
 	return &env, nil
 }
 
+//initAuth sets up API authentication, SSO and the authorization policy from the command line flags.
+//If neither -auth nor -oidc is given, requests are neither authenticated nor authorized.
+func initAuth(env *Env) error {
+	if *authConfig != "" {
+		authenticator, err := auth.LoadConfig(*authConfig)
+		if err != nil {
+			return err
+		}
+		env.Auth = authenticator
+	}
+
+	if *oidcConfig != "" {
+		c, err := oidc.LoadConfig(*oidcConfig)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		rp, err := oidc.New(ctx, c, nil)
+		if err != nil {
+			return err
+		}
+		env.OIDC = rp
+
+		if env.Auth == nil {
+			env.Auth = &auth.Authenticator{Realm: "users"}
+		}
+		env.Auth.Sessions = rp.Sessions
+		env.Auth.LoginURL = "/login"
+	}
+
+	if env.Auth == nil {
+		return nil
+	}
+
+	env.Policy = authz.DefaultPolicy()
+	if *policyFile != "" {
+		policy, err := authz.LoadPolicy(*policyFile)
+		if err != nil {
+			return err
+		}
+		env.Policy = policy
+	}
+	return nil
+}
+
+func checkManagerDeletion() error {
+	if *managerDelete != model.ManagerDeleteBlock && *managerDelete != model.ManagerDeleteReassign {
+		return fmt.Errorf("manager-delete must be %q or %q", model.ManagerDeleteBlock, model.ManagerDeleteReassign)
+	}
+	return nil
+}
+
+//initBackups sets up scheduled backups of the datastore if a directory was given.
+func initBackups(env *Env, db model.UserDataStore) error {
+	if *backupDir == "" {
+		return nil
+	}
+	if _, ok := db.(model.Backuper); !ok {
+		return fmt.Errorf("Start: the %v datastore doesn't support backups, backup-dir can't be used", *dbType)
+	}
+	if *backupInterval <= 0 {
+		return errors.New("Start: backup-interval must be greater than zero when a backup directory is set")
+	}
+	env.BackupSchedule = &backup.Schedule{DB: db, Dir: *backupDir, Interval: *backupInterval, Keep: *backupKeep, ErrorLog: env.ErrorLog}
+	return nil
+}
+
+//organizationMigrator is implemented by datastores that can link their users to organization records, e.g. the file
+//datastore.
+type organizationMigrator interface {
+	MigrateOrganizations(orgs *fileusermodel.FileOrganizationModel) (int, error)
+}
+
+//initOrganizations opens the organizations file, if one was given, and links the existing users in db to organization records.
+func initOrganizations(db model.UserDataStore) (model.OrganizationDataStore, error) {
+	if *orgConn == "" {
+		return nil, nil
+	}
+	migrator, ok := db.(organizationMigrator)
+	if !ok {
+		return nil, fmt.Errorf("the %v datastore can't link users to organization records, org-conn can't be used", *dbType)
+	}
+	store := &fileusermodel.FileOrganizationModel{Filepath: *orgConn}
+	linked, err := migrator.MigrateOrganizations(store)
+	if err != nil {
+		return nil, err
+	}
+	if linked > 0 {
+		log.Printf("initOrganizations: linked %v users to organization records", linked)
+	}
+	return store, nil
+}
+
+//initSuggestions builds the suggestion index and wraps the datastore so the index follows every change.
+func initSuggestions(env *Env) error {
+	cfg := suggest.DefaultConfig()
+	if *suggestFile != "" {
+		var err error
+		if cfg, err = suggest.ReadConfig(*suggestFile); err != nil {
+			return err
+		}
+	}
+	index := suggest.NewIndex(cfg)
+	store, err := suggest.NewStore(context.Background(), env.Datastore, index, env.ErrorLog)
+	if err != nil {
+		return err
+	}
+	env.Suggestions = index
+	env.Datastore = store
+	return nil
+}
+
+//initRules loads the validation rule file, if one was given, and sets up its email verifier.
+func initRules(env *Env) error {
+	rules, verifier, err := loadRules()
+	env.Rules = rules
+	env.EmailVerifier = verifier
+	return err
+}
+
+//loadRules returns the built-in rules extended by the rule file, if one is given, and the file's email verifier,
+//nil if it doesn't configure one.
+func loadRules() (*validation.Ruleset, *validation.EmailVerifier, error) {
+	if *rulesFile == "" {
+		return validation.DefaultRules(), nil, nil
+	}
+
+	f, err := validation.ReadRuleFile(*rulesFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var verifier *validation.EmailVerifier
+	if f.Email != nil {
+		if verifier, err = f.Email.Verifier(net.DefaultResolver); err != nil {
+			return nil, nil, err
+		}
+	}
+	rules, err := f.Ruleset()
+	return rules, verifier, err
+}
+
+//initSensitivity returns the classification of sensitive fields from the sensitivity file, or the built-in one.
+func initSensitivity() (*sensitivity.Policy, error) {
+	if *sensitivityFile == "" {
+		return sensitivity.Default(), nil
+	}
+	policy, err := sensitivity.Load(*sensitivityFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the sensitivity classification: %w", err)
+	}
+	return policy, nil
+}
+
 //initDb constructs the database connection depending on the type specified in the command line.
 func initDb() (model.UserDataStore, error) {
-	requestedType, ok := databaseTypes[*dbType]
+	return openDb(*dbType, *connString)
+}
+
+//openDb opens a datastore of one of the registered databaseTypes.
+func openDb(name, conn string) (model.UserDataStore, error) {
+	requestedType, ok := databaseTypes[name]
 	if !ok {
-		if *dbType == "" {
+		if name == "" {
 			return nil, errors.New("initDb: database type not specified")
 		}
-		return nil, fmt.Errorf("initDb: unrecognized database type \"%v\"", *dbType)
+		return nil, fmt.Errorf("initDb: unrecognized database type \"%v\"", name)
+	}
+
+	return requestedType.InitFunc(conn)
+}
+
+//initAudit constructs the audit sink specified in the command line, or nil if auditing is turned off.
+func initAudit(db model.UserDataStore) (audit.Sink, error) {
+	return openAudit(*auditType, *auditConn, db)
+}
+
+//openAudit opens a sink of one of the registered auditTypes for the users in db, or returns nil for "none".
+func openAudit(name, conn string, db model.UserDataStore) (audit.Sink, error) {
+	requestedType, ok := auditTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("initAudit: unrecognized audit sink type \"%v\"", name)
+	}
+	return requestedType.InitFunc(conn, db)
+}
+
+func registerJSONLAudit(conn string, db model.UserDataStore) (audit.Sink, error) {
+	if conn == "" {
+		return nil, fmt.Errorf("registerJSONLAudit: no file path was provided through the %v flag", auditConnFlag)
+	}
+	policy, err := initSensitivity()
+	if err != nil {
+		return nil, fmt.Errorf("registerJSONLAudit: %w", err)
 	}
+	return &audit.FileSink{Path: conn, Sensitivity: policy}, nil
+}
+
+func registerMemoryAudit(conn string, db model.UserDataStore) (audit.Sink, error) {
+	return &audit.MemorySink{}, nil
+}
 
-	return requestedType.InitFunc()
+func registerDatastoreAudit(conn string, db model.UserDataStore) (audit.Sink, error) {
+	trail, ok := db.(model.AuditLog)
+	if !ok {
+		return nil, errors.New("registerDatastoreAudit: the database can't keep the audit trail, use another audit sink")
+	}
+	return &audit.DatastoreSink{DB: trail}, nil
 }
 
 func initLogger() (*log.Logger, error) {
@@ -91,19 +523,97 @@ func initLogger() (*log.Logger, error) {
 }
 
 //registerFileDb determines the filepath permissions given in the userFilePath argument, and if the file has the correct permissions the path is stored for future "database" uses.
-func registerFileDb() (model.UserDataStore, error) {
-	if connString == nil || *connString == "" {
+func registerFileDb(conn string) (model.UserDataStore, error) {
+	if conn == "" {
 		return nil, fmt.Errorf("registerFileDb: using a file as a database but no file path was provided through the %v flag", connFlag)
 	}
 
-	if _, err := os.Stat(*connString); os.IsNotExist(err) {
-		newFile, err := os.Create(*connString)
-		if err != nil {
+	if err := lockUsersFile(conn); err != nil {
+		return nil, fmt.Errorf("registerFileDb: %w", err)
+	}
+
+	if _, err := os.Stat(conn); os.IsNotExist(err) {
+		//Initialize JSON format so the first file read won't fail.
+		if err := ioutil.WriteFile(conn, []byte("{}"), 0600); err != nil {
 			return nil, err
 		}
-		//Initialize JSON format so the first file read won't fail.
-		newFile.Write([]byte("{}"))
 	}
 
-	return &fileusermodel.FileUserModel{Filepath: *connString}, nil
+	store, err := openUserFile(conn)
+	if err != nil {
+		return nil, fmt.Errorf("registerFileDb: %w", err)
+	}
+	if store.Keys != nil {
+		//encrypts a file that isn't encrypted yet, or moves it to a new primary key.
+		changed, err := store.RotateKey(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("registerFileDb: encrypting the users file: %w", err)
+		}
+		if changed {
+			primary, _ := store.Keys.Primary()
+			log.Printf("registerFileDb: encrypted the users file with key %v", primary)
+		}
+	}
+
+	backfilled, err := fileusermodel.BackfillMetadata(store, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("registerFileDb: backfilling user metadata: %w", err)
+	}
+	if backfilled > 0 {
+		log.Printf("registerFileDb: backfilled creation metadata for %v users", backfilled)
+	}
+
+	return store, nil
+}
+
+//usersFileLocks holds the locks this process has taken on users files by their absolute path, so opening a file
+//twice doesn't trip over its own lock.
+var usersFileLocks = struct {
+	sync.Mutex
+	held map[string]*fileusermodel.FileLock
+}{held: make(map[string]*fileusermodel.FileLock)}
+
+//lockUsersFile takes the lock of the users file at path for the rest of the process's life. The server and userctl
+//both take it, so userctl can't change the file while a server is running: the server's search and suggestion
+//indexes wouldn't see the change, and no events or webhooks would be sent for it.
+func lockUsersFile(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	usersFileLocks.Lock()
+	defer usersFileLocks.Unlock()
+	if _, ok := usersFileLocks.held[abs]; ok {
+		return nil
+	}
+	lock, err := fileusermodel.Lock(path)
+	if err == fileusermodel.ErrLocked {
+		return fmt.Errorf("%v is in use by another process, e.g. a running server: stop it first, or use its HTTP API instead", path)
+	}
+	if err != nil {
+		return err
+	}
+	usersFileLocks.held[abs] = lock
+	return nil
+}
+
+//openUserFile returns the file datastore at path, encrypted with the keys from the keyfile or the environment.
+func openUserFile(path string) (*fileusermodel.FileUserModel, error) {
+	keys, err := initKeys()
+	if err != nil {
+		return nil, err
+	}
+	return &fileusermodel.FileUserModel{Filepath: path, Keys: keys}, nil
+}
+
+//initKeys loads the users file's encryption keys from the keyfile, or else the environment. It returns nil if
+//neither is set, leaving the file unencrypted.
+func initKeys() (*encryption.Keyring, error) {
+	if *keyFile != "" {
+		return encryption.LoadKeyfile(*keyFile)
+	}
+	if keys := os.Getenv(KeysEnv); keys != "" {
+		return encryption.ParseKeys(keys)
+	}
+	return nil, nil
 }
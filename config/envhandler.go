@@ -1,15 +1,25 @@
 package config
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/nmalensek/go-user-form/i18n"
+	"github.com/nmalensek/go-user-form/sensitivity"
+)
 
 //MakeHandler checks the requested path and returns 404 if not found. Otherwise, it calls the handler function passed in that requires an environment variable.
+//The request's context carries the language negotiated from its Accept-Language header, see i18n.LanguageFromContext,
+//and the environment's sensitivity classification, so errors logged for the request are redacted.
 func MakeHandler(fn func(w http.ResponseWriter, r *http.Request, e *Env), env *Env) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		m := validPath.FindStringSubmatch(r.URL.Path)
-		if m == nil {
-			http.NotFound(w, r)
-			return
+		for _, p := range validPaths {
+			if p.MatchString(r.URL.Path) {
+				lang := i18n.Default.Match(r.Header.Get("Accept-Language"))
+				ctx := sensitivity.WithPolicy(i18n.WithLanguage(r.Context(), lang), env.Sensitivity)
+				fn(w, r.WithContext(ctx), env)
+				return
+			}
 		}
-		fn(w, r, env)
+		http.NotFound(w, r)
 	}
 }
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nmalensek/go-user-form/audit"
+	"github.com/nmalensek/go-user-form/fileusermodel"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/schema"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//OpenDatastore opens the datastore chosen by the command line for tools that manage users directly. Changes are
+//recorded in the audit trail like the server's, but no events are published and no indexes are kept, so the file
+//datastore is locked against a server using the same file: the server's indexes wouldn't see the tool's changes and
+//its event subscribers and webhooks wouldn't hear of them. Opening fails while a server holds the lock.
+func OpenDatastore() (model.UserDataStore, error) {
+	db, sink, err := Backends()
+	if err != nil {
+		return nil, fmt.Errorf("OpenDatastore: %w", err)
+	}
+	if sink != nil {
+		//the tool has no log file, failures to record a change are reported on stderr instead.
+		db = audit.NewStore(db, sink, log.New(os.Stderr, "ERROR: ", 0))
+	}
+	return db, nil
+}
+
+//Backends opens the datastore and audit sink chosen by the command line as they are, without the audit trail
+//being recorded for changes to the datastore. The sink is nil if auditing is turned off. Like OpenDatastore, it
+//fails while a server holds the datastore's lock.
+func Backends() (model.UserDataStore, audit.Sink, error) {
+	db, err := initDb()
+	if err != nil {
+		return nil, nil, err
+	}
+	sink, err := initAudit(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, sink, nil
+}
+
+//LockDatastore takes the lock that OpenDatastore takes, without opening the datastore, for tools that change files
+//the datastore depends on before opening it, e.g. its keyfile. It fails while a server holds the lock.
+func LockDatastore() error {
+	if *dbType != fileDb {
+		return nil
+	}
+	if err := lockUsersFile(*connString); err != nil {
+		return fmt.Errorf("LockDatastore: %w", err)
+	}
+	return nil
+}
+
+//OpenBackend opens a datastore of one of the types the db flag accepts, e.g. the target of a migration.
+func OpenBackend(dbType, conn string) (model.UserDataStore, error) {
+	return openDb(dbType, conn)
+}
+
+//OpenAuditSink opens a sink of one of the types the audit flag accepts for the users in db, or returns nil for "none".
+func OpenAuditSink(sinkType, conn string, db model.UserDataStore) (audit.Sink, error) {
+	return openAudit(sinkType, conn, db)
+}
+
+//ValidationRules returns the rules the server checks users against: the rule file's, its email verifier looking up
+//addresses within ctx, the custom attribute schema and, once organizations have been migrated, the known organizations,
+//before that no organization IDs. Unlike Start it never migrates anything.
+func ValidationRules(ctx context.Context) (*validation.Ruleset, error) {
+	rules, verifier, err := loadRules()
+	if err != nil {
+		return nil, fmt.Errorf("ValidationRules: %w", err)
+	}
+	if verifier != nil {
+		rules = rules.With(validation.AnyOperation, verifier.Rule(ctx))
+	}
+
+	if *schemaFile != "" {
+		attrSchema, err := schema.Open(*schemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("ValidationRules: loading schema: %w", err)
+		}
+		rules = rules.With(validation.AnyOperation, validation.AttributeRule(attrSchema.Current()))
+	}
+
+	if *orgConn != "" {
+		orgs, err := (&fileusermodel.FileOrganizationModel{Filepath: *orgConn}).GetAll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("ValidationRules: loading organizations: %w", err)
+		}
+		if len(orgs) > 0 {
+			return rules.With(validation.AnyOperation, &validation.OrganizationRule{Organizations: orgs}), nil
+		}
+	}
+	return rules.With(validation.AnyOperation, validation.UnmanagedOrganizationRule), nil
+}
+
+//ManagerDeletion returns how the reports of a deleted manager are handled, model.ManagerDeleteBlock or
+//model.ManagerDeleteReassign.
+func ManagerDeletion() (string, error) {
+	return *managerDelete, checkManagerDeletion()
+}
+
+//BackupDir returns the directory scheduled backups are saved in, empty if scheduled backups are turned off.
+func BackupDir() string {
+	return *backupDir
+}
+
+//WebhookDir returns the directory the webhooks and their delivery queue are kept in, empty if webhooks are turned off.
+func WebhookDir() string {
+	return *webhookDir
+}
+
+//Keyfile returns the path of the keyfile the users file is encrypted with, empty if keys aren't read from a keyfile.
+func Keyfile() string {
+	return *keyFile
+}
@@ -0,0 +1,188 @@
+//Package encryption encrypts data at rest with envelope encryption: every time data is sealed it's encrypted with a
+//new random data key using AES-256-GCM, and the data key is itself encrypted ("wrapped") with a master key from a
+//Keyring. The sealed data names the master key by ID, so the master key can be rotated by adding a new primary key
+//while keeping the old ones for reading, and re-wrapping only the data key, not the data.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//Format identifies sealed data.
+const Format = "go-user-form-encrypted"
+
+//Version is the version of the sealed format written by this package.
+const Version = 1
+
+//KeySize is the size of master and data keys, AES-256.
+const KeySize = 32
+
+//Encryption errors.
+var (
+	ErrNotSealed = errors.New("encryption: the data isn't encrypted")
+	ErrDecrypt   = errors.New("encryption: the data can't be decrypted, it's damaged or was encrypted with a different key")
+)
+
+//UnknownKeyError is returned when data was sealed with a master key that isn't in the keyring.
+type UnknownKeyError struct {
+	KeyID string
+}
+
+func (e *UnknownKeyError) Error() string {
+	return fmt.Sprintf("encryption: the data was encrypted with key %q, which isn't in the keyring", e.KeyID)
+}
+
+//envelope is sealed data as it's stored. Byte slices are base64 encoded by encoding/json.
+type envelope struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	KeyID   string `json:"keyId"`
+	//WrappedKey is the data key encrypted with the master key, prefixed with its nonce.
+	WrappedKey []byte `json:"wrappedKey"`
+	//Data is the data encrypted with the data key, prefixed with its nonce.
+	Data []byte `json:"data"`
+}
+
+//IsSealed reports whether data was written by Seal.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(`{"format":"`+Format+`"`))
+}
+
+//GenerateKey returns a new random key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+//Seal encrypts plaintext with a new data key wrapped with the keyring's primary key.
+func (k *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	id, master, err := k.primaryKey()
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	data, err := encrypt(dataKey, plaintext, []byte(Format))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := encrypt(master, dataKey, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Format: Format, Version: Version, KeyID: id, WrappedKey: wrapped, Data: data})
+}
+
+//Open decrypts data written by Seal with any key in the keyring.
+func (k *Keyring) Open(sealed []byte) ([]byte, error) {
+	env, dataKey, err := k.unwrap(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(dataKey, env.Data, []byte(Format))
+}
+
+//KeyID returns the ID of the master key data written by Seal is encrypted with.
+func KeyID(sealed []byte) (string, error) {
+	env, err := readEnvelope(sealed)
+	return env.KeyID, err
+}
+
+//Rewrap re-encrypts the data key of sealed data with the keyring's primary key, leaving the data itself as it is.
+//changed is false, and sealed is returned as it is, if the primary key was already used.
+func (k *Keyring) Rewrap(sealed []byte) (rewrapped []byte, changed bool, err error) {
+	id, master, err := k.primaryKey()
+	if err != nil {
+		return nil, false, err
+	}
+	env, dataKey, err := k.unwrap(sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	if env.KeyID == id {
+		return sealed, false, nil
+	}
+	if env.WrappedKey, err = encrypt(master, dataKey, []byte(id)); err != nil {
+		return nil, false, err
+	}
+	env.KeyID = id
+	rewrapped, err = json.Marshal(env)
+	return rewrapped, err == nil, err
+}
+
+//unwrap reads the envelope of sealed data and decrypts its data key.
+func (k *Keyring) unwrap(sealed []byte) (envelope, []byte, error) {
+	env, err := readEnvelope(sealed)
+	if err != nil {
+		return env, nil, err
+	}
+	master, err := k.key(env.KeyID)
+	if err != nil {
+		return env, nil, err
+	}
+	dataKey, err := decrypt(master, env.WrappedKey, []byte(env.KeyID))
+	return env, dataKey, err
+}
+
+func readEnvelope(sealed []byte) (envelope, error) {
+	var env envelope
+	if !IsSealed(sealed) {
+		return env, ErrNotSealed
+	}
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		return env, ErrDecrypt
+	}
+	if env.Version > Version {
+		return env, fmt.Errorf("encryption: the data is in version %v of the format, this server reads up to version %v", env.Version, Version)
+	}
+	return env, nil
+}
+
+//encrypt encrypts plaintext with AES-GCM under key, returning the nonce followed by the ciphertext.
+func encrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func decrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,151 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newKeyring(t *testing.T, primary string, ids ...string) (*Keyring, map[string][]byte) {
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		key, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[id] = key
+	}
+	k, err := NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, keys
+}
+
+func TestSealOpen(t *testing.T) {
+	k, keys := newKeyring(t, "a", "a")
+	plaintext := []byte(`{"1":{"email":"ann@example.com"}}`)
+
+	sealed, err := k.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || bytes.Contains(sealed, []byte("ann@example.com")) {
+		t.Errorf("got %s want the data encrypted", sealed)
+	}
+	if IsSealed(plaintext) {
+		t.Errorf("plain JSON shouldn't look sealed")
+	}
+	got, err := k.Open(sealed)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("got %s, %v want %s", got, err, plaintext)
+	}
+	if again, _ := k.Seal(plaintext); bytes.Equal(again, sealed) {
+		t.Errorf("every seal should use a new data key and nonce")
+	}
+
+	tampered := bytes.Replace(sealed, []byte(`"data":"`), []byte(`"data":"AAAA`), 1)
+	if _, err := k.Open(tampered); err != ErrDecrypt {
+		t.Errorf("got %v want %v", err, ErrDecrypt)
+	}
+	if _, err := k.Open(plaintext); err != ErrNotSealed {
+		t.Errorf("got %v want %v", err, ErrNotSealed)
+	}
+
+	//a keyring with a different key under the same ID can't unwrap the data key.
+	other, _ := newKeyring(t, "a", "a")
+	if _, err := other.Open(sealed); err != ErrDecrypt {
+		t.Errorf("got %v want %v", err, ErrDecrypt)
+	}
+	missing, _ := newKeyring(t, "b", "b")
+	var unknown *UnknownKeyError
+	if _, err := missing.Open(sealed); !errors.As(err, &unknown) || unknown.KeyID != "a" {
+		t.Errorf("got %v want an unknown key a", err)
+	}
+
+	rotated, err := NewKeyring("b", map[string][]byte{"a": keys["a"], "b": bytes.Repeat([]byte{1}, KeySize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, changed, err := rotated.Rewrap(sealed)
+	if err != nil || !changed {
+		t.Fatalf("got %v, %v want the data key rewrapped", changed, err)
+	}
+	if id, _ := KeyID(rewrapped); id != "b" {
+		t.Errorf("got key %v want b", id)
+	}
+	onlyB, _ := NewKeyring("b", map[string][]byte{"b": bytes.Repeat([]byte{1}, KeySize)})
+	if got, err := onlyB.Open(rewrapped); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("got %s, %v want %s", got, err, plaintext)
+	}
+	if _, changed, _ := rotated.Rewrap(rewrapped); changed {
+		t.Errorf("data already wrapped with the primary key shouldn't change")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	a, b := bytes.Repeat([]byte{1}, KeySize), bytes.Repeat([]byte{2}, KeySize)
+	k, err := ParseKeys("new:" + base64.StdEncoding.EncodeToString(b) + ", old:" + base64.StdEncoding.EncodeToString(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primary, _ := k.Primary(); primary != "new" || len(k.keys) != 2 {
+		t.Errorf("got primary %v and %v keys want new and 2", primary, len(k.keys))
+	}
+
+	for _, bad := range []string{"", "nokey", "short:" + base64.StdEncoding.EncodeToString([]byte("short")), "x:not base64"} {
+		if _, err := ParseKeys(bad); err == nil {
+			t.Errorf("%q should be refused", bad)
+		}
+	}
+}
+
+func TestKeyfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	first, _ := GenerateKey()
+	if err := AddKey(path, "first", first); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKeyfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := k.Seal([]byte("data"))
+
+	//the keyring picks up a key added to the file while it's in use.
+	second, _ := GenerateKey()
+	if err := AddKey(path, "second", second); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	if primary, _ := k.Primary(); primary != "second" {
+		t.Errorf("got primary %v want second", primary)
+	}
+	if got, err := k.Open(sealed); err != nil || string(got) != "data" {
+		t.Errorf("got %s, %v want the data sealed with the first key", got, err)
+	}
+	if err := AddKey(path, "second", second); err == nil {
+		t.Errorf("adding a key ID twice should fail")
+	}
+
+	retired, err := RetireKeys(path)
+	if err != nil || len(retired) != 1 || retired[0] != "first" {
+		t.Errorf("got %v, %v want first retired", retired, err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	var unknown *UnknownKeyError
+	if _, err := k.Open(sealed); !errors.As(err, &unknown) {
+		t.Errorf("got %v want the retired key unknown", err)
+	}
+}
@@ -0,0 +1,214 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Keyring holds master keys by ID, one of which is the primary key that new data is sealed with. A keyring loaded
+//from a keyfile reloads it whenever it changes, so a running server picks up a rotated key without restarting.
+//It's safe for concurrent use.
+type Keyring struct {
+	//path is the keyfile the keys were loaded from, empty if they weren't loaded from a file.
+	path string
+
+	mu sync.Mutex
+	//modTime and size identify the version of the keyfile that was read.
+	modTime time.Time
+	size    int64
+	primary string
+	keys    map[string][]byte
+}
+
+//keyfile is the JSON format of a keyfile, e.g. {"primary":"2020-01","keys":{"2020-01":"<base64 key>"}}.
+//Byte slices are base64 encoded by encoding/json.
+type keyfile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+//NewKeyring returns a keyring of keys, sealing new data with the primary key.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if err := check(keyfile{Primary: primary, Keys: keys}); err != nil {
+		return nil, err
+	}
+	return &Keyring{primary: primary, keys: keys}, nil
+}
+
+//ParseKeys reads keys in the form "id:base64key[,id:base64key...]", as given in an environment variable. The first
+//key is the primary key.
+func ParseKeys(s string) (*Keyring, error) {
+	f := keyfile{Keys: make(map[string][]byte)}
+	for _, part := range strings.Split(s, ",") {
+		i := strings.Index(part, ":")
+		if i < 0 {
+			return nil, errors.New("encryption: keys must be given as id:base64key, separated by commas")
+		}
+		id := strings.TrimSpace(part[:i])
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(part[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("encryption: key %q isn't valid base64: %w", id, err)
+		}
+		if f.Primary == "" {
+			f.Primary = id
+		}
+		f.Keys[id] = key
+	}
+	return NewKeyring(f.Primary, f.Keys)
+}
+
+//LoadKeyfile reads the keys in a keyfile.
+func LoadKeyfile(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+//AddKey adds a key to the keyfile at path and makes it the primary key, creating the keyfile if it doesn't exist.
+//The other keys are kept so data sealed with them can still be read.
+func AddKey(path, id string, key []byte) error {
+	f, err := readKeyfile(path)
+	if os.IsNotExist(err) {
+		f, err = keyfile{Keys: make(map[string][]byte)}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Keys[id]; ok {
+		return fmt.Errorf("encryption: the keyfile already has a key %q", id)
+	}
+	f.Primary = id
+	f.Keys[id] = key
+	return writeKeyfile(path, f)
+}
+
+//RetireKeys removes every key but the primary key from the keyfile at path, and returns the IDs of the removed keys.
+//Data sealed with them can no longer be read.
+func RetireKeys(path string) ([]string, error) {
+	f, err := readKeyfile(path)
+	if err != nil {
+		return nil, err
+	}
+	var retired []string
+	for id := range f.Keys {
+		if id != f.Primary {
+			retired = append(retired, id)
+			delete(f.Keys, id)
+		}
+	}
+	return retired, writeKeyfile(path, f)
+}
+
+//Primary returns the ID of the primary key.
+func (k *Keyring) Primary() (string, error) {
+	id, _, err := k.primaryKey()
+	return id, err
+}
+
+func (k *Keyring) primaryKey() (string, []byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.refresh(); err != nil {
+		return "", nil, err
+	}
+	return k.primary, k.keys[k.primary], nil
+}
+
+func (k *Keyring) key(id string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, &UnknownKeyError{KeyID: id}
+	}
+	return key, nil
+}
+
+//refresh reloads the keyfile if it has changed since it was last read. k.mu must be held, unless k isn't shared yet.
+func (k *Keyring) refresh() error {
+	if k.path == "" {
+		return nil
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return fmt.Errorf("encryption: reading the keyfile: %w", err)
+	}
+	if k.keys != nil && info.ModTime().Equal(k.modTime) && info.Size() == k.size {
+		return nil
+	}
+	f, err := readKeyfile(k.path)
+	if err != nil {
+		return err
+	}
+	k.modTime, k.size, k.primary, k.keys = info.ModTime(), info.Size(), f.Primary, f.Keys
+	return nil
+}
+
+func readKeyfile(path string) (keyfile, error) {
+	var f keyfile
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("encryption: reading the keyfile: %w", err)
+	}
+	return f, check(f)
+}
+
+//writeKeyfile replaces the keyfile at path, writing to a temporary file first so a running server never reads
+//a partly written keyfile.
+func writeKeyfile(path string, f keyfile) error {
+	if err := check(f); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func check(f keyfile) error {
+	if f.Primary == "" {
+		return errors.New("encryption: no primary key")
+	}
+	if _, ok := f.Keys[f.Primary]; !ok {
+		return fmt.Errorf("encryption: the primary key %q isn't one of the keys", f.Primary)
+	}
+	for id, key := range f.Keys {
+		if id == "" || strings.ContainsAny(id, ":,") {
+			return fmt.Errorf("encryption: key IDs must be non-empty and can't contain ':' or ',', got %q", id)
+		}
+		if len(key) != KeySize {
+			return fmt.Errorf("encryption: key %q is %v bytes, keys must be %v bytes", id, len(key), KeySize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+//Entry is an event in a Broker's log, numbered in the order it was published starting from 1.
+type Entry struct {
+	Seq   int64
+	Event Event
+}
+
+//Broker is a Publisher that fans events out to any number of subscribers and keeps the most recent ones,
+//so a subscriber that reconnects can catch up on what it missed. Publish never waits for subscribers:
+//one that falls too far behind is dropped, its channel is closed and it has to subscribe again.
+type Broker struct {
+	//LogSize is how many recent events are kept for catching up, and BufferSize how many events
+	//a subscriber may fall behind before it's dropped.
+	LogSize    int
+	BufferSize int
+
+	mu sync.Mutex
+	//log is a ring of LogSize entries, the entry numbered seq is kept at index (seq-1) % LogSize until it's overwritten.
+	log  []Entry
+	seq  int64
+	subs map[*Subscription]struct{}
+}
+
+//Subscription receives the events published after it was made. C is closed when the subscriber is dropped
+//or unsubscribes.
+type Subscription struct {
+	C <-chan Entry
+
+	c      chan Entry
+	closed bool
+}
+
+//NewBroker returns a broker keeping the last logSize events, with room for bufferSize events per subscriber.
+func NewBroker(logSize, bufferSize int) *Broker {
+	return &Broker{LogSize: logSize, BufferSize: bufferSize, subs: make(map[*Subscription]struct{})}
+}
+
+//Publish numbers e, adds it to the log and offers it to every subscriber.
+func (b *Broker) Publish(ctx context.Context, e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	entry := Entry{Seq: b.seq, Event: e}
+	if b.LogSize > 0 {
+		if len(b.log) != b.LogSize {
+			b.log = make([]Entry, b.LogSize)
+		}
+		b.log[b.index(b.seq)] = entry
+	}
+
+	for s := range b.subs {
+		select {
+		case s.c <- entry:
+		default:
+			b.drop(s)
+		}
+	}
+}
+
+//Subscribe starts a subscription. If after is greater than zero, the logged events published after the one with that
+//number are returned so the subscriber can send them before reading C. complete is false if some of those events
+//have already left the log, the subscriber has missed changes and should reload everything.
+func (b *Broker) Subscribe(after int64) (s *Subscription, missed []Entry, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Entry, b.BufferSize)
+	s = &Subscription{C: c, c: c}
+	b.subs[s] = struct{}{}
+
+	complete = true
+	if after <= 0 {
+		return s, nil, complete
+	}
+	oldest := b.oldest()
+	if after > b.seq || after+1 < oldest {
+		//an unknown number, e.g. from before a restart, or events that are no longer logged.
+		complete = false
+	}
+	from := after + 1
+	if from < oldest {
+		from = oldest
+	}
+	for n := from; n <= b.seq; n++ {
+		missed = append(missed, b.log[b.index(n)])
+	}
+	return s, missed, complete
+}
+
+//oldest returns the number of the oldest event in the log, or seq+1 if the log is empty.
+func (b *Broker) oldest() int64 {
+	kept := int64(len(b.log))
+	if b.seq < kept {
+		kept = b.seq
+	}
+	return b.seq - kept + 1
+}
+
+//index returns where the entry numbered seq is kept in the log.
+func (b *Broker) index(seq int64) int {
+	return int((seq - 1) % int64(len(b.log)))
+}
+
+//Unsubscribe ends the subscription.
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(s)
+}
+
+//Subscribers returns the number of active subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func (b *Broker) drop(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(b.subs, s)
+	close(s.c)
+}
@@ -0,0 +1,236 @@
+//Package events announces changes to users, so other parts of the system (such as webhooks) can react to them
+//without the datastores or handlers knowing about them.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Event types.
+const (
+	UserCreated = "user.created"
+	UserUpdated = "user.updated"
+	UserDeleted = "user.deleted"
+	//UsersRestored is published once when every user is replaced with those in a backup, subscribers keeping
+	//copies of the users should reload them all.
+	UsersRestored = "users.restored"
+)
+
+//Types are all the event types, in the order they're documented.
+var Types = []string{UserCreated, UserUpdated, UserDeleted, UsersRestored}
+
+//Event is a change to a user that has been saved. User is the user after the change, or their last values if they were
+//deleted, and empty for UsersRestored. Previous is set for updates and holds the user's values before the change.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Time      time.Time   `json:"time"`
+	Actor     string      `json:"actor,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
+	User      model.User  `json:"user"`
+	Previous  *model.User `json:"previous,omitempty"`
+}
+
+//Publisher receives events. Publish is called after the change has been saved, so it can't fail the change,
+//and it should return quickly since the request that made the change waits for it.
+type Publisher interface {
+	Publish(ctx context.Context, e Event)
+}
+
+//Publishers sends each event to every publisher in the list, in order.
+type Publishers []Publisher
+
+//Publish sends e to every publisher.
+func (p Publishers) Publish(ctx context.Context, e Event) {
+	for _, pub := range p {
+		pub.Publish(ctx, e)
+	}
+}
+
+//NewID returns a random identifier for an event or a delivery.
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//Store is a UserDataStore that publishes an event for every successful change made through it. Restoring a user
+//publishes UserCreated since, to anyone who acted on their deletion, they're a new user. Purges publish nothing,
+//the users were announced as deleted when they were soft deleted. Changes made through the store are serialized, so
+//an event's values before and after are those of its change alone, and events are published in the order of the changes.
+type Store struct {
+	Next      model.UserDataStore
+	Publisher Publisher
+	ErrorLog  *log.Logger
+
+	//mu is held from reading the values before a change until the change is published.
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+//NewStore wraps next so changes made through it are published to pub.
+func NewStore(next model.UserDataStore, pub Publisher, errorLog *log.Logger) *Store {
+	return &Store{Next: next, Publisher: pub, ErrorLog: errorLog, now: time.Now}
+}
+
+//GetAll retrieves all saved users.
+func (s *Store) GetAll(ctx context.Context) ([]model.User, error) {
+	return s.Next.GetAll(ctx)
+}
+
+//GetDeleted retrieves all deleted users.
+func (s *Store) GetDeleted(ctx context.Context) ([]model.User, error) {
+	return s.Next.GetDeleted(ctx)
+}
+
+//Create creates the user, then publishes UserCreated.
+func (s *Store) Create(ctx context.Context, u *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Next.Create(ctx, u); err != nil {
+		return err
+	}
+	s.publish(ctx, UserCreated, *u, nil)
+	return nil
+}
+
+//Edit modifies the user, then publishes UserUpdated with the user's values before and after.
+func (s *Store) Edit(ctx context.Context, u model.User, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before, err := s.find(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	if err := s.Next.Edit(ctx, u, id); err != nil {
+		return err
+	}
+
+	//read back the stored result, the datastore decides how a partial edit is merged.
+	after, err := s.find(model.Detach(ctx), id, false)
+	if err != nil {
+		s.logf("events: could not read user %v after edit: %v", id, err)
+		return nil
+	}
+	s.publish(ctx, UserUpdated, after, &before)
+	return nil
+}
+
+//Delete deletes the user, then publishes UserDeleted.
+func (s *Store) Delete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before, err := s.find(ctx, id, false)
+	if err != nil && err.Error() != model.CouldNotFind {
+		return err
+	}
+	if err := s.Next.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	if after, err := s.find(model.Detach(ctx), id, true); err == nil {
+		before = after
+	}
+	s.publish(ctx, UserDeleted, before, nil)
+	return nil
+}
+
+//Restore restores the deleted user, then publishes UserCreated.
+func (s *Store) Restore(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Next.Restore(ctx, id); err != nil {
+		return err
+	}
+	after, err := s.find(model.Detach(ctx), id, false)
+	if err != nil {
+		s.logf("events: could not read user %v after restore: %v", id, err)
+		return nil
+	}
+	s.publish(ctx, UserCreated, after, nil)
+	return nil
+}
+
+//Purge permanently removes users deleted before the given time.
+func (s *Store) Purge(ctx context.Context, deletedBefore time.Time) ([]model.User, error) {
+	return s.Next.Purge(ctx, deletedBefore)
+}
+
+//Backup writes a backup of the users to w, if the next datastore supports backups.
+func (s *Store) Backup(ctx context.Context, w io.Writer) error {
+	return backup.Backup(ctx, s.Next, w)
+}
+
+//RestoreBackup replaces every user with those in the backup read from r, then publishes UsersRestored.
+func (s *Store) RestoreBackup(ctx context.Context, r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := backup.Restore(ctx, s.Next, r); err != nil {
+		return err
+	}
+	s.publish(ctx, UsersRestored, model.User{}, nil)
+	return nil
+}
+
+//Reports retrieves the users below the given user.
+func (s *Store) Reports(ctx context.Context, id int, transitive bool) ([]model.User, error) {
+	return s.Next.Reports(ctx, id, transitive)
+}
+
+//Chain retrieves the managers above the given user.
+func (s *Store) Chain(ctx context.Context, id int) ([]model.User, error) {
+	return s.Next.Chain(ctx, id)
+}
+
+//Search retrieves the users matching query.
+func (s *Store) Search(ctx context.Context, query string) ([]model.User, error) {
+	return s.Next.Search(ctx, query)
+}
+
+func (s *Store) publish(ctx context.Context, eventType string, u model.User, previous *model.User) {
+	e := Event{
+		ID:        NewID(),
+		Type:      eventType,
+		Time:      s.now().UTC(),
+		Actor:     model.ActorFromContext(ctx),
+		RequestID: model.RequestIDFromContext(ctx),
+		User:      u,
+		Previous:  previous,
+	}
+	//the change has been made, so publish it even if the request has since been cancelled.
+	s.Publisher.Publish(model.Detach(ctx), e)
+}
+
+//find looks for the user among the current users, or the deleted users if deleted is set.
+func (s *Store) find(ctx context.Context, id int, deleted bool) (model.User, error) {
+	get := s.Next.GetAll
+	if deleted {
+		get = s.Next.GetDeleted
+	}
+	users, err := get(ctx)
+	if err != nil {
+		return model.User{}, err
+	}
+	for _, u := range users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return model.User{}, errors.New(model.CouldNotFind)
+}
+
+func (s *Store) logf(format string, v ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, v...)
+	}
+}
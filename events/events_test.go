@@ -0,0 +1,224 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//mapStore is a minimal in-memory UserDataStore for exercising the events decorator.
+type mapStore struct {
+	model.UserDataStore
+	users map[int]model.User
+}
+
+func (m *mapStore) list(deleted bool) []model.User {
+	list := make([]model.User, 0, len(m.users))
+	for _, u := range m.users {
+		if u.IsDeleted() == deleted {
+			list = append(list, u)
+		}
+	}
+	return list
+}
+
+func (m *mapStore) GetAll(ctx context.Context) ([]model.User, error) {
+	return m.list(false), nil
+}
+
+func (m *mapStore) GetDeleted(ctx context.Context) ([]model.User, error) {
+	return m.list(true), nil
+}
+
+func (m *mapStore) Create(ctx context.Context, u *model.User) error {
+	u.ID = len(m.users) + 1
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *mapStore) Edit(ctx context.Context, u model.User, id int) error {
+	saved, ok := m.users[id]
+	if !ok {
+		return errors.New(model.CouldNotFind)
+	}
+	saved.Email = u.Email
+	m.users[id] = saved
+	return nil
+}
+
+func (m *mapStore) Delete(ctx context.Context, id int) error {
+	saved := m.users[id]
+	now := time.Now()
+	saved.DeletedAt = &now
+	m.users[id] = saved
+	return nil
+}
+
+func (m *mapStore) Restore(ctx context.Context, id int) error {
+	saved := m.users[id]
+	saved.DeletedAt = nil
+	m.users[id] = saved
+	return nil
+}
+
+type recorder struct {
+	events []Event
+}
+
+func (r *recorder) Publish(ctx context.Context, e Event) {
+	r.events = append(r.events, e)
+}
+
+func TestStorePublishes(t *testing.T) {
+	rec := &recorder{}
+	store := NewStore(&mapStore{users: make(map[int]model.User)}, Publishers{rec}, nil)
+	ctx := model.WithRequestID(model.WithActor(context.Background(), "admin"), "req-1")
+
+	u := model.User{Email: "a@b.com"}
+	store.Create(ctx, &u)
+	store.Edit(ctx, model.User{Email: "c@d.com"}, u.ID)
+	if err := store.Edit(ctx, model.User{Email: "x@y.com"}, 99); err == nil {
+		t.Errorf("editing a missing user should fail")
+	}
+	store.Delete(ctx, u.ID)
+	store.Restore(ctx, u.ID)
+
+	types := make([]string, 0)
+	for _, e := range rec.events {
+		types = append(types, e.Type)
+		if e.Actor != "admin" || e.RequestID != "req-1" || e.ID == "" || e.User.ID != u.ID {
+			t.Errorf("event %+v is missing its actor, request, ID or user", e)
+		}
+	}
+	if want := []string{UserCreated, UserUpdated, UserDeleted, UserCreated}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("got events %v want %v", types, want)
+	}
+
+	updated := rec.events[1]
+	if updated.Previous == nil || updated.Previous.Email != "a@b.com" || updated.User.Email != "c@d.com" {
+		t.Errorf("got update %+v want previous a@b.com and current c@d.com", updated)
+	}
+	if rec.events[2].User.DeletedAt == nil {
+		t.Errorf("the deleted event should carry the deletion time")
+	}
+}
+
+//gateStore holds its first edit until release is closed, so a second edit can be attempted while it's in progress.
+type gateStore struct {
+	*mapStore
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (g *gateStore) Edit(ctx context.Context, u model.User, id int) error {
+	first := false
+	g.once.Do(func() { first = true })
+	if first {
+		close(g.entered)
+		<-g.release
+	}
+	return g.mapStore.Edit(ctx, u, id)
+}
+
+func TestStoreSerializesEdits(t *testing.T) {
+	rec := &recorder{}
+	gate := &gateStore{mapStore: &mapStore{users: map[int]model.User{1: {ID: 1, Email: "ann@a.com"}}}, entered: make(chan struct{}), release: make(chan struct{})}
+	store := NewStore(gate, rec, nil)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		store.Edit(ctx, model.User{Email: "ann@b.com"}, 1)
+	}()
+	<-gate.entered
+	go func() {
+		defer wg.Done()
+		store.Edit(ctx, model.User{Email: "ann@c.com"}, 1)
+	}()
+	//give the second edit time to read the user, were it not held back until the first is published.
+	time.Sleep(20 * time.Millisecond)
+	close(gate.release)
+	wg.Wait()
+
+	if len(rec.events) != 2 {
+		t.Fatalf("got %v events want 2", len(rec.events))
+	}
+	first, second := rec.events[0], rec.events[1]
+	if first.Previous.Email != "ann@a.com" || first.User.Email != "ann@b.com" || second.Previous.Email != "ann@b.com" || second.User.Email != "ann@c.com" {
+		t.Errorf("each event should hold its own edit, got %+v then %+v", first, second)
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(3, 2)
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		b.Publish(ctx, Event{Type: UserCreated})
+	}
+
+	for _, tt := range []struct {
+		after    int64
+		missed   []int64
+		complete bool
+	}{
+		{after: 0, missed: []int64{}, complete: true},
+		{after: 1, missed: []int64{2, 3, 4}, complete: true},
+		{after: 3, missed: []int64{4}, complete: true},
+		{after: 4, missed: []int64{}, complete: true},
+		//a number from before a restart.
+		{after: 9, missed: []int64{}, complete: false},
+	} {
+		s, missed, complete := b.Subscribe(tt.after)
+		b.Unsubscribe(s)
+		got := make([]int64, 0, len(missed))
+		for _, e := range missed {
+			got = append(got, e.Seq)
+		}
+		if !reflect.DeepEqual(got, tt.missed) || complete != tt.complete {
+			t.Errorf("after %v: got %v, %v want %v, %v", tt.after, got, complete, tt.missed, tt.complete)
+		}
+	}
+
+	b.Publish(ctx, Event{Type: UserCreated})
+	gap, _, complete := b.Subscribe(1)
+	b.Unsubscribe(gap)
+	if complete {
+		t.Errorf("event 2 has left the log, the backlog after 1 should be incomplete")
+	}
+
+	slow, _, _ := b.Subscribe(0)
+	for i := 0; i < 3; i++ {
+		b.Publish(ctx, Event{Type: UserUpdated})
+	}
+	//slow never read, so it was dropped on the third event instead of holding up the publisher.
+	got := make([]int64, 0)
+	for e := range slow.C {
+		got = append(got, e.Seq)
+	}
+	if want := []int64{6, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v for the slow subscriber want %v", got, want)
+	}
+	b.Unsubscribe(slow)
+
+	//the log wraps around, the missed events still come oldest first.
+	after5, missed, complete := b.Subscribe(5)
+	b.Unsubscribe(after5)
+	got = got[:0]
+	for _, e := range missed {
+		got = append(got, e.Seq)
+	}
+	if want := []int64{6, 7, 8}; !reflect.DeepEqual(got, want) || !complete {
+		t.Errorf("got %v, %v after 5 want %v, true", got, complete, want)
+	}
+	if b.Subscribers() != 0 {
+		t.Errorf("got %v subscribers want none", b.Subscribers())
+	}
+}
@@ -0,0 +1,124 @@
+package fileusermodel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/nmalensek/go-user-form/encryption"
+)
+
+//auditSuffix names the file the audit trail is kept in, next to the users file.
+const auditSuffix = ".audit"
+
+//AuditPath returns the path of the file the model keeps the audit trail in.
+func (m *FileUserModel) AuditPath() string {
+	return m.Filepath + auditSuffix
+}
+
+//AppendAudit appends an audit entry to the audit file as a line of its own, encrypted with the same keys as the
+//users file. Lines are sealed one at a time, so entries are appended without rewriting the file.
+func (m *FileUserModel) AppendAudit(ctx context.Context, entry []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if bytes.ContainsAny(entry, "\r\n") {
+		return errors.New("fileusermodel: audit entries must be a single line of JSON")
+	}
+	line := entry
+	if m.Keys != nil {
+		sealed, err := m.Keys.Seal(entry)
+		if err != nil {
+			return err
+		}
+		line = sealed
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.AuditPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//rotateAuditKey reseals every entry of the audit file that isn't sealed with the primary key, see RotateKey, and
+//reports whether the file changed. m.mu must be held.
+func (m *FileUserModel) rotateAuditKey() (bool, error) {
+	content, err := ioutil.ReadFile(m.AuditPath())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var updated bytes.Buffer
+	changed := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		sealed, resealed, err := m.reseal(line)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || resealed
+		updated.Write(sealed)
+		updated.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil || !changed {
+		return false, err
+	}
+	return true, writeFile(m.AuditPath(), updated.Bytes())
+}
+
+//ReadAudit returns every entry in the audit file, oldest first.
+func (m *FileUserModel) ReadAudit(ctx context.Context) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	content, err := ioutil.ReadFile(m.AuditPath())
+	m.mu.Unlock()
+	if os.IsNotExist(err) {
+		return [][]byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([][]byte, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		entry := append([]byte(nil), line...)
+		if encryption.IsSealed(line) {
+			if m.Keys == nil {
+				return nil, errors.New(keysMissing)
+			}
+			if entry, err = m.Keys.Open(line); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
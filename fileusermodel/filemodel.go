@@ -1,8 +1,18 @@
 package fileusermodel
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/search"
 	"github.com/nmalensek/go-user-form/validation"
 
 	"github.com/nmalensek/go-user-form/model"
@@ -11,64 +21,134 @@ import (
 //FileModel constants.
 const (
 	databaseUnavailable = "The database is currently unavailable, please try again later."
+	keysMissing         = "The users file is encrypted, but no encryption keys were given."
 )
 
 //FileUserModel is an implementation of UserDataStore using the filesystem as a pseudo-database.
+//Deleted users stay in the file with their deletion time until they're purged. Email addresses are unique among
+//the users that haven't been deleted, and managers must be users that haven't been deleted (see model.CheckManager), so
+//users with reports can't be deleted (see model.DeleteUser for moving the reports first).
+//Changes made to the file other than through the model aren't seen by Search.
+//The model can also keep the audit trail, in a file next to the users file, see AppendAudit.
 type FileUserModel struct {
 	Filepath string
+	//Keys, if set, encrypts the file. A file that isn't encrypted yet is still read, and encrypted on the next change.
+	Keys *encryption.Keyring
+
+	//mu serializes changes so concurrent read-modify-write cycles (e.g. a request racing the purge job) don't lose updates.
+	mu sync.Mutex
+	//index is the search index of the users that haven't been deleted, built on the first search and updated
+	//with every change made through the model after that.
+	index *search.Index
+}
+
+//GetAll retrieves all saved users that haven't been deleted.
+func (m *FileUserModel) GetAll(ctx context.Context) ([]model.User, error) {
+	return m.filter(ctx, false)
 }
 
-//GetAll retrieves all saved users.
-func (m *FileUserModel) GetAll() ([]model.User, error) {
-	users, err := readFileToSlice(m.Filepath)
+//GetDeleted retrieves all users that have been deleted but not yet purged.
+func (m *FileUserModel) GetDeleted(ctx context.Context) ([]model.User, error) {
+	return m.filter(ctx, true)
+}
+
+func (m *FileUserModel) filter(ctx context.Context, deleted bool) ([]model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	users, err := m.readFileToSlice()
 	if err != nil {
 		return nil, err
 	}
-	return users, nil
+
+	filtered := make([]model.User, 0, len(users))
+	for _, u := range users {
+		if u.IsDeleted() == deleted {
+			filtered = append(filtered, u)
+		}
+	}
+	return filtered, nil
 }
 
 //Create creates a new user and saves it to the "database" file.
-func (m *FileUserModel) Create(u *model.User) error {
+func (m *FileUserModel) Create(ctx context.Context, u *model.User) error {
 	errs := validation.ValidateCompleteInput(*u)
 	if len(errs) > 0 {
 		return errors.New(model.CreateErrorIncomplete)
 	}
 
-	userMap, err := readFileToMap(m.Filepath)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMap, err := m.readFileToMap()
 	if err != nil {
 		return err
 	}
+	if err := model.CheckEmailUnique(mapValues(userMap), u.Email, 0); err != nil {
+		return err
+	}
+	if err := model.CheckManager(activeUsers(userMap), 0, u.ManagerID); err != nil {
+		return err
+	}
+
+	//last chance to abort before anything is written.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	u.ID = GetNextID(userMap)
+	u.DeletedAt = nil
+	if u.ManagerID == model.NoManager {
+		u.ManagerID = 0
+	}
+	u.StampCreated(ctx, time.Now())
 
 	userMap[u.ID] = *u
 
-	err = saveMapToFile(m.Filepath, userMap)
+	err = m.saveMapToFile(userMap)
 	if err != nil {
 		return err
 	}
+	m.indexUser(*u)
 
 	return nil
 }
 
 //Edit modifies the properties of the given user based on UI input.
-func (m *FileUserModel) Edit(u model.User, id int) error {
+func (m *FileUserModel) Edit(ctx context.Context, u model.User, id int) error {
 	errs := validation.ValidatePartialInput(u)
 	if len(errs) > 0 {
 		return errors.New(model.EditErrorIncomplete)
 	}
 
-	userMap, err := readFileToMap(m.Filepath)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMap, err := m.readFileToMap()
 	if err != nil {
 		return err
 	}
 
-	_, ok := userMap[id]
-	if !ok {
+	savedUser, ok := userMap[id]
+	if !ok || savedUser.IsDeleted() {
 		return errors.New(model.CouldNotFind)
 	}
+	if err := model.CheckEmailUnique(mapValues(userMap), u.Email, id); err != nil {
+		return err
+	}
+	if err := model.CheckManager(activeUsers(userMap), id, u.ManagerID); err != nil {
+		return err
+	}
 
-	savedUser := userMap[id]
 	if u.FirstName != "" {
 		savedUser.FirstName = u.FirstName
 	}
@@ -81,39 +161,362 @@ func (m *FileUserModel) Edit(u model.User, id int) error {
 	if u.Organization != "" {
 		savedUser.Organization = u.Organization
 	}
+	if u.OrganizationID != 0 {
+		savedUser.OrganizationID = u.OrganizationID
+	}
+	switch u.ManagerID {
+	case 0:
+	case model.NoManager:
+		savedUser.ManagerID = 0
+	default:
+		savedUser.ManagerID = u.ManagerID
+	}
+	savedUser.Attributes = MergeAttributes(savedUser.Attributes, u.Attributes)
+	savedUser.StampUpdated(ctx, time.Now())
 
 	userMap[id] = savedUser
 
-	err = saveMapToFile(m.Filepath, userMap)
+	//last chance to abort before anything is written.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err = m.saveMapToFile(userMap)
 	if err != nil {
 		return err
 	}
+	m.indexUser(savedUser)
 
 	return nil
 }
 
-//Delete finds the specified user by ID and deletes them.
-func (m *FileUserModel) Delete(id int) error {
-	userMap, err := readFileToMap(m.Filepath)
+//Delete finds the specified user by ID and marks them as deleted.
+func (m *FileUserModel) Delete(ctx context.Context, id int) error {
+	return m.setDeletedAt(ctx, id, true)
+}
+
+//Restore finds the specified deleted user by ID and undoes the deletion.
+func (m *FileUserModel) Restore(ctx context.Context, id int) error {
+	return m.setDeletedAt(ctx, id, false)
+}
+
+func (m *FileUserModel) setDeletedAt(ctx context.Context, id int, deleted bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMap, err := m.readFileToMap()
 	if err != nil {
 		return err
 	}
 
-	_, ok := userMap[id]
-	if !ok {
+	savedUser, ok := userMap[id]
+	if !ok || (deleted && savedUser.IsDeleted()) {
 		return errors.New(model.CouldNotFind)
 	}
+	if !deleted && !savedUser.IsDeleted() {
+		return errors.New(model.NotDeleted)
+	}
+	if deleted {
+		if err := model.CheckNoReports(activeUsers(userMap), id); err != nil {
+			return err
+		}
+	}
+	//the address may have been given to someone else while the user was deleted.
+	if !deleted {
+		if err := model.CheckEmailUnique(mapValues(userMap), savedUser.Email, id); err != nil {
+			return err
+		}
+	}
 
-	delete(userMap, id)
+	now := time.Now().UTC()
+	if deleted {
+		savedUser.DeletedAt = &now
+	} else {
+		savedUser.DeletedAt = nil
+	}
+	savedUser.StampUpdated(ctx, now)
+	userMap[id] = savedUser
+
+	//last chance to abort before anything is written.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	err = saveMapToFile(m.Filepath, userMap)
+	err = m.saveMapToFile(userMap)
 	if err != nil {
 		return err
 	}
+	m.indexUser(savedUser)
 
 	return nil
 }
 
+//Purge permanently removes users that were deleted before the given time and returns them.
+func (m *FileUserModel) Purge(ctx context.Context, deletedBefore time.Time) ([]model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMap, err := m.readFileToMap()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := make([]model.User, 0)
+	for id, u := range userMap {
+		if u.IsDeleted() && u.DeletedAt.Before(deletedBefore) {
+			purged = append(purged, u)
+			delete(userMap, id)
+		}
+	}
+	if len(purged) == 0 {
+		return purged, nil
+	}
+	sort.Slice(purged, func(i, j int) bool { return purged[i].ID < purged[j].ID })
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	err = m.saveMapToFile(userMap)
+	if err != nil {
+		return nil, err
+	}
+	if m.index != nil {
+		for _, u := range purged {
+			m.index.Remove(u.ID)
+		}
+	}
+
+	return purged, nil
+}
+
+//Reports retrieves the users below the given user, who must not be deleted.
+func (m *FileUserModel) Reports(ctx context.Context, id int, transitive bool) ([]model.User, error) {
+	users, err := m.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return model.FindReports(users, id, transitive)
+}
+
+//Chain retrieves the managers above the given user, who must not be deleted.
+func (m *FileUserModel) Chain(ctx context.Context, id int) ([]model.User, error) {
+	users, err := m.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return model.ManagementChain(users, id)
+}
+
+//Search retrieves the users that haven't been deleted matching query, best match first.
+func (m *FileUserModel) Search(ctx context.Context, query string) ([]model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if m.index == nil {
+		users, err := m.filter(ctx, false)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		m.index = model.IndexUsers(users)
+	}
+	index := m.index
+	m.mu.Unlock()
+
+	matches := index.Search(query)
+	users, err := m.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return model.RankUsers(users, matches), nil
+}
+
+//indexUser brings the search index up to date with a saved user, if the index has been built. m.mu must be held.
+func (m *FileUserModel) indexUser(u model.User) {
+	if m.index == nil {
+		return
+	}
+	if u.IsDeleted() {
+		m.index.Remove(u.ID)
+	} else {
+		m.index.Add(u.ID, model.SearchFields(u)...)
+	}
+}
+
+//Import saves the users as given, replacing any with the same IDs. Emails and managers aren't checked, the users are
+//expected to come from a datastore that already enforced them.
+func (m *FileUserModel) Import(ctx context.Context, users []model.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMap, err := m.readFileToMap()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if u.ID < 1 {
+			return errors.New(model.CreateErrorBadID)
+		}
+		userMap[u.ID] = u
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := m.saveMapToFile(userMap); err != nil {
+		return err
+	}
+	for _, u := range users {
+		m.indexUser(u)
+	}
+	return nil
+}
+
+//Backup writes every user in the file, deleted or not, to w as a backup archive, sealed with Keys if they are set.
+func (m *FileUserModel) Backup(ctx context.Context, w io.Writer) error {
+	m.mu.Lock()
+	userMap, err := m.readFileToMap()
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	users := mapValues(userMap)
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return backup.Write(w, users, time.Now(), m.Keys)
+}
+
+//RestoreBackup replaces the file's users with those of the backup archive in r. Nothing is changed if the archive
+//can't be read.
+func (m *FileUserModel) RestoreBackup(ctx context.Context, r io.Reader) error {
+	archive, err := backup.Read(r, m.Keys)
+	if err != nil {
+		return err
+	}
+	userMap := make(map[int]model.User, len(archive.Users))
+	for _, u := range archive.Users {
+		if u.ID < 1 {
+			return errors.New(model.CreateErrorBadID)
+		}
+		if _, ok := userMap[u.ID]; ok {
+			return fmt.Errorf("backup: user %v appears more than once in the archive", u.ID)
+		}
+		userMap[u.ID] = u
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.saveMapToFile(userMap); err != nil {
+		return err
+	}
+	//every user may have changed, so the index is rebuilt on the next search.
+	m.index = nil
+	return nil
+}
+
+//RotateKey brings the file's encryption up to date with Keys: a file that isn't encrypted yet is encrypted, and
+//the data key of a file encrypted with an older master key is re-wrapped with the primary key. The entries of the
+//audit file are brought up to date the same way, so retiring the older keys leaves the audit trail readable. It
+//reports whether either file changed.
+func (m *FileUserModel) RotateKey(ctx context.Context) (bool, error) {
+	if m.Keys == nil {
+		return false, errors.New("fileusermodel: the model has no encryption keys")
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	content, err := ioutil.ReadFile(m.Filepath)
+	if err != nil {
+		return false, err
+	}
+	updated, changed, err := m.reseal(content)
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		if err := writeFile(m.Filepath, updated); err != nil {
+			return false, err
+		}
+	}
+
+	auditChanged, err := m.rotateAuditKey()
+	return changed || auditChanged, err
+}
+
+//reseal returns content sealed with the primary key, re-wrapping its data key if it's already sealed, and whether
+//that changed it.
+func (m *FileUserModel) reseal(content []byte) ([]byte, bool, error) {
+	if encryption.IsSealed(content) {
+		return m.Keys.Rewrap(content)
+	}
+	sealed, err := m.Keys.Seal(content)
+	return sealed, err == nil, err
+}
+
+//MergeAttributes applies a partial update of custom attributes: given values replace the saved ones and
+//empty values remove the attribute. A new map is returned, or nil if no attributes remain.
+func MergeAttributes(saved, changes map[string]string) map[string]string {
+	merged := make(map[string]string, len(saved)+len(changes))
+	for k, v := range saved {
+		merged[k] = v
+	}
+	for k, v := range changes {
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+func mapValues(userMap map[int]model.User) []model.User {
+	users := make([]model.User, 0, len(userMap))
+	for _, u := range userMap {
+		users = append(users, u)
+	}
+	return users
+}
+
+//activeUsers returns the users in userMap that haven't been deleted.
+func activeUsers(userMap map[int]model.User) []model.User {
+	users := make([]model.User, 0, len(userMap))
+	for _, u := range userMap {
+		if !u.IsDeleted() {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
 //GetNextID returns the next ID value to be assigned (current max ID + 1).
 func GetNextID(userMap map[int]model.User) int {
 	maxID := 0
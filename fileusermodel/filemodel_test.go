@@ -1,12 +1,22 @@
 package fileusermodel
 
 import (
+	"bytes"
+	"context"
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/nmalensek/go-user-form/audit"
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/events"
 	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/suggest"
 )
 
 const (
@@ -26,7 +36,7 @@ func TestFileUnavailable(t *testing.T) {
 
 func TestGetAll(t *testing.T) {
 	model := FileUserModel{Filepath: testFilePath}
-	mockUsers, err := model.GetAll()
+	mockUsers, err := model.GetAll(context.Background())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -41,7 +51,7 @@ func TestGetAll(t *testing.T) {
 	}
 
 	for i := range mockUsers {
-		if mockUsers[i] != convertedConst[i] {
+		if !reflect.DeepEqual(mockUsers[i], convertedConst[i]) {
 			t.Errorf("expected %v, got %v", convertedConst[i], mockUsers[i])
 		}
 	}
@@ -50,7 +60,7 @@ func TestGetAll(t *testing.T) {
 func TestCreate(t *testing.T) {
 	mockModel := FileUserModel{Filepath: testFilePath}
 
-	currUsers, _ := mockModel.GetAll()
+	currUsers, _ := mockModel.GetAll(context.Background())
 	oldLength := len(currUsers)
 	var newID float64 = math.MinInt16
 	for _, v := range currUsers {
@@ -60,13 +70,13 @@ func TestCreate(t *testing.T) {
 
 	testUser := model.User{FirstName: "testxyz", LastName: "ln", Email: "fake@email.org", Organization: "abc123"}
 
-	mockModel.Create(&testUser)
+	mockModel.Create(context.Background(), &testUser)
 
 	if testUser.ID != int(newID) {
 		t.Errorf("expected ID %v got ID %v", newID, testUser.ID)
 	}
 
-	currUsers, _ = mockModel.GetAll()
+	currUsers, _ = mockModel.GetAll(context.Background())
 
 	if len(currUsers) <= oldLength {
 		t.Errorf("new user list should be longer than old user list")
@@ -74,7 +84,7 @@ func TestCreate(t *testing.T) {
 
 	newUser := getUserWithID(int(newID), currUsers)
 
-	if newUser != testUser {
+	if !reflect.DeepEqual(newUser, testUser) {
 		t.Errorf("got %v want %v", newUser, testUser)
 	}
 }
@@ -82,7 +92,7 @@ func TestCreate(t *testing.T) {
 func TestEdit(t *testing.T) {
 	mockModel := FileUserModel{Filepath: testFilePath}
 
-	currUsers, _ := mockModel.GetAll()
+	currUsers, _ := mockModel.GetAll(context.Background())
 
 	editData := model.User{Email: "xyz@123", LastName: "zzzzz", Organization: "..."}
 	originalUser := currUsers[0]
@@ -91,13 +101,18 @@ func TestEdit(t *testing.T) {
 	originalUser.LastName = "zzzzz"
 	originalUser.Organization = "..."
 
-	mockModel.Edit(editData, originalUser.ID)
+	mockModel.Edit(context.Background(), editData, originalUser.ID)
 
-	currUsers, _ = mockModel.GetAll()
+	currUsers, _ = mockModel.GetAll(context.Background())
 
 	storedEdits := getUserWithID(originalUser.ID, currUsers)
 
-	if originalUser != storedEdits {
+	if !storedEdits.UpdatedAt.After(originalUser.UpdatedAt) {
+		t.Errorf("edit should move updatedAt forward, got %v (was %v)", storedEdits.UpdatedAt, originalUser.UpdatedAt)
+	}
+	originalUser.UpdatedAt = storedEdits.UpdatedAt
+
+	if !reflect.DeepEqual(originalUser, storedEdits) {
 		t.Errorf("edit failed, got %v want %v", storedEdits, originalUser)
 	}
 }
@@ -105,14 +120,14 @@ func TestEdit(t *testing.T) {
 func TestDelete(t *testing.T) {
 	mockModel := FileUserModel{Filepath: testFilePath}
 
-	currUsers, _ := mockModel.GetAll()
+	currUsers, _ := mockModel.GetAll(context.Background())
 	oldLength := len(currUsers)
 
 	delID := currUsers[len(currUsers)-1].ID
 
-	mockModel.Delete(delID)
+	mockModel.Delete(context.Background(), delID)
 
-	currUsers, _ = mockModel.GetAll()
+	currUsers, _ = mockModel.GetAll(context.Background())
 
 	if len(currUsers) >= oldLength {
 		t.Errorf("new user list should be shorter than old user list")
@@ -121,14 +136,117 @@ func TestDelete(t *testing.T) {
 	emptyUser := getUserWithID(delID, currUsers)
 	testVal := model.User{}
 
-	if emptyUser != testVal {
+	if !reflect.DeepEqual(emptyUser, testVal) {
 		t.Errorf("expected no user to be found but found %v", emptyUser)
 	}
 }
 
+func TestRestoreAndPurge(t *testing.T) {
+	mockModel := FileUserModel{Filepath: testFilePath}
+	ctx := context.Background()
+
+	testUser := model.User{FirstName: "restore", LastName: "me", Email: "restore@email.org", Organization: "abc123"}
+	mockModel.Create(ctx, &testUser)
+	mockModel.Delete(ctx, testUser.ID)
+
+	if err := mockModel.Edit(ctx, model.User{FirstName: "x"}, testUser.ID); err == nil || err.Error() != model.CouldNotFind {
+		t.Errorf("editing a deleted user should fail with %v, got %v", model.CouldNotFind, err)
+	}
+
+	deleted, _ := mockModel.GetDeleted(ctx)
+	if found := getUserWithID(testUser.ID, deleted); found.DeletedAt == nil {
+		t.Fatalf("deleted user should be listed with a deletion time, got %v", found)
+	}
+
+	if err := mockModel.Restore(ctx, testUser.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := mockModel.Restore(ctx, testUser.ID); err == nil || err.Error() != model.NotDeleted {
+		t.Errorf("restoring twice should fail with %v, got %v", model.NotDeleted, err)
+	}
+	current, _ := mockModel.GetAll(ctx)
+	if restored := getUserWithID(testUser.ID, current); restored.ID != testUser.ID || restored.DeletedAt != nil {
+		t.Errorf("restored user should be listed again, got %v", restored)
+	}
+
+	mockModel.Delete(ctx, testUser.ID)
+	if purged, _ := mockModel.Purge(ctx, time.Now().Add(-time.Hour)); len(purged) != 0 {
+		t.Errorf("recently deleted users should be kept, purged %v", purged)
+	}
+	purged, err := mockModel.Purge(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getUserWithID(testUser.ID, purged).ID != testUser.ID {
+		t.Errorf("expected user %v to be purged, got %v", testUser.ID, purged)
+	}
+	if deleted, _ := mockModel.GetDeleted(ctx); getUserWithID(testUser.ID, deleted).ID != 0 {
+		t.Errorf("purged user should be gone for good")
+	}
+}
+
+func TestDuplicateEmail(t *testing.T) {
+	mockModel := FileUserModel{Filepath: testFilePath}
+	ctx := context.Background()
+
+	first := model.User{FirstName: "dup", LastName: "one", Email: "dup@email.org", Organization: "abc123"}
+	if err := mockModel.Create(ctx, &first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := model.User{FirstName: "dup", LastName: "two", Email: "DUP@Email.org", Organization: "abc123"}
+	err := mockModel.Create(ctx, &second)
+	dup, ok := err.(*model.DuplicateEmailError)
+	if !ok || dup.ExistingID != first.ID {
+		t.Fatalf("creating a user with a taken address should conflict with %v, got %v", first.ID, err)
+	}
+
+	if err := mockModel.Edit(ctx, model.User{Email: "dup@email.org"}, 2); err == nil || err.Error() != model.DuplicateEmail {
+		t.Errorf("editing to a taken address should fail with %v, got %v", model.DuplicateEmail, err)
+	}
+	if err := mockModel.Edit(ctx, model.User{Email: "Dup@email.org", LastName: "uno"}, first.ID); err != nil {
+		t.Errorf("users should be able to keep their own address, got %v", err)
+	}
+
+	mockModel.Delete(ctx, first.ID)
+	if err := mockModel.Create(ctx, &second); err != nil {
+		t.Fatalf("a deleted user's address should be reusable, got %v", err)
+	}
+	if err := mockModel.Restore(ctx, first.ID); err == nil || err.Error() != model.DuplicateEmail {
+		t.Errorf("restoring a user whose address was reused should fail with %v, got %v", model.DuplicateEmail, err)
+	}
+}
+
+func TestBackfillMetadata(t *testing.T) {
+	path := "./testBackfill.json"
+	ioutil.WriteFile(path, []byte(baseMockData), 0644)
+	defer os.Remove(path)
+
+	store := &FileUserModel{Filepath: path}
+	migratedAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	n, err := BackfillMetadata(store, migratedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %v backfilled users want 2", n)
+	}
+
+	users, _ := store.readFileToSlice()
+	for _, u := range users {
+		if !u.CreatedAt.Equal(migratedAt) || u.CreatedBy != MigrationActor || !u.UpdatedAt.Equal(migratedAt) {
+			t.Errorf("user %v was not backfilled: %+v", u.ID, u)
+		}
+	}
+
+	if n, _ := BackfillMetadata(store, time.Now()); n != 0 {
+		t.Errorf("backfill should only touch users without metadata, updated %v", n)
+	}
+}
+
 func TestMissingDelete(t *testing.T) {
 	mockModel := FileUserModel{Filepath: testFilePath}
-	err := mockModel.Delete(math.MaxInt64)
+	err := mockModel.Delete(context.Background(), math.MaxInt64)
 
 	if err == nil {
 		t.Errorf("expected error, got none.")
@@ -144,12 +262,35 @@ func TestMissingEdit(t *testing.T) {
 	//covered by MissingDelete
 }
 
+func TestCancelledContext(t *testing.T) {
+	mockModel := FileUserModel{Filepath: testFilePath}
+
+	before, _ := mockModel.GetAll(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testUser := model.User{FirstName: "cancelled", LastName: "ln", Email: "cancelled@email.org", Organization: "abc123"}
+	if err := mockModel.Create(ctx, &testUser); err != context.Canceled {
+		t.Errorf("error mismatch; got %v want %v", err, context.Canceled)
+	}
+
+	if _, err := mockModel.GetAll(ctx); err != context.Canceled {
+		t.Errorf("error mismatch; got %v want %v", err, context.Canceled)
+	}
+
+	after, _ := mockModel.GetAll(context.Background())
+	if len(after) != len(before) {
+		t.Errorf("cancelled create should not save, got length %v want length %v", len(after), len(before))
+	}
+}
+
 func TestIncompleteCreate(t *testing.T) {
 	mockModel := FileUserModel{Filepath: testFilePath}
 
 	incompleteUser := model.User{FirstName: "test"}
 
-	err := mockModel.Create(&incompleteUser)
+	err := mockModel.Create(context.Background(), &incompleteUser)
 
 	if err == nil {
 		t.Errorf("expected error, got none.")
@@ -161,6 +302,405 @@ func TestIncompleteCreate(t *testing.T) {
 	}
 }
 
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(path, []byte(baseMockData), 0644)
+	mockModel := FileUserModel{Filepath: path}
+	ctx := context.Background()
+
+	ids := func(query string) []int {
+		found, err := mockModel.Search(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]int, 0, len(found))
+		for _, u := range found {
+			got = append(got, u.ID)
+		}
+		return got
+	}
+
+	if got := ids("sales"); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("got %v want [2]", got)
+	}
+
+	//the index follows creates, edits, deletes and restores.
+	newUser := model.User{FirstName: "Jane", LastName: "Doe", Email: "jane@sales.org", Organization: "sales"}
+	mockModel.Create(ctx, &newUser)
+	mockModel.Edit(ctx, model.User{Organization: "support"}, 2)
+	if got := ids("sales"); !reflect.DeepEqual(got, []int{newUser.ID}) {
+		t.Errorf("got %v want [%v]", got, newUser.ID)
+	}
+	mockModel.Delete(ctx, newUser.ID)
+	if got := ids("jane"); len(got) != 0 {
+		t.Errorf("got %v want no deleted users", got)
+	}
+	mockModel.Restore(ctx, newUser.ID)
+	if got := ids("jnae"); !reflect.DeepEqual(got, []int{newUser.ID}) {
+		t.Errorf("got %v want [%v]", got, newUser.ID)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(path, []byte(baseMockData), 0644)
+	mockModel := FileUserModel{Filepath: path}
+	ctx := context.Background()
+	mockModel.Search(ctx, "sales")
+
+	deletedAt := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	imported := []model.User{
+		{ID: 2, FirstName: "Replaced", LastName: "User", Email: "r@sales.org", Organization: "sales", CreatedBy: "elsewhere"},
+		{ID: 9, FirstName: "Gone", LastName: "User", Email: "g@sales.org", Organization: "sales", DeletedAt: &deletedAt},
+	}
+	if err := mockModel.Import(ctx, imported); err != nil {
+		t.Fatal(err)
+	}
+
+	all, _ := mockModel.GetAll(ctx)
+	deleted, _ := mockModel.GetDeleted(ctx)
+	if len(all) != 2 || all[1].FirstName != "Replaced" || all[1].CreatedBy != "elsewhere" {
+		t.Errorf("got %+v want user 2 replaced as given", all)
+	}
+	if len(deleted) != 1 || deleted[0].ID != 9 || !deleted[0].DeletedAt.Equal(deletedAt) {
+		t.Errorf("got %+v want user 9 imported as deleted", deleted)
+	}
+	if found, _ := mockModel.Search(ctx, "replaced"); len(found) != 1 || found[0].ID != 2 {
+		t.Errorf("got %+v want the index to follow the import", found)
+	}
+	if err := mockModel.Import(ctx, []model.User{{FirstName: "No ID"}}); err == nil {
+		t.Errorf("importing a user without an ID should fail")
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(path, []byte(baseMockData), 0644)
+	mockModel := FileUserModel{Filepath: path}
+	ctx := context.Background()
+
+	var archive bytes.Buffer
+	if err := mockModel.Backup(ctx, &archive); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := mockModel.GetAll(ctx)
+
+	mockModel.Delete(ctx, 1)
+	mockModel.Create(ctx, &model.User{FirstName: "After", LastName: "Backup", Email: "after@backup.com", Organization: "sales"})
+	mockModel.Search(ctx, "after")
+	if err := mockModel.RestoreBackup(ctx, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := mockModel.GetAll(ctx)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	if deleted, _ := mockModel.GetDeleted(ctx); len(deleted) != 0 {
+		t.Errorf("got deleted %+v want none", deleted)
+	}
+	if found, _ := mockModel.Search(ctx, "after"); len(found) != 0 {
+		t.Errorf("got %+v want the index rebuilt from the restored users", found)
+	}
+
+	if err := mockModel.RestoreBackup(ctx, bytes.NewReader([]byte("not a backup"))); err == nil {
+		t.Errorf("restoring something that isn't a backup should fail")
+	}
+	if got, _ := mockModel.GetAll(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("a failed restore shouldn't change anything, got %+v", got)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(path, []byte(baseMockData), 0644)
+	oldKey, _ := encryption.GenerateKey()
+	newKey, _ := encryption.GenerateKey()
+	keys, _ := encryption.NewKeyring("old", map[string][]byte{"old": oldKey})
+	mockModel := FileUserModel{Filepath: path, Keys: keys}
+	ctx := context.Background()
+
+	//a file that isn't encrypted yet is read, and encrypted on the next change.
+	if err := mockModel.Create(ctx, &model.User{FirstName: "New", LastName: "User", Email: "new@user.com", Organization: "sales"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if !encryption.IsSealed(data) || strings.Contains(string(data), "test@email.com") {
+		t.Errorf("the file should be encrypted, got %s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("got file mode %v want 0600", info.Mode().Perm())
+	}
+	if all, err := mockModel.GetAll(ctx); err != nil || len(all) != 3 {
+		t.Errorf("got %+v, %v want 3 users", all, err)
+	}
+	if _, err := (&FileUserModel{Filepath: path}).GetAll(ctx); err == nil || err.Error() != keysMissing {
+		t.Errorf("got %v want %v", err, keysMissing)
+	}
+
+	mockModel.Keys, _ = encryption.NewKeyring("new", map[string][]byte{"old": oldKey, "new": newKey})
+	if changed, err := mockModel.RotateKey(ctx); err != nil || !changed {
+		t.Fatalf("got %v, %v want the file re-encrypted", changed, err)
+	}
+	data, _ = ioutil.ReadFile(path)
+	if id, _ := encryption.KeyID(data); id != "new" {
+		t.Errorf("got key %v want new", id)
+	}
+	if changed, _ := mockModel.RotateKey(ctx); changed {
+		t.Errorf("a file already using the primary key shouldn't change")
+	}
+	mockModel.Keys, _ = encryption.NewKeyring("new", map[string][]byte{"new": newKey})
+	if all, err := mockModel.GetAll(ctx); err != nil || len(all) != 3 {
+		t.Errorf("got %+v, %v want 3 users readable without the old key", all, err)
+	}
+}
+
+//eventRecorder collects the events published to it.
+type eventRecorder []events.Event
+
+func (r *eventRecorder) Publish(ctx context.Context, e events.Event) {
+	*r = append(*r, e)
+}
+
+func TestRestoreBackupThroughStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(path, []byte(baseMockData), 0600)
+	mockModel := &FileUserModel{Filepath: path}
+	ctx := model.WithActor(context.Background(), "admin")
+
+	//the same layers the server puts on top of the datastore.
+	sink := &audit.MemorySink{}
+	var published eventRecorder
+	index := suggest.NewIndex(suggest.DefaultConfig())
+	store, err := suggest.NewStore(ctx, events.NewStore(audit.NewStore(mockModel, sink, nil), &published, nil), index, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := store.Backup(ctx, &archive); err != nil {
+		t.Fatal(err)
+	}
+	store.Create(ctx, &model.User{FirstName: "After", LastName: "Backup", Email: "after@backup.com", Organization: "Zeta"})
+	if err := store.RestoreBackup(ctx, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := sink.Query(ctx, audit.Filter{})
+	if last := entries[len(entries)-1]; last.Operation != audit.OpRestoreBackup || last.Actor != "admin" {
+		t.Errorf("got %+v want the restore recorded", last)
+	}
+	if last := published[len(published)-1]; last.Type != events.UsersRestored {
+		t.Errorf("got %+v want %v", last, events.UsersRestored)
+	}
+	if got, _ := index.Suggest("organization", "zeta", 0, func(string) bool { return true }); len(got) != 0 {
+		t.Errorf("got %+v want the suggestions rebuilt from the restored users", got)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(path, []byte(baseMockData), 0600)
+	key, _ := encryption.GenerateKey()
+	keys, _ := encryption.NewKeyring("k", map[string][]byte{"k": key})
+	mockModel := &FileUserModel{Filepath: path, Keys: keys}
+	ctx := context.Background()
+
+	//the audit trail is kept in the same database as the users through the datastore sink.
+	store := audit.NewStore(mockModel, &audit.DatastoreSink{DB: mockModel}, nil)
+	if err := store.Edit(ctx, model.User{Email: "changed@email.com"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(mockModel.AuditPath())
+	if strings.Contains(string(data), "changed@email.com") {
+		t.Errorf("the audit file should be encrypted, got %s", data)
+	}
+	if info, _ := os.Stat(mockModel.AuditPath()); info.Mode().Perm() != 0600 {
+		t.Errorf("got file mode %v want 0600", info.Mode().Perm())
+	}
+	entries, err := store.Sink.Query(ctx, audit.Filter{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Changes[0].After != "changed@email.com" {
+		t.Errorf("got %+v want the edit of user 1", entries)
+	}
+	if err := mockModel.AppendAudit(ctx, []byte("{\n}")); err == nil {
+		t.Error("expected an entry spanning lines to be refused")
+	}
+
+	//rotating the key re-wraps the audit entries too, so they're still read once the old key is retired.
+	newKey, _ := encryption.GenerateKey()
+	mockModel.Keys, _ = encryption.NewKeyring("new", map[string][]byte{"k": key, "new": newKey})
+	if _, err := mockModel.RotateKey(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mockModel.Keys, _ = encryption.NewKeyring("new", map[string][]byte{"new": newKey})
+	if lines, err := mockModel.ReadAudit(ctx); err != nil || len(lines) != 2 {
+		t.Errorf("got %v entries, %v want 2 readable with the new key alone", len(lines), err)
+	}
+}
+
+func TestOrganizations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	orgs := FileOrganizationModel{Filepath: filepath.Join(dir, "organizations.json")}
+	ctx := context.Background()
+
+	sales := model.Organization{Name: " Sales "}
+	if err := orgs.Create(ctx, &sales); err != nil {
+		t.Fatal(err)
+	}
+	if sales.ID != 1 || sales.Name != "Sales" {
+		t.Errorf("got %+v want ID 1 and a trimmed name", sales)
+	}
+	if err := orgs.Create(ctx, &model.Organization{Name: "SALES"}); err == nil || err.Error() != model.OrganizationNameTaken {
+		t.Errorf("got %v want %v", err, model.OrganizationNameTaken)
+	}
+	support := model.Organization{Name: "Support"}
+	orgs.Create(ctx, &support)
+
+	if err := orgs.Edit(ctx, model.Organization{Name: "sales"}, support.ID); err == nil || err.Error() != model.OrganizationNameTaken {
+		t.Errorf("got %v want %v", err, model.OrganizationNameTaken)
+	}
+	if err := orgs.Edit(ctx, model.Organization{Name: "Customer Support"}, support.ID); err != nil {
+		t.Error(err)
+	}
+	if err := orgs.Delete(ctx, 99); err == nil || err.Error() != model.OrganizationNotFound {
+		t.Errorf("got %v want %v", err, model.OrganizationNotFound)
+	}
+	orgs.Delete(ctx, sales.ID)
+
+	got, _ := orgs.GetAll(ctx)
+	want := []model.Organization{{ID: support.ID, Name: "Customer Support"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestMigrateOrganizations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userPath := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(userPath, []byte(`{"1":{"id":1,"organization":"Sales"},"2":{"id":2,"organization":"sales "},"3":{"id":3,"organization":"Sales Dept"},"4":{"id":4,"organization":"Support"}}`), 0644)
+	orgs := &FileOrganizationModel{Filepath: filepath.Join(dir, "organizations.json")}
+	ctx := context.Background()
+	orgs.Create(ctx, &model.Organization{Name: "Support"})
+	store := &FileUserModel{Filepath: userPath}
+	//build the search index before migrating, so searches afterwards show whether it was rebuilt.
+	if _, err := store.Search(ctx, "sales"); err != nil {
+		t.Fatal(err)
+	}
+
+	linked, err := store.MigrateOrganizations(orgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked != 4 {
+		t.Errorf("got %v users linked want 4", linked)
+	}
+
+	got, _ := orgs.GetAll(ctx)
+	want := []model.Organization{{ID: 1, Name: "Support"}, {ID: 2, Name: "Sales"}, {ID: 3, Name: "Sales Dept"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	users, _ := (&FileUserModel{Filepath: userPath}).GetAll(ctx)
+	wantIDs := map[int]int{1: 2, 2: 2, 3: 3, 4: 1}
+	for _, u := range users {
+		if u.OrganizationID != wantIDs[u.ID] {
+			t.Errorf("user %v: got organization %v want %v", u.ID, u.OrganizationID, wantIDs[u.ID])
+		}
+	}
+	if u := getUserWithID(2, users); u.Organization != "Sales" {
+		t.Errorf("got organization name %q want the canonical %q", u.Organization, "Sales")
+	}
+	if store.index != nil {
+		t.Error("the search index should be rebuilt after migrating")
+	}
+	found, err := store.Search(ctx, "sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) < 2 || found[0].Organization != "Sales" || found[1].Organization != "Sales" {
+		t.Errorf("got %+v want users 1 and 2 in %q first", found, "Sales")
+	}
+
+	if linked, _ := (&FileUserModel{Filepath: userPath}).MigrateOrganizations(orgs); linked != 0 {
+		t.Errorf("a second migration linked %v users, want 0", linked)
+	}
+}
+
+func TestLock(t *testing.T) {
+	if !lockEnforced {
+		t.Skip("the lock isn't enforced on this platform")
+	}
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.json")
+
+	held, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a second hold on the same file conflicts like another process's would.
+	if _, err := Lock(path); err != ErrLocked {
+		t.Errorf("got %v want %v", err, ErrLocked)
+	}
+	if err := held.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Lock(path)
+	if err != nil {
+		t.Fatalf("got %v want the lock once it's released", err)
+	}
+	again.Unlock()
+}
+
 func getUserWithID(ID int, uList []model.User) model.User {
 	for _, v := range uList {
 		if v.ID == ID {
@@ -5,16 +5,29 @@ import (
 	"errors"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 
+	"github.com/nmalensek/go-user-form/encryption"
 	"github.com/nmalensek/go-user-form/model"
 )
 
-func readUserFile(path string) ([]byte, error) {
-	content, err := ioutil.ReadFile(path)
+//fileMode is the permission of the files written by the model: they hold personal data, so only the server's user
+//may read them.
+const fileMode = 0600
+
+func (m *FileUserModel) readUserFile() ([]byte, error) {
+	content, err := ioutil.ReadFile(m.Filepath)
 	if err != nil {
 		log.Fatal(err)
 		return nil, errors.New(databaseUnavailable)
 	}
+	if encryption.IsSealed(content) {
+		if m.Keys == nil {
+			return nil, errors.New(keysMissing)
+		}
+		return m.Keys.Open(content)
+	}
 
 	//copying prevents the whole file from staying in memory,
 	//which is unnecessary right now because it's returning all users
@@ -24,13 +37,19 @@ func readUserFile(path string) ([]byte, error) {
 	return cop, nil
 }
 
-func saveMapToFile(path string, u map[int]model.User) error {
+//saveMapToFile writes the users to the file, encrypted if the model has keys.
+func (m *FileUserModel) saveMapToFile(u map[int]model.User) error {
 	userBytes, err := json.Marshal(u)
 	if err != nil {
 		return err
 	}
+	if m.Keys != nil {
+		if userBytes, err = m.Keys.Seal(userBytes); err != nil {
+			return err
+		}
+	}
 
-	err = ioutil.WriteFile(path, userBytes, 0644)
+	err = writeFile(m.Filepath, userBytes)
 	if err != nil {
 		log.Fatal(err)
 		return errors.New(databaseUnavailable)
@@ -38,24 +57,48 @@ func saveMapToFile(path string, u map[int]model.User) error {
 	return nil
 }
 
-func readFileToMap(path string) (map[int]model.User, error) {
-	_, users, err := fileToUsers(path)
+//writeFile replaces the file at path with data through a temporary file, so an interrupted write can't leave it
+//truncated, and with fileMode whatever the permissions of the file it replaces were.
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(fileMode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (m *FileUserModel) readFileToMap() (map[int]model.User, error) {
+	_, users, err := m.fileToUsers()
 	if err != nil {
 		return nil, err
 	}
 	return users, nil
 }
 
-func readFileToSlice(path string) ([]model.User, error) {
-	users, _, err := fileToUsers(path)
+func (m *FileUserModel) readFileToSlice() ([]model.User, error) {
+	users, _, err := m.fileToUsers()
 	if err != nil {
 		return nil, err
 	}
 	return users, nil
 }
 
-func fileToUsers(path string) ([]model.User, map[int]model.User, error) {
-	fileData, err := readUserFile(path)
+func (m *FileUserModel) fileToUsers() ([]model.User, map[int]model.User, error) {
+	fileData, err := m.readUserFile()
 	if err != nil {
 		return nil, nil, err
 	}
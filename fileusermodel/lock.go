@@ -0,0 +1,38 @@
+package fileusermodel
+
+import (
+	"errors"
+	"os"
+)
+
+//lockSuffix is appended to the users file's path for the file its lock is taken on.
+const lockSuffix = ".lock"
+
+//ErrLocked is returned by Lock when another process holds the users file's lock.
+var ErrLocked = errors.New("fileusermodel: the users file is in use by another process")
+
+//FileLock is a process's hold on the lock of a users file.
+type FileLock struct {
+	file *os.File
+}
+
+//Lock takes the lock of the users file at path, or returns ErrLocked if another process holds it. The lock is an
+//advisory lock on a file next to the users file, so it only keeps out processes that take it too, e.g. a server and
+//userctl. It's held until Unlock is called or the process exits. On platforms without flock it isn't enforced.
+func Lock(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path+lockSuffix, os.O_RDWR|os.O_CREATE, fileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileLock{file: f}, nil
+}
+
+//Unlock releases the lock.
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return unlockFile(l.file)
+}
@@ -0,0 +1,115 @@
+package fileusermodel
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//MigrationActor is recorded as the creator of users saved before creation metadata was tracked.
+const MigrationActor = "system:migration"
+
+//BackfillMetadata gives users saved before creation and update metadata existed a creation and update time
+//of now, attributed to MigrationActor, and saves the file if anything changed. It returns how many users were updated.
+func BackfillMetadata(m *FileUserModel, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userMap, err := m.readFileToMap()
+	if err != nil {
+		return 0, err
+	}
+
+	now = now.UTC()
+	updated := 0
+	for id, u := range userMap {
+		if !u.CreatedAt.IsZero() {
+			continue
+		}
+		u.CreatedAt = now
+		u.CreatedBy = MigrationActor
+		if u.UpdatedAt.IsZero() {
+			u.UpdatedAt = now
+			u.UpdatedBy = MigrationActor
+		}
+		userMap[id] = u
+		updated++
+	}
+
+	if updated == 0 {
+		return 0, nil
+	}
+	if err := m.saveMapToFile(userMap); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+//MigrateOrganizations links users that only have an organization name to an organization record, creating one for
+//each distinct name. Names that differ only in case or surrounding space ("Sales", "sales ") become one organization,
+//named as the lowest user ID spelled it, and the users' names are updated to match. It returns how many users were linked.
+func (m *FileUserModel) MigrateOrganizations(orgs *FileOrganizationModel) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	orgs.mu.Lock()
+	defer orgs.mu.Unlock()
+
+	userMap, err := m.readFileToMap()
+	if err != nil {
+		return 0, err
+	}
+	orgMap, err := readOrgFile(orgs.Filepath)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]int, 0, len(userMap))
+	for id := range userMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	byKey := make(map[string]model.Organization, len(orgMap))
+	maxID := 0
+	for id, o := range orgMap {
+		byKey[model.OrganizationKey(o.Name)] = o
+		if id > maxID {
+			maxID = id
+		}
+	}
+
+	updated := 0
+	for _, id := range ids {
+		u := userMap[id]
+		key := model.OrganizationKey(u.Organization)
+		if u.OrganizationID != 0 || key == "" {
+			continue
+		}
+		o, ok := byKey[key]
+		if !ok {
+			maxID++
+			o = model.Organization{ID: maxID, Name: strings.TrimSpace(u.Organization)}
+			orgMap[o.ID] = o
+			byKey[key] = o
+		}
+		u.OrganizationID = o.ID
+		u.Organization = o.Name
+		userMap[id] = u
+		updated++
+	}
+
+	if updated == 0 {
+		return 0, nil
+	}
+	if err := saveOrgFile(orgs.Filepath, orgMap); err != nil {
+		return 0, err
+	}
+	if err := m.saveMapToFile(userMap); err != nil {
+		return 0, err
+	}
+	//the users' organization names may have changed, so the index is rebuilt on the next search.
+	m.index = nil
+	return updated, nil
+}
@@ -0,0 +1,161 @@
+package fileusermodel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//FileOrganizationModel is an implementation of OrganizationDataStore using a JSON file, a missing file is an empty store.
+type FileOrganizationModel struct {
+	Filepath string
+
+	mu sync.Mutex
+}
+
+//GetAll retrieves all organizations, sorted by ID.
+func (m *FileOrganizationModel) GetAll(ctx context.Context) ([]model.Organization, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	orgMap, err := readOrgFile(m.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return sortedOrgs(orgMap), nil
+}
+
+//Create saves a new organization and assigns its ID.
+func (m *FileOrganizationModel) Create(ctx context.Context, o *model.Organization) error {
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" {
+		return errors.New(model.CreateErrorIncomplete)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgMap, err := readOrgFile(m.Filepath)
+	if err != nil {
+		return err
+	}
+	if _, ok := model.FindOrganizationByName(sortedOrgs(orgMap), o.Name); ok {
+		return errors.New(model.OrganizationNameTaken)
+	}
+
+	maxID := 0
+	for id := range orgMap {
+		if id > maxID {
+			maxID = id
+		}
+	}
+	o.ID = maxID + 1
+	orgMap[o.ID] = *o
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return saveOrgFile(m.Filepath, orgMap)
+}
+
+//Edit renames the organization with the given ID.
+func (m *FileOrganizationModel) Edit(ctx context.Context, o model.Organization, id int) error {
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" {
+		return errors.New(model.EditErrorIncomplete)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgMap, err := readOrgFile(m.Filepath)
+	if err != nil {
+		return err
+	}
+	if _, ok := orgMap[id]; !ok {
+		return errors.New(model.OrganizationNotFound)
+	}
+	if other, ok := model.FindOrganizationByName(sortedOrgs(orgMap), o.Name); ok && other.ID != id {
+		return errors.New(model.OrganizationNameTaken)
+	}
+	o.ID = id
+	orgMap[id] = o
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return saveOrgFile(m.Filepath, orgMap)
+}
+
+//Delete removes the organization with the given ID. Callers are responsible for checking it has no members.
+func (m *FileOrganizationModel) Delete(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgMap, err := readOrgFile(m.Filepath)
+	if err != nil {
+		return err
+	}
+	if _, ok := orgMap[id]; !ok {
+		return errors.New(model.OrganizationNotFound)
+	}
+	delete(orgMap, id)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return saveOrgFile(m.Filepath, orgMap)
+}
+
+func readOrgFile(path string) (map[int]model.Organization, error) {
+	orgMap := make(map[int]model.Organization)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return orgMap, nil
+	}
+	if err != nil {
+		return nil, errors.New(databaseUnavailable)
+	}
+	if err := json.Unmarshal(data, &orgMap); err != nil {
+		return nil, err
+	}
+	return orgMap, nil
+}
+
+func saveOrgFile(path string, orgMap map[int]model.Organization) error {
+	data, err := json.Marshal(orgMap)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(path, data); err != nil {
+		return errors.New(databaseUnavailable)
+	}
+	return nil
+}
+
+func sortedOrgs(orgMap map[int]model.Organization) []model.Organization {
+	orgs := make([]model.Organization, 0, len(orgMap))
+	for _, o := range orgMap {
+		orgs = append(orgs, o)
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].ID < orgs[j].ID })
+	return orgs
+}
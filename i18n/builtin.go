@@ -0,0 +1,120 @@
+package i18n
+
+//builtin returns a catalog with the shipped translations. The English messages are added by the packages that
+//define them, so the English text stays next to the code that uses it.
+func builtin() *Catalog {
+	c := NewCatalog()
+	c.Add("de", german)
+	c.Add("fr", french)
+	return c
+}
+
+var german = map[string]string{
+	"field.FirstName":    "Vorname",
+	"field.LastName":     "Nachname",
+	"field.Email":        "E-Mail",
+	"field.Organization": "Organisation",
+
+	"required":             "{field} ist erforderlich.",
+	"format":               "{field} hat nicht das richtige Format.",
+	"not_allowed":          "{field} ist keiner der zulässigen Werte.",
+	"unknown_attribute":    "{field} ist kein bekanntes Attribut.",
+	"range_between":        "{field} muss zwischen {min} und {max} liegen.",
+	"range_min":            "{field} muss mindestens {min} sein.",
+	"range_max":            "{field} darf höchstens {max} sein.",
+	"length_between":       "{field} muss zwischen {min} und {max} Zeichen lang sein.",
+	"length_min":           "{field} muss mindestens {min} Zeichen lang sein.",
+	"length_max":           "{field} darf höchstens {max} Zeichen lang sein.",
+	"invalid_characters":   "{field} enthält unzulässige Zeichen.",
+	"email_domain":         "{field} muss eine Adresse unter einer Domain von {org} sein ({domains}).",
+	"blocked_domain":       "{field} verwendet eine nicht zulässige Domain.",
+	"disposable_domain":    "{field} darf keine Wegwerfadresse sein.",
+	"no_mail_server":       "Die Domain von {field} kann keine E-Mails empfangen.",
+	"missing_all_props":    "Für eine Änderung muss mindestens ein Feld ausgefüllt werden.",
+	"unknown_organization": "{field} ist keine bestehende Organisation.",
+
+	"not_found":               "Der angegebene Benutzer wurde nicht gefunden.",
+	"create_bad_id":           "Der Benutzer konnte nicht angelegt werden, es konnte keine gültige ID vergeben werden.",
+	"create_incomplete":       "Aus den angegebenen Informationen konnte kein Benutzer angelegt werden.",
+	"edit_incomplete":         "Der Benutzer konnte mit den angegebenen Informationen nicht geändert werden.",
+	"not_deleted":             "Der angegebene Benutzer wurde nicht gelöscht.",
+	"not_supported":           "Dieser Vorgang wird von der konfigurierten Datenbank nicht unterstützt.",
+	"duplicate_email":         "Es gibt bereits einen Benutzer mit dieser E-Mail-Adresse.",
+	"malformed_uri":           "Ungültige URI erhalten, bitte Eingabe prüfen und erneut versuchen",
+	"invalid_input":           "Ungültige Eingabe, Einzelheiten siehe Fehlerliste.",
+	"error_while_processing":  "Bei der Verarbeitung Ihrer Anfrage ist ein Fehler aufgetreten, bitte versuchen Sie es später erneut.",
+	"request_timed_out":       "Die Verarbeitung der Anfrage hat zu lange gedauert, bitte versuchen Sie es später erneut.",
+	"forbidden":               "Sie sind nicht berechtigt, diese Aktion auszuführen.",
+	"audit_disabled":          "Das Änderungsprotokoll ist auf diesem Server nicht aktiviert.",
+	"schema_disabled":         "Benutzerdefinierte Attribute sind auf diesem Server nicht aktiviert.",
+	"invalid_schema":          "Ungültiges Schema erhalten, Einzelheiten siehe Fehlerliste.",
+	"attribute_not_found":     "Das angegebene Attribut wurde im Schema nicht gefunden.",
+	"organization_not_found":  "Die angegebene Organisation wurde nicht gefunden.",
+	"organization_name_taken": "Es gibt bereits eine Organisation mit diesem Namen.",
+	"organization_in_use":     "Die Organisation hat noch Benutzer und kann nicht gelöscht werden.",
+	"organizations_disabled":  "Organisationen sind auf diesem Server nicht aktiviert.",
+	"manager_not_found":       "Der angegebene Vorgesetzte wurde nicht gefunden.",
+	"manager_cycle":           "Ein Benutzer kann weder sich selbst noch einem seiner Mitarbeiter unterstellt werden.",
+	"has_reports":             "Der Benutzer hat noch Mitarbeiter und kann erst gelöscht werden, wenn diese neu zugeordnet sind.",
+	"suggestions_disabled":    "Vorschläge sind auf diesem Server nicht aktiviert.",
+	"webhooks_disabled":       "Webhooks sind auf diesem Server nicht aktiviert.",
+	"events_disabled":         "Der Ereignis-Feed ist auf diesem Server nicht aktiviert.",
+	"presence_disabled":       "Die Anzeige gleichzeitiger Bearbeiter ist auf diesem Server nicht aktiviert.",
+	"backups_disabled":        "Die Datenbank dieses Servers unterstützt keine Sicherungen.",
+	"webhook_not_found":       "Der angegebene Webhook wurde nicht gefunden.",
+}
+
+var french = map[string]string{
+	"field.FirstName":    "Prénom",
+	"field.LastName":     "Nom",
+	"field.Email":        "E-mail",
+	"field.Organization": "Organisation",
+
+	"required":             "{field} est obligatoire.",
+	"format":               "{field} n'est pas au bon format.",
+	"not_allowed":          "{field} ne fait pas partie des valeurs autorisées.",
+	"unknown_attribute":    "{field} n'est pas un attribut reconnu.",
+	"range_between":        "{field} doit être compris entre {min} et {max}.",
+	"range_min":            "{field} doit être au moins {min}.",
+	"range_max":            "{field} doit être au plus {max}.",
+	"length_between":       "{field} doit contenir entre {min} et {max} caractères.",
+	"length_min":           "{field} doit contenir au moins {min} caractères.",
+	"length_max":           "{field} doit contenir au plus {max} caractères.",
+	"invalid_characters":   "{field} contient des caractères non autorisés.",
+	"email_domain":         "{field} doit être une adresse d'un des domaines de {org} ({domains}).",
+	"blocked_domain":       "{field} utilise un domaine non autorisé.",
+	"disposable_domain":    "{field} ne peut pas être une adresse jetable.",
+	"no_mail_server":       "Le domaine de {field} ne peut pas recevoir d'e-mails.",
+	"missing_all_props":    "Au moins un champ doit être rempli pour effectuer une modification.",
+	"unknown_organization": "{field} n'est pas une organisation existante.",
+
+	"not_found":               "L'utilisateur indiqué est introuvable.",
+	"create_bad_id":           "Impossible de créer l'utilisateur, aucun identifiant valide n'a pu être attribué.",
+	"create_incomplete":       "Impossible de créer l'utilisateur à partir des informations fournies.",
+	"edit_incomplete":         "Impossible de modifier l'utilisateur à partir des informations fournies.",
+	"not_deleted":             "L'utilisateur indiqué n'a pas été supprimé.",
+	"not_supported":           "Cette opération n'est pas prise en charge par la base de données configurée.",
+	"duplicate_email":         "Un utilisateur avec cette adresse e-mail existe déjà.",
+	"malformed_uri":           "URI mal formée reçue, veuillez vérifier la saisie et réessayer",
+	"invalid_input":           "Saisie invalide, voir la liste des erreurs pour plus de détails.",
+	"error_while_processing":  "Une erreur s'est produite lors du traitement de votre demande, veuillez réessayer plus tard.",
+	"request_timed_out":       "Le traitement de la demande a pris trop de temps, veuillez réessayer plus tard.",
+	"forbidden":               "Vous n'avez pas l'autorisation d'effectuer cette action.",
+	"audit_disabled":          "Le journal des modifications n'est pas activé sur ce serveur.",
+	"schema_disabled":         "Les attributs personnalisés ne sont pas activés sur ce serveur.",
+	"invalid_schema":          "Schéma invalide reçu, voir la liste des erreurs pour plus de détails.",
+	"attribute_not_found":     "L'attribut indiqué est introuvable dans le schéma.",
+	"organization_not_found":  "L'organisation indiquée est introuvable.",
+	"organization_name_taken": "Une organisation portant ce nom existe déjà.",
+	"organization_in_use":     "L'organisation a encore des utilisateurs et ne peut pas être supprimée.",
+	"organizations_disabled":  "Les organisations ne sont pas activées sur ce serveur.",
+	"manager_not_found":       "Le responsable indiqué est introuvable.",
+	"manager_cycle":           "Un utilisateur ne peut pas dépendre de lui-même ni d'une personne qui dépend de lui.",
+	"has_reports":             "L'utilisateur a encore des collaborateurs et ne peut pas être supprimé avant leur réaffectation.",
+	"suggestions_disabled":    "Les suggestions ne sont pas activées sur ce serveur.",
+	"webhooks_disabled":       "Les webhooks ne sont pas activés sur ce serveur.",
+	"events_disabled":         "Le flux d'événements n'est pas activé sur ce serveur.",
+	"presence_disabled":       "Le suivi des éditeurs simultanés n'est pas activé sur ce serveur.",
+	"backups_disabled":        "La base de données de ce serveur ne prend pas en charge les sauvegardes.",
+	"webhook_not_found":       "Le webhook indiqué est introuvable.",
+}
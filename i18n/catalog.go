@@ -0,0 +1,207 @@
+package i18n
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefaultLanguage is used when none of the requested languages are supported, and for messages without a translation.
+const DefaultLanguage = "en"
+
+//fieldPrefix starts the keys of friendly field names, e.g. "field.FirstName".
+const fieldPrefix = "field."
+
+//Catalog holds message templates for each language, keyed on a stable message code. Templates refer to
+//parameters in braces, e.g. "{field} is required.".
+type Catalog struct {
+	mu       sync.RWMutex
+	messages map[string]map[string]string
+}
+
+//NewCatalog returns an empty catalog.
+func NewCatalog() *Catalog {
+	return &Catalog{messages: make(map[string]map[string]string)}
+}
+
+//Default is the catalog used by the application, it starts out with the built-in translations.
+var Default = builtin()
+
+//Add merges messages into the language's translations, replacing any with the same codes.
+func (c *Catalog) Add(lang string, messages map[string]string) {
+	lang = strings.ToLower(lang)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.messages[lang]
+	if !ok {
+		m = make(map[string]string, len(messages))
+		c.messages[lang] = m
+	}
+	for k, v := range messages {
+		m[k] = v
+	}
+}
+
+//AddField adds the friendly name of a field, e.g. AddField("de", "FirstName", "Vorname").
+func (c *Catalog) AddField(lang, field, name string) {
+	c.Add(lang, map[string]string{fieldPrefix + field: name})
+}
+
+//LoadDir adds the translations in every <language>.json file in dir, each a flat object of codes to templates.
+func (c *Catalog) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("LoadDir: %v: %w", p, err)
+		}
+		c.Add(strings.TrimSuffix(filepath.Base(p), ".json"), messages)
+	}
+	return nil
+}
+
+//Languages returns the languages in the catalog, sorted.
+func (c *Catalog) Languages() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	langs := make([]string, 0, len(c.messages))
+	for l := range c.messages {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+//Codes returns the message codes with a template in lang, sorted. Friendly field names aren't included.
+func (c *Catalog) Codes(lang string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	codes := make([]string, 0, len(c.messages[lang]))
+	for code := range c.messages[lang] {
+		if !strings.HasPrefix(code, fieldPrefix) {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+//Match picks the catalog language that best fits an Accept-Language header, e.g. "de-CH, de;q=0.9, en;q=0.5".
+//Regional tags fall back to their base language. DefaultLanguage is returned if nothing matches.
+func (c *Catalog) Match(acceptLanguage string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	choices := make([]choice, 0)
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{tag: tag, q: q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, ch := range choices {
+		if ch.tag == "*" {
+			return DefaultLanguage
+		}
+		if _, ok := c.messages[ch.tag]; ok {
+			return ch.tag
+		}
+		if i := strings.Index(ch.tag, "-"); i > 0 {
+			if _, ok := c.messages[ch.tag[:i]]; ok {
+				return ch.tag[:i]
+			}
+		}
+	}
+	return DefaultLanguage
+}
+
+//Lookup finds the template for code in lang, falling back to the base language of a regional tag and
+//then to DefaultLanguage.
+func (c *Catalog) Lookup(lang, code string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	lang = strings.ToLower(lang)
+	candidates := []string{lang}
+	if i := strings.Index(lang, "-"); i > 0 {
+		candidates = append(candidates, lang[:i])
+	}
+	candidates = append(candidates, DefaultLanguage)
+	for _, l := range candidates {
+		if t, ok := c.messages[l][code]; ok {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+//Message renders the template for code in lang with params. If no language has the code, the code itself is returned.
+func (c *Catalog) Message(lang, code string, params map[string]string) string {
+	t, ok := c.Lookup(lang, code)
+	if !ok {
+		return code
+	}
+	if len(params) == 0 {
+		return t
+	}
+	pairs := make([]string, 0, 2*len(params))
+	for k, v := range params {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(t)
+}
+
+//Field returns the friendly name of a field in lang, or fallback if it hasn't been translated.
+func (c *Catalog) Field(lang, field, fallback string) string {
+	if t, ok := c.Lookup(lang, fieldPrefix+field); ok {
+		return t
+	}
+	return fallback
+}
+
+type contextKey int
+
+const languageKey contextKey = iota
+
+//WithLanguage returns a copy of ctx carrying the language responses should be written in.
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey, lang)
+}
+
+//LanguageFromContext returns the language set with WithLanguage, or DefaultLanguage.
+func LanguageFromContext(ctx context.Context) string {
+	if lang, ok := ctx.Value(languageKey).(string); ok && lang != "" {
+		return lang
+	}
+	return DefaultLanguage
+}
@@ -0,0 +1,92 @@
+package i18n
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testCatalog() *Catalog {
+	c := NewCatalog()
+	c.Add("en", map[string]string{"required": "{field} is required.", "only_english": "Hello"})
+	c.Add("de", map[string]string{"required": "{field} ist erforderlich."})
+	c.Add("fr-ca", map[string]string{"required": "{field} est requis."})
+	c.AddField("de", "FirstName", "Vorname")
+	return c
+}
+
+func TestMatch(t *testing.T) {
+	c := testCatalog()
+	cases := map[string]string{
+		"":                           "en",
+		"de":                         "de",
+		"de-CH":                      "de",
+		"DE-de, en;q=0.5":            "de",
+		"fr-CA":                      "fr-ca",
+		"fr":                         "en",
+		"it, de;q=0.8":               "de",
+		"en;q=0.3, de;q=0.9":         "de",
+		"de;q=0, en":                 "en",
+		"*":                          "en",
+		"xx, yy;q=0.5, zz;q=garbage": "en",
+	}
+	for header, want := range cases {
+		if got := c.Match(header); got != want {
+			t.Errorf("%q: got %v want %v", header, got, want)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	c := testCatalog()
+	params := map[string]string{"field": c.Field("de", "FirstName", "First Name")}
+
+	if got, want := c.Message("de", "required", params), "Vorname ist erforderlich."; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got, want := c.Message("de-AT", "only_english", nil), "Hello"; got != want {
+		t.Errorf("missing translations should fall back to English, got %q want %q", got, want)
+	}
+	if got, want := c.Message("de", "no_such_code", nil), "no_such_code"; got != want {
+		t.Errorf("unknown codes should be returned as is, got %q want %q", got, want)
+	}
+	if got, want := c.Field("fr-ca", "FirstName", "First Name"), "First Name"; got != want {
+		t.Errorf("untranslated field names should use the fallback, got %q want %q", got, want)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "nl.json"), []byte(`{"required": "{field} is verplicht.", "field.FirstName": "Voornaam"}`), 0600)
+
+	c := testCatalog()
+	if err := c.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Match("nl-BE"); got != "nl" {
+		t.Errorf("loaded language should be matched, got %v", got)
+	}
+	if got, want := c.Message("nl", "required", map[string]string{"field": c.Field("nl", "FirstName", "")}), "Voornaam is verplicht."; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte(`not json`), 0600)
+	if err := c.LoadDir(dir); err == nil {
+		t.Errorf("expected an error for a malformed file")
+	}
+}
+
+func TestLanguageContext(t *testing.T) {
+	if got := LanguageFromContext(context.Background()); got != DefaultLanguage {
+		t.Errorf("got %v want %v", got, DefaultLanguage)
+	}
+	if got := LanguageFromContext(WithLanguage(context.Background(), "fr")); got != "fr" {
+		t.Errorf("got %v want fr", got)
+	}
+}
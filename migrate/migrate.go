@@ -0,0 +1,337 @@
+//Package migrate copies the stored users, and their audit trail, from one datastore to another, e.g. from the JSON
+//file to a database server. Users keep their IDs, every user is validated before anything is written, and the copy
+//is checked against the source once it's done.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/audit"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//Stages of a migration, as reported to Options.Progress.
+const (
+	StageUsers = "users"
+	StageAudit = "audit"
+)
+
+//DefaultBatchSize is used when Options.BatchSize isn't set.
+const DefaultBatchSize = 500
+
+//Migration errors.
+var (
+	ErrNotImporter    = errors.New("migrate: the target datastore can't import users with their IDs")
+	ErrTargetNotEmpty = errors.New("migrate: the target already has users or audit entries, migrations start from an empty target")
+	ErrSourceChanged  = errors.New("migrate: the source has changed since the migration started, remove the state file to start over")
+)
+
+//Options control a migration.
+type Options struct {
+	//BatchSize is how many users or audit entries are written before progress is saved.
+	BatchSize int
+	//Rules checks every user before anything is written, nil skips validation.
+	Rules *validation.Ruleset
+	//StatePath is where progress is saved so that running the migration again resumes it. Empty to always start over.
+	StatePath string
+	//SourceAudit and TargetAudit are the audit sinks to copy entries between. The audit trail is left alone
+	//if either is nil.
+	SourceAudit audit.Sink
+	TargetAudit audit.Sink
+	//Progress, if set, is called after every batch.
+	Progress func(Progress)
+}
+
+//Progress is how far a stage of the migration has got.
+type Progress struct {
+	Stage string
+	Done  int
+	Total int
+}
+
+//Report describes a finished and verified migration.
+type Report struct {
+	Users        int
+	AuditEntries int
+	//Checksum is the checksum of the users, identical in the source and the target.
+	Checksum string
+	//Resumed is set if an earlier run had already copied some of the users.
+	Resumed bool
+}
+
+//InvalidUsersError lists the users that failed validation, nothing is written if there are any.
+type InvalidUsersError struct {
+	Problems []string
+}
+
+func (e *InvalidUsersError) Error() string {
+	return fmt.Sprintf("migrate: %v problems found, fix them (e.g. with userctl edit) and run the migration again:\n%v",
+		len(e.Problems), strings.Join(e.Problems, "\n"))
+}
+
+//state is the progress saved between runs. Audit entries are resumed from the number already in the target,
+//since the target sink started out empty.
+type state struct {
+	//Checksum is the source's when the migration started, resuming from a different source would mix the two.
+	Checksum string `json:"checksum"`
+	LastID   int    `json:"lastId"`
+}
+
+//Run copies every user, deleted or not, from source to target, followed by the audit trail if both sinks are given.
+//The target must be empty when a migration starts, and must implement model.Importer.
+func Run(ctx context.Context, source, target model.UserDataStore, opts Options) (Report, error) {
+	importer, ok := target.(model.Importer)
+	if !ok {
+		return Report{}, ErrNotImporter
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	copyAudit := opts.SourceAudit != nil && opts.TargetAudit != nil
+
+	users, err := allUsers(ctx, source)
+	if err != nil {
+		return Report{}, fmt.Errorf("migrate: reading the source: %w", err)
+	}
+	sum, err := Checksum(users)
+	if err != nil {
+		return Report{}, err
+	}
+	if opts.Rules != nil {
+		if err := validate(opts.Rules, users); err != nil {
+			return Report{}, err
+		}
+	}
+
+	st, resumed, err := loadState(opts.StatePath)
+	if err != nil {
+		return Report{}, err
+	}
+	if resumed && st.Checksum != sum {
+		return Report{}, ErrSourceChanged
+	}
+	if !resumed {
+		if err := checkEmpty(ctx, target, opts.TargetAudit, copyAudit); err != nil {
+			return Report{}, err
+		}
+		st = state{Checksum: sum}
+		if err := saveState(opts.StatePath, st); err != nil {
+			return Report{}, err
+		}
+	}
+
+	remaining := users[sort.Search(len(users), func(i int) bool { return users[i].ID > st.LastID }):]
+	for len(remaining) > 0 {
+		n := opts.BatchSize
+		if n > len(remaining) {
+			n = len(remaining)
+		}
+		if err := importer.Import(ctx, remaining[:n]); err != nil {
+			return Report{}, fmt.Errorf("migrate: writing users %v to %v: %w", remaining[0].ID, remaining[n-1].ID, err)
+		}
+		st.LastID = remaining[n-1].ID
+		if err := saveState(opts.StatePath, st); err != nil {
+			return Report{}, err
+		}
+		remaining = remaining[n:]
+		report(opts, StageUsers, len(users)-len(remaining), len(users))
+	}
+
+	entries := 0
+	if copyAudit {
+		if entries, err = copyEntries(ctx, opts); err != nil {
+			return Report{}, err
+		}
+	}
+
+	if err := verify(ctx, target, users, sum, opts, copyAudit); err != nil {
+		return Report{}, err
+	}
+	if opts.StatePath != "" {
+		if err := os.Remove(opts.StatePath); err != nil && !os.IsNotExist(err) {
+			return Report{}, err
+		}
+	}
+	return Report{Users: len(users), AuditEntries: entries, Checksum: sum, Resumed: resumed}, nil
+}
+
+//copyEntries appends the source's audit entries that the target doesn't have yet, in order, and returns the total.
+func copyEntries(ctx context.Context, opts Options) (int, error) {
+	entries, err := opts.SourceAudit.Query(ctx, audit.Filter{})
+	if err != nil {
+		return 0, fmt.Errorf("migrate: reading the audit trail: %w", err)
+	}
+	copied, err := opts.TargetAudit.Query(ctx, audit.Filter{})
+	if err != nil {
+		return 0, fmt.Errorf("migrate: reading the target audit trail: %w", err)
+	}
+
+	for done := len(copied); done < len(entries); {
+		end := done + opts.BatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		for ; done < end; done++ {
+			if err := opts.TargetAudit.Record(ctx, entries[done]); err != nil {
+				return 0, fmt.Errorf("migrate: writing audit entry %v: %w", done+1, err)
+			}
+		}
+		report(opts, StageAudit, done, len(entries))
+	}
+	return len(entries), nil
+}
+
+//verify compares the target with the source, by count and by checksum.
+func verify(ctx context.Context, target model.UserDataStore, users []model.User, sum string, opts Options, copyAudit bool) error {
+	copied, err := allUsers(ctx, target)
+	if err != nil {
+		return fmt.Errorf("migrate: reading the target: %w", err)
+	}
+	if len(copied) != len(users) {
+		return fmt.Errorf("migrate: verification failed, the source has %v users and the target %v", len(users), len(copied))
+	}
+	if got, err := Checksum(copied); err != nil || got != sum {
+		return fmt.Errorf("migrate: verification failed, the target's users differ from the source's (checksum %v, want %v)", got, sum)
+	}
+
+	if !copyAudit {
+		return nil
+	}
+	entries, err := opts.SourceAudit.Query(ctx, audit.Filter{})
+	if err != nil {
+		return err
+	}
+	copiedEntries, err := opts.TargetAudit.Query(ctx, audit.Filter{})
+	if err != nil {
+		return err
+	}
+	want, err := Checksum(entries)
+	if err != nil {
+		return err
+	}
+	got, err := Checksum(copiedEntries)
+	if err != nil {
+		return err
+	}
+	if len(copiedEntries) != len(entries) || got != want {
+		return fmt.Errorf("migrate: verification failed, the target has %v audit entries (checksum %v) and the source %v (checksum %v)",
+			len(copiedEntries), got, len(entries), want)
+	}
+	return nil
+}
+
+//Checksum returns a SHA-256 checksum of the JSON encoding of each item in order. items must be a slice.
+func Checksum(items interface{}) (string, error) {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return "", err
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, item := range list {
+		h.Write(item)
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//allUsers returns the active and deleted users, ordered by ID.
+func allUsers(ctx context.Context, db model.UserDataStore) ([]model.User, error) {
+	active, err := db.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := db.GetDeleted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users := append(append([]model.User{}, active...), deleted...)
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func validate(rules *validation.Ruleset, users []model.User) error {
+	var problems []string
+	for _, u := range users {
+		for _, e := range rules.Validate(u, validation.Create) {
+			problems = append(problems, fmt.Sprintf("user %v: %v: %v", u.ID, e.PropName, e.Message))
+		}
+	}
+	if len(problems) > 0 {
+		return &InvalidUsersError{Problems: problems}
+	}
+	return nil
+}
+
+func checkEmpty(ctx context.Context, target model.UserDataStore, sink audit.Sink, copyAudit bool) error {
+	users, err := allUsers(ctx, target)
+	if err != nil {
+		return fmt.Errorf("migrate: reading the target: %w", err)
+	}
+	if len(users) > 0 {
+		return ErrTargetNotEmpty
+	}
+	if copyAudit {
+		entries, err := sink.Query(ctx, audit.Filter{})
+		if err != nil {
+			return fmt.Errorf("migrate: reading the target audit trail: %w", err)
+		}
+		if len(entries) > 0 {
+			return ErrTargetNotEmpty
+		}
+	}
+	return nil
+}
+
+func report(opts Options, stage string, done, total int) {
+	if opts.Progress != nil {
+		opts.Progress(Progress{Stage: stage, Done: done, Total: total})
+	}
+}
+
+//loadState reads the saved progress, found is false if there isn't any.
+func loadState(path string) (st state, found bool, err error) {
+	if path == "" {
+		return st, false, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, false, nil
+	}
+	if err != nil {
+		return st, false, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, false, fmt.Errorf("migrate: reading the state file: %w", err)
+	}
+	return st, true, nil
+}
+
+//saveState writes the progress to a temporary file first, so an interrupted write can't lose it.
+func saveState(path string, st state) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
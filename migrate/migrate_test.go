@@ -0,0 +1,133 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nmalensek/go-user-form/audit"
+	"github.com/nmalensek/go-user-form/fileusermodel"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+const sourceData = `{
+"1":{"id":1,"firstName":"Ann","lastName":"Lee","email":"ann@example.com","organization":"sales","createdAt":"2020-01-02T03:04:05Z","createdBy":"admin"},
+"2":{"id":2,"firstName":"Bob","lastName":"Ray","email":"bob@example.com","organization":"sales","managerId":1},
+"4":{"id":4,"firstName":"Cy","lastName":"Dee","email":"cy@example.com","organization":"marketing","deletedAt":"2021-05-06T07:08:09Z"},
+"7":{"id":7,"firstName":"Di","lastName":"Eve","email":"di@example.com","organization":"marketing","attributes":{"floor":"3"}}}`
+
+//failingImporter fails the import after the given number of batches, as if the migration were interrupted.
+type failingImporter struct {
+	*fileusermodel.FileUserModel
+	batches int
+}
+
+func (f *failingImporter) Import(ctx context.Context, users []model.User) error {
+	if f.batches == 0 {
+		return errors.New("connection lost")
+	}
+	f.batches--
+	return f.FileUserModel.Import(ctx, users)
+}
+
+func setup(t *testing.T, data string) (dir string, source, target *fileusermodel.FileUserModel) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "source.json"), []byte(data), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "target.json"), []byte("{}"), 0600)
+	return dir, &fileusermodel.FileUserModel{Filepath: filepath.Join(dir, "source.json")},
+		&fileusermodel.FileUserModel{Filepath: filepath.Join(dir, "target.json")}
+}
+
+func TestRun(t *testing.T) {
+	dir, source, target := setup(t, sourceData)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	sourceAudit, targetAudit := &audit.MemorySink{}, &audit.MemorySink{}
+	for i := 0; i < 3; i++ {
+		sourceAudit.Record(ctx, audit.Entry{Time: time.Date(2020, 1, i+1, 0, 0, 0, 0, time.UTC), Operation: "create", UserID: i + 1})
+	}
+
+	var progress []Progress
+	opts := Options{
+		BatchSize:   3,
+		Rules:       validation.DefaultRules(),
+		StatePath:   filepath.Join(dir, "state.json"),
+		SourceAudit: sourceAudit,
+		TargetAudit: targetAudit,
+		Progress:    func(p Progress) { progress = append(progress, p) },
+	}
+
+	//the first run is interrupted after one batch, the second carries on from user 4.
+	if _, err := Run(ctx, source, &failingImporter{FileUserModel: target, batches: 1}, opts); err == nil {
+		t.Fatal("the interrupted run should fail")
+	}
+	if _, err := os.Stat(opts.StatePath); err != nil {
+		t.Fatalf("the progress should have been saved: %v", err)
+	}
+	report, err := Run(ctx, source, target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Users != 4 || report.AuditEntries != 3 || !report.Resumed {
+		t.Errorf("got %+v want 4 users and 3 entries, resumed", report)
+	}
+	want := []Progress{{StageUsers, 3, 4}, {StageUsers, 4, 4}, {StageAudit, 3, 3}}
+	if !reflect.DeepEqual(progress, want) {
+		t.Errorf("got progress %v want %v", progress, want)
+	}
+	if _, err := os.Stat(opts.StatePath); !os.IsNotExist(err) {
+		t.Errorf("the state file should be removed after a verified migration")
+	}
+
+	sourceUsers, _ := allUsers(ctx, source)
+	targetUsers, _ := allUsers(ctx, target)
+	if !reflect.DeepEqual(sourceUsers, targetUsers) {
+		t.Errorf("got %+v want %+v", targetUsers, sourceUsers)
+	}
+	deleted, _ := target.GetDeleted(ctx)
+	if len(deleted) != 1 || deleted[0].ID != 4 {
+		t.Errorf("got deleted %+v want user 4", deleted)
+	}
+
+	if _, err := Run(ctx, source, target, opts); err != ErrTargetNotEmpty {
+		t.Errorf("got %v want %v", err, ErrTargetNotEmpty)
+	}
+}
+
+func TestRunChecks(t *testing.T) {
+	dir, source, target := setup(t, sourceData)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	opts := Options{BatchSize: 2, StatePath: filepath.Join(dir, "state.json")}
+
+	if _, err := Run(ctx, source, struct{ model.UserDataStore }{target}, opts); err != ErrNotImporter {
+		t.Errorf("got %v want %v", err, ErrNotImporter)
+	}
+
+	Run(ctx, source, &failingImporter{FileUserModel: target, batches: 1}, opts)
+	source.Edit(ctx, model.User{FirstName: "Changed"}, 1)
+	if _, err := Run(ctx, source, target, opts); err != ErrSourceChanged {
+		t.Errorf("got %v want %v", err, ErrSourceChanged)
+	}
+
+	dir, source, target = setup(t, `{"1":{"id":1,"firstName":"","lastName":"Lee","email":"nope","organization":"sales"}}`)
+	defer os.RemoveAll(dir)
+	_, err := Run(ctx, source, target, Options{Rules: validation.DefaultRules()})
+	var invalid *InvalidUsersError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 2 {
+		t.Errorf("got %v want the first name and email problems", err)
+	}
+	if users, _ := target.GetAll(ctx); len(users) != 0 {
+		t.Errorf("nothing should be written when users are invalid, got %+v", users)
+	}
+}
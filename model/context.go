@@ -0,0 +1,50 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestIDKey
+)
+
+//WithActor returns a copy of ctx carrying the name of the user performing the current operation.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+//ActorFromContext returns the acting user stored in ctx, or an empty string if there isn't one.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey).(string)
+	return actor
+}
+
+//WithRequestID returns a copy of ctx carrying an identifier for the current request, used to trace it through the logs.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+//RequestIDFromContext returns the request ID stored in ctx, or an empty string if there isn't one.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+//Detach returns a context that carries ctx's values but is never cancelled and has no deadline. It's used for
+//follow-up work (such as recording a change that has already been made) that must finish even if the request is cancelled.
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
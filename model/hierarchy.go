@@ -0,0 +1,177 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+//Hierarchy error message constants.
+const (
+	ManagerNotFound = "Could not find the specified manager in database."
+	ManagerCycle    = "A user can't report to themselves or to anyone who reports to them."
+	HasReports      = "The user still has reports and can't be deleted until they're reassigned."
+)
+
+//Ways of handling the reports of a deleted manager.
+const (
+	//ManagerDeleteBlock refuses to delete users who still have reports.
+	ManagerDeleteBlock = "block"
+	//ManagerDeleteReassign moves the reports of a deleted user to that user's own manager.
+	ManagerDeleteReassign = "reassign"
+)
+
+//NoManager is given as the ManagerID of an edit to remove the user's manager, since a zero ManagerID leaves it unchanged.
+const NoManager = -1
+
+//FindReports returns the direct reports of the user with the given ID, or everyone below them if transitive is set.
+func FindReports(users []User, id int, transitive bool) ([]User, error) {
+	if !containsID(users, id) {
+		return nil, errors.New(CouldNotFind)
+	}
+	if transitive {
+		return AllReports(users, id), nil
+	}
+	return DirectReports(users, id), nil
+}
+
+func containsID(users []User, id int) bool {
+	for _, u := range users {
+		if u.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+//DirectReports returns the users who report directly to the user with the given ID, ordered by ID.
+func DirectReports(users []User, id int) []User {
+	reports := make([]User, 0)
+	for _, u := range users {
+		if u.ManagerID == id && id != 0 {
+			reports = append(reports, u)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	return reports
+}
+
+//AllReports returns everyone below the user with the given ID, level by level and ordered by ID within each level.
+func AllReports(users []User, id int) []User {
+	reports := make([]User, 0)
+	seen := map[int]bool{id: true}
+	level := []int{id}
+	for len(level) > 0 {
+		next := make([]int, 0)
+		for _, managerID := range level {
+			for _, u := range DirectReports(users, managerID) {
+				//a cycle can't be saved through the datastores, but don't loop forever on a hand-edited file.
+				if seen[u.ID] {
+					continue
+				}
+				seen[u.ID] = true
+				reports = append(reports, u)
+				next = append(next, u.ID)
+			}
+		}
+		level = next
+	}
+	return reports
+}
+
+//ManagementChain returns the managers above the user with the given ID, from their direct manager to the top.
+//A manager who isn't among users ends the chain.
+func ManagementChain(users []User, id int) ([]User, error) {
+	byID := make(map[int]User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	u, ok := byID[id]
+	if !ok {
+		return nil, errors.New(CouldNotFind)
+	}
+
+	chain := make([]User, 0)
+	seen := map[int]bool{id: true}
+	for u.ManagerID != 0 && !seen[u.ManagerID] {
+		manager, ok := byID[u.ManagerID]
+		if !ok {
+			break
+		}
+		seen[manager.ID] = true
+		chain = append(chain, manager)
+		u = manager
+	}
+	return chain, nil
+}
+
+//CheckNoReports returns an error if any of users reports to the user with the given ID. Datastores check it before
+//deleting a user, so no one is left reporting to a deleted manager.
+func CheckNoReports(users []User, id int) error {
+	if len(DirectReports(users, id)) > 0 {
+		return errors.New(HasReports)
+	}
+	return nil
+}
+
+//DeleteUser deletes the user with the given ID from db, handling their reports per policy: ManagerDeleteBlock
+//(or an empty policy) leaves the datastore to refuse the deletion, ManagerDeleteReassign first moves the reports
+//to the user's own manager. The reports are edited through db, so the layers wrapping it see every change.
+func DeleteUser(ctx context.Context, db UserDataStore, id int, policy string) error {
+	if policy == ManagerDeleteReassign {
+		if err := reassignReports(ctx, db, id); err != nil {
+			return err
+		}
+	}
+	return db.Delete(ctx, id)
+}
+
+func reassignReports(ctx context.Context, db UserDataStore, id int) error {
+	reports, err := db.Reports(ctx, id, false)
+	if err != nil {
+		//a missing user is reported by the delete itself.
+		if err.Error() == CouldNotFind {
+			return nil
+		}
+		return err
+	}
+	if len(reports) == 0 {
+		return nil
+	}
+
+	chain, err := db.Chain(ctx, id)
+	if err != nil {
+		return err
+	}
+	newManager := NoManager
+	if len(chain) > 0 {
+		newManager = chain[0].ID
+	}
+	for _, u := range reports {
+		if err := db.Edit(ctx, User{ManagerID: newManager}, u.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//CheckManager returns an error if the user with the given ID can't report to managerID: the manager must be
+//one of users, and must not be the user or anyone below them. A zero or NoManager managerID is always allowed.
+func CheckManager(users []User, id, managerID int) error {
+	if managerID == 0 || managerID == NoManager {
+		return nil
+	}
+	if managerID == id {
+		return errors.New(ManagerCycle)
+	}
+	chain, err := ManagementChain(users, managerID)
+	if err != nil {
+		return errors.New(ManagerNotFound)
+	}
+	for _, m := range chain {
+		if m.ID == id {
+			return errors.New(ManagerCycle)
+		}
+	}
+	return nil
+}
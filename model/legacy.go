@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//LegacyUserDataStore is the original, context-free shape of UserDataStore.
+type LegacyUserDataStore interface {
+	GetAll() ([]User, error)
+	Create(*User) error
+	Edit(User, int) error
+	Delete(int) error
+}
+
+//FromLegacy adapts a LegacyUserDataStore so it can be used wherever a UserDataStore is expected.
+//The context is checked before each call, but because the wrapped store can't observe it,
+//an operation that has already started will run to completion. Legacy stores delete permanently,
+//so they never have deleted users to list, restore or purge. Creation and update metadata are stamped
+//on the user before it's handed to the legacy store, which may or may not keep them. Email uniqueness is
+//checked against the store's users first, and so are reporting lines (see CheckManager). Legacy stores have no way
+//to do this atomically so concurrent writes could still race.
+func FromLegacy(l LegacyUserDataStore) UserDataStore {
+	return &legacyAdapter{store: l}
+}
+
+type legacyAdapter struct {
+	store LegacyUserDataStore
+}
+
+func (a *legacyAdapter) GetAll(ctx context.Context) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.store.GetAll()
+}
+
+func (a *legacyAdapter) Create(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := a.check(*u, 0); err != nil {
+		return err
+	}
+	u.StampCreated(ctx, time.Now())
+	return a.store.Create(u)
+}
+
+func (a *legacyAdapter) Edit(ctx context.Context, u User, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := a.check(u, id); err != nil {
+		return err
+	}
+	u.StampUpdated(ctx, time.Now())
+	return a.store.Edit(u, id)
+}
+
+//check verifies that u's email address is unique and that they can report to their manager, id is zero for a new user.
+func (a *legacyAdapter) check(u User, id int) error {
+	if u.Email == "" && u.ManagerID == 0 {
+		return nil
+	}
+	users, err := a.store.GetAll()
+	if err != nil {
+		return err
+	}
+	if err := CheckEmailUnique(users, u.Email, id); err != nil {
+		return err
+	}
+	return CheckManager(users, id, u.ManagerID)
+}
+
+func (a *legacyAdapter) Delete(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	users, err := a.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	if err := CheckNoReports(users, id); err != nil {
+		return err
+	}
+	return a.store.Delete(id)
+}
+
+func (a *legacyAdapter) GetDeleted(ctx context.Context) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return []User{}, nil
+}
+
+func (a *legacyAdapter) Restore(ctx context.Context, id int) error {
+	return errors.New(NotSupported)
+}
+
+func (a *legacyAdapter) Purge(ctx context.Context, deletedBefore time.Time) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return []User{}, nil
+}
+
+func (a *legacyAdapter) Reports(ctx context.Context, id int, transitive bool) ([]User, error) {
+	users, err := a.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return FindReports(users, id, transitive)
+}
+
+func (a *legacyAdapter) Chain(ctx context.Context, id int) ([]User, error) {
+	users, err := a.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ManagementChain(users, id)
+}
+
+//Search builds an index of the legacy store's users for every query, since it can't be told when they change.
+func (a *legacyAdapter) Search(ctx context.Context, query string) ([]User, error) {
+	users, err := a.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return SearchUsers(users, query), nil
+}
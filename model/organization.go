@@ -0,0 +1,53 @@
+package model
+
+import (
+	"context"
+	"strings"
+)
+
+//Organization error messages.
+const (
+	OrganizationNotFound  = "Could not find specified organization in database."
+	OrganizationNameTaken = "An organization with this name already exists."
+	OrganizationInUse     = "The organization still has users and can't be deleted."
+)
+
+//Organization is a department or company that users belong to. Users reference it by ID and keep a copy of its name.
+type Organization struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+//OrganizationDataStore is the interface for organization database operations. Names are unique, ignoring case.
+type OrganizationDataStore interface {
+	GetAll(ctx context.Context) ([]Organization, error)
+	Create(ctx context.Context, o *Organization) error
+	Edit(ctx context.Context, o Organization, id int) error
+	Delete(ctx context.Context, id int) error
+}
+
+//OrganizationKey returns the form of a name used to match organizations, names are compared case-insensitively.
+func OrganizationKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+//FindOrganization returns the organization with the given ID.
+func FindOrganization(orgs []Organization, id int) (Organization, bool) {
+	for _, o := range orgs {
+		if o.ID == id {
+			return o, true
+		}
+	}
+	return Organization{}, false
+}
+
+//FindOrganizationByName returns the organization with the given name, ignoring case and surrounding space.
+func FindOrganizationByName(orgs []Organization, name string) (Organization, bool) {
+	key := OrganizationKey(name)
+	for _, o := range orgs {
+		if OrganizationKey(o.Name) == key {
+			return o, true
+		}
+	}
+	return Organization{}, false
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"github.com/nmalensek/go-user-form/search"
+)
+
+//Search field weights: names are what people search for most, organizations the least.
+const (
+	nameWeight         = 1.0
+	emailWeight        = 0.8
+	organizationWeight = 0.5
+)
+
+//SearchFields returns the fields of u that are indexed for search, weighted so name matches rank first.
+func SearchFields(u User) []search.Field {
+	return []search.Field{
+		{Text: u.FirstName, Weight: nameWeight},
+		{Text: u.LastName, Weight: nameWeight},
+		{Text: u.Email, Weight: emailWeight},
+		{Text: u.Organization, Weight: organizationWeight},
+	}
+}
+
+//IndexUsers returns a new search index of users.
+func IndexUsers(users []User) *search.Index {
+	x := search.NewIndex()
+	for _, u := range users {
+		x.Add(u.ID, SearchFields(u)...)
+	}
+	return x
+}
+
+//SearchUsers returns the users matching query, best match first, using a throwaway index. It's for stores that
+//can't keep an index up to date.
+func SearchUsers(users []User, query string) []User {
+	return RankUsers(users, IndexUsers(users).Search(query))
+}
+
+//RankUsers returns the users with the IDs in matches, in the same order. Matches without a user are skipped.
+func RankUsers(users []User, matches []search.Match) []User {
+	byID := make(map[int]User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	ranked := make([]User, 0, len(matches))
+	for _, m := range matches {
+		if u, ok := byID[m.ID]; ok {
+			ranked = append(ranked, u)
+		}
+	}
+	return ranked
+}
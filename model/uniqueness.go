@@ -0,0 +1,36 @@
+package model
+
+import "strings"
+
+//DuplicateEmail is the message of a DuplicateEmailError.
+const DuplicateEmail = "A user with this email address already exists."
+
+//DuplicateEmailError is returned by datastores when a create or edit would give two active users the same email address.
+type DuplicateEmailError struct {
+	//ExistingID is the ID of the user who already has the address.
+	ExistingID int
+}
+
+func (e *DuplicateEmailError) Error() string {
+	return DuplicateEmail
+}
+
+//EmailKey returns the form of an address used to check uniqueness. Addresses are compared case-insensitively.
+func EmailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+//CheckEmailUnique returns a DuplicateEmailError if a user other than the one with exceptID already has email.
+//Use an exceptID of 0 for a new user. Deleted users are ignored so their addresses can be reused.
+func CheckEmailUnique(users []User, email string, exceptID int) error {
+	key := EmailKey(email)
+	if key == "" {
+		return nil
+	}
+	for _, u := range users {
+		if u.ID != exceptID && !u.IsDeleted() && EmailKey(u.Email) == key {
+			return &DuplicateEmailError{ExistingID: u.ID}
+		}
+	}
+	return nil
+}
@@ -1,8 +1,11 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"time"
 )
 
 //Model error message constants.
@@ -11,23 +14,103 @@ const (
 	CreateErrorBadID      = "Could not create user, unable to assign valid ID."
 	CreateErrorIncomplete = "Could not create user from the information provided."
 	EditErrorIncomplete   = "Could not modify user from the information provided."
+	NotDeleted            = "The specified user has not been deleted."
+	NotSupported          = "This operation is not supported by the configured database."
 )
 
-//UserDataStore defines the User type data operations.
+//UserDataStore defines the User type data operations. Every operation takes a context so a cancelled request
+//or server shutdown can abort it, and so request-scoped values (acting user, request ID) reach the datastore.
+//
+//Deletes are soft: Delete marks the user as deleted, which hides them from GetAll and Edit until they're restored
+//or purged. Purge permanently removes users deleted before the given time and returns them.
+//
+//Reports returns the users below the given user, only their direct reports unless transitive is set, and Chain
+//returns the managers above them, see AllReports and ManagementChain. Both only consider users that haven't been deleted.
+//
+//Search returns the users that haven't been deleted matching a free-text query, best match first. Stores keep
+//a search index up to date as users change or, like SQL databases, use their own full-text features.
 type UserDataStore interface {
-	GetAll() ([]User, error)
-	Create(*User) error
-	Edit(User, int) error
-	Delete(int) error
+	GetAll(ctx context.Context) ([]User, error)
+	GetDeleted(ctx context.Context) ([]User, error)
+	Create(ctx context.Context, u *User) error
+	Edit(ctx context.Context, u User, id int) error
+	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) error
+	Purge(ctx context.Context, deletedBefore time.Time) ([]User, error)
+	Reports(ctx context.Context, id int, transitive bool) ([]User, error)
+	Chain(ctx context.Context, id int) ([]User, error)
+	Search(ctx context.Context, query string) ([]User, error)
 }
 
-//User is an instance of an employee in a company.
+//AuditLog is implemented by datastores that can keep the audit trail of changes to their users in the same
+//database. Entries are JSON documents, kept as they are and returned in the order they were appended.
+type AuditLog interface {
+	AppendAudit(ctx context.Context, entry []byte) error
+	ReadAudit(ctx context.Context) ([][]byte, error)
+}
+
+//Importer is implemented by datastores that can save users exactly as given, keeping their IDs, timestamps and
+//deletion times, e.g. when users are moved from another datastore. Users whose IDs are already stored are replaced,
+//so an interrupted import can be run again.
+type Importer interface {
+	Import(ctx context.Context, users []User) error
+}
+
+//Backuper is implemented by datastores that can write a consistent backup of all their users, in the archive format
+//of the backup package, and replace all their users with the ones in a backup.
+type Backuper interface {
+	Backup(ctx context.Context, w io.Writer) error
+	RestoreBackup(ctx context.Context, r io.Reader) error
+}
+
+//User is an instance of an employee in a company. The timestamps and actors are managed by the datastore
+//and are never taken from client input. Validation rules for the text fields are declared in the `validate`
+//struct tags, see the validation package. Fields holding personal data are classified in the `sensitivity` struct
+//tags, see the sensitivity package.
 type User struct {
 	ID           int    `json:"id"`
-	FirstName    string `json:"firstName"`
-	LastName     string `json:"lastName"`
-	Email        string `json:"email"`
-	Organization string `json:"organization"`
+	FirstName    string `json:"firstName" validate:"trim,nfc,required,printable,maxlen=100" label:"First Name" sensitivity:"partial"`
+	LastName     string `json:"lastName" validate:"trim,nfc,required,printable,maxlen=100" label:"Last Name" sensitivity:"partial"`
+	Email        string `json:"email" validate:"trim,required,maxlen=254,pattern=email" sensitivity:"email"`
+	Organization string `json:"organization" validate:"trim,nfc,required,printable,maxlen=100"`
+	//OrganizationID references the user's Organization record when organizations are managed, Organization then holds its name.
+	OrganizationID int `json:"organizationId,omitempty"`
+	//ManagerID is the ID of the user this user reports to, zero if they don't report to anyone.
+	ManagerID int `json:"managerId,omitempty"`
+	//Attributes are the custom attributes defined by the admin-managed schema, keyed on attribute name.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	CreatedBy  string            `json:"createdBy"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+	UpdatedBy  string            `json:"updatedBy"`
+	DeletedAt  *time.Time        `json:"deletedAt,omitempty"`
+}
+
+//StampCreated sets the creation and update metadata of a new user to now and the acting user in ctx.
+func (u *User) StampCreated(ctx context.Context, now time.Time) {
+	u.CreatedAt = now.UTC()
+	u.CreatedBy = ActorFromContext(ctx)
+	u.StampUpdated(ctx, now)
+}
+
+//StampUpdated sets the update metadata of a changed user to now and the acting user in ctx.
+func (u *User) StampUpdated(ctx context.Context, now time.Time) {
+	u.UpdatedAt = now.UTC()
+	u.UpdatedBy = ActorFromContext(ctx)
+}
+
+//ClearServerManaged resets the fields only the datastore may set, so they can't be supplied by a client.
+func (u *User) ClearServerManaged() {
+	u.CreatedAt = time.Time{}
+	u.CreatedBy = ""
+	u.UpdatedAt = time.Time{}
+	u.UpdatedBy = ""
+	u.DeletedAt = nil
+}
+
+//IsDeleted reports whether the user has been soft deleted.
+func (u User) IsDeleted() bool {
+	return u.DeletedAt != nil
 }
 
 func (u User) String() string {
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"context"
+	"reflect"
 	"testing"
 )
 
@@ -18,3 +20,84 @@ func TestToString(t *testing.T) {
 		t.Errorf("String() = %q, want %q", got, want)
 	}
 }
+
+type countingStore struct {
+	calls int
+}
+
+func (c *countingStore) GetAll() ([]User, error) { c.calls++; return nil, nil }
+func (c *countingStore) Create(*User) error      { c.calls++; return nil }
+func (c *countingStore) Edit(User, int) error    { c.calls++; return nil }
+func (c *countingStore) Delete(int) error        { c.calls++; return nil }
+
+func TestFromLegacyCancelled(t *testing.T) {
+	legacy := &countingStore{}
+	store := FromLegacy(legacy)
+
+	if _, err := store.GetAll(context.Background()); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := store.Delete(ctx, 1); err != context.Canceled {
+		t.Errorf("Delete() error = %v, want %v", err, context.Canceled)
+	}
+	if legacy.calls != 1 {
+		t.Errorf("legacy store called %v times, want 1", legacy.calls)
+	}
+}
+
+func TestActorContext(t *testing.T) {
+	ctx := WithActor(context.Background(), "admin@example.com")
+	if got := ActorFromContext(ctx); got != "admin@example.com" {
+		t.Errorf("ActorFromContext() = %q, want %q", got, "admin@example.com")
+	}
+	if got := ActorFromContext(context.Background()); got != "" {
+		t.Errorf("ActorFromContext() = %q, want empty", got)
+	}
+}
+
+func TestHierarchy(t *testing.T) {
+	//1 manages 2 and 3, 2 manages 4, 5 has no manager.
+	users := []User{{ID: 4, ManagerID: 2}, {ID: 3, ManagerID: 1}, {ID: 2, ManagerID: 1}, {ID: 1}, {ID: 5}}
+	ids := func(list []User) []int {
+		got := make([]int, 0, len(list))
+		for _, u := range list {
+			got = append(got, u.ID)
+		}
+		return got
+	}
+
+	if got := ids(DirectReports(users, 1)); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Errorf("DirectReports() = %v, want [2 3]", got)
+	}
+	if got := ids(AllReports(users, 1)); !reflect.DeepEqual(got, []int{2, 3, 4}) {
+		t.Errorf("AllReports() = %v, want [2 3 4]", got)
+	}
+	if chain, _ := ManagementChain(users, 4); !reflect.DeepEqual(ids(chain), []int{2, 1}) {
+		t.Errorf("ManagementChain() = %v, want [2 1]", ids(chain))
+	}
+	if _, err := FindReports(users, 9, false); err == nil || err.Error() != CouldNotFind {
+		t.Errorf("FindReports() error = %v, want %v", err, CouldNotFind)
+	}
+
+	tests := []struct {
+		id, managerID int
+		want          string
+	}{
+		{5, 4, ""},
+		{0, 1, ""},
+		{2, NoManager, ""},
+		{1, 1, ManagerCycle},
+		{1, 4, ManagerCycle},
+		{5, 9, ManagerNotFound},
+	}
+	for _, tt := range tests {
+		err := CheckManager(users, tt.id, tt.managerID)
+		if (err == nil && tt.want != "") || (err != nil && err.Error() != tt.want) {
+			t.Errorf("CheckManager(%v, %v) = %v, want %q", tt.id, tt.managerID, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nmalensek/go-user-form/auth"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//Login error messages.
+const (
+	LoginFailed  = "Sign in failed, please try again."
+	LoginExpired = "The sign in attempt expired or was started in another browser, please try again."
+)
+
+//stateCookie binds a login attempt to the browser that started it.
+const stateCookie = "user_form_login_state"
+
+//loginTimeout is how long a user has to complete sign in at the provider.
+const loginTimeout = 10 * time.Minute
+
+//keyRefreshInterval is the least time between fetches of the provider's keys for tokens signed with a key we haven't
+//seen, so tokens naming made-up keys can't make us call the provider on every request.
+const keyRefreshInterval = time.Minute
+
+//unknownKeyTTL is how long a key the provider's keys didn't include is refused without fetching them again.
+const unknownKeyTTL = 5 * time.Minute
+
+//Config is the OIDC configuration file format.
+type Config struct {
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"clientId"`
+	ClientSecret  string   `json:"clientSecret"`
+	RedirectURL   string   `json:"redirectUrl"`
+	Scopes        []string `json:"scopes"`
+	SessionTTL    string   `json:"sessionTtl"`
+	SecureCookies bool     `json:"secureCookies"`
+}
+
+//LoadConfig reads the OIDC configuration file at path.
+func LoadConfig(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("LoadConfig: %w", err)
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Config{}, fmt.Errorf("LoadConfig: %w", err)
+	}
+	return c, nil
+}
+
+//RelyingParty signs browser users in through an OpenID Connect provider using the authorization code flow with PKCE,
+//then tracks them with a session cookie.
+type RelyingParty struct {
+	Sessions *SessionStore
+
+	config   Config
+	provider *ProviderMetadata
+	verifier *auth.TokenVerifier
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending map[string]pendingLogin
+
+	//keysMu serializes fetches of the provider's keys and guards the state that throttles them.
+	keysMu sync.Mutex
+	//keysFetched is when the provider's keys were last fetched.
+	keysFetched time.Time
+	//unknownKeys holds the IDs of keys the last fetches didn't include, until they may be looked up again.
+	unknownKeys map[string]time.Time
+}
+
+//pendingLogin is a login that has been sent to the provider but hasn't come back yet, keyed on its state parameter.
+type pendingLogin struct {
+	codeVerifier string
+	nonce        string
+	returnTo     string
+	expires      time.Time
+}
+
+//New discovers the provider's endpoints and keys and returns a RelyingParty for it.
+func New(ctx context.Context, c Config, client *http.Client) (*RelyingParty, error) {
+	if c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return nil, errors.New("New: issuer, clientId and redirectUrl are required")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	ttl := 8 * time.Hour
+	if c.SessionTTL != "" {
+		d, err := time.ParseDuration(c.SessionTTL)
+		if err != nil {
+			return nil, fmt.Errorf("New: invalid sessionTtl: %w", err)
+		}
+		ttl = d
+	}
+
+	meta, err := Discover(ctx, client, c.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := FetchKeySet(ctx, client, meta.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RelyingParty{
+		Sessions: NewSessionStore(ttl),
+		config:   c,
+		provider: meta,
+		verifier: &auth.TokenVerifier{Keys: keys, Issuer: meta.Issuer, Audience: c.ClientID},
+		client:   client,
+		pending:  make(map[string]pendingLogin),
+
+		keysFetched: time.Now(),
+		unknownKeys: make(map[string]time.Time),
+	}, nil
+}
+
+//CallbackPath returns the local path of the configured redirect URL, where Callback should be served.
+func (rp *RelyingParty) CallbackPath() string {
+	u, err := url.Parse(rp.config.RedirectURL)
+	if err != nil || u.Path == "" {
+		return "/callback"
+	}
+	return u.Path
+}
+
+//Login redirects the browser to the provider's authorization endpoint. The optional returnTo query
+//parameter is the local path the user is sent back to once signed in.
+func (rp *RelyingParty) Login(w http.ResponseWriter, r *http.Request) {
+	state, err1 := randomString(24)
+	nonce, err2 := randomString(24)
+	codeVerifier, err3 := randomString(48)
+	if err1 != nil || err2 != nil || err3 != nil {
+		loginFailed(w, http.StatusInternalServerError, LoginFailed)
+		return
+	}
+
+	rp.mu.Lock()
+	rp.removeExpired()
+	rp.pending[state] = pendingLogin{
+		codeVerifier: codeVerifier,
+		nonce:        nonce,
+		returnTo:     safeReturnPath(r.URL.Query().Get("returnTo")),
+		expires:      time.Now().Add(loginTimeout),
+	}
+	rp.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(loginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   rp.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", rp.config.ClientID)
+	q.Set("redirect_uri", rp.config.RedirectURL)
+	q.Set("scope", strings.Join(rp.config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(rp.provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, rp.provider.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+//Callback completes a login: it checks the state, exchanges the authorization code for tokens, verifies
+//the ID token and starts a session for the user.
+func (rp *RelyingParty) Callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		loginFailed(w, http.StatusUnauthorized, LoginFailed)
+		return
+	}
+
+	state := q.Get("state")
+	c, err := r.Cookie(stateCookie)
+	if err != nil || state == "" || c.Value != state {
+		loginFailed(w, http.StatusBadRequest, LoginExpired)
+		return
+	}
+
+	rp.mu.Lock()
+	login, ok := rp.pending[state]
+	delete(rp.pending, state)
+	rp.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		loginFailed(w, http.StatusBadRequest, LoginExpired)
+		return
+	}
+
+	claims, err := rp.exchange(r.Context(), q.Get("code"), login)
+	if err != nil {
+		loginFailed(w, http.StatusUnauthorized, LoginFailed)
+		return
+	}
+
+	subject := claims.Subject
+	if claims.Email != "" {
+		subject = claims.Email
+	}
+	sessionID, expires, err := rp.Sessions.Create(auth.Identity{
+		Subject:      subject,
+		Organization: claims.Organization,
+		Roles:        claims.Roles,
+		Method:       auth.MethodSession,
+	})
+	if err != nil {
+		loginFailed(w, http.StatusInternalServerError, LoginFailed)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    sessionID,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   rp.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, login.returnTo, http.StatusFound)
+}
+
+//Logout ends the browser's session and clears its cookie.
+func (rp *RelyingParty) Logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		rp.Sessions.Delete(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+//exchange redeems the authorization code at the token endpoint and returns the verified ID token's claims.
+func (rp *RelyingParty) exchange(ctx context.Context, code string, login pendingLogin) (*auth.Claims, error) {
+	if code == "" {
+		return nil, errors.New("exchange: no authorization code")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", rp.config.RedirectURL)
+	form.Set("client_id", rp.config.ClientID)
+	form.Set("code_verifier", login.codeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, rp.provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if rp.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(rp.config.ClientID), url.QueryEscape(rp.config.ClientSecret))
+	}
+
+	resp, err := rp.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("exchange: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange: token endpoint returned %v", resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("exchange: %w", err)
+	}
+
+	claims, err := rp.verify(ctx, tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != login.nonce {
+		return nil, errors.New("exchange: ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+//verify checks the ID token, refreshing the provider's keys if the token was signed with a key we haven't seen (key
+//rotation). Refreshes are throttled to one per keyRefreshInterval, and keys the provider didn't have are refused for
+//unknownKeyTTL without another refresh.
+func (rp *RelyingParty) verify(ctx context.Context, idToken string) (*auth.Claims, error) {
+	rp.mu.Lock()
+	verifier := rp.verifier
+	rp.mu.Unlock()
+
+	claims, err := verifier.Verify(idToken)
+	if err != auth.ErrUnknownKey {
+		return claims, err
+	}
+
+	kid := tokenKeyID(idToken)
+	rp.keysMu.Lock()
+	defer rp.keysMu.Unlock()
+	now := time.Now()
+	if until, ok := rp.unknownKeys[kid]; ok && now.Before(until) {
+		return nil, auth.ErrUnknownKey
+	}
+	if now.Sub(rp.keysFetched) >= keyRefreshInterval {
+		keys, err := FetchKeySet(ctx, rp.client, rp.provider.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+		rp.keysFetched = now
+		for k, until := range rp.unknownKeys {
+			if now.After(until) {
+				delete(rp.unknownKeys, k)
+			}
+		}
+
+		rp.mu.Lock()
+		rp.verifier = &auth.TokenVerifier{Keys: keys, Issuer: verifier.Issuer, Audience: verifier.Audience}
+		verifier = rp.verifier
+		rp.mu.Unlock()
+	} else {
+		//another request may have refreshed the keys since this one read them.
+		rp.mu.Lock()
+		verifier = rp.verifier
+		rp.mu.Unlock()
+	}
+
+	claims, err = verifier.Verify(idToken)
+	if err == auth.ErrUnknownKey {
+		rp.unknownKeys[kid] = now.Add(unknownKeyTTL)
+	}
+	return claims, err
+}
+
+//tokenKeyID returns the kid in the header of a JWT, empty if it can't be read.
+func tokenKeyID(token string) string {
+	header, err := base64.RawURLEncoding.DecodeString(strings.SplitN(token, ".", 2)[0])
+	if err != nil {
+		return ""
+	}
+	var h struct {
+		Kid string `json:"kid"`
+	}
+	json.Unmarshal(header, &h)
+	return h.Kid
+}
+
+//removeExpired drops logins that were never completed, must be called with the lock held.
+func (rp *RelyingParty) removeExpired() {
+	now := time.Now()
+	for k, v := range rp.pending {
+		if now.After(v.expires) {
+			delete(rp.pending, k)
+		}
+	}
+}
+
+//codeChallenge derives the S256 PKCE challenge from a code verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+//safeReturnPath only allows redirects to local paths so the login flow can't be used as an open redirect.
+func safeReturnPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	return p
+}
+
+func loginFailed(w http.ResponseWriter, status int, msg string) {
+	body, _ := json.Marshal(validation.UserErrors{Message: msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,339 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmalensek/go-user-form/auth"
+)
+
+const testClientID = "user-form"
+
+//mockProvider is a minimal OpenID provider that signs in a fixed user without prompting.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+	//kid is the ID the key is published and tokens are signed under.
+	kid string
+	//keyFetches counts requests for the provider's keys.
+	keyFetches int
+}
+
+type authRequest struct {
+	challenge string
+	nonce     string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, codes: make(map[string]authRequest), kid: "k1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(ProviderMetadata{
+		Issuer:                p.server.URL,
+		AuthorizationEndpoint: p.server.URL + "/authorize",
+		TokenEndpoint:         p.server.URL + "/token",
+		JWKSURI:               p.server.URL + "/jwks",
+	})
+}
+
+func (p *mockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != testClientID {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	code := fmt.Sprintf("code-%v", time.Now().UnixNano())
+	p.mu.Lock()
+	p.codes[code] = authRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	p.mu.Unlock()
+
+	http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	req, ok := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code"))
+	p.mu.Unlock()
+
+	if !ok || codeChallenge(r.Form.Get("code_verifier")) != req.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken(req.nonce), "token_type": "Bearer"})
+}
+
+func (p *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.keyFetches++
+	kid := p.kid
+	p.mu.Unlock()
+
+	enc := base64.RawURLEncoding
+	fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":%q,"use":"sig","n":%q,"e":%q}]}`,
+		kid, enc.EncodeToString(p.key.N.Bytes()), enc.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()))
+}
+
+func (p *mockProvider) fetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keyFetches
+}
+
+func (p *mockProvider) idToken(nonce string) string {
+	p.mu.Lock()
+	kid := p.kid
+	p.mu.Unlock()
+	return p.signedToken(kid, nonce)
+}
+
+//signedToken returns an ID token for the fixed user signed with the provider's key under the given key ID.
+func (p *mockProvider) signedToken(kid, nonce string) string {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   p.server.URL,
+		"sub":   "12345",
+		"aud":   testClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+		"email": "jdoe@example.com",
+		"org":   "sales",
+		"roles": []string{"editor"},
+	})
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+//newTestApp starts a server with the login endpoints and an SSO-protected /users/ page.
+func newTestApp(t *testing.T, provider *mockProvider) (*httptest.Server, *RelyingParty) {
+	mux := http.NewServeMux()
+	app := httptest.NewServer(mux)
+
+	rp, err := New(context.Background(), Config{
+		Issuer:      provider.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: app.URL + "/callback",
+	}, provider.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator := &auth.Authenticator{Realm: "users", Sessions: rp.Sessions, LoginURL: "/login"}
+	mux.HandleFunc("/login", rp.Login)
+	mux.HandleFunc(rp.CallbackPath(), rp.Callback)
+	mux.HandleFunc("/logout", rp.Logout)
+	mux.HandleFunc("/users/", authenticator.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := auth.FromContext(r.Context())
+		fmt.Fprintf(w, "hello %v from %v", id.Subject, id.Organization)
+	}))
+	return app, rp
+}
+
+func browser() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar}
+}
+
+func getPage(t *testing.T, c *http.Client, u string) (*http.Response, string) {
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestLoginFlow(t *testing.T) {
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	app, _ := newTestApp(t, provider)
+	defer app.Close()
+
+	c := browser()
+	resp, body := getPage(t, c, app.URL+"/users/")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %v want %v: %v", resp.StatusCode, http.StatusOK, body)
+	}
+	if resp.Request.URL.Path != "/users/" {
+		t.Errorf("expected to be returned to /users/, ended at %v", resp.Request.URL)
+	}
+	if body != "hello jdoe@example.com from sales" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	//the session cookie keeps the browser signed in without another trip to the provider.
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+	if resp, _ := getPage(t, c, app.URL+"/users/"); resp.StatusCode != http.StatusOK {
+		t.Errorf("signed in browser got status %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	getPage(t, c, app.URL+"/logout")
+	resp, _ = getPage(t, c, app.URL+"/users/")
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(resp.Header.Get("Location"), "/login") {
+		t.Errorf("signed out browser should be sent to login, got %v %v", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestAPIRequestsAreNotRedirected(t *testing.T) {
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	app, _ := newTestApp(t, provider)
+	defer app.Close()
+
+	resp, err := http.Get(app.URL + "/users/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %v want %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestCallbackRejectsForgedState(t *testing.T) {
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	app, _ := newTestApp(t, provider)
+	defer app.Close()
+
+	//a callback that wasn't started by this browser has no matching state cookie.
+	resp, _ := getPage(t, browser(), app.URL+"/callback?code=stolen&state=attacker")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestCallbackRejectsWrongVerifier(t *testing.T) {
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	app, rp := newTestApp(t, provider)
+	defer app.Close()
+
+	c := browser()
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, _ := getPage(t, c, app.URL+"/login")
+	authURL, _ := url.Parse(resp.Header.Get("Location"))
+	state := authURL.Query().Get("state")
+
+	//tamper with the stored verifier, as if an attacker injected a code from another login.
+	rp.mu.Lock()
+	login := rp.pending[state]
+	login.codeVerifier = "not-the-right-verifier"
+	rp.pending[state] = login
+	rp.mu.Unlock()
+
+	resp, _ = getPage(t, c, authURL.String())
+	resp, _ = getPage(t, c, resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %v want %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestUnknownKeysThrottleRefresh(t *testing.T) {
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	app, rp := newTestApp(t, provider)
+	defer app.Close()
+	ctx := context.Background()
+
+	start := provider.fetches()
+	for i := 0; i < 5; i++ {
+		if _, err := rp.verify(ctx, provider.signedToken("forged", "n")); err != auth.ErrUnknownKey {
+			t.Fatalf("got %v, want %v", err, auth.ErrUnknownKey)
+		}
+	}
+	if got := provider.fetches() - start; got != 0 {
+		t.Errorf("keys were fetched %v times right after startup, want 0", got)
+	}
+
+	//once the interval has passed, an unknown key is looked up once and then remembered.
+	rp.keysMu.Lock()
+	rp.keysFetched = time.Time{}
+	rp.keysMu.Unlock()
+	for i := 0; i < 5; i++ {
+		if _, err := rp.verify(ctx, provider.signedToken("forged-2", "n")); err != auth.ErrUnknownKey {
+			t.Fatalf("got %v, want %v", err, auth.ErrUnknownKey)
+		}
+	}
+	if got := provider.fetches() - start; got != 1 {
+		t.Errorf("keys were fetched %v times, want 1", got)
+	}
+}
+
+func TestRotatedKeyIsFetched(t *testing.T) {
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	app, rp := newTestApp(t, provider)
+	defer app.Close()
+
+	provider.mu.Lock()
+	provider.kid = "k2"
+	provider.mu.Unlock()
+	rp.keysMu.Lock()
+	rp.keysFetched = time.Now().Add(-keyRefreshInterval)
+	rp.keysMu.Unlock()
+
+	claims, err := rp.verify(context.Background(), provider.idToken("n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "12345" {
+		t.Errorf("got subject %q, want %q", claims.Subject, "12345")
+	}
+}
+
+func TestSafeReturnPath(t *testing.T) {
+	cases := map[string]string{
+		"/users/":               "/users/",
+		"":                      "/",
+		"https://evil.example/": "/",
+		"//evil.example/":       "/",
+	}
+	for in, want := range cases {
+		if got := safeReturnPath(in); got != want {
+			t.Errorf("safeReturnPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
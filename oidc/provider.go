@@ -0,0 +1,79 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/auth"
+)
+
+//ProviderMetadata is the subset of the OpenID Provider discovery document the server uses.
+type ProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+//Discover fetches the provider's discovery document from {issuer}/.well-known/openid-configuration
+//and checks that it describes the expected issuer.
+func Discover(ctx context.Context, client *http.Client, issuer string) (*ProviderMetadata, error) {
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+
+	var meta ProviderMetadata
+	if err := getJSON(ctx, client, wellKnown, &meta); err != nil {
+		return nil, fmt.Errorf("Discover: %w", err)
+	}
+
+	if meta.Issuer != issuer {
+		return nil, fmt.Errorf("Discover: provider reports issuer %q, expected %q", meta.Issuer, issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("Discover: discovery document for %q is missing required endpoints", issuer)
+	}
+	return &meta, nil
+}
+
+//FetchKeySet downloads and parses the provider's signing keys.
+func FetchKeySet(ctx context.Context, client *http.Client, jwksURI string) (*auth.KeySet, error) {
+	req, err := http.NewRequest(http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("FetchKeySet: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("FetchKeySet: unexpected status %v", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("FetchKeySet: %w", err)
+	}
+	return auth.ParseKeySet(data)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, dst interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v from %v", resp.Status, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
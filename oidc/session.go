@@ -0,0 +1,97 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nmalensek/go-user-form/auth"
+)
+
+//SessionCookie is the name of the cookie holding the browser's session ID.
+const SessionCookie = "user_form_session"
+
+type session struct {
+	identity auth.Identity
+	expires  time.Time
+}
+
+//SessionStore keeps logged in browser sessions in memory. Sessions are lost when the server restarts,
+//which only means users have to sign in again.
+type SessionStore struct {
+	TTL time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]session
+	now      func() time.Time
+}
+
+//NewSessionStore returns an empty session store whose sessions last for ttl.
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{TTL: ttl, sessions: make(map[string]session), now: time.Now}
+}
+
+//Create starts a session for id and returns the session ID.
+func (s *SessionStore) Create(id auth.Identity) (string, time.Time, error) {
+	sessionID, err := randomString(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := s.now().Add(s.TTL)
+	s.sessions[sessionID] = session{identity: id, expires: expires}
+	s.removeExpired()
+	return sessionID, expires, nil
+}
+
+//Delete ends the session with the given ID.
+func (s *SessionStore) Delete(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+}
+
+//Lookup returns the identity of the session named by the request's session cookie, if it's still valid.
+func (s *SessionStore) Lookup(r *http.Request) (auth.Identity, bool) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return auth.Identity{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[c.Value]
+	if !ok {
+		return auth.Identity{}, false
+	}
+	if s.now().After(sess.expires) {
+		delete(s.sessions, c.Value)
+		return auth.Identity{}, false
+	}
+	return sess.identity, true
+}
+
+//removeExpired drops sessions that have expired, must be called with the lock held.
+func (s *SessionStore) removeExpired() {
+	now := s.now()
+	for k, v := range s.sessions {
+		if now.After(v.expires) {
+			delete(s.sessions, k)
+		}
+	}
+}
+
+//randomString returns n random bytes encoded as unpadded base64url.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,273 @@
+//Package presence lets the people editing the same user see each other, and pushes the changes saved to that user
+//while they have it open, so one editor's save doesn't silently overwrite another's.
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/nmalensek/go-user-form/events"
+)
+
+//Message types. Clients send watch and leave, the server sends the rest.
+const (
+	//TypeWelcome is the first message of a session and carries its ID, clients send it back in SessionHeader with their saves.
+	TypeWelcome = "welcome"
+	//TypeWatch announces that the client is editing UserID, replacing the user it was editing before.
+	TypeWatch = "watch"
+	//TypeLeave announces that the client stopped editing.
+	TypeLeave = "leave"
+	//TypePresence lists everyone editing UserID, it's sent to each of them whenever someone starts or stops.
+	TypePresence = "presence"
+	//TypeChanged carries a change saved to UserID by someone else.
+	TypeChanged = "changed"
+	//TypeError reports a message the server couldn't act on.
+	TypeError = "error"
+)
+
+//SessionHeader is the request header naming the session a save comes from, that session isn't sent its own change.
+//It's only honored for a session of the caller making the save, so no one can keep a change from someone else.
+const SessionHeader = "X-Editor-Session"
+
+//Message is a message sent over a presence connection, in either direction.
+type Message struct {
+	Type    string        `json:"type"`
+	Session string        `json:"session,omitempty"`
+	UserID  int           `json:"userId,omitempty"`
+	Editors []Editor      `json:"editors,omitempty"`
+	Event   *events.Event `json:"event,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+//Editor is someone who has a user open, as shown to the other editors.
+type Editor struct {
+	Session string `json:"session"`
+	Name    string `json:"name"`
+}
+
+//Masker returns an event as a session's user may see it, e.g. with the sensitive fields they may not unmask masked.
+type Masker func(e events.Event) events.Event
+
+//Session is one client's connection to the hub. C receives the messages for the client and is closed when the
+//session is disconnected, including when the client falls too far behind.
+type Session struct {
+	ID   string
+	Name string
+	C    <-chan Message
+
+	//mask is applied to the changes sent to the session, nil sends them as they are.
+	mask     Masker
+	c        chan Message
+	watching int
+	closed   bool
+}
+
+//Hub keeps track of who is editing which user. It's an events.Publisher, so the changes saved to a user reach
+//the sessions watching it.
+type Hub struct {
+	//BufferSize is how many messages a session may fall behind before it's disconnected.
+	BufferSize int
+
+	mu       sync.Mutex
+	watchers map[int]map[*Session]struct{}
+}
+
+//NewHub returns a hub giving each session room for bufferSize messages.
+func NewHub(bufferSize int) *Hub {
+	return &Hub{BufferSize: bufferSize, watchers: make(map[int]map[*Session]struct{})}
+}
+
+//Connect starts a session for the named user, its first message is the welcome. The changes sent to the session
+//are passed through mask, if it isn't nil.
+func (h *Hub) Connect(name string, mask Masker) *Session {
+	c := make(chan Message, h.BufferSize+1)
+	s := &Session{ID: events.NewID(), Name: name, C: c, mask: mask, c: c}
+	c <- Message{Type: TypeWelcome, Session: s.ID}
+	return s
+}
+
+//Watch records that s is editing the user with the given ID, and tells the editors of that user and of the user
+//s was editing before.
+func (h *Hub) Watch(s *Session, userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s.closed || s.watching == userID {
+		return
+	}
+	h.leave(s)
+
+	room, ok := h.watchers[userID]
+	if !ok {
+		room = make(map[*Session]struct{})
+		h.watchers[userID] = room
+	}
+	room[s] = struct{}{}
+	s.watching = userID
+	h.announce(userID)
+}
+
+//Leave records that s stopped editing.
+func (h *Hub) Leave(s *Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leave(s)
+}
+
+//Disconnect ends the session and closes its channel.
+func (h *Hub) Disconnect(s *Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disconnect(s)
+}
+
+//Editors returns everyone editing the user with the given ID, ordered by name.
+func (h *Hub) Editors(userID int) []Editor {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.editors(userID)
+}
+
+//Publish sends a saved change, masked for each session, to the sessions editing the changed user, except the session
+//that saved it if that session belongs to the event's actor.
+func (h *Hub) Publish(ctx context.Context, e events.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	from := SessionFromContext(ctx)
+	for s := range h.watchers[e.User.ID] {
+		//editors see each other's session IDs, so the ID alone doesn't prove the save came from the session.
+		if s.ID == from && s.Name == e.Actor {
+			continue
+		}
+		seen := e
+		if s.mask != nil {
+			seen = s.mask(e)
+		}
+		h.send(s, Message{Type: TypeChanged, UserID: e.User.ID, Event: &seen})
+	}
+}
+
+func (h *Hub) leave(s *Session) {
+	if s.watching == 0 {
+		return
+	}
+	userID := s.watching
+	s.watching = 0
+	delete(h.watchers[userID], s)
+	if len(h.watchers[userID]) == 0 {
+		delete(h.watchers, userID)
+	}
+	h.announce(userID)
+}
+
+func (h *Hub) disconnect(s *Session) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.c)
+	h.leave(s)
+}
+
+//announce sends the current editors of the user to each of them.
+func (h *Hub) announce(userID int) {
+	editors := h.editors(userID)
+	for s := range h.watchers[userID] {
+		h.send(s, Message{Type: TypePresence, UserID: userID, Editors: editors})
+	}
+}
+
+//send queues m for s without waiting, a session whose queue is full is disconnected instead.
+func (h *Hub) send(s *Session, m Message) {
+	if s.closed {
+		return
+	}
+	select {
+	case s.c <- m:
+	default:
+		h.disconnect(s)
+	}
+}
+
+func (h *Hub) editors(userID int) []Editor {
+	editors := make([]Editor, 0, len(h.watchers[userID]))
+	for s := range h.watchers[userID] {
+		editors = append(editors, Editor{Session: s.ID, Name: s.Name})
+	}
+	sort.Slice(editors, func(i, j int) bool {
+		if editors[i].Name != editors[j].Name {
+			return editors[i].Name < editors[j].Name
+		}
+		return editors[i].Session < editors[j].Session
+	})
+	return editors
+}
+
+//Serve runs a session for the named user over conn until either side closes it, passing the changes sent through mask.
+//canWatch is asked before the session watches a user, its error is sent to the client instead.
+func (h *Hub) Serve(conn *Conn, name string, mask Masker, canWatch func(userID int) error) {
+	s := h.Connect(name, mask)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer h.Disconnect(s)
+		for {
+			data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var m Message
+			if err := json.Unmarshal(data, &m); err != nil {
+				h.reply(conn, Message{Type: TypeError, Error: err.Error()})
+				continue
+			}
+			switch m.Type {
+			case TypeWatch:
+				if err := canWatch(m.UserID); err != nil {
+					h.reply(conn, Message{Type: TypeError, UserID: m.UserID, Error: err.Error()})
+				} else {
+					h.Watch(s, m.UserID)
+				}
+			case TypeLeave:
+				h.Leave(s)
+			default:
+				h.reply(conn, Message{Type: TypeError, Error: "unknown message type " + m.Type})
+			}
+		}
+	}()
+
+	for m := range s.C {
+		data, err := json.Marshal(m)
+		if err == nil {
+			err = conn.WriteMessage(data)
+		}
+		if err != nil {
+			break
+		}
+	}
+	conn.Close()
+	<-done
+}
+
+//reply writes m straight to the connection, it's only used for errors in answer to the client's own messages.
+func (h *Hub) reply(conn *Conn, m Message) {
+	data, _ := json.Marshal(m)
+	conn.WriteMessage(data)
+}
+
+type contextKey int
+
+const sessionKey contextKey = 0
+
+//WithSession returns a copy of ctx recording that the current change comes from the session with the given ID.
+func WithSession(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionKey, id)
+}
+
+//SessionFromContext returns the session stored in ctx, or an empty string if there isn't one.
+func SessionFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(sessionKey).(string)
+	return id
+}
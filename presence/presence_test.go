@@ -0,0 +1,250 @@
+package presence
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nmalensek/go-user-form/events"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//testClient is the client side of a WebSocket connection, masking its frames as browsers do.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, server *httptest.Server, origin string) (*testClient, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	req.Write(conn)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testClient{t: t, conn: conn, r: r}, resp
+}
+
+func (c *testClient) writeFrame(fin bool, op byte, payload []byte) {
+	head := []byte{op, 0x80 | byte(len(payload))}
+	if fin {
+		head[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	c.conn.Write(append(append(head, mask...), masked...))
+}
+
+func (c *testClient) send(m Message) {
+	data, _ := json.Marshal(m)
+	c.writeFrame(true, opText, data)
+}
+
+func (c *testClient) readFrame() (byte, []byte) {
+	var head [2]byte
+	if _, err := c.r.Read(head[:1]); err != nil {
+		c.t.Fatal(err)
+	}
+	c.r.Read(head[1:])
+	n := int(head[1] & 0x7F)
+	if n == 126 {
+		var ext [2]byte
+		c.r.Read(ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	for read := 0; read < n; {
+		m, err := c.r.Read(payload[read:])
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		read += m
+	}
+	return head[0] & 0x0F, payload
+}
+
+func (c *testClient) receive() Message {
+	op, payload := c.readFrame()
+	if op != opText {
+		c.t.Fatalf("got opcode %v want a text message", op)
+	}
+	var m Message
+	json.Unmarshal(payload, &m)
+	return m
+}
+
+func serveHub(h *Hub, name string, canWatch func(int) error) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		h.Serve(conn, name, nil, canWatch)
+	}))
+}
+
+func TestAcceptKey(t *testing.T) {
+	//the example from RFC 6455 section 1.3.
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	h := NewHub(4)
+	server := serveHub(h, "ann", func(int) error { return nil })
+	defer server.Close()
+
+	_, resp := dial(t, server, "http://evil.example")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got %v for a cross-origin handshake want %v", resp.StatusCode, http.StatusForbidden)
+	}
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %v for a plain request want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	c, resp := dial(t, server, server.URL)
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != AcceptKey("dGhlIHNhbXBsZSBub25jZQ==") {
+		t.Fatalf("got %v %v want an accepted upgrade", resp.StatusCode, resp.Header)
+	}
+	if m := c.receive(); m.Type != TypeWelcome || m.Session == "" {
+		t.Errorf("got %+v want a welcome with the session ID", m)
+	}
+
+	c.writeFrame(true, opPing, []byte("hi"))
+	if op, payload := c.readFrame(); op != opPong || string(payload) != "hi" {
+		t.Errorf("got opcode %v %q want a pong echoing the ping", op, payload)
+	}
+
+	//a watch split over two frames.
+	c.writeFrame(false, opText, []byte(`{"type":"wat`))
+	c.writeFrame(true, opContinuation, []byte(`ch","userId":3}`))
+	if m := c.receive(); m.Type != TypePresence || m.UserID != 3 || len(m.Editors) != 1 || m.Editors[0].Name != "ann" {
+		t.Errorf("got %+v want ann as the only editor of 3", m)
+	}
+
+	c.writeFrame(true, opClose, []byte{0x03, 0xE8})
+	if op, _ := c.readFrame(); op != opClose {
+		t.Errorf("got opcode %v want the close echoed", op)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(h.Editors(3)) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the session wasn't removed after closing")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHub(t *testing.T) {
+	h := NewHub(4)
+	forbidden := errors.New("forbidden")
+	server := serveHub(h, "bob", func(id int) error {
+		if id == 9 {
+			return forbidden
+		}
+		return nil
+	})
+	defer server.Close()
+
+	bob, _ := dial(t, server, "")
+	bobID := bob.receive().Session
+	bob.send(Message{Type: TypeWatch, UserID: 9})
+	if m := bob.receive(); m.Type != TypeError || m.Error != "forbidden" {
+		t.Errorf("got %+v want the watch refused", m)
+	}
+	bob.send(Message{Type: TypeWatch, UserID: 1})
+	bob.receive()
+
+	ann := h.Connect("ann", nil)
+	welcome := <-ann.C
+	h.Watch(ann, 1)
+	want := []Editor{{Session: ann.ID, Name: "ann"}, {Session: bobID, Name: "bob"}}
+	if m := <-ann.C; !reflect.DeepEqual(m.Editors, want) {
+		t.Errorf("got editors %+v want %+v", m.Editors, want)
+	}
+	if m := bob.receive(); m.Type != TypePresence || !reflect.DeepEqual(m.Editors, want) {
+		t.Errorf("got %+v want bob told ann joined", m)
+	}
+
+	//bob can't keep his save from ann by naming her session.
+	ctx := WithSession(context.Background(), welcome.Session)
+	h.Publish(ctx, events.Event{Type: events.UserUpdated, Actor: "bob", User: model.User{ID: 1}})
+	if m := <-ann.C; m.Type != TypeChanged {
+		t.Errorf("got %+v want ann sent bob's save", m)
+	}
+	bob.receive()
+
+	//ann's save reaches bob but isn't echoed back to ann.
+	h.Publish(ctx, events.Event{Type: events.UserUpdated, Actor: "ann", User: model.User{ID: 1, FirstName: "New"}})
+	h.Publish(ctx, events.Event{Type: events.UserUpdated, Actor: "ann", User: model.User{ID: 2}})
+	if m := bob.receive(); m.Type != TypeChanged || m.Event == nil || m.Event.User.FirstName != "New" {
+		t.Errorf("got %+v want the change to user 1", m)
+	}
+	select {
+	case m := <-ann.C:
+		t.Errorf("got %+v want ann not sent their own save", m)
+	default:
+	}
+
+	h.Leave(ann)
+	if m := bob.receive(); len(m.Editors) != 1 || m.Editors[0].Name != "bob" {
+		t.Errorf("got %+v want bob left alone", m)
+	}
+
+	//a session that stops reading is dropped rather than blocking the publisher.
+	h = NewHub(2)
+	slow, fast := h.Connect("slow", nil), h.Connect("fast", nil)
+	h.Watch(slow, 1)
+	h.Watch(fast, 1)
+	//fast reads its welcome and the news that it joined.
+	<-fast.C
+	<-fast.C
+	h.Publish(context.Background(), events.Event{Type: events.UserUpdated, User: model.User{ID: 1}})
+	types := make([]string, 0)
+	for m := range slow.C {
+		types = append(types, m.Type)
+	}
+	if want := []string{TypeWelcome, TypePresence, TypePresence}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got %v queued for the slow session want %v", types, want)
+	}
+	//the change and the news that slow left, in either order.
+	got := map[string]int{}
+	for i := 0; i < 2; i++ {
+		m := <-fast.C
+		got[m.Type] += len(m.Editors)
+	}
+	if want := map[string]int{TypeChanged: 0, TypePresence: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want the change and fast as the only editor", got)
+	}
+}
@@ -0,0 +1,274 @@
+package presence
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//acceptGUID is appended to the client's key to compute Sec-WebSocket-Accept (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+//writeTimeout bounds each write, so a client that stops reading can't hold up the others.
+const writeTimeout = 10 * time.Second
+
+//MaxMessageSize is the largest message a client may send, presence messages are a few dozen bytes.
+const MaxMessageSize = 4096
+
+//Frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+//Close status codes.
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+	closeTooBig        = 1009
+)
+
+//Handshake errors.
+var (
+	ErrNotWebSocket = errors.New("presence: not a websocket handshake")
+	ErrBadOrigin    = errors.New("presence: cross-origin websocket request")
+)
+
+var errProtocol = errors.New("presence: websocket protocol error")
+var errTooBig = errors.New("presence: websocket message too large")
+var errClosed = errors.New("presence: websocket connection closed")
+
+//Conn is a server-side WebSocket connection carrying text messages. Reads must come from a single goroutine,
+//writes may come from any.
+type Conn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+//Upgrade completes the WebSocket handshake for r and takes over its connection. Browsers send the page's origin with
+//the handshake, it must match the request's host so other sites can't open connections with the user's cookies.
+//If the handshake is refused an error response has been written.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+		return nil, ErrNotWebSocket
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return nil, ErrBadOrigin
+		}
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, errors.New("presence: response writer can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	rw.WriteString(AcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	rw.WriteString("\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, rw: rw}, nil
+}
+
+//AcceptKey returns the Sec-WebSocket-Accept value answering a client's Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//ReadMessage returns the next text or binary message, answering pings along the way. It returns io.EOF once
+//the client closes the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			c.closeWith(statusFor(err))
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.closeWith(closeNormal)
+			return nil, io.EOF
+		case opText, opBinary:
+			if started {
+				c.closeWith(closeProtocolError)
+				return nil, errProtocol
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				c.closeWith(closeProtocolError)
+				return nil, errProtocol
+			}
+		default:
+			c.closeWith(closeProtocolError)
+			return nil, errProtocol
+		}
+		if len(message)+len(payload) > MaxMessageSize {
+			c.closeWith(closeTooBig)
+			return nil, errTooBig
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+//readFrame reads one frame and unmasks its payload. Clients must mask every frame.
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.rw, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		//reserved bits are only used by extensions, which aren't negotiated.
+		return fin, op, nil, errProtocol
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (!fin || length > 125) {
+		return fin, op, nil, errProtocol
+	}
+	if length > MaxMessageSize {
+		return fin, op, nil, errTooBig
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.rw, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.rw, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+func statusFor(err error) int {
+	if err == errTooBig {
+		return closeTooBig
+	}
+	return closeProtocolError
+}
+
+//WriteMessage sends data as a text message.
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+//writeFrame sends payload as a single unmasked frame, as servers must.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return errClosed
+	}
+
+	head := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n <= 125:
+		head[1] = byte(n)
+	case n <= 0xFFFF:
+		head[1] = 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(n))
+	default:
+		head[1] = 127
+		head = append(head, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	c.rw.Write(head)
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+//Close sends a normal close frame and closes the connection.
+func (c *Conn) Close() error {
+	return c.closeWith(closeNormal)
+}
+
+func (c *Conn) closeWith(status int) error {
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], uint16(status))
+	c.writeFrame(opClose, payload[:])
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -0,0 +1,116 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+)
+
+//Attribute value types.
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeDate    = "date"
+)
+
+//DateLayout is the format date attributes are stored in.
+const DateLayout = "2006-01-02"
+
+var validName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,63}$`)
+
+//Attribute defines a custom user attribute. Values are always stored as strings and checked against the type:
+//Min and Max bound the length of strings and the value of numbers and integers. Pattern and Enum only apply to strings.
+type Attribute struct {
+	Name         string   `json:"name"`
+	FriendlyName string   `json:"friendlyName,omitempty"`
+	Type         string   `json:"type"`
+	Required     bool     `json:"required"`
+	Pattern      string   `json:"pattern,omitempty"`
+	Enum         []string `json:"enum,omitempty"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+
+	compiled *regexp.Regexp
+}
+
+//Schema is the set of custom attributes users may have.
+type Schema struct {
+	Attributes []Attribute `json:"attributes"`
+}
+
+//Label returns the name shown to people for the attribute.
+func (a *Attribute) Label() string {
+	if a.FriendlyName == "" {
+		return a.Name
+	}
+	return a.FriendlyName
+}
+
+//Regexp returns the attribute's compiled pattern, or nil if it doesn't have one.
+func (a *Attribute) Regexp() *regexp.Regexp {
+	if a.compiled == nil && a.Pattern != "" {
+		a.compiled, _ = regexp.Compile(a.Pattern)
+	}
+	return a.compiled
+}
+
+//Check returns an error describing the first problem with the attribute definition.
+func (a *Attribute) Check() error {
+	if !validName.MatchString(a.Name) {
+		return fmt.Errorf("attribute name %q must start with a letter and contain only letters, digits and underscores", a.Name)
+	}
+	switch a.Type {
+	case TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeDate:
+	default:
+		return fmt.Errorf("attribute %q has unknown type %q", a.Name, a.Type)
+	}
+	if a.Pattern != "" {
+		if a.Type != TypeString {
+			return fmt.Errorf("attribute %q: patterns only apply to string attributes", a.Name)
+		}
+		re, err := regexp.Compile(a.Pattern)
+		if err != nil {
+			return fmt.Errorf("attribute %q: %v", a.Name, err)
+		}
+		a.compiled = re
+	}
+	if len(a.Enum) > 0 && a.Type != TypeString {
+		return fmt.Errorf("attribute %q: enums only apply to string attributes", a.Name)
+	}
+	if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+		return fmt.Errorf("attribute %q: min is greater than max", a.Name)
+	}
+	return nil
+}
+
+//Check verifies every attribute definition and that no name is used twice.
+func (s *Schema) Check() error {
+	seen := make(map[string]struct{})
+	for i := range s.Attributes {
+		if err := s.Attributes[i].Check(); err != nil {
+			return err
+		}
+		if _, dup := seen[s.Attributes[i].Name]; dup {
+			return fmt.Errorf("attribute %q is defined more than once", s.Attributes[i].Name)
+		}
+		seen[s.Attributes[i].Name] = struct{}{}
+	}
+	return nil
+}
+
+func (s Schema) clone() Schema {
+	attrs := make([]Attribute, len(s.Attributes))
+	copy(attrs, s.Attributes)
+	return Schema{Attributes: attrs}
+}
+
+//Find returns the attribute with the given name.
+func (s *Schema) Find(name string) (*Attribute, bool) {
+	for i := range s.Attributes {
+		if s.Attributes[i].Name == name {
+			return &s.Attributes[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name  string
+		attr  Attribute
+		valid bool
+	}{
+		{"string", Attribute{Name: "title", Type: TypeString, Max: float(50)}, true},
+		{"pattern", Attribute{Name: "phone", Type: TypeString, Pattern: `^\+?[0-9 ]+$`}, true},
+		{"bad name", Attribute{Name: "1st", Type: TypeString}, false},
+		{"bad type", Attribute{Name: "x", Type: "color"}, false},
+		{"bad pattern", Attribute{Name: "x", Type: TypeString, Pattern: "("}, false},
+		{"pattern on number", Attribute{Name: "x", Type: TypeNumber, Pattern: "1"}, false},
+		{"min over max", Attribute{Name: "x", Type: TypeInteger, Min: float(5), Max: float(1)}, false},
+	}
+	for _, c := range cases {
+		err := c.attr.Check()
+		if (err == nil) != c.valid {
+			t.Errorf("%v: Check() = %v, want valid %v", c.name, err, c.valid)
+		}
+	}
+
+	dup := Schema{Attributes: []Attribute{{Name: "a", Type: TypeString}, {Name: "a", Type: TypeNumber}}}
+	if err := dup.Check(); err == nil {
+		t.Errorf("duplicate attribute names should be rejected")
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "schema.json")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Current().Attributes) != 0 {
+		t.Errorf("missing schema file should give an empty schema")
+	}
+
+	store.Set(Attribute{Name: "title", Type: TypeString})
+	store.Set(Attribute{Name: "level", Type: TypeInteger})
+	store.Set(Attribute{Name: "title", Type: TypeString, Required: true})
+	if err := store.Set(Attribute{Name: "bad", Type: "nope"}); err == nil {
+		t.Errorf("invalid attribute should be rejected")
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := reopened.Current()
+	if len(current.Attributes) != 2 {
+		t.Fatalf("got %v attributes want 2: %v", len(current.Attributes), current.Attributes)
+	}
+	if title, _ := current.Find("title"); !title.Required {
+		t.Errorf("setting an existing attribute should replace it")
+	}
+
+	if err := reopened.Remove("level"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Remove("level"); err == nil || err.Error() != AttributeNotFound {
+		t.Errorf("removing a missing attribute should fail with %v, got %v", AttributeNotFound, err)
+	}
+}
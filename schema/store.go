@@ -0,0 +1,117 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+//Store error messages.
+const (
+	AttributeNotFound = "Could not find the specified attribute in the schema."
+)
+
+//Store keeps the attribute schema in memory and saves every change to a JSON file.
+type Store struct {
+	Path string
+
+	mu     sync.RWMutex
+	schema Schema
+}
+
+//Open loads the schema file at path. A missing file is treated as an empty schema.
+func Open(path string) (*Store, error) {
+	s := &Store{Path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.schema); err != nil {
+		return nil, err
+	}
+	if err := s.schema.Check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//Current returns a copy of the schema.
+func (s *Store) Current() Schema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schema.clone()
+}
+
+//Replace checks and saves a whole new schema.
+func (s *Store) Replace(next Schema) error {
+	return s.update(func(current *Schema) error {
+		*current = next.clone()
+		return nil
+	})
+}
+
+//Set adds the attribute or replaces the existing attribute with the same name.
+func (s *Store) Set(a Attribute) error {
+	return s.update(func(current *Schema) error {
+		if existing, ok := current.Find(a.Name); ok {
+			*existing = a
+		} else {
+			current.Attributes = append(current.Attributes, a)
+		}
+		return nil
+	})
+}
+
+//Remove deletes the named attribute from the schema. Values users already have are kept but no longer validated.
+func (s *Store) Remove(name string) error {
+	return s.update(func(current *Schema) error {
+		kept := make([]Attribute, 0, len(current.Attributes))
+		for _, a := range current.Attributes {
+			if a.Name != name {
+				kept = append(kept, a)
+			}
+		}
+		if len(kept) == len(current.Attributes) {
+			return errors.New(AttributeNotFound)
+		}
+		current.Attributes = kept
+		return nil
+	})
+}
+
+//update applies change to a copy of the schema, then checks and saves the result.
+func (s *Store) update(change func(*Schema) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.schema.clone()
+	if err := change(&next); err != nil {
+		return err
+	}
+	if err := next.Check(); err != nil {
+		return err
+	}
+	return s.save(next)
+}
+
+//save writes next to the file and makes it current, must be called with the write lock held.
+func (s *Store) save(next Schema) error {
+	if s.Path != "" {
+		data, err := json.MarshalIndent(next, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(s.Path, data, 0600); err != nil {
+			return err
+		}
+	}
+	s.schema = next
+	return nil
+}
@@ -0,0 +1,323 @@
+//Package search is an in-memory inverted index for finding documents, such as users, by the words in their fields.
+//Query terms match indexed words exactly, as a prefix, as a substring or with a few typos, and results are
+//ranked by how well and in which fields each term matched.
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//How much each kind of match is worth, before the field's weight is applied.
+const (
+	exactScore     = 1.0
+	prefixScore    = 0.8
+	substringScore = 0.6
+	fuzzyScore     = 0.4
+)
+
+//Field is a piece of text in a document. Matches in fields with a higher weight rank higher.
+type Field struct {
+	Text   string
+	Weight float64
+}
+
+//Match is a document that matched a query and its relevance, higher is better.
+type Match struct {
+	ID    int
+	Score float64
+}
+
+//Index maps words to the documents containing them. It's safe for concurrent use.
+type Index struct {
+	mu sync.RWMutex
+	//postings holds the documents each word appears in, with the highest weight of the fields it appears in.
+	postings map[string]map[int]float64
+	//words holds each document's words, so the document can be removed.
+	words map[int][]string
+	//trigrams maps each three-rune sequence to the words containing it, for substring matching.
+	trigrams map[string]map[string]struct{}
+	//vocabulary is the sorted list of words for prefix matching.
+	vocabulary []string
+}
+
+//NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{
+		postings: make(map[string]map[int]float64),
+		words:    make(map[int][]string),
+		trigrams: make(map[string]map[string]struct{}),
+	}
+}
+
+//Add indexes the fields of the document with the given ID, replacing anything previously indexed for it.
+func (x *Index) Add(id int, fields ...Field) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+
+	weights := make(map[string]float64)
+	for _, f := range fields {
+		for _, w := range Tokenize(f.Text) {
+			if f.Weight > weights[w] {
+				weights[w] = f.Weight
+			}
+		}
+	}
+	words := make([]string, 0, len(weights))
+	for w, weight := range weights {
+		docs, ok := x.postings[w]
+		if !ok {
+			docs = make(map[int]float64)
+			x.postings[w] = docs
+			x.addWord(w)
+		}
+		docs[id] = weight
+		words = append(words, w)
+	}
+	x.words[id] = words
+}
+
+//Remove drops the document with the given ID from the index.
+func (x *Index) Remove(id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+func (x *Index) remove(id int) {
+	for _, w := range x.words[id] {
+		docs := x.postings[w]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(x.postings, w)
+			x.removeWord(w)
+		}
+	}
+	delete(x.words, id)
+}
+
+//Len returns the number of documents in the index.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.words)
+}
+
+//Search returns the documents matching every term of the query, best first and then by ID.
+//An empty query matches nothing.
+func (x *Index) Search(query string) []Match {
+	terms := Tokenize(query)
+	if len(terms) == 0 {
+		return []Match{}
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var scores map[int]float64
+	for _, term := range terms {
+		termScores := x.scoreTerm(term)
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id, s := range scores {
+			if ts, ok := termScores[id]; ok {
+				scores[id] = s + ts
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	matches := make([]Match, 0, len(scores))
+	for id, s := range scores {
+		matches = append(matches, Match{ID: id, Score: s})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
+
+//scoreTerm returns the best score of each document with a word matching term.
+func (x *Index) scoreTerm(term string) map[int]float64 {
+	scores := make(map[int]float64)
+	credit := func(word string, kind float64) {
+		for id, weight := range x.postings[word] {
+			if s := kind * weight; s > scores[id] {
+				scores[id] = s
+			}
+		}
+	}
+
+	credit(term, exactScore)
+	for i := sort.SearchStrings(x.vocabulary, term); i < len(x.vocabulary) && strings.HasPrefix(x.vocabulary[i], term); i++ {
+		if x.vocabulary[i] != term {
+			credit(x.vocabulary[i], prefixScore)
+		}
+	}
+	for _, w := range x.substringCandidates(term) {
+		if w != term && !strings.HasPrefix(w, term) && strings.Contains(w, term) {
+			credit(w, substringScore)
+		}
+	}
+	if allowed := MaxEdits(term); allowed > 0 {
+		n := len([]rune(term))
+		for _, w := range x.vocabulary {
+			if d := len([]rune(w)) - n; d > allowed || -d > allowed {
+				continue
+			}
+			if w != term && EditDistance(term, w) <= allowed {
+				credit(w, fuzzyScore)
+			}
+		}
+	}
+	return scores
+}
+
+//substringCandidates returns the words sharing every trigram of term. Terms shorter than a trigram
+//only match as prefixes.
+func (x *Index) substringCandidates(term string) []string {
+	grams := trigramsOf(term)
+	if len(grams) == 0 {
+		return nil
+	}
+	var smallest map[string]struct{}
+	for _, g := range grams {
+		words := x.trigrams[g]
+		if len(words) == 0 {
+			return nil
+		}
+		if smallest == nil || len(words) < len(smallest) {
+			smallest = words
+		}
+	}
+	candidates := make([]string, 0, len(smallest))
+	for w := range smallest {
+		candidates = append(candidates, w)
+	}
+	return candidates
+}
+
+//addWord adds a word that's new to the index to the vocabulary and the trigrams.
+func (x *Index) addWord(word string) {
+	i := sort.SearchStrings(x.vocabulary, word)
+	x.vocabulary = append(x.vocabulary, "")
+	copy(x.vocabulary[i+1:], x.vocabulary[i:])
+	x.vocabulary[i] = word
+
+	for _, g := range trigramsOf(word) {
+		words, ok := x.trigrams[g]
+		if !ok {
+			words = make(map[string]struct{})
+			x.trigrams[g] = words
+		}
+		words[word] = struct{}{}
+	}
+}
+
+//removeWord drops a word that no document contains any more.
+func (x *Index) removeWord(word string) {
+	if i := sort.SearchStrings(x.vocabulary, word); i < len(x.vocabulary) && x.vocabulary[i] == word {
+		x.vocabulary = append(x.vocabulary[:i], x.vocabulary[i+1:]...)
+	}
+
+	for _, g := range trigramsOf(word) {
+		delete(x.trigrams[g], word)
+		if len(x.trigrams[g]) == 0 {
+			delete(x.trigrams, g)
+		}
+	}
+}
+
+func trigramsOf(word string) []string {
+	r := []rune(word)
+	grams := make([]string, 0, len(r))
+	for i := 0; i+3 <= len(r); i++ {
+		grams = append(grams, string(r[i:i+3]))
+	}
+	return grams
+}
+
+//Tokenize splits text into lowercase words without accents, so "José O'Neil" and "jose oneil" have the same words.
+//Letters and digits make up words, apostrophes are dropped and everything else separates words.
+func Tokenize(text string) []string {
+	words := make([]string, 0)
+	b := strings.Builder{}
+	flush := func() {
+		if b.Len() > 0 {
+			words = append(words, b.String())
+			b.Reset()
+		}
+	}
+	for _, r := range norm.NFD.String(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.Is(unicode.Mn, r) || r == '\'' || r == '’':
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+//MaxEdits returns the number of typos tolerated in a word: none for very short words, one for words up to six
+//runes and two for longer ones.
+func MaxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 2:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+//EditDistance returns the number of single-rune insertions, deletions, substitutions and swaps of adjacent runes
+//needed to turn a into b (the optimal string alignment distance).
+func EditDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	//only the previous two rows are needed.
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	m := first
+	for _, n := range rest {
+		if n < m {
+			m = n
+		}
+	}
+	return m
+}
@@ -0,0 +1,80 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("José O'Neil <jose.oneil+work@Example.com>")
+	want := []string{"jose", "oneil", "jose", "oneil", "work", "example", "com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() = %v, want %v", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	x := NewIndex()
+	x.Add(1, Field{Text: "Jane Doe", Weight: 1}, Field{Text: "Sales", Weight: 0.5})
+	x.Add(2, Field{Text: "Janet Smith", Weight: 1}, Field{Text: "Support", Weight: 0.5})
+	x.Add(3, Field{Text: "Bob Janeway", Weight: 1}, Field{Text: "Sales", Weight: 0.5})
+	x.Add(4, Field{Text: "Alice Mejane", Weight: 1}, Field{Text: "Marketing", Weight: 0.5})
+
+	ids := func(matches []Match) []int {
+		got := make([]int, 0, len(matches))
+		for _, m := range matches {
+			got = append(got, m.ID)
+		}
+		return got
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		//exact beats prefix beats substring, the typo "jnae" still finds jane.
+		{"jane", []int{1, 2, 3, 4}},
+		{"jnae", []int{1}},
+		{"jane sales", []int{1, 3}},
+		{"smiht", []int{2}},
+		{"sal", []int{1, 3}},
+		{"nobody", []int{}},
+		{"", []int{}},
+	}
+	for _, tt := range tests {
+		if got := ids(x.Search(tt.query)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	x.Add(1, Field{Text: "Jane Roe", Weight: 1})
+	x.Remove(3)
+	if got := ids(x.Search("sales")); len(got) != 0 {
+		t.Errorf("Search(sales) after replacing user 1 and removing user 3 = %v, want none", got)
+	}
+	if got := ids(x.Search("janeway")); len(got) != 0 {
+		t.Errorf("Search(janeway) after removing user 3 = %v, want none", got)
+	}
+	if x.Len() != 3 {
+		t.Errorf("Len() = %v, want 3", x.Len())
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"jane", "jane", 0},
+		{"jane", "jnae", 1},
+		{"jane", "janet", 1},
+		{"garcia", "garcai", 1},
+		{"smith", "smyth", 1},
+		{"", "abc", 3},
+	}
+	for _, tt := range tests {
+		if got := EditDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("EditDistance(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
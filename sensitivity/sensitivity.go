@@ -0,0 +1,177 @@
+//Package sensitivity classifies user fields by how they're shown to callers who may not see personal data, and masks
+//or redacts the values of sensitive fields. Fields are named by their JSON names, custom attributes as
+//"attributes.<name>". The built-in classification comes from the sensitivity tags on model.User.
+package sensitivity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Masks say how the value of a field is shown to callers who may not see it.
+const (
+	//MaskNone shows the value as it is, the field isn't sensitive.
+	MaskNone = "none"
+	//MaskEmail keeps the first character of an email address and its domain, e.g. j***@example.com.
+	MaskEmail = "email"
+	//MaskPartial keeps the first character, e.g. J***.
+	MaskPartial = "partial"
+	//MaskFull hides the whole value.
+	MaskFull = "full"
+)
+
+//Redacted replaces the values of sensitive fields in logs.
+const Redacted = "[redacted]"
+
+//masked replaces the hidden part of a value, whatever its length, so the length isn't revealed either.
+const masked = "***"
+
+//Policy maps fields to their mask, fields that aren't listed aren't sensitive. A nil policy treats every field as
+//not sensitive.
+type Policy struct {
+	fields map[string]string
+}
+
+//Default returns the classification given by the sensitivity tags on model.User.
+func Default() *Policy {
+	p := &Policy{fields: make(map[string]string)}
+	t := reflect.TypeOf(model.User{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if mask := f.Tag.Get("sensitivity"); mask != "" {
+			p.fields[strings.Split(f.Tag.Get("json"), ",")[0]] = mask
+		}
+	}
+	return p
+}
+
+//Load returns the default classification changed by a JSON file mapping fields to their mask, e.g.
+//{"firstName": "none", "attributes.phone": "full"}.
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sensitivity: %w", err)
+	}
+	var fields map[string]string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("sensitivity: %w", err)
+	}
+
+	p := Default()
+	for field, mask := range fields {
+		switch mask {
+		case MaskNone:
+			delete(p.fields, fieldKey(field))
+		case MaskEmail, MaskPartial, MaskFull:
+			p.fields[fieldKey(field)] = mask
+		default:
+			return nil, fmt.Errorf("sensitivity: field %q has unknown mask %q, use %v, %v, %v or %v", field, mask, MaskNone, MaskEmail, MaskPartial, MaskFull)
+		}
+	}
+	return p, nil
+}
+
+//Mask returns the mask of field, MaskNone if it isn't sensitive. Field names may also be given as Go field names,
+//e.g. "Email", as validation errors name them.
+func (p *Policy) Mask(field string) string {
+	if p == nil {
+		return MaskNone
+	}
+	if mask, ok := p.fields[fieldKey(field)]; ok {
+		return mask
+	}
+	return MaskNone
+}
+
+//Sensitive reports whether field is masked.
+func (p *Policy) Sensitive(field string) bool {
+	return p.Mask(field) != MaskNone
+}
+
+//MaskValue returns value as it's shown to callers who may not see field.
+func (p *Policy) MaskValue(field, value string) string {
+	return MaskString(p.Mask(field), value)
+}
+
+//Redact returns value as it's written to logs: Redacted if field is sensitive and not empty.
+func (p *Policy) Redact(field, value string) string {
+	if value == "" || !p.Sensitive(field) {
+		return value
+	}
+	return Redacted
+}
+
+//MaskUser returns u with its sensitive fields masked. The attributes of u aren't changed, a masked copy is made.
+func (p *Policy) MaskUser(u model.User) model.User {
+	if p == nil {
+		return u
+	}
+	u.FirstName = p.MaskValue("firstName", u.FirstName)
+	u.LastName = p.MaskValue("lastName", u.LastName)
+	u.Email = p.MaskValue("email", u.Email)
+	u.Organization = p.MaskValue("organization", u.Organization)
+	u.CreatedBy = p.MaskValue("createdBy", u.CreatedBy)
+	u.UpdatedBy = p.MaskValue("updatedBy", u.UpdatedBy)
+	if len(u.Attributes) > 0 {
+		attrs := make(map[string]string, len(u.Attributes))
+		for k, v := range u.Attributes {
+			attrs[k] = p.MaskValue("attributes."+k, v)
+		}
+		u.Attributes = attrs
+	}
+	return u
+}
+
+//MaskString masks value with mask. Empty values stay empty, so it's still clear whether a field is set.
+func MaskString(mask, value string) string {
+	if value == "" {
+		return value
+	}
+	switch mask {
+	case MaskNone:
+		return value
+	case MaskEmail:
+		at := strings.LastIndex(value, "@")
+		if at < 0 {
+			return MaskString(MaskPartial, value)
+		}
+		return MaskString(MaskPartial, value[:at]) + value[at:]
+	case MaskPartial:
+		r, size := utf8.DecodeRuneInString(value)
+		if size == 0 || !unicode.IsPrint(r) {
+			return masked
+		}
+		return string(r) + masked
+	}
+	return masked
+}
+
+//fieldKey returns the JSON name of a field given by its JSON or Go name, e.g. "firstName" for "FirstName".
+func fieldKey(field string) string {
+	if field == "" {
+		return field
+	}
+	r, size := utf8.DecodeRuneInString(field)
+	return string(unicode.ToLower(r)) + field[size:]
+}
+
+type contextKey struct{}
+
+//WithPolicy returns a copy of ctx carrying p, so errors can be redacted where the environment isn't at hand.
+func WithPolicy(ctx context.Context, p *Policy) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+//FromContext returns the policy in ctx, nil if there isn't one.
+func FromContext(ctx context.Context) *Policy {
+	p, _ := ctx.Value(contextKey{}).(*Policy)
+	return p
+}
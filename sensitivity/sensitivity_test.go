@@ -0,0 +1,104 @@
+package sensitivity
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+func TestMaskString(t *testing.T) {
+	tests := []struct {
+		mask, value, want string
+	}{
+		{MaskNone, "Ann", "Ann"},
+		{MaskPartial, "Ann", "A***"},
+		{MaskPartial, "Élodie", "É***"},
+		{MaskEmail, "ann.smith@example.com", "a***@example.com"},
+		{MaskEmail, "not-an-address", "n***"},
+		{MaskFull, "555-0100", "***"},
+		{MaskFull, "", ""},
+	}
+	for _, tt := range tests {
+		if got := MaskString(tt.mask, tt.value); got != tt.want {
+			t.Errorf("MaskString(%q, %q) = %q want %q", tt.mask, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	p := Default()
+	for field, want := range map[string]string{"firstName": MaskPartial, "LastName": MaskPartial, "email": MaskEmail, "organization": MaskNone, "id": MaskNone} {
+		if got := p.Mask(field); got != want {
+			t.Errorf("Mask(%q) = %q want %q", field, got, want)
+		}
+	}
+
+	var none *Policy
+	if none.Sensitive("email") || none.Redact("email", "ann@example.com") != "ann@example.com" {
+		t.Error("a nil policy shouldn't treat any field as sensitive")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sensitivity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sensitivity.json")
+	ioutil.WriteFile(path, []byte(`{"firstName": "none", "email": "full", "attributes.phone": "full"}`), 0600)
+	p, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]string{"firstName": MaskNone, "lastName": MaskPartial, "email": MaskFull, "attributes.phone": MaskFull} {
+		if got := p.Mask(field); got != want {
+			t.Errorf("Mask(%q) = %q want %q", field, got, want)
+		}
+	}
+
+	ioutil.WriteFile(path, []byte(`{"email": "hidden"}`), 0600)
+	if _, err := Load(path); err == nil {
+		t.Error("expected an unknown mask to be refused")
+	}
+}
+
+func TestMaskUser(t *testing.T) {
+	p := Default()
+	p.fields["attributes.phone"] = MaskFull
+	u := model.User{ID: 1, FirstName: "Ann", LastName: "Smith", Email: "ann@example.com", Organization: "sales",
+		Attributes: map[string]string{"phone": "555-0100", "team": "north"}}
+
+	got := p.MaskUser(u)
+	if got.ID != 1 || got.FirstName != "A***" || got.LastName != "S***" || got.Email != "a***@example.com" || got.Organization != "sales" {
+		t.Errorf("got %+v", got)
+	}
+	if got.Attributes["phone"] != "***" || got.Attributes["team"] != "north" {
+		t.Errorf("got attributes %v", got.Attributes)
+	}
+	if u.Attributes["phone"] != "555-0100" {
+		t.Error("masking changed the attributes of the original user")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	p := Default()
+	if got := p.Redact("Email", "ann@example.com"); got != Redacted {
+		t.Errorf("got %q want %q", got, Redacted)
+	}
+	if got := p.Redact("organization", "sales"); got != "sales" {
+		t.Errorf("got %q want the value as it is", got)
+	}
+	if got := p.Redact("email", ""); got != "" {
+		t.Errorf("got %q want an empty value to stay empty", got)
+	}
+
+	if FromContext(context.Background()) != nil || FromContext(WithPolicy(context.Background(), p)) != p {
+		t.Error("expected the policy to be carried by the context")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
@@ -13,6 +14,34 @@ func userHandler(w http.ResponseWriter, r *http.Request, e *config.Env) {
 	users.ProcessRequestByType(w, r, e)
 }
 
+func auditHandler(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	users.ProcessAuditRequest(w, r, e)
+}
+
+//protect puts the environment's authenticator in front of h, if one is configured.
+func protect(h http.HandlerFunc, e *config.Env) http.HandlerFunc {
+	if e.Auth == nil {
+		return h
+	}
+	return e.Auth.Wrap(h)
+}
+
+func schemaHandler(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	users.ProcessSchemaRequest(w, r, e)
+}
+
+func organizationHandler(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	users.ProcessOrganizationRequest(w, r, e)
+}
+
+func webhookHandler(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	users.ProcessWebhookRequest(w, r, e)
+}
+
+func adminHandler(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	users.ProcessAdminRequest(w, r, e)
+}
+
 func main() {
 	flag.Parse()
 	if flag.NFlag() == 0 {
@@ -22,7 +51,35 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if env.Auth == nil {
+		log.Println("WARNING: no authentication configured (-auth or -oidc), the API is open to anyone who can reach it.")
+	}
+
+	if env.Retention > 0 {
+		go users.RunPurgeJob(context.Background(), env)
+	}
+	if env.Webhooks != nil {
+		go env.Webhooks.Run(context.Background())
+	}
+	if env.BackupSchedule != nil {
+		go env.BackupSchedule.Run(context.Background())
+	}
 
-	http.HandleFunc("/users/", config.MakeHandler(userHandler, env))
+	http.HandleFunc("/users/", protect(config.MakeHandler(userHandler, env), env))
+	http.HandleFunc("/organizations/", protect(config.MakeHandler(organizationHandler, env), env))
+	http.HandleFunc("/webhooks", protect(config.MakeHandler(webhookHandler, env), env))
+	http.HandleFunc("/webhooks/", protect(config.MakeHandler(webhookHandler, env), env))
+	http.HandleFunc("/audit", protect(config.MakeHandler(auditHandler, env), env))
+	http.HandleFunc("/schema", protect(config.MakeHandler(schemaHandler, env), env))
+	http.HandleFunc("/schema/", protect(config.MakeHandler(schemaHandler, env), env))
+	http.HandleFunc("/admin/", protect(config.MakeHandler(adminHandler, env), env))
+	if env.OIDC != nil {
+		http.HandleFunc("/login", env.OIDC.Login)
+		http.HandleFunc(env.OIDC.CallbackPath(), env.OIDC.Callback)
+		http.HandleFunc("/logout", env.OIDC.Logout)
+	}
+	if env.StaticDir != "" {
+		http.HandleFunc("/", protect(http.FileServer(http.Dir(env.StaticDir)).ServeHTTP, env))
+	}
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
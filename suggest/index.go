@@ -0,0 +1,254 @@
+//Package suggest provides as-you-type suggestions of user field values and of existing users, from a sorted
+//in-memory index that's rebuilt whenever users change.
+package suggest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/nmalensek/go-user-form/model"
+	"golang.org/x/text/unicode/norm"
+)
+
+//UserField is the field name for suggestions of existing users, matched by first name then last name or by last name.
+const UserField = "user"
+
+//valueFields are the user fields whose distinct values can be suggested, keyed on their JSON names.
+var valueFields = map[string]func(model.User) string{
+	"firstName":    func(u model.User) string { return u.FirstName },
+	"lastName":     func(u model.User) string { return u.LastName },
+	"email":        func(u model.User) string { return u.Email },
+	"organization": func(u model.User) string { return u.Organization },
+}
+
+//Fields returns the names of every field that can be configured for suggestions, sorted.
+func Fields() []string {
+	fields := []string{UserField}
+	for f := range valueFields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+//FieldConfig controls the suggestions for one field.
+type FieldConfig struct {
+	//MaxResults caps the suggestions returned, whatever limit the request asks for.
+	MaxResults int `json:"maxResults"`
+	//MinPrefix is the shortest prefix suggestions are given for, shorter prefixes get none.
+	MinPrefix int `json:"minPrefix"`
+}
+
+//Config is the fields that may be suggested, keyed on field name. Fields that aren't listed aren't suggested.
+type Config map[string]FieldConfig
+
+//DefaultConfig suggests organizations, names and users but not email addresses.
+func DefaultConfig() Config {
+	return Config{
+		"organization": {MaxResults: 10, MinPrefix: 1},
+		"firstName":    {MaxResults: 10, MinPrefix: 1},
+		"lastName":     {MaxResults: 10, MinPrefix: 1},
+		UserField:      {MaxResults: 10, MinPrefix: 2},
+	}
+}
+
+//ReadConfig reads a JSON suggestion configuration file, a map of field names to their FieldConfig.
+func ReadConfig(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := make(Config)
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	for f, fc := range c {
+		if _, ok := valueFields[f]; !ok && f != UserField {
+			return nil, &FieldError{Field: f}
+		}
+		if fc.MaxResults <= 0 {
+			fc.MaxResults = 10
+			c[f] = fc
+		}
+	}
+	return c, nil
+}
+
+//FieldError reports a field that can't be suggested, either because it doesn't exist or isn't configured.
+type FieldError struct {
+	Field string
+}
+
+func (e *FieldError) Error() string {
+	return "suggest: field " + e.Field + " can't be suggested"
+}
+
+//Suggestion is a suggested value. Value suggestions have the number of users with the value, user suggestions
+//have the user's ID and organization.
+type Suggestion struct {
+	Value        string `json:"value"`
+	Count        int    `json:"count,omitempty"`
+	ID           int    `json:"id,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
+
+//entry is a value, or a user, in the sorted index. Value entries are kept per organization so callers only
+//get suggestions from the organizations they may read.
+type entry struct {
+	key   string
+	value string
+	org   string
+	count int
+	id    int
+}
+
+//Index holds the suggestions for each configured field, sorted by their normalized text. It's safe for concurrent use.
+type Index struct {
+	config Config
+
+	mu     sync.RWMutex
+	fields map[string][]entry
+}
+
+//NewIndex returns an empty index of the fields in config.
+func NewIndex(config Config) *Index {
+	return &Index{config: config, fields: make(map[string][]entry)}
+}
+
+//Build replaces the contents of the index with the values of users.
+func (x *Index) Build(users []model.User) {
+	fields := make(map[string][]entry, len(x.config))
+	for f := range x.config {
+		if f == UserField {
+			fields[f] = userEntries(users)
+		} else if get, ok := valueFields[f]; ok {
+			fields[f] = valueEntries(users, get)
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.fields = fields
+}
+
+func valueEntries(users []model.User, get func(model.User) string) []entry {
+	type group struct{ key, org string }
+	byGroup := make(map[group]*entry)
+	for _, u := range users {
+		v := strings.TrimSpace(get(u))
+		if v == "" {
+			continue
+		}
+		g := group{key: normalize(v), org: u.Organization}
+		e, ok := byGroup[g]
+		if !ok {
+			e = &entry{key: g.key, value: v, org: u.Organization}
+			byGroup[g] = e
+		}
+		e.count++
+	}
+	entries := make([]entry, 0, len(byGroup))
+	for _, e := range byGroup {
+		entries = append(entries, *e)
+	}
+	sortEntries(entries)
+	return entries
+}
+
+func userEntries(users []model.User) []entry {
+	entries := make([]entry, 0, 2*len(users))
+	for _, u := range users {
+		name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+		if name == "" {
+			continue
+		}
+		entries = append(entries, entry{key: normalize(name), value: name, org: u.Organization, id: u.ID})
+		if last := normalize(u.LastName); last != "" {
+			entries = append(entries, entry{key: last, value: name, org: u.Organization, id: u.ID})
+		}
+	}
+	sortEntries(entries)
+	return entries
+}
+
+func sortEntries(entries []entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		if entries[i].org != entries[j].org {
+			return entries[i].org < entries[j].org
+		}
+		return entries[i].id < entries[j].id
+	})
+}
+
+//Suggest returns up to limit suggestions for field starting with prefix, ignoring case and accents. allowed reports
+//whether the caller may see users in an organization. Limits of zero or above the field's MaxResults are capped.
+//Values are ordered by how many users have them, users by name.
+func (x *Index) Suggest(field, prefix string, limit int, allowed func(org string) bool) ([]Suggestion, error) {
+	fc, ok := x.config[field]
+	if !ok {
+		return nil, &FieldError{Field: field}
+	}
+	if limit <= 0 || limit > fc.MaxResults {
+		limit = fc.MaxResults
+	}
+	key := normalize(prefix)
+	if len([]rune(key)) < fc.MinPrefix {
+		return []Suggestion{}, nil
+	}
+
+	x.mu.RLock()
+	entries := x.fields[field]
+	x.mu.RUnlock()
+	start := sort.Search(len(entries), func(i int) bool { return entries[i].key >= key })
+
+	suggestions := make([]Suggestion, 0)
+	if field == UserField {
+		seen := make(map[int]bool)
+		for i := start; i < len(entries) && strings.HasPrefix(entries[i].key, key) && len(suggestions) < limit; i++ {
+			e := entries[i]
+			if seen[e.id] || !allowed(e.org) {
+				continue
+			}
+			seen[e.id] = true
+			suggestions = append(suggestions, Suggestion{Value: e.value, ID: e.id, Organization: e.org})
+		}
+		return suggestions, nil
+	}
+
+	byKey := make(map[string]int)
+	for i := start; i < len(entries) && strings.HasPrefix(entries[i].key, key); i++ {
+		e := entries[i]
+		if !allowed(e.org) {
+			continue
+		}
+		if j, ok := byKey[e.key]; ok {
+			suggestions[j].Count += e.count
+			continue
+		}
+		byKey[e.key] = len(suggestions)
+		suggestions = append(suggestions, Suggestion{Value: e.value, Count: e.count})
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].Count > suggestions[j].Count })
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+//normalize lowercases text and strips its accents, so "Zoë" is suggested for "zoe".
+func normalize(text string) string {
+	b := strings.Builder{}
+	for _, r := range norm.NFD.String(strings.TrimSpace(text)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
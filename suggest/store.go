@@ -0,0 +1,114 @@
+package suggest
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Store is a UserDataStore that rebuilds a suggestion index after every successful change made through it.
+//If the users can't be read back, the index keeps its previous contents and the failure is logged.
+type Store struct {
+	Next     model.UserDataStore
+	Index    *Index
+	ErrorLog *log.Logger
+
+	//refreshMu orders rebuilds, so the last one to finish has read the latest users.
+	refreshMu sync.Mutex
+}
+
+//NewStore wraps next so index is kept up to date with the changes made through it, and fills the index with next's users.
+func NewStore(ctx context.Context, next model.UserDataStore, index *Index, errorLog *log.Logger) (*Store, error) {
+	s := &Store{Next: next, Index: index, ErrorLog: errorLog}
+	users, err := next.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index.Build(users)
+	return s, nil
+}
+
+//GetAll retrieves all saved users.
+func (s *Store) GetAll(ctx context.Context) ([]model.User, error) {
+	return s.Next.GetAll(ctx)
+}
+
+//GetDeleted retrieves all deleted users.
+func (s *Store) GetDeleted(ctx context.Context) ([]model.User, error) {
+	return s.Next.GetDeleted(ctx)
+}
+
+//Create creates the user, then refreshes the index.
+func (s *Store) Create(ctx context.Context, u *model.User) error {
+	return s.refreshAfter(ctx, s.Next.Create(ctx, u))
+}
+
+//Edit modifies the user, then refreshes the index.
+func (s *Store) Edit(ctx context.Context, u model.User, id int) error {
+	return s.refreshAfter(ctx, s.Next.Edit(ctx, u, id))
+}
+
+//Delete deletes the user, then refreshes the index.
+func (s *Store) Delete(ctx context.Context, id int) error {
+	return s.refreshAfter(ctx, s.Next.Delete(ctx, id))
+}
+
+//Restore restores the deleted user, then refreshes the index.
+func (s *Store) Restore(ctx context.Context, id int) error {
+	return s.refreshAfter(ctx, s.Next.Restore(ctx, id))
+}
+
+//Purge permanently removes users deleted before the given time. Deleted users aren't suggested, so the index doesn't change.
+func (s *Store) Purge(ctx context.Context, deletedBefore time.Time) ([]model.User, error) {
+	return s.Next.Purge(ctx, deletedBefore)
+}
+
+//Backup writes a backup of the users to w, if the next datastore supports backups.
+func (s *Store) Backup(ctx context.Context, w io.Writer) error {
+	return backup.Backup(ctx, s.Next, w)
+}
+
+//RestoreBackup replaces every user with those in the backup read from r, then refreshes the index.
+func (s *Store) RestoreBackup(ctx context.Context, r io.Reader) error {
+	return s.refreshAfter(ctx, backup.Restore(ctx, s.Next, r))
+}
+
+//Reports retrieves the users below the given user.
+func (s *Store) Reports(ctx context.Context, id int, transitive bool) ([]model.User, error) {
+	return s.Next.Reports(ctx, id, transitive)
+}
+
+//Chain retrieves the managers above the given user.
+func (s *Store) Chain(ctx context.Context, id int) ([]model.User, error) {
+	return s.Next.Chain(ctx, id)
+}
+
+//Search retrieves the users matching query.
+func (s *Store) Search(ctx context.Context, query string) ([]model.User, error) {
+	return s.Next.Search(ctx, query)
+}
+
+//refreshAfter rebuilds the index if the change succeeded, and returns the change's error.
+func (s *Store) refreshAfter(ctx context.Context, err error) error {
+	if err != nil {
+		return err
+	}
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	//the change has been made, so refresh even if the request has since been cancelled.
+	users, err := s.Next.GetAll(model.Detach(ctx))
+	if err != nil {
+		if s.ErrorLog != nil {
+			s.ErrorLog.Printf("suggest: could not refresh suggestions: %v", err)
+		}
+		return nil
+	}
+	s.Index.Build(users)
+	return nil
+}
@@ -0,0 +1,116 @@
+package suggest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+var testUsers = []model.User{
+	{ID: 1, FirstName: "Zoë", LastName: "Adams", Email: "zoe@a.com", Organization: "Sales"},
+	{ID: 2, FirstName: "Zoe", LastName: "Baker", Email: "zb@a.com", Organization: "Sales"},
+	{ID: 3, FirstName: "Sam", LastName: "Zoeller", Email: "sam@a.com", Organization: "Support"},
+	{ID: 4, FirstName: "Sally", LastName: "Adams", Email: "sally@a.com", Organization: "sales"},
+}
+
+func everyone(string) bool { return true }
+
+func TestSuggest(t *testing.T) {
+	x := NewIndex(DefaultConfig())
+	x.Build(testUsers)
+
+	tests := []struct {
+		field, prefix string
+		limit         int
+		allowed       func(string) bool
+		want          []Suggestion
+	}{
+		{"organization", "sa", 0, everyone, []Suggestion{{Value: "Sales", Count: 3}}},
+		{"organization", "s", 1, everyone, []Suggestion{{Value: "Sales", Count: 3}}},
+		{"firstName", "zo", 0, everyone, []Suggestion{{Value: "Zoë", Count: 2}}},
+		{"lastName", "a", 0, func(org string) bool { return org == "Sales" }, []Suggestion{{Value: "Adams", Count: 1}}},
+		{UserField, "zo", 0, everyone, []Suggestion{
+			{Value: "Zoë Adams", ID: 1, Organization: "Sales"},
+			{Value: "Zoe Baker", ID: 2, Organization: "Sales"},
+			{Value: "Sam Zoeller", ID: 3, Organization: "Support"},
+		}},
+		{UserField, "adams", 0, everyone, []Suggestion{
+			{Value: "Zoë Adams", ID: 1, Organization: "Sales"},
+			{Value: "Sally Adams", ID: 4, Organization: "sales"},
+		}},
+		//users need a two letter prefix.
+		{UserField, "z", 0, everyone, []Suggestion{}},
+	}
+	for _, tt := range tests {
+		got, err := x.Suggest(tt.field, tt.prefix, tt.limit, tt.allowed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Suggest(%v, %q) = %+v, want %+v", tt.field, tt.prefix, got, tt.want)
+		}
+	}
+
+	if _, err := x.Suggest("email", "z", 0, everyone); err == nil {
+		t.Errorf("email isn't in the default configuration and shouldn't be suggested")
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "suggest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "suggest.json")
+
+	ioutil.WriteFile(path, []byte(`{"email":{"minPrefix":3}}`), 0644)
+	c, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Config{"email": {MaxResults: 10, MinPrefix: 3}}); !reflect.DeepEqual(c, want) {
+		t.Errorf("got %v want %v", c, want)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"password":{}}`), 0644)
+	if _, err := ReadConfig(path); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
+
+//sliceStore is a minimal UserDataStore for checking the index follows changes.
+type sliceStore struct {
+	model.UserDataStore
+	users []model.User
+}
+
+func (s *sliceStore) GetAll(ctx context.Context) ([]model.User, error) {
+	return s.users, nil
+}
+
+func (s *sliceStore) Create(ctx context.Context, u *model.User) error {
+	u.ID = len(s.users) + 1
+	s.users = append(s.users, *u)
+	return nil
+}
+
+func TestStoreRefreshes(t *testing.T) {
+	x := NewIndex(DefaultConfig())
+	store, err := NewStore(context.Background(), &sliceStore{users: testUsers[:1]}, x, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Create(context.Background(), &model.User{FirstName: "Marta", Organization: "Marketing"})
+
+	got, _ := x.Suggest("organization", "m", 0, everyone)
+	want := []Suggestion{{Value: "Marketing", Count: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package users
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/encryption"
+)
+
+//BackupsDisabled is returned when the server's datastore doesn't support backups.
+const BackupsDisabled = "Backups are not supported by this server's datastore."
+
+//ProcessAdminRequest handles administration endpoints: POST /admin/backup returns a backup archive of every user,
+//deleted or not, as a download, sealed with the datastore's keys if it's encrypted. Backups are restored with userctl
+//while the server is stopped.
+func ProcessAdminRequest(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	if r.URL.Path != "/admin/backup" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), e.Timeouts.Get)
+	defer cancel()
+
+	if e.Backups == nil {
+		handleLogError(ctx, w, errors.New(BackupsDisabled), e.ErrorLog)
+		return
+	}
+	if err := e.Policy.Authorize(ctx, authz.ActionBackup, ""); err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+		return
+	}
+
+	//buffered so a failure part way through is still reported as an error rather than a truncated archive.
+	var buf bytes.Buffer
+	if err := e.Backups.Backup(ctx, &buf); err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+		return
+	}
+	contentType := "application/gzip"
+	if encryption.IsSealed(buf.Bytes()) {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", backup.FileName(time.Now())))
+	w.Write(buf.Bytes())
+}
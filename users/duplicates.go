@@ -0,0 +1,166 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/search"
+	"golang.org/x/text/unicode/norm"
+)
+
+//Reasons users are reported as likely duplicates.
+const (
+	//ReasonEmail means the users' addresses are the same once normalized, see normalizeEmail.
+	ReasonEmail = "email"
+	//ReasonName means the users are in the same organization and have nearly the same name.
+	ReasonName = "name"
+)
+
+//DuplicateGroup is a set of users who are likely the same person. Key is the normalized email address
+//for email matches and the organization for name matches.
+type DuplicateGroup struct {
+	Reason string       `json:"reason"`
+	Key    string       `json:"key"`
+	Users  []model.User `json:"users"`
+}
+
+//dotInsensitiveDomains maps providers that ignore dots in the local part of an address to their canonical domain.
+var dotInsensitiveDomains = map[string]string{
+	"gmail.com":      "gmail.com",
+	"googlemail.com": "gmail.com",
+}
+
+//processDuplicates returns the likely duplicates among the users the caller is allowed to read. Duplicates are found
+//on the unmasked users, then masked for the caller, including the key of a group with any user the caller may not unmask.
+func processDuplicates(ctx context.Context, e *config.Env) ([]byte, error) {
+	if !e.Policy.Allowed(ctx, authz.ActionRead) {
+		return nil, authz.ErrForbidden
+	}
+
+	userList, err := e.Datastore.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userList = e.Policy.Filter(ctx, userList)
+
+	groups := findDuplicates(userList)
+	for i, g := range groups {
+		field := "email"
+		if g.Reason == ReasonName {
+			field = "organization"
+		}
+		for _, u := range g.Users {
+			if !canUnmask(ctx, e, u.Organization) {
+				groups[i].Key = e.Sensitivity.MaskValue(field, g.Key)
+				break
+			}
+		}
+		groups[i].Users = maskUsers(ctx, e, g.Users)
+	}
+	return json.Marshal(groups)
+}
+
+//findDuplicates groups users with the same normalized email address, then pairs up users in the same organization
+//whose names are within a few typos of each other (ignoring case, accents and punctuation, and allowing the first
+//and last names to be swapped). Pairs already reported for their email aren't reported again.
+func findDuplicates(users []model.User) []DuplicateGroup {
+	groups := make([]DuplicateGroup, 0)
+
+	byEmail := make(map[string][]model.User)
+	for _, u := range users {
+		key := normalizeEmail(u.Email)
+		byEmail[key] = append(byEmail[key], u)
+	}
+	emails := make([]string, 0, len(byEmail))
+	for key, list := range byEmail {
+		if key != "" && len(list) > 1 {
+			emails = append(emails, key)
+		}
+	}
+	sort.Strings(emails)
+	for _, key := range emails {
+		groups = append(groups, DuplicateGroup{Reason: ReasonEmail, Key: key, Users: byEmail[key]})
+	}
+
+	byOrg := make(map[string][]model.User)
+	for _, u := range users {
+		key := strings.ToLower(strings.TrimSpace(u.Organization))
+		byOrg[key] = append(byOrg[key], u)
+	}
+	orgs := make([]string, 0, len(byOrg))
+	for org := range byOrg {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		list := byOrg[org]
+		for i := 0; i < len(list); i++ {
+			for j := i + 1; j < len(list); j++ {
+				a, b := list[i], list[j]
+				if normalizeEmail(a.Email) == normalizeEmail(b.Email) || !namesMatch(a, b) {
+					continue
+				}
+				groups = append(groups, DuplicateGroup{Reason: ReasonName, Key: a.Organization, Users: []model.User{a, b}})
+			}
+		}
+	}
+
+	return groups
+}
+
+//normalizeEmail reduces an address to the mailbox it's delivered to: the address is lowercased, plus-addressing
+//tags are dropped and so are the dots in the local part for providers that ignore them.
+func normalizeEmail(email string) string {
+	email = model.EmailKey(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+	if i := strings.Index(local, "+"); i > 0 {
+		local = local[:i]
+	}
+	if canonical, ok := dotInsensitiveDomains[domain]; ok {
+		local = strings.Replace(local, ".", "", -1)
+		domain = canonical
+	}
+	return local + "@" + domain
+}
+
+func namesMatch(a, b model.User) bool {
+	af, al := nameKey(a.FirstName), nameKey(a.LastName)
+	bf, bl := nameKey(b.FirstName), nameKey(b.LastName)
+	if af == "" || al == "" || bf == "" || bl == "" {
+		return false
+	}
+	return (similar(af, bf) && similar(al, bl)) || (similar(af, bl) && similar(al, bf))
+}
+
+//nameKey lowercases a name and strips accents, spaces and punctuation so "José" and "jose" compare equal.
+func nameKey(name string) string {
+	b := strings.Builder{}
+	for _, r := range norm.NFD.String(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+//similar reports whether two name keys are within the typos allowed for the shorter one, see search.MaxEdits.
+func similar(a, b string) bool {
+	if a == b {
+		return true
+	}
+	shortest := a
+	if len([]rune(b)) < len([]rune(a)) {
+		shortest = b
+	}
+	return search.EditDistance(a, b) <= search.MaxEdits(shortest)
+}
@@ -0,0 +1,158 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//ChartNode is a user in the org chart, with everyone who reports to them.
+type ChartNode struct {
+	ID           int          `json:"id"`
+	FirstName    string       `json:"firstName"`
+	LastName     string       `json:"lastName"`
+	Email        string       `json:"email"`
+	Organization string       `json:"organization"`
+	Reports      []*ChartNode `json:"reports,omitempty"`
+}
+
+//processReports returns the users who report to the given user, everyone below them if the transitive=true
+//query parameter is given. Callers need read access to the user and only see the reports they may read, masked
+//unless they may unmask them.
+func processReports(ctx context.Context, r *http.Request, e *config.Env, id int) ([]byte, error) {
+	transitive := r.URL.Query().Get("transitive")
+	if transitive != "" && transitive != "true" && transitive != "false" {
+		return nil, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: []validation.UserError{validation.FormatError("transitive", transitive)}}
+	}
+	if err := authorizeExisting(ctx, e, authz.ActionRead, id); err != nil {
+		return nil, err
+	}
+
+	reports, err := e.Datastore.Reports(ctx, id, transitive == "true")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(maskUsers(ctx, e, e.Policy.Filter(ctx, reports)))
+}
+
+//processChain returns the managers above the given user, from their direct manager to the top.
+//The chain stops at the first manager the caller may not read.
+func processChain(ctx context.Context, e *config.Env, id int) ([]byte, error) {
+	if err := authorizeExisting(ctx, e, authz.ActionRead, id); err != nil {
+		return nil, err
+	}
+
+	chain, err := e.Datastore.Chain(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]model.User, 0, len(chain))
+	for _, m := range chain {
+		if len(e.Policy.Filter(ctx, []model.User{m})) == 0 {
+			break
+		}
+		visible = append(visible, m)
+	}
+	return json.Marshal(maskUsers(ctx, e, visible))
+}
+
+//serveOrgChart handles GET /users/orgchart, which exports the reporting lines of the users the caller may read
+//as a JSON tree or, with format=dot, as a Graphviz digraph. Users whose manager isn't visible are roots.
+func serveOrgChart(ctx context.Context, w http.ResponseWriter, r *http.Request, e *config.Env) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+	defer cancel()
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		handleLogError(ctx, w, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: []validation.UserError{validation.FormatError("format", format)}}, e.ErrorLog)
+		return
+	}
+
+	roots, err := processOrgChart(ctx, e)
+	if err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+		return
+	}
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Write([]byte(chartDOT(roots)))
+		return
+	}
+	resp, err := json.Marshal(roots)
+	if err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+func processOrgChart(ctx context.Context, e *config.Env) ([]*ChartNode, error) {
+	if !e.Policy.Allowed(ctx, authz.ActionRead) {
+		return nil, authz.ErrForbidden
+	}
+	userList, err := e.Datastore.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return buildChart(maskUsers(ctx, e, e.Policy.Filter(ctx, userList))), nil
+}
+
+//buildChart arranges users into trees by their managers, ordered by ID at every level.
+func buildChart(users []model.User) []*ChartNode {
+	sorted := append([]model.User(nil), users...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	nodes := make(map[int]*ChartNode, len(sorted))
+	for _, u := range sorted {
+		nodes[u.ID] = &ChartNode{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email, Organization: u.Organization}
+	}
+	roots := make([]*ChartNode, 0)
+	for _, u := range sorted {
+		manager, ok := nodes[u.ManagerID]
+		if !ok || u.ManagerID == u.ID {
+			roots = append(roots, nodes[u.ID])
+			continue
+		}
+		manager.Reports = append(manager.Reports, nodes[u.ID])
+	}
+	return roots
+}
+
+//chartDOT writes the chart as a Graphviz digraph with a box per user, labelled with their name and organization,
+//and an edge from each manager to each of their reports.
+func chartDOT(roots []*ChartNode) string {
+	b := strings.Builder{}
+	b.WriteString("digraph orgchart {\n\tnode [shape=box];\n")
+	var walk func(n *ChartNode)
+	walk = func(n *ChartNode) {
+		label := strings.TrimSpace(n.FirstName+" "+n.LastName) + "\n" + n.Organization
+		fmt.Fprintf(&b, "\t%v [label=%v];\n", n.ID, dotQuote(label))
+		for _, r := range n.Reports {
+			fmt.Fprintf(&b, "\t%v -> %v;\n", n.ID, r.ID)
+			walk(r)
+		}
+	}
+	for _, n := range roots {
+		walk(n)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+//dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,122 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nmalensek/go-user-form/audit"
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//Audit error messages.
+const (
+	AuditDisabled = "The audit trail is not enabled on this server."
+)
+
+//ProcessAuditRequest returns the global audit feed, optionally filtered by the actor, userId, since and until
+//query parameters (times in RFC 3339 format). Sensitive values are masked unless the caller may unmask every user.
+func ProcessAuditRequest(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), e.Timeouts.Get)
+	defer cancel()
+
+	if resp, err := processAudit(ctx, r, e); err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+	} else {
+		writeJSON(w, resp)
+	}
+}
+
+func processAudit(ctx context.Context, r *http.Request, e *config.Env) ([]byte, error) {
+	if e.Audit == nil {
+		return nil, errors.New(AuditDisabled)
+	}
+	if err := e.Policy.Authorize(ctx, authz.ActionAudit, ""); err != nil {
+		return nil, err
+	}
+
+	f, err := auditFilterFromQuery(r)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := e.Audit.Query(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	//the feed spans organizations, so only callers who may unmask every user see it unmasked.
+	return json.Marshal(maskEntries(ctx, e, entries, ""))
+}
+
+//processHistory returns the audit entries for a single user. Callers need read access to the user,
+//or audit access if the user no longer exists. Sensitive values are masked unless the caller may unmask the user.
+func processHistory(ctx context.Context, r *http.Request, e *config.Env, id int) ([]byte, error) {
+	if e.Audit == nil {
+		return nil, errors.New(AuditDisabled)
+	}
+
+	org := ""
+	if e.Policy != nil {
+		existing, err := findUser(ctx, e.Datastore, id)
+		switch {
+		case err == nil:
+			org = existing.Organization
+			err = e.Policy.Authorize(ctx, authz.ActionRead, existing.Organization)
+		case err.Error() == model.CouldNotFind:
+			err = e.Policy.Authorize(ctx, authz.ActionAudit, "")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := e.Audit.Query(ctx, audit.Filter{UserID: id})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(maskEntries(ctx, e, entries, org))
+}
+
+func auditFilterFromQuery(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	f := audit.Filter{Actor: q.Get("actor")}
+	errs := make([]validation.UserError, 0)
+
+	if v := q.Get("userId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, validation.FormatError("userId", v))
+		}
+		f.UserID = id
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs = append(errs, validation.FormatError(p.name, v))
+		}
+		*p.dst = t
+	}
+
+	if len(errs) > 0 {
+		return f, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: errs}
+	}
+	return f, nil
+}
@@ -0,0 +1,104 @@
+package users
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//sortKeys are the values accepted by the list endpoint's sort parameter.
+var sortKeys = map[string]func(a, b model.User) bool{
+	"id":           func(a, b model.User) bool { return a.ID < b.ID },
+	"firstName":    func(a, b model.User) bool { return strings.ToLower(a.FirstName) < strings.ToLower(b.FirstName) },
+	"lastName":     func(a, b model.User) bool { return strings.ToLower(a.LastName) < strings.ToLower(b.LastName) },
+	"email":        func(a, b model.User) bool { return strings.ToLower(a.Email) < strings.ToLower(b.Email) },
+	"organization": func(a, b model.User) bool { return strings.ToLower(a.Organization) < strings.ToLower(b.Organization) },
+	"createdAt":    func(a, b model.User) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"updatedAt":    func(a, b model.User) bool { return a.UpdatedAt.Before(b.UpdatedAt) },
+}
+
+//timeFilters are the query parameters that restrict the list to a time range, all in RFC 3339 format.
+var timeFilters = []struct {
+	name  string
+	match func(u model.User, t time.Time) bool
+}{
+	{"createdAfter", func(u model.User, t time.Time) bool { return u.CreatedAt.After(t) }},
+	{"createdBefore", func(u model.User, t time.Time) bool { return u.CreatedAt.Before(t) }},
+	{"updatedAfter", func(u model.User, t time.Time) bool { return u.UpdatedAt.After(t) }},
+	{"updatedBefore", func(u model.User, t time.Time) bool { return u.UpdatedAt.Before(t) }},
+}
+
+//applyListOptions filters and sorts the user list according to the query parameters:
+//createdBy/updatedBy match the acting user exactly, the timeFilters restrict by time, and
+//sort (one of sortKeys, default id) with order=desc orders the result.
+func applyListOptions(users []model.User, q url.Values) ([]model.User, error) {
+	errs := make([]validation.UserError, 0)
+
+	times := make([]time.Time, len(timeFilters))
+	for i, f := range timeFilters {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs = append(errs, validation.FormatError(f.name, v))
+			continue
+		}
+		times[i] = t
+	}
+
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	less, ok := sortKeys[sortBy]
+	if !ok {
+		errs = append(errs, validation.FormatError("sort", sortBy))
+	}
+	order := q.Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		errs = append(errs, validation.FormatError("order", order))
+	}
+
+	if len(errs) > 0 {
+		return nil, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: errs}
+	}
+
+	createdBy, updatedBy := q.Get("createdBy"), q.Get("updatedBy")
+	filtered := make([]model.User, 0, len(users))
+	for _, u := range users {
+		if createdBy != "" && u.CreatedBy != createdBy {
+			continue
+		}
+		if updatedBy != "" && u.UpdatedBy != updatedBy {
+			continue
+		}
+		if !matchesTimes(u, times) {
+			continue
+		}
+		filtered = append(filtered, u)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if order == "desc" {
+			return less(filtered[j], filtered[i])
+		}
+		return less(filtered[i], filtered[j])
+	})
+	return filtered, nil
+}
+
+//matchesTimes reports whether u passes every time filter that was given (non-zero).
+func matchesTimes(u model.User, times []time.Time) bool {
+	for i, t := range times {
+		if !t.IsZero() && !timeFilters[i].match(u, t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/audit"
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/events"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/sensitivity"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//canUnmask reports whether the caller may see the sensitive fields of users in org unmasked.
+func canUnmask(ctx context.Context, e *config.Env, org string) bool {
+	return e.Sensitivity == nil || e.Policy.Authorize(ctx, authz.ActionUnmask, org) == nil
+}
+
+//maskUsers returns a copy of users with the sensitive fields masked of the users the caller may not unmask.
+func maskUsers(ctx context.Context, e *config.Env, users []model.User) []model.User {
+	masked := make([]model.User, len(users))
+	for i, u := range users {
+		masked[i] = maskUser(ctx, e, u)
+	}
+	return masked
+}
+
+func maskUser(ctx context.Context, e *config.Env, u model.User) model.User {
+	if canUnmask(ctx, e, u.Organization) {
+		return u
+	}
+	return e.Sensitivity.MaskUser(u)
+}
+
+//maskEntries returns entries with the values of sensitive fields masked in their changes, unless the caller may
+//unmask the users of org.
+func maskEntries(ctx context.Context, e *config.Env, entries []audit.Entry, org string) []audit.Entry {
+	if canUnmask(ctx, e, org) {
+		return entries
+	}
+	masked := make([]audit.Entry, len(entries))
+	for i, entry := range entries {
+		changes := make([]audit.Change, len(entry.Changes))
+		for j, c := range entry.Changes {
+			changes[j] = audit.Change{Field: c.Field, Before: e.Sensitivity.MaskValue(c.Field, c.Before), After: e.Sensitivity.MaskValue(c.Field, c.After)}
+		}
+		entry.Changes = changes
+		masked[i] = entry
+	}
+	return masked
+}
+
+//maskEvent returns ev with the user before and after the change masked for the caller.
+func maskEvent(ctx context.Context, e *config.Env, ev events.Event) events.Event {
+	ev.User = maskUser(ctx, e, ev.User)
+	if ev.Previous != nil {
+		previous := maskUser(ctx, e, *ev.Previous)
+		ev.Previous = &previous
+	}
+	return ev
+}
+
+//logText returns the text an error is logged as. Validation errors list each field with its value and code, the
+//values of sensitive fields redacted, so a log shows why a request was rejected without the personal data in it.
+func logText(err error, p *sensitivity.Policy) string {
+	errs, ok := err.(validation.UserErrors)
+	if !ok || len(errs.ErrorList) == 0 {
+		return err.Error()
+	}
+	fields := make([]string, len(errs.ErrorList))
+	for i, fe := range errs.ErrorList {
+		fields[i] = fmt.Sprintf("%v=%q (%v)", fe.PropName, p.Redact(fe.PropName, fe.PropValue), fe.Code)
+	}
+	return fmt.Sprintf("%v: %v", errs.Message, strings.Join(fields, ", "))
+}
@@ -0,0 +1,90 @@
+package users
+
+import (
+	"github.com/nmalensek/go-user-form/i18n"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/schema"
+	"github.com/nmalensek/go-user-form/webhook"
+)
+
+//Error codes of the handler and datastore messages, the validation package has the codes of individual validation errors.
+const (
+	CodeNotFound              = "not_found"
+	CodeCreateBadID           = "create_bad_id"
+	CodeCreateIncomplete      = "create_incomplete"
+	CodeEditIncomplete        = "edit_incomplete"
+	CodeNotDeleted            = "not_deleted"
+	CodeNotSupported          = "not_supported"
+	CodeDuplicateEmail        = "duplicate_email"
+	CodeMalformedURI          = "malformed_uri"
+	CodeInvalidInput          = "invalid_input"
+	CodeErrorWhileProcessing  = "error_while_processing"
+	CodeRequestTimedOut       = "request_timed_out"
+	CodeForbidden             = "forbidden"
+	CodeAuditDisabled         = "audit_disabled"
+	CodeSchemaDisabled        = "schema_disabled"
+	CodeInvalidSchema         = "invalid_schema"
+	CodeAttributeNotFound     = "attribute_not_found"
+	CodeOrganizationNotFound  = "organization_not_found"
+	CodeOrganizationNameTaken = "organization_name_taken"
+	CodeOrganizationInUse     = "organization_in_use"
+	CodeOrganizationsDisabled = "organizations_disabled"
+	CodeManagerNotFound       = "manager_not_found"
+	CodeManagerCycle          = "manager_cycle"
+	CodeHasReports            = "has_reports"
+	CodeSuggestionsDisabled   = "suggestions_disabled"
+	CodeWebhooksDisabled      = "webhooks_disabled"
+	CodeWebhookNotFound       = "webhook_not_found"
+	CodeEventsDisabled        = "events_disabled"
+	CodePresenceDisabled      = "presence_disabled"
+	CodeBackupsDisabled       = "backups_disabled"
+)
+
+//messageCodes maps the English messages to their codes, so errors that are sent as plain text can be translated.
+var messageCodes = map[string]string{
+	model.CouldNotFind:          CodeNotFound,
+	model.CreateErrorBadID:      CodeCreateBadID,
+	model.CreateErrorIncomplete: CodeCreateIncomplete,
+	model.EditErrorIncomplete:   CodeEditIncomplete,
+	model.NotDeleted:            CodeNotDeleted,
+	model.NotSupported:          CodeNotSupported,
+	model.DuplicateEmail:        CodeDuplicateEmail,
+	MalformedURI:                CodeMalformedURI,
+	InvalidInput:                CodeInvalidInput,
+	ErrorWhileProcessing:        CodeErrorWhileProcessing,
+	RequestTimedOut:             CodeRequestTimedOut,
+	Forbidden:                   CodeForbidden,
+	AuditDisabled:               CodeAuditDisabled,
+	SchemaDisabled:              CodeSchemaDisabled,
+	InvalidSchema:               CodeInvalidSchema,
+	schema.AttributeNotFound:    CodeAttributeNotFound,
+	model.OrganizationNotFound:  CodeOrganizationNotFound,
+	model.OrganizationNameTaken: CodeOrganizationNameTaken,
+	model.OrganizationInUse:     CodeOrganizationInUse,
+	OrganizationsDisabled:       CodeOrganizationsDisabled,
+	model.ManagerNotFound:       CodeManagerNotFound,
+	model.ManagerCycle:          CodeManagerCycle,
+	model.HasReports:            CodeHasReports,
+	SuggestionsDisabled:         CodeSuggestionsDisabled,
+	WebhooksDisabled:            CodeWebhooksDisabled,
+	webhook.EndpointNotFound:    CodeWebhookNotFound,
+	EventsDisabled:              CodeEventsDisabled,
+	PresenceDisabled:            CodePresenceDisabled,
+	BackupsDisabled:             CodeBackupsDisabled,
+}
+
+func init() {
+	english := make(map[string]string, len(messageCodes))
+	for text, code := range messageCodes {
+		english[code] = text
+	}
+	i18n.Default.Add(i18n.DefaultLanguage, english)
+}
+
+//localizeText translates a plain text error message into lang, messages without a code are returned unchanged.
+func localizeText(lang, text string) string {
+	if code, ok := messageCodes[text]; ok {
+		return i18n.Default.Message(lang, code, nil)
+	}
+	return text
+}
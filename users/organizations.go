@@ -0,0 +1,208 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//OrganizationsDisabled is returned when the server has no organization datastore.
+const OrganizationsDisabled = "Organizations are not enabled on this server."
+
+var organizationPatt = regexp.MustCompile(`^/organizations/([0-9]*)(/users)?$`)
+
+//ProcessOrganizationRequest manages organizations: GET /organizations/ lists them and POST creates one,
+//GET, PUT and DELETE /organizations/{id} read, rename and delete one, and GET /organizations/{id}/users lists its members.
+//Anyone who may read users may read organizations, changing them requires the organizations action.
+func ProcessOrganizationRequest(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	ctx := r.Context()
+	m := organizationPatt.FindStringSubmatch(r.URL.EscapedPath())
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id, _ := strconv.Atoi(m[1])
+	members := m[2] != ""
+
+	if e.Organizations == nil {
+		handleLogError(ctx, w, errors.New(OrganizationsDisabled), e.ErrorLog)
+		return
+	}
+
+	var resp []byte
+	var err error
+	switch {
+	case members && r.Method == http.MethodGet:
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+		defer cancel()
+		resp, err = processMembers(ctx, e, id)
+	case members:
+		methodNotAllowed(w, http.MethodGet)
+		return
+	case id == 0 && r.Method == http.MethodGet:
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+		defer cancel()
+		resp, err = processGetOrganizations(ctx, e, 0)
+	case id == 0 && r.Method == http.MethodPost:
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Create)
+		defer cancel()
+		resp, err = processPostOrganization(ctx, r, e)
+	case id == 0:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	case r.Method == http.MethodGet:
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+		defer cancel()
+		resp, err = processGetOrganizations(ctx, e, id)
+	case r.Method == http.MethodPut:
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Edit)
+		defer cancel()
+		err = processPutOrganization(ctx, r, e, id)
+	case r.Method == http.MethodDelete:
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Delete)
+		defer cancel()
+		err = processDeleteOrganization(ctx, e, id)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+		return
+	}
+
+	switch {
+	case err != nil:
+		handleLogError(ctx, w, err, e.ErrorLog)
+	case resp != nil:
+		writeJSON(w, resp)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+//processGetOrganizations returns all organizations, or only the one with the given ID if it isn't zero.
+func processGetOrganizations(ctx context.Context, e *config.Env, id int) ([]byte, error) {
+	if !e.Policy.Allowed(ctx, authz.ActionRead) {
+		return nil, authz.ErrForbidden
+	}
+	orgs, err := e.Organizations.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if id == 0 {
+		return json.Marshal(orgs)
+	}
+	o, ok := model.FindOrganization(orgs, id)
+	if !ok {
+		return nil, errors.New(model.OrganizationNotFound)
+	}
+	return json.Marshal(o)
+}
+
+//processPostOrganization creates an organization and returns it with its new ID.
+func processPostOrganization(ctx context.Context, r *http.Request, e *config.Env) ([]byte, error) {
+	if err := e.Policy.Authorize(ctx, authz.ActionManageOrganizations, ""); err != nil {
+		return nil, err
+	}
+	o, err := organizationFromBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.Organizations.Create(ctx, o); err != nil {
+		return nil, err
+	}
+	return json.Marshal(o)
+}
+
+//processPutOrganization renames an organization, then updates the copy of the name held by each of its active users.
+func processPutOrganization(ctx context.Context, r *http.Request, e *config.Env, id int) error {
+	if err := e.Policy.Authorize(ctx, authz.ActionManageOrganizations, ""); err != nil {
+		return err
+	}
+	o, err := organizationFromBody(r)
+	if err != nil {
+		return err
+	}
+	if err := e.Organizations.Edit(ctx, *o, id); err != nil {
+		return err
+	}
+
+	members, err := e.Datastore.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range members {
+		if u.OrganizationID != id || u.Organization == o.Name {
+			continue
+		}
+		if err := e.Datastore.Edit(ctx, model.User{Organization: o.Name, OrganizationID: id}, u.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//processDeleteOrganization deletes an organization nobody belongs to, including deleted users who could be restored.
+func processDeleteOrganization(ctx context.Context, e *config.Env, id int) error {
+	if err := e.Policy.Authorize(ctx, authz.ActionManageOrganizations, ""); err != nil {
+		return err
+	}
+
+	active, err := e.Datastore.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	deleted, err := e.Datastore.GetDeleted(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range append(active, deleted...) {
+		if u.OrganizationID == id {
+			return errors.New(model.OrganizationInUse)
+		}
+	}
+
+	return e.Organizations.Delete(ctx, id)
+}
+
+//processMembers returns the users in an organization that the caller is allowed to see.
+func processMembers(ctx context.Context, e *config.Env, id int) ([]byte, error) {
+	if !e.Policy.Allowed(ctx, authz.ActionRead) {
+		return nil, authz.ErrForbidden
+	}
+	orgs, err := e.Organizations.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := model.FindOrganization(orgs, id); !ok {
+		return nil, errors.New(model.OrganizationNotFound)
+	}
+
+	userList, err := e.Datastore.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]model.User, 0)
+	for _, u := range e.Policy.Filter(ctx, userList) {
+		if u.OrganizationID == id {
+			members = append(members, u)
+		}
+	}
+	return json.Marshal(maskUsers(ctx, e, members))
+}
+
+//organizationFromBody decodes and validates an organization from the request body.
+func organizationFromBody(r *http.Request) (*model.Organization, error) {
+	o := model.Organization{}
+	json.NewDecoder(r.Body).Decode(&o)
+	o.ID = 0
+	if errs := validation.ValidateOrganization(&o); len(errs) > 0 {
+		return nil, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: errs}
+	}
+	return &o, nil
+}
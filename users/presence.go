@@ -0,0 +1,71 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/events"
+	"github.com/nmalensek/go-user-form/i18n"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/presence"
+)
+
+//PresenceDisabled is returned when the server doesn't track who is editing users.
+const PresenceDisabled = "Editing presence is not enabled on this server."
+
+//servePresence handles GET /users/presence, a WebSocket on which clients say which user they're editing and hear
+//about the other editors of that user and the changes they save.
+func servePresence(ctx context.Context, w http.ResponseWriter, r *http.Request, e *config.Env) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if e.Presence == nil {
+		handleLogError(ctx, w, errors.New(PresenceDisabled), e.ErrorLog)
+		return
+	}
+	if !e.Policy.Allowed(ctx, authz.ActionEdit) {
+		handleLogError(ctx, w, authz.ErrForbidden, e.ErrorLog)
+		return
+	}
+
+	conn, err := presence.Upgrade(w, r)
+	if err != nil {
+		e.ErrorLog.Printf("servePresence: %v", err)
+		return
+	}
+	//editing a user doesn't mean the caller may see their sensitive fields, so the changes they're sent are masked.
+	mask := func(ev events.Event) events.Event {
+		return maskEvent(ctx, e, ev)
+	}
+	e.Presence.Serve(conn, model.ActorFromContext(ctx), mask, func(id int) error {
+		return canEdit(ctx, e, id)
+	})
+}
+
+//canEdit checks that the caller may edit the user with the given ID. Its errors are sent over the presence connection,
+//so they're already translated.
+func canEdit(ctx context.Context, e *config.Env, id int) error {
+	lang := i18n.LanguageFromContext(ctx)
+	ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+	defer cancel()
+
+	existing, err := findUser(ctx, e.Datastore, id)
+	if err == nil {
+		err = e.Policy.Authorize(ctx, authz.ActionEdit, existing.Organization)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, authz.ErrForbidden):
+		return errors.New(localizeText(lang, Forbidden))
+	case err.Error() == model.CouldNotFind:
+		return errors.New(localizeText(lang, model.CouldNotFind))
+	default:
+		e.ErrorLog.Printf("canEdit: %v", err)
+		return errors.New(localizeText(lang, ErrorWhileProcessing))
+	}
+}
@@ -0,0 +1,45 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//purgeActor is recorded as the acting user for purges made by the background job.
+const purgeActor = "system:purge"
+
+//RunPurgeJob permanently removes users that have been deleted for longer than the configured retention window,
+//checking every purge interval until ctx is cancelled.
+func RunPurgeJob(ctx context.Context, e *config.Env) {
+	ticker := time.NewTicker(e.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purgeExpired(ctx, e, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//purgeExpired removes users deleted before now minus the retention window.
+func purgeExpired(ctx context.Context, e *config.Env, now time.Time) {
+	ctx = model.WithActor(ctx, purgeActor)
+	ctx, cancel := withTimeout(ctx, e.Timeouts.Delete)
+	defer cancel()
+
+	purged, err := e.Datastore.Purge(ctx, now.Add(-e.Retention))
+	if err != nil {
+		e.ErrorLog.Printf("purge: %v", err)
+		return
+	}
+	if len(purged) > 0 {
+		e.ErrorLog.Printf("purge: permanently removed %v users deleted more than %v ago", len(purged), e.Retention)
+	}
+}
@@ -0,0 +1,94 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/schema"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//Schema error messages.
+const (
+	SchemaDisabled = "Custom attributes are not enabled on this server."
+	InvalidSchema  = "Invalid schema received, see ErrorList for details."
+)
+
+//ProcessSchemaRequest manages the custom attribute schema: GET /schema returns it, PUT /schema replaces it,
+//and PUT or DELETE /schema/{name} adds, replaces or removes a single attribute.
+func ProcessSchemaRequest(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/schema"), "/")
+
+	var err error
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		ctx := r.Context()
+		if !e.Policy.Allowed(ctx, authz.ActionRead) {
+			handleLogError(ctx, w, authz.ErrForbidden, e.ErrorLog)
+			return
+		}
+		if e.Schema == nil {
+			handleLogError(ctx, w, errors.New(SchemaDisabled), e.ErrorLog)
+			return
+		}
+		resp, err := json.Marshal(e.Schema.Current())
+		if err != nil {
+			handleLogError(ctx, w, err, e.ErrorLog)
+			return
+		}
+		writeJSON(w, resp)
+		return
+	case r.Method == http.MethodPut && name == "":
+		err = processSchemaChange(r.Context(), e, func() error {
+			var next schema.Schema
+			json.NewDecoder(r.Body).Decode(&next)
+			return e.Schema.Replace(next)
+		})
+	case r.Method == http.MethodPut:
+		err = processSchemaChange(r.Context(), e, func() error {
+			var a schema.Attribute
+			json.NewDecoder(r.Body).Decode(&a)
+			a.Name = name
+			return e.Schema.Set(a)
+		})
+	case r.Method == http.MethodDelete && name != "":
+		err = processSchemaChange(r.Context(), e, func() error {
+			return e.Schema.Remove(name)
+		})
+	default:
+		if name == "" {
+			methodNotAllowed(w, http.MethodGet, http.MethodPut)
+		} else {
+			methodNotAllowed(w, http.MethodPut, http.MethodDelete)
+		}
+		return
+	}
+
+	if err != nil {
+		handleLogError(r.Context(), w, err, e.ErrorLog)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+//processSchemaChange checks that the caller may manage the schema, then applies the change.
+//Definitions the schema rejects are reported in the standard error format.
+func processSchemaChange(ctx context.Context, e *config.Env, change func() error) error {
+	if err := e.Policy.Authorize(ctx, authz.ActionManageSchema, ""); err != nil {
+		return err
+	}
+	if e.Schema == nil {
+		return errors.New(SchemaDisabled)
+	}
+
+	err := change()
+	if err != nil && err.Error() != schema.AttributeNotFound {
+		return validation.UserErrors{Message: InvalidSchema, Code: CodeInvalidSchema, ErrorList: []validation.UserError{{PropName: "schema", Message: err.Error()}}}
+	}
+	return err
+}
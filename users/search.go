@@ -0,0 +1,85 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//defaultSearchLimit is the number of search results returned when the request doesn't give a limit.
+const defaultSearchLimit = 20
+
+//serveSearch handles GET /users/search.
+func serveSearch(ctx context.Context, w http.ResponseWriter, r *http.Request, e *config.Env) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+	defer cancel()
+	if resp, err := processSearch(ctx, r, e); err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+	} else {
+		writeJSON(w, resp)
+	}
+}
+
+//processSearch returns the users matching the q query parameter, best match first. Words match first names,
+//last names, email addresses and organizations by prefix, substring or with a typo or two, and every word must match.
+//At most limit results (default defaultSearchLimit) are returned, out of the users the caller may read. Users the
+//caller may not unmask are only returned if they still match with their sensitive fields masked.
+func processSearch(ctx context.Context, r *http.Request, e *config.Env) ([]byte, error) {
+	if !e.Policy.Allowed(ctx, authz.ActionRead) {
+		return nil, authz.ErrForbidden
+	}
+
+	q := r.URL.Query()
+	errs := make([]validation.UserError, 0)
+	query := q.Get("q")
+	if query == "" {
+		errs = append(errs, validation.NewError("q", "", "q", validation.Problem{Code: validation.CodeRequired}))
+	}
+	limit := defaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs = append(errs, validation.FormatError("limit", v))
+		}
+		limit = n
+	}
+	if len(errs) > 0 {
+		return nil, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: errs}
+	}
+
+	found, err := e.Datastore.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	found = matchMasked(maskUsers(ctx, e, e.Policy.Filter(ctx, found)), query)
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return json.Marshal(found)
+}
+
+//matchMasked returns the users, in the same order, that match query as they're shown to the caller, so a search
+//can't find users by the values masked for the caller.
+func matchMasked(users []model.User, query string) []model.User {
+	matching := make(map[int]bool, len(users))
+	for _, u := range model.SearchUsers(users, query) {
+		matching[u.ID] = true
+	}
+	visible := make([]model.User, 0, len(users))
+	for _, u := range users {
+		if matching[u.ID] {
+			visible = append(visible, u)
+		}
+	}
+	return visible
+}
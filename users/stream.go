@@ -0,0 +1,131 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/events"
+)
+
+//EventsDisabled is returned when the server doesn't have an event feed.
+const EventsDisabled = "The event feed is not enabled on this server."
+
+//resetEvent tells a subscriber that it missed events that are no longer logged, so it should reload the users it shows.
+const resetEvent = "reset"
+
+//heartbeatInterval is how often an idle stream sends a comment, so proxies don't close the connection.
+var heartbeatInterval = 30 * time.Second
+
+//serveEvents handles GET /users/events, a Server-Sent Events stream of user changes. Each event's id is its number
+//in the server's event log, a client that reconnects with it in the Last-Event-ID header (or the lastEventId query
+//parameter) receives the events it missed if they're still logged, and a reset event if they aren't.
+func serveEvents(ctx context.Context, w http.ResponseWriter, r *http.Request, e *config.Env) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if err := processEvents(ctx, w, r, e); err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+	}
+}
+
+//processEvents streams events until the client disconnects or falls too far behind. Errors are only returned before
+//the stream starts, once it has started the response can't be changed.
+func processEvents(ctx context.Context, w http.ResponseWriter, r *http.Request, e *config.Env) error {
+	if e.Events == nil {
+		return errors.New(EventsDisabled)
+	}
+	if !e.Policy.Allowed(ctx, authz.ActionRead) {
+		return authz.ErrForbidden
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("processEvents: response writer does not support streaming")
+	}
+
+	var after int64
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	if lastID != "" {
+		//an ID this server didn't hand out can't be resumed from, the subscriber is told to reload instead.
+		if n, err := strconv.ParseInt(lastID, 10, 64); err == nil && n > 0 {
+			after = n
+		} else {
+			after = -1
+		}
+	}
+
+	sub, missed, complete := e.Events.Subscribe(after)
+	defer e.Events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if after < 0 || !complete {
+		fmt.Fprintf(w, "event: %v\ndata: {}\n\n", resetEvent)
+	}
+	for _, entry := range missed {
+		if err := writeEvent(ctx, w, e, entry); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry, ok := <-sub.C:
+			if !ok {
+				//dropped for falling behind, the client reconnects with the last ID it received.
+				return nil
+			}
+			if err := writeEvent(ctx, w, e, entry); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+//writeEvent writes entry as an SSE message if the caller may read the user before or after the change, masking the
+//user for callers who may not unmask them.
+func writeEvent(ctx context.Context, w http.ResponseWriter, e *config.Env, entry events.Entry) error {
+	if !canSeeEvent(ctx, e, entry.Event) {
+		return nil
+	}
+	data, err := json.Marshal(maskEvent(ctx, e, entry.Event))
+	if err != nil {
+		e.ErrorLog.Printf("writeEvent: %v", err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %s\n\n", entry.Seq, entry.Event.Type, data)
+	return err
+}
+
+//canSeeEvent reports whether the caller may read the changed user, a user moved out of the caller's organization
+//is still announced so the caller can remove it.
+func canSeeEvent(ctx context.Context, e *config.Env, ev events.Event) bool {
+	if e.Policy.Authorize(ctx, authz.ActionRead, ev.User.Organization) == nil {
+		return true
+	}
+	return ev.Previous != nil && e.Policy.Authorize(ctx, authz.ActionRead, ev.Previous.Organization) == nil
+}
@@ -0,0 +1,69 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/suggest"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//SuggestionsDisabled is returned when the server isn't configured to make suggestions.
+const SuggestionsDisabled = "Suggestions are not enabled on this server."
+
+//serveSuggest handles GET /users/suggest.
+func serveSuggest(ctx context.Context, w http.ResponseWriter, r *http.Request, e *config.Env) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if resp, err := processSuggest(ctx, r, e); err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+	} else {
+		writeJSON(w, resp)
+	}
+}
+
+//processSuggest returns suggestions for the field query parameter that start with prefix, at most limit of them.
+//Only the fields in the server's suggestion configuration may be suggested, and only from the users the caller may read.
+//Sensitive fields, and users when their names are sensitive, are only suggested from the users the caller may unmask.
+func processSuggest(ctx context.Context, r *http.Request, e *config.Env) ([]byte, error) {
+	if e.Suggestions == nil {
+		return nil, errors.New(SuggestionsDisabled)
+	}
+	if !e.Policy.Allowed(ctx, authz.ActionRead) {
+		return nil, authz.ErrForbidden
+	}
+
+	q := r.URL.Query()
+	errs := make([]validation.UserError, 0)
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs = append(errs, validation.FormatError("limit", v))
+		}
+		limit = n
+	}
+	field := q.Get("field")
+	sensitive := e.Sensitivity.Sensitive(field)
+	if field == suggest.UserField {
+		sensitive = e.Sensitivity.Sensitive("firstName") || e.Sensitivity.Sensitive("lastName")
+	}
+	suggestions, err := e.Suggestions.Suggest(field, q.Get("prefix"), limit, func(org string) bool {
+		return e.Policy.Authorize(ctx, authz.ActionRead, org) == nil && (!sensitive || canUnmask(ctx, e, org))
+	})
+	if err != nil {
+		errs = append(errs, validation.NewError("field", field, "field", validation.Problem{Code: validation.CodeNotAllowed}))
+	}
+	if len(errs) > 0 {
+		return nil, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: errs}
+	}
+
+	return json.Marshal(suggestions)
+}
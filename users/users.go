@@ -1,16 +1,25 @@
 package users
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"math"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/nmalensek/go-user-form/authz"
 	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/i18n"
 	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/presence"
+	"github.com/nmalensek/go-user-form/schema"
+	"github.com/nmalensek/go-user-form/sensitivity"
 	"github.com/nmalensek/go-user-form/validation"
 )
 
@@ -19,42 +28,177 @@ const (
 	MalformedURI         = "Received malformed URI, please check input and try again"
 	InvalidInput         = "Invalid input received, see ErrorList for details."
 	ErrorWhileProcessing = "An error occurred while processing your request, please try again later."
+	RequestTimedOut      = "The request took too long to process, please try again later."
+	Forbidden            = "You do not have permission to perform this action."
 )
 
 //ProcessRequestByType checks which HTTP verb the request has and processes it accordingly.
+//Each datastore operation runs under a context derived from the request's, bounded by the configured timeout for that operation.
 func ProcessRequestByType(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	ctx := r.Context()
+	if reqID := r.Header.Get("X-Request-ID"); reqID != "" {
+		ctx = model.WithRequestID(ctx, reqID)
+	}
+	//saves from an editor with the user open aren't echoed back to it, if the session is the caller's own.
+	if session := r.Header.Get(presence.SessionHeader); session != "" {
+		ctx = presence.WithSession(ctx, session)
+	}
+
+	if action, ok := getActionFromPath(r.URL.EscapedPath()); ok {
+		action(ctx, w, r, e)
+		return
+	}
+	if id, sub, ok := getSubresourceFromPath(r.URL.EscapedPath()); ok {
+		processSubresource(ctx, w, r, e, id, sub)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		if u, err := processGet(r, e.Datastore); err != nil {
-			handleLogError(w, err, e.ErrorLog)
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+		defer cancel()
+		if u, err := processGet(ctx, r, e); err != nil {
+			handleLogError(ctx, w, err, e.ErrorLog)
 		} else {
 			w.Write(u)
 		}
 	case http.MethodPost:
-		if err := processPost(r, e.Datastore); err != nil {
-			handleLogError(w, err, e.ErrorLog)
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Create)
+		defer cancel()
+		if err := processPost(ctx, r, e); err != nil {
+			handleLogError(ctx, w, err, e.ErrorLog)
 		} else {
 			w.WriteHeader(http.StatusOK)
 		}
 	case http.MethodPut:
-		if err := processPut(r, e.Datastore); err != nil {
-			handleLogError(w, err, e.ErrorLog)
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Edit)
+		defer cancel()
+		if err := processPut(ctx, r, e); err != nil {
+			handleLogError(ctx, w, err, e.ErrorLog)
 		} else {
 			w.WriteHeader(http.StatusOK)
 		}
 	case http.MethodDelete:
-		if err := processDelete(r, e.Datastore); err != nil {
-			handleLogError(w, err, e.ErrorLog)
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Delete)
+		defer cancel()
+		if err := processDelete(ctx, r, e); err != nil {
+			handleLogError(ctx, w, err, e.ErrorLog)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+//processSubresource handles requests for /users/{id}/{sub} paths.
+func processSubresource(ctx context.Context, w http.ResponseWriter, r *http.Request, e *config.Env, id int, sub string) {
+	switch sub {
+	case "history":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+		defer cancel()
+		if resp, err := processHistory(ctx, r, e, id); err != nil {
+			handleLogError(ctx, w, err, e.ErrorLog)
+		} else {
+			writeJSON(w, resp)
+		}
+	case "restore":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Edit)
+		defer cancel()
+		if err := processRestore(ctx, e, id); err != nil {
+			handleLogError(ctx, w, err, e.ErrorLog)
 		} else {
 			w.WriteHeader(http.StatusOK)
 		}
+	case "reports", "chain":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+		defer cancel()
+		var resp []byte
+		var err error
+		if sub == "reports" {
+			resp, err = processReports(ctx, r, e, id)
+		} else {
+			resp, err = processChain(ctx, e, id)
+		}
+		if err != nil {
+			handleLogError(ctx, w, err, e.ErrorLog)
+		} else {
+			writeJSON(w, resp)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+//collectionActions are the named endpoints under /users/ that aren't user IDs, e.g. /users/duplicates.
+var collectionActions = map[string]func(context.Context, http.ResponseWriter, *http.Request, *config.Env){
+	"duplicates": serveDuplicates,
+	"events":     serveEvents,
+	"orgchart":   serveOrgChart,
+	"presence":   servePresence,
+	"search":     serveSearch,
+	"suggest":    serveSuggest,
+}
+
+//serveDuplicates handles GET /users/duplicates.
+func serveDuplicates(ctx context.Context, w http.ResponseWriter, r *http.Request, e *config.Env) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	ctx, cancel := withTimeout(ctx, e.Timeouts.Get)
+	defer cancel()
+	if resp, err := processDuplicates(ctx, e); err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+	} else {
+		writeJSON(w, resp)
+	}
+}
+
+//withTimeout derives a cancellable context from ctx, adding a deadline if d is greater than zero.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, d)
 }
 
 //processGet returns bytes from JSON records from the database or an error if one occurs.
+//Deleted users are only included if the include=deleted query parameter is given, see applyListOptions for sorting and filtering.
 //TODO: because no processing's done here, this method should use io.Copy or http.ServeContent to pass database content directly to the client.
-func processGet(r *http.Request, db model.UserDataStore) ([]byte, error) {
-	userList, err := db.GetAll()
+//Callers whose read permission is scoped only receive the users they're allowed to see, with the sensitive fields
+//masked of the users they may not unmask.
+func processGet(ctx context.Context, r *http.Request, e *config.Env) ([]byte, error) {
+	if !e.Policy.Allowed(ctx, authz.ActionRead) {
+		return nil, authz.ErrForbidden
+	}
+
+	userList, err := e.Datastore.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r.URL.Query().Get("include") == "deleted" {
+		deleted, err := e.Datastore.GetDeleted(ctx)
+		if err != nil {
+			return nil, err
+		}
+		userList = append(userList, deleted...)
+		sort.Slice(userList, func(i, j int) bool { return userList[i].ID < userList[j].ID })
+	}
+	//masked before they're filtered and sorted, so neither can reveal the values the caller doesn't see.
+	userList = maskUsers(ctx, e, e.Policy.Filter(ctx, userList))
+
+	userList, err = applyListOptions(userList, r.URL.Query())
 	if err != nil {
 		return nil, err
 	}
@@ -68,13 +212,17 @@ func processGet(r *http.Request, db model.UserDataStore) ([]byte, error) {
 
 //processPost runs validation methods, then returns nil
 //if the post was successful or an error if one occurred.
-func processPost(r *http.Request, db model.UserDataStore) error {
-	user, errs := validateBodyToUser(r, true)
+func processPost(ctx context.Context, r *http.Request, e *config.Env) error {
+	user, errs := validateBodyToUser(ctx, r, validation.Create, e)
 	if errs != nil {
 		return errs
 	}
 
-	err := db.Create(user)
+	if err := e.Policy.Authorize(ctx, authz.ActionCreate, user.Organization); err != nil {
+		return err
+	}
+
+	err := e.Datastore.Create(ctx, user)
 	if err != nil {
 		return err
 	}
@@ -83,8 +231,9 @@ func processPost(r *http.Request, db model.UserDataStore) error {
 
 //processPut runs validation methods, then returns nil
 //if the put was successful or an error if one occurred.
-func processPut(r *http.Request, db model.UserDataStore) error {
-	u, valErrs := validateBodyToUser(r, false)
+//Scoped callers may only edit users in their organization and may not move them to another one.
+func processPut(ctx context.Context, r *http.Request, e *config.Env) error {
+	u, valErrs := validateBodyToUser(ctx, r, validation.Update, e)
 	if valErrs != nil {
 		return valErrs
 	}
@@ -94,7 +243,16 @@ func processPut(r *http.Request, db model.UserDataStore) error {
 		return errors.New(MalformedURI)
 	}
 
-	err := db.Edit(*u, id)
+	if err := authorizeExisting(ctx, e, authz.ActionEdit, id); err != nil {
+		return err
+	}
+	if u.Organization != "" {
+		if err := e.Policy.Authorize(ctx, authz.ActionEdit, u.Organization); err != nil {
+			return err
+		}
+	}
+
+	err := e.Datastore.Edit(ctx, *u, id)
 	if err != nil {
 		return err
 	}
@@ -103,36 +261,112 @@ func processPut(r *http.Request, db model.UserDataStore) error {
 }
 
 //processDelete checks for the user in the database and deletes them if
-//present or returns an error if they're not found.
-func processDelete(r *http.Request, db model.UserDataStore) error {
+//present or returns an error if they're not found. Managers' reports are handled per the environment's ManagerDeletion policy.
+func processDelete(ctx context.Context, r *http.Request, e *config.Env) error {
 	id, ok := getIDFromPath(r.URL.EscapedPath())
 
 	if !ok {
 		return errors.New(MalformedURI)
 	}
 
-	err := db.Delete(id)
+	if err := authorizeExisting(ctx, e, authz.ActionDelete, id); err != nil {
+		return err
+	}
+	return model.DeleteUser(ctx, e.Datastore, id, e.ManagerDeletion)
+}
+
+//processRestore undoes the deletion of a user. Restoring requires the same permission as deleting.
+func processRestore(ctx context.Context, e *config.Env, id int) error {
+	if e.Policy != nil {
+		if !e.Policy.Allowed(ctx, authz.ActionDelete) {
+			return authz.ErrForbidden
+		}
+		deleted, err := e.Datastore.GetDeleted(ctx)
+		if err != nil {
+			return err
+		}
+		for _, u := range deleted {
+			if u.ID == id {
+				if err := e.Policy.Authorize(ctx, authz.ActionDelete, u.Organization); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return e.Datastore.Restore(ctx, id)
+}
+
+//authorizeExisting checks that the caller may perform action on the stored user with the given ID.
+//Users that don't exist are left for the datastore to report.
+func authorizeExisting(ctx context.Context, e *config.Env, action string, id int) error {
+	if e.Policy == nil {
+		return nil
+	}
+	if !e.Policy.Allowed(ctx, action) {
+		return authz.ErrForbidden
+	}
+
+	existing, err := findUser(ctx, e.Datastore, id)
 	if err != nil {
+		if err.Error() == model.CouldNotFind {
+			return nil
+		}
 		return err
 	}
-	return nil
+	return e.Policy.Authorize(ctx, action, existing.Organization)
 }
 
-//validateBodyToUser validates the request body to make sure a complete User object was submitted.
+//findUser returns the stored user with the given ID.
+func findUser(ctx context.Context, db model.UserDataStore, id int) (model.User, error) {
+	users, err := db.GetAll(ctx)
+	if err != nil {
+		return model.User{}, err
+	}
+	for _, u := range users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return model.User{}, errors.New(model.CouldNotFind)
+}
+
+//validateBodyToUser normalizes and validates the request body against the environment's rules for the given operation.
+//Custom attributes are checked against the schema, if there is no schema store no attributes are accepted.
+//If organizations are managed, the user's organization must exist and may be given by ID or by name, otherwise
+//organization IDs aren't accepted.
+//The email address is verified here rather than in the datastore, within the request's context.
 //If valid, returns a pointer to a new model.User struct from the submitted object.
-func validateBodyToUser(r *http.Request, isComplete bool) (*model.User, error) {
+func validateBodyToUser(ctx context.Context, r *http.Request, op validation.Operation, e *config.Env) (*model.User, error) {
 	newUser := model.User{}
 	json.NewDecoder(r.Body).Decode(&newUser)
+	//timestamps, actors and deletion are managed by the server, never by the request body.
+	newUser.ClearServerManaged()
 
-	var inputErrors []validation.UserError
-	if isComplete {
-		inputErrors = validation.ValidateCompleteInput(newUser)
+	rules := e.Rules
+	if rules == nil {
+		rules = validation.DefaultRules()
+	}
+	var current schema.Schema
+	if e.Schema != nil {
+		current = e.Schema.Current()
+	}
+	rules = rules.With(validation.AnyOperation, validation.AttributeRule(current))
+	if e.EmailVerifier != nil {
+		rules = rules.With(validation.AnyOperation, e.EmailVerifier.Rule(ctx))
+	}
+	if e.Organizations != nil {
+		orgs, err := e.Organizations.GetAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rules = rules.With(validation.AnyOperation, &validation.OrganizationRule{Organizations: orgs})
 	} else {
-		inputErrors = validation.ValidatePartialInput(newUser)
+		rules = rules.With(validation.AnyOperation, validation.UnmanagedOrganizationRule)
 	}
 
-	if inputErrors != nil {
-		return nil, validation.UserErrors{Message: InvalidInput, ErrorList: inputErrors}
+	if inputErrors := rules.Apply(&newUser, op); len(inputErrors) > 0 {
+		return nil, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: inputErrors}
 	}
 
 	return &newUser, nil
@@ -157,24 +391,104 @@ func getIDFromPath(p string) (int, bool) {
 	return parsedID, true
 }
 
-//handleError logs the error that occurred, writes a 500 HTTP code response header, then sends details about the error back to the requestor if applicable.
-func handleLogError(w http.ResponseWriter, e error, log *log.Logger) {
-	log.Println(e)
+//getActionFromPath returns the handler for a /users/{action} path, if there is one.
+func getActionFromPath(p string) (func(context.Context, http.ResponseWriter, *http.Request, *config.Env), bool) {
+	m := actionPatt.FindStringSubmatch(p)
+	if m == nil {
+		return nil, false
+	}
+	action, ok := collectionActions[m[1]]
+	return action, ok
+}
 
-	var resp []byte
-	switch e.(type) {
+var actionPatt = regexp.MustCompile(`^/users/([a-z]+)$`)
+
+//getSubresourceFromPath splits a /users/{id}/{sub} path into the user ID and subresource name.
+func getSubresourceFromPath(p string) (int, string, bool) {
+	m := subresourcePatt.FindStringSubmatch(p)
+	if m == nil {
+		return 0, "", false
+	}
+	id, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, "", false
+	}
+	return id, m[2], true
+}
+
+var subresourcePatt = regexp.MustCompile(`/([0-9]+)/([a-z]+)$`)
+
+//writeJSON sends a successful response containing JSON.
+func writeJSON(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+//methodNotAllowed responds 405, listing the methods the resource does support.
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+//conflictErrors is the body of a 409 Conflict response, it names the user who already has the email address.
+type conflictErrors struct {
+	validation.UserErrors
+	ExistingID int `json:"existingId"`
+}
+
+//handleError logs the error that occurred (with sensitive values redacted), writes an HTTP error code response header (500 unless the error has a more
+//specific status), then sends details about the error back to the requestor if applicable, in the language negotiated
+//for the request.
+func handleLogError(ctx context.Context, w http.ResponseWriter, e error, log *log.Logger) {
+	text := logText(e, sensitivity.FromContext(ctx))
+	if reqID := model.RequestIDFromContext(ctx); reqID != "" {
+		log.Printf("[%v] %v", reqID, text)
+	} else {
+		log.Println(text)
+	}
+
+	status := http.StatusInternalServerError
+	var dup *model.DuplicateEmailError
+	switch {
+	case errors.Is(e, authz.ErrForbidden):
+		status = http.StatusForbidden
+		e = validation.UserErrors{Message: Forbidden, Code: CodeForbidden}
+	case errors.Is(e, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+		e = errors.New(RequestTimedOut)
+	case e.Error() == model.OrganizationInUse || e.Error() == model.OrganizationNameTaken || e.Error() == model.HasReports:
+		status = http.StatusConflict
+	case errors.As(e, &dup):
+		status = http.StatusConflict
+		e = conflictErrors{UserErrors: validation.UserErrors{Message: model.DuplicateEmail, Code: CodeDuplicateEmail, ErrorList: []validation.UserError{
+			{PropName: "Email", Message: model.DuplicateEmail, Code: CodeDuplicateEmail},
+		}}, ExistingID: dup.ExistingID}
+	}
+
+	lang := i18n.LanguageFromContext(ctx)
+	var body interface{}
+	switch v := e.(type) {
 	case validation.UserErrors:
-		data, err := json.Marshal(e)
+		body = v.Localize(lang)
+	case conflictErrors:
+		v.UserErrors = v.UserErrors.Localize(lang)
+		body = v
+	}
+
+	var resp []byte
+	if body != nil {
+		data, err := json.Marshal(body)
 		if err != nil {
 			log.Println(err.Error())
-			resp = []byte(ErrorWhileProcessing)
+			resp = []byte(localizeText(lang, ErrorWhileProcessing))
 		} else {
 			resp = data
 		}
-	default:
-		resp = []byte(e.Error())
+	} else {
+		resp = []byte(localizeText(lang, e.Error()))
 	}
 
-	w.WriteHeader(500)
+	w.Header().Set("Content-Language", lang)
+	w.WriteHeader(status)
 	w.Write([]byte(resp))
 }
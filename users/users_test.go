@@ -1,19 +1,38 @@
 package users
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/nmalensek/go-user-form/audit"
+	"github.com/nmalensek/go-user-form/auth"
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/backup"
+	"github.com/nmalensek/go-user-form/events"
 	"github.com/nmalensek/go-user-form/fileusermodel"
+	"github.com/nmalensek/go-user-form/i18n"
+	"github.com/nmalensek/go-user-form/presence"
+	"github.com/nmalensek/go-user-form/schema"
+	"github.com/nmalensek/go-user-form/sensitivity"
+	"github.com/nmalensek/go-user-form/suggest"
 	"github.com/nmalensek/go-user-form/validation"
+	"github.com/nmalensek/go-user-form/webhook"
 
 	"github.com/nmalensek/go-user-form/config"
 	"github.com/nmalensek/go-user-form/model"
@@ -148,7 +167,7 @@ func makeMockEnv() config.Env {
 	testStore := &mockUsers{}
 	var buf bytes.Buffer
 	testLogger := log.New(&buf, "test log: ", log.Lshortfile)
-	return config.Env{Datastore: testStore, ErrorLog: testLogger}
+	return config.Env{Datastore: model.FromLegacy(testStore), ErrorLog: testLogger}
 }
 
 //Test getting all users; method should return all users in the datastore.
@@ -177,10 +196,10 @@ func TestGetProcessing(t *testing.T) {
 //Test new user creation; new user should be added to the datastore.
 func TestPostProcessingGood(t *testing.T) {
 	testStore := &mockUsers{}
-	mockEnv := config.Env{Datastore: testStore}
+	mockEnv := config.Env{Datastore: model.FromLegacy(testStore)}
 
 	req, err := http.NewRequest(http.MethodPost, "/users/",
-		strings.NewReader(`{"firstName":"testUser","lastName":"test1","email":"test@email.com","organization":"sales"}`))
+		strings.NewReader(`{"firstName":"testUser","lastName":"test1","email":"test1@email.com","organization":"sales"}`))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -197,9 +216,13 @@ func TestPostProcessingGood(t *testing.T) {
 	json.Unmarshal([]byte(updatedData), &userMap)
 
 	want := model.User{ID: 3, FirstName: "testUser", LastName: "test1",
-		Email: "test@email.com", Organization: "sales"}
+		Email: "test1@email.com", Organization: "sales"}
 	got := userMap[3]
-	if want != got {
+	if got.CreatedAt.IsZero() || got.UpdatedAt.IsZero() {
+		t.Errorf("new user should have creation and update times, got %+v", got)
+	}
+	got.ClearServerManaged()
+	if !reflect.DeepEqual(want, got) {
 		t.Errorf("incorrect user save data, got %v want %v",
 			got, want)
 	}
@@ -277,7 +300,7 @@ func TestPutValid(t *testing.T) {
 
 	compareStatusCode(rec.Code, http.StatusOK, t)
 
-	updatedData, _ := mockEnv.Datastore.GetAll()
+	updatedData, _ := mockEnv.Datastore.GetAll(context.Background())
 	var got model.User
 	for _, item := range updatedData {
 		if item.ID == 1 {
@@ -351,11 +374,65 @@ func TestPutInvalidUser(t *testing.T) {
 	compareGotWant(errs.ErrorList[0].Message, validation.MissingAllProps, t)
 }
 
+func TestPutInvalidEmail(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+
+	req, err := http.NewRequest(http.MethodPut, "/users/1", strings.NewReader(`{"email":"not-an-email"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+
+	var errs validation.UserErrors
+	json.NewDecoder(rec.Body).Decode(&errs)
+	if len(errs.ErrorList) != 1 || errs.ErrorList[0].PropName != "Email" {
+		t.Fatalf("Expected an Email error, got %v", errs.ErrorList)
+	}
+
+	users, _ := mockEnv.Datastore.GetAll(context.Background())
+	for _, u := range users {
+		if u.Email == "not-an-email" {
+			t.Errorf("Invalid email was saved: %v", u)
+		}
+	}
+}
+
+func TestPutNormalizesInput(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+
+	req, err := http.NewRequest(http.MethodPut, "/users/1",
+		strings.NewReader(`{"firstName":"  Jose\u0301 ", "email":" jose@example.com "}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	compareStatusCode(rec.Code, http.StatusOK, t)
+
+	users, _ := mockEnv.Datastore.GetAll(context.Background())
+	for _, u := range users {
+		if u.ID == 1 {
+			compareGotWant(u.FirstName, "Jos\u00e9", t)
+			compareGotWant(u.Email, "jose@example.com", t)
+		}
+	}
+}
+
 func TestDeleteValid(t *testing.T) {
 	mockEnv := makeMockEnv()
 
 	//make sure user to delete's there to make sure delete's actually modifying collection.
-	users, _ := mockEnv.Datastore.GetAll()
+	users, _ := mockEnv.Datastore.GetAll(context.Background())
 	var ok bool
 	for _, v := range users {
 		if v.ID == 1 {
@@ -379,7 +456,7 @@ func TestDeleteValid(t *testing.T) {
 	compareStatusCode(rec.Code, http.StatusOK, t)
 
 	//user from before shouldn't be there anymore.
-	users, _ = mockEnv.Datastore.GetAll()
+	users, _ = mockEnv.Datastore.GetAll(context.Background())
 	for _, v := range users {
 		if v.ID == 1 {
 			ok = false
@@ -409,8 +486,1036 @@ func TestDeleteInvalid(t *testing.T) {
 	compareGotWant(gotErr, wantErr, t)
 }
 
+func TestGetScopedToOrganization(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.Policy = authz.DefaultPolicy()
+
+	req, err := http.NewRequest(http.MethodGet, "/users/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Subject: "viewer", Organization: "sales", Roles: []string{"viewer"}}))
+
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	compareStatusCode(rec.Code, http.StatusOK, t)
+
+	var got []model.User
+	json.NewDecoder(rec.Body).Decode(&got)
+	if len(got) != 1 || got[0].Organization != "sales" {
+		t.Errorf("scoped caller should only see sales users, got %v", got)
+	}
+}
+
+func TestForbiddenActions(t *testing.T) {
+	editor := auth.Identity{Subject: "editor", Organization: "sales", Roles: []string{"editor"}}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"create in other org", http.MethodPost, "/users/", `{"firstName":"a","lastName":"b","email":"a@b.com","organization":"marketing"}`, http.StatusForbidden},
+		{"create in own org", http.MethodPost, "/users/", `{"firstName":"a","lastName":"b","email":"a@b.com","organization":"sales"}`, http.StatusOK},
+		{"edit other org", http.MethodPut, "/users/1", `{"firstName":"x"}`, http.StatusForbidden},
+		{"move user out of org", http.MethodPut, "/users/2", `{"organization":"marketing"}`, http.StatusForbidden},
+		{"edit own org", http.MethodPut, "/users/2", `{"firstName":"x"}`, http.StatusOK},
+		{"delete", http.MethodDelete, "/users/2", ``, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		mockEnv := makeMockEnv()
+		mockEnv.Policy = authz.DefaultPolicy()
+
+		req, err := http.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(auth.WithIdentity(req.Context(), editor))
+
+		handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != c.want {
+			t.Errorf("%v: got status %v want %v", c.name, rec.Code, c.want)
+		}
+		if c.want == http.StatusForbidden {
+			var errs validation.UserErrors
+			json.NewDecoder(rec.Body).Decode(&errs)
+			compareGotWant(errs.Message, Forbidden, t)
+		}
+	}
+}
+
+func TestHistoryAndAuditFeed(t *testing.T) {
+	mockEnv := makeMockEnv()
+	sink := &audit.MemorySink{}
+	mockEnv.Audit = sink
+	mockEnv.Datastore = audit.NewStore(mockEnv.Datastore, sink, mockEnv.ErrorLog)
+
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+	req, _ := http.NewRequest(http.MethodPut, "/users/1", strings.NewReader(`{"firstName":"edited","lastName":"testLn","email":"test@email.com","organization":"marketing"}`))
+	req = req.WithContext(model.WithActor(req.Context(), "admin"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest(http.MethodGet, "/users/1/history", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+
+	var history []audit.Entry
+	json.NewDecoder(rec.Body).Decode(&history)
+	if len(history) != 1 || history[0].Actor != "admin" || len(history[0].Changes) != 1 || history[0].Changes[0].Field != "firstName" {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	auditHandler := http.HandlerFunc(config.MakeHandler(ProcessAuditRequest, &mockEnv))
+	req, _ = http.NewRequest(http.MethodGet, "/audit?actor=someone-else", nil)
+	rec = httptest.NewRecorder()
+	auditHandler.ServeHTTP(rec, req)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	compareGotWant(rec.Body.String(), "[]", t)
+
+	req, _ = http.NewRequest(http.MethodGet, "/audit?since=yesterday", nil)
+	rec = httptest.NewRecorder()
+	auditHandler.ServeHTTP(rec, req)
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+
+	//only callers with audit access may read the global feed.
+	mockEnv.Policy = authz.DefaultPolicy()
+	req, _ = http.NewRequest(http.MethodGet, "/audit", nil)
+	req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Subject: "ed", Organization: "marketing", Roles: []string{"editor"}}))
+	rec = httptest.NewRecorder()
+	auditHandler.ServeHTTP(rec, req)
+	compareStatusCode(rec.Code, http.StatusForbidden, t)
+}
+
+func TestSensitiveFieldsMasked(t *testing.T) {
+	mockEnv := makeMockEnv()
+	var logs bytes.Buffer
+	mockEnv.ErrorLog = log.New(&logs, "", 0)
+	mockEnv.Policy = authz.DefaultPolicy()
+	mockEnv.Sensitivity = sensitivity.Default()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	get := func(role string) model.User {
+		req, _ := http.NewRequest(http.MethodGet, "/users/", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Subject: role, Organization: "sales", Roles: []string{role}}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		compareStatusCode(rec.Code, http.StatusOK, t)
+		var got []model.User
+		json.NewDecoder(rec.Body).Decode(&got)
+		if len(got) != 1 {
+			t.Fatalf("expected the one sales user, got %v", got)
+		}
+		return got[0]
+	}
+
+	//viewers may read users but not unmask them, editors may unmask users in their organization.
+	masked := get("viewer")
+	compareGotWant(masked.Email, "n***@employee.com", t)
+	compareGotWant(masked.FirstName, "t***", t)
+	compareGotWant(masked.Organization, "sales", t)
+	unmasked := get("editor")
+	compareGotWant(unmasked.Email, "new@employee.com", t)
+	compareGotWant(unmasked.FirstName, "test2", t)
+
+	//a search only finds the users the caller can't unmask by the parts of their values left visible.
+	search := func(role, query string) int {
+		req, _ := http.NewRequest(http.MethodGet, "/users/search?q="+query, nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Subject: role, Organization: "sales", Roles: []string{role}}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		compareStatusCode(rec.Code, http.StatusOK, t)
+		var got []model.User
+		json.NewDecoder(rec.Body).Decode(&got)
+		return len(got)
+	}
+	if n := search("viewer", "new"); n != 0 {
+		t.Errorf("a viewer found %v users by their masked email", n)
+	}
+	if n := search("viewer", "employee"); n != 1 {
+		t.Errorf("a viewer found %v users by the visible email domain, want 1", n)
+	}
+	if n := search("editor", "new"); n != 1 {
+		t.Errorf("an editor found %v users by their email, want 1", n)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/users/", strings.NewReader(`{"firstName":"Secret","lastName":"Person","email":"secret@","organization":"sales"}`))
+	req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Subject: "ed", Organization: "sales", Roles: []string{"editor"}}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+	if strings.Contains(logs.String(), "secret@") || !strings.Contains(logs.String(), `Email="[redacted]" (format)`) {
+		t.Errorf("the invalid email should be redacted in the log, got %q", logs.String())
+	}
+}
+
+func TestListSortAndFilter(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	req, _ := http.NewRequest(http.MethodPost, "/users/",
+		strings.NewReader(`{"firstName":"Aaron","lastName":"A","email":"aaron@email.com","organization":"sales","createdBy":"forged","createdAt":"2000-01-01T00:00:00Z"}`))
+	req = req.WithContext(model.WithActor(req.Context(), "creator"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	list := func(query string) ([]model.User, int) {
+		req, _ := http.NewRequest(http.MethodGet, "/users/"+query, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var users []model.User
+		json.NewDecoder(rec.Body).Decode(&users)
+		return users, rec.Code
+	}
+
+	created, _ := list("?createdBy=creator")
+	if len(created) != 1 || created[0].FirstName != "Aaron" {
+		t.Fatalf("expected only the new user to be created by creator, got %v", created)
+	}
+	if created[0].CreatedAt.Year() == 2000 {
+		t.Errorf("createdAt must not be taken from the request body")
+	}
+	if forged, _ := list("?createdBy=forged"); len(forged) != 0 {
+		t.Errorf("createdBy must not be taken from the request body, got %v", forged)
+	}
+
+	byName, _ := list("?sort=firstName&order=desc")
+	if len(byName) != 3 || byName[0].FirstName != "test2" || byName[2].FirstName != "Aaron" {
+		t.Errorf("unexpected sort order %v", byName)
+	}
+
+	since := url.QueryEscape(created[0].CreatedAt.Add(-time.Second).Format(time.RFC3339))
+	if recent, _ := list("?createdAfter=" + since); len(recent) != 1 {
+		t.Errorf("expected one user created after %v, got %v", since, recent)
+	}
+
+	if _, code := list("?sort=salary"); code != http.StatusInternalServerError {
+		t.Errorf("unknown sort key should be rejected, got status %v", code)
+	}
+}
+
+func TestCustomAttributes(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	mockEnv.Schema, _ = schema.Open("")
+
+	serve := func(h func(http.ResponseWriter, *http.Request, *config.Env), method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		config.MakeHandler(h, &mockEnv).ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(ProcessSchemaRequest, http.MethodPut, "/schema/title", `{"type":"string","required":true}`)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	rec = serve(ProcessSchemaRequest, http.MethodPut, "/schema/level", `{"type":"integer","min":1,"max":5}`)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	rec = serve(ProcessSchemaRequest, http.MethodPut, "/schema/broken", `{"type":"colour"}`)
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+
+	rec = serve(ProcessRequestByType, http.MethodPost, "/users/", `{"firstName":"a","lastName":"b","email":"a@b.com","organization":"sales"}`)
+	var errs validation.UserErrors
+	json.NewDecoder(rec.Body).Decode(&errs)
+	if len(errs.ErrorList) != 1 || errs.ErrorList[0].PropName != "attributes.title" {
+		t.Errorf("missing required attribute should be reported, got %v", errs.ErrorList)
+	}
+
+	rec = serve(ProcessRequestByType, http.MethodPost, "/users/", `{"firstName":"a","lastName":"b","email":"a@b.com","organization":"sales","attributes":{"title":"Engineer","level":"2"}}`)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+
+	rec = serve(ProcessRequestByType, http.MethodPut, "/users/3", `{"attributes":{"level":"9"}}`)
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+	rec = serve(ProcessRequestByType, http.MethodPut, "/users/3", `{"attributes":{"level":""}}`)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+
+	users, _ := mockEnv.Datastore.GetAll(context.Background())
+	want := map[string]string{"title": "Engineer"}
+	for _, u := range users {
+		if u.ID == 3 {
+			compareGotWant(u.Attributes, want, t)
+		}
+	}
+
+	rec = serve(ProcessSchemaRequest, http.MethodGet, "/schema", "")
+	var got schema.Schema
+	json.NewDecoder(rec.Body).Decode(&got)
+	if len(got.Attributes) != 2 {
+		t.Errorf("got %v attributes want 2", len(got.Attributes))
+	}
+}
+
+func TestDuplicateEmailConflict(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	checkConflict := func(rec *httptest.ResponseRecorder, wantID int) {
+		compareStatusCode(rec.Code, http.StatusConflict, t)
+		var body struct {
+			Message    string
+			ExistingID int `json:"existingId"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		compareGotWant(body.Message, model.DuplicateEmail, t)
+		compareGotWant(body.ExistingID, wantID, t)
+	}
+
+	checkConflict(serve(http.MethodPost, "/users/", `{"firstName":"a","lastName":"b","email":"TEST@email.com","organization":"sales"}`), 1)
+	checkConflict(serve(http.MethodPut, "/users/2", `{"email":"test@EMAIL.com"}`), 1)
+}
+
+func TestDuplicatesReport(t *testing.T) {
+	users := []model.User{
+		{ID: 1, FirstName: "Jane", LastName: "Doe", Email: "jane.doe@gmail.com", Organization: "Sales"},
+		{ID: 2, FirstName: "J", LastName: "D", Email: "JaneDoe+work@googlemail.com", Organization: "Support"},
+		{ID: 3, FirstName: "José", LastName: "Garcia", Email: "jose@example.com", Organization: "sales"},
+		{ID: 4, FirstName: "Jose", LastName: "Garcai", Email: "jgarcia@example.com", Organization: "Sales"},
+		{ID: 5, FirstName: "Garcia", LastName: "Jose", Email: "garcia@example.com", Organization: "Support"},
+		{ID: 6, FirstName: "first.last", LastName: "x", Email: "first.last@example.com", Organization: "Sales"},
+		{ID: 7, FirstName: "firstlast", LastName: "y", Email: "firstlast@example.com", Organization: "Sales"},
+		{ID: 8, FirstName: "Al", LastName: "Li", Email: "al@example.com", Organization: "Sales"},
+		{ID: 9, FirstName: "Ed", LastName: "Li", Email: "ed@example.com", Organization: "Sales"},
+	}
+
+	got := findDuplicates(users)
+	want := []struct {
+		reason string
+		ids    []int
+	}{
+		{ReasonEmail, []int{1, 2}},
+		{ReasonName, []int{3, 4}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v groups want %v: %+v", len(got), len(want), got)
+	}
+	for i, g := range got {
+		ids := make([]int, 0)
+		for _, u := range g.Users {
+			ids = append(ids, u.ID)
+		}
+		compareGotWant(g.Reason, want[i].reason, t)
+		compareGotWant(ids, want[i].ids, t)
+	}
+
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	req, _ := http.NewRequest(http.MethodGet, "/users/duplicates", nil)
+	rec := httptest.NewRecorder()
+	config.MakeHandler(ProcessRequestByType, &mockEnv).ServeHTTP(rec, req)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	compareGotWant(rec.Body.String(), "[]", t)
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	mockEnv := makeMockEnv()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	serve := func(method, path, body, lang string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Accept-Language", lang)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/users/", `{"lastName":"test","email":"test@.net","organization":"sales"}`, "de-DE, en;q=0.5")
+	compareGotWant(rec.Header().Get("Content-Language"), "de", t)
+	var errs validation.UserErrors
+	json.NewDecoder(rec.Body).Decode(&errs)
+	compareGotWant(errs.Code, CodeInvalidInput, t)
+	compareGotWant(errs.Message, "Ungültige Eingabe, Einzelheiten siehe Fehlerliste.", t)
+	if len(errs.ErrorList) != 2 {
+		t.Fatalf("expected two errors, got %v", errs.ErrorList)
+	}
+	compareGotWant(errs.ErrorList[0].Code, validation.CodeRequired, t)
+	compareGotWant(errs.ErrorList[0].Message, "Vorname ist erforderlich.", t)
+	compareGotWant(errs.ErrorList[1].Code, validation.CodeFormat, t)
+	compareGotWant(errs.ErrorList[1].Message, "E-Mail hat nicht das richtige Format.", t)
+
+	rec = serve(http.MethodPut, "/users/1asdf", `{"firstName":"x"}`, "fr")
+	compareGotWant(rec.Body.String(), "URI mal formée reçue, veuillez vérifier la saisie et réessayer", t)
+
+	rec = serve(http.MethodPut, "/users/1asdf", `{"firstName":"x"}`, "ja")
+	compareGotWant(rec.Body.String(), MalformedURI, t)
+}
+
+func TestEmailVerification(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	mockEnv.EmailVerifier = &validation.EmailVerifier{Disposable: validation.DefaultDisposableDomains}
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	serve := func(method, path, body string) validation.UserErrors {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var errs validation.UserErrors
+		json.NewDecoder(rec.Body).Decode(&errs)
+		return errs
+	}
+
+	errs := serve(http.MethodPost, "/users/", `{"firstName":"a","lastName":"b","email":"a@mailinator.com","organization":"sales"}`)
+	compareGotWant(errs.Code, CodeInvalidInput, t)
+	if len(errs.ErrorList) != 1 || errs.ErrorList[0].Code != validation.CodeDisposableDomain {
+		t.Errorf("got %+v want the disposable domain error", errs.ErrorList)
+	}
+	errs = serve(http.MethodPut, "/users/1", `{"email":"a@mailinator.com"}`)
+	if len(errs.ErrorList) != 1 || errs.ErrorList[0].Code != validation.CodeDisposableDomain {
+		t.Errorf("got %+v want the disposable domain error", errs.ErrorList)
+	}
+}
+
+func TestTranslationsComplete(t *testing.T) {
+	english := i18n.Default.Codes(i18n.DefaultLanguage)
+	for _, lang := range i18n.Default.Languages() {
+		//Lookup falls back to English, so compare the codes each language actually has.
+		translated := make(map[string]bool)
+		for _, code := range i18n.Default.Codes(lang) {
+			translated[code] = true
+		}
+		for _, code := range english {
+			if !translated[code] {
+				t.Errorf("%v has no translation for %v", lang, code)
+			}
+		}
+		for code := range translated {
+			if _, ok := i18n.Default.Lookup(i18n.DefaultLanguage, code); !ok {
+				t.Errorf("%v translates %v, which has no English message", lang, code)
+			}
+		}
+	}
+}
+
+func TestOrganizations(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	dir, _ := ioutil.TempDir("", "orgs")
+	defer os.RemoveAll(dir)
+	mockEnv.Organizations = &fileusermodel.FileOrganizationModel{Filepath: filepath.Join(dir, "organizations.json")}
+	orgHandler := http.HandlerFunc(config.MakeHandler(ProcessOrganizationRequest, &mockEnv))
+	userHandler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	serve := func(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(orgHandler, http.MethodPost, "/organizations/", `{"name":" Sales "}`)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	var sales model.Organization
+	json.NewDecoder(rec.Body).Decode(&sales)
+	compareGotWant(sales, model.Organization{ID: 1, Name: "Sales"}, t)
+
+	compareStatusCode(serve(orgHandler, http.MethodPost, "/organizations/", `{"name":"sales"}`).Code, http.StatusConflict, t)
+	compareStatusCode(serve(orgHandler, http.MethodPost, "/organizations/", `{"name":""}`).Code, http.StatusInternalServerError, t)
+	compareStatusCode(serve(orgHandler, http.MethodGet, "/organizations/7", "").Code, http.StatusInternalServerError, t)
+
+	//users can be given an organization by ID or by name, and unknown ones are rejected.
+	rec = serve(userHandler, http.MethodPost, "/users/", `{"firstName":"a","lastName":"b","email":"a@b.com","organizationId":1}`)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	rec = serve(userHandler, http.MethodPut, "/users/2", `{"organization":"SALES"}`)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	rec = serve(userHandler, http.MethodPut, "/users/1", `{"organization":"Sales Dept"}`)
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+	var userErrs validation.UserErrors
+	json.NewDecoder(rec.Body).Decode(&userErrs)
+	if len(userErrs.ErrorList) != 1 || userErrs.ErrorList[0].Code != validation.CodeUnknownOrganization {
+		t.Errorf("got %+v want one %v error", userErrs.ErrorList, validation.CodeUnknownOrganization)
+	}
+
+	listMembers := func() []model.User {
+		var members []model.User
+		json.NewDecoder(serve(orgHandler, http.MethodGet, "/organizations/1/users", "").Body).Decode(&members)
+		return members
+	}
+	members := listMembers()
+	if len(members) != 2 || members[0].ID != 2 || members[0].Organization != "Sales" {
+		t.Errorf("got members %+v want user 2 and the new user in Sales", members)
+	}
+
+	compareStatusCode(serve(orgHandler, http.MethodPut, "/organizations/1", `{"name":"Field Sales"}`).Code, http.StatusOK, t)
+	for _, u := range listMembers() {
+		compareGotWant(u.Organization, "Field Sales", t)
+	}
+
+	compareStatusCode(serve(orgHandler, http.MethodDelete, "/organizations/1", "").Code, http.StatusConflict, t)
+	serve(orgHandler, http.MethodPost, "/organizations/", `{"name":"Empty"}`)
+	compareStatusCode(serve(orgHandler, http.MethodDelete, "/organizations/2", "").Code, http.StatusOK, t)
+
+	var orgs []model.Organization
+	json.NewDecoder(serve(orgHandler, http.MethodGet, "/organizations/", "").Body).Decode(&orgs)
+	compareGotWant(orgs, []model.Organization{{ID: 1, Name: "Field Sales"}}, t)
+}
+
+func TestUnmanagedOrganizationID(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	//without an organization store a user's organization is only a name, so IDs refer to nothing.
+	for _, c := range []struct{ method, path, body string }{
+		{http.MethodPost, "/users/", `{"firstName":"a","lastName":"b","email":"a@b.com","organization":"Sales","organizationId":7}`},
+		{http.MethodPut, "/users/1", `{"firstName":"x","organizationId":7}`},
+	} {
+		req, _ := http.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+		var userErrs validation.UserErrors
+		json.NewDecoder(rec.Body).Decode(&userErrs)
+		if len(userErrs.ErrorList) != 1 || userErrs.ErrorList[0].Code != validation.CodeUnknownOrganization {
+			t.Errorf("%v %v: got %+v want one %v error", c.method, c.path, userErrs.ErrorList, validation.CodeUnknownOrganization)
+		}
+	}
+}
+
+func TestManagerHierarchy(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	listIDs := func(path string) []int {
+		var list []model.User
+		json.NewDecoder(serve(http.MethodGet, path, "").Body).Decode(&list)
+		ids := make([]int, 0)
+		for _, u := range list {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+
+	//1 manages 2, who manages 3.
+	compareStatusCode(serve(http.MethodPut, "/users/2", `{"managerId":1}`).Code, http.StatusOK, t)
+	compareStatusCode(serve(http.MethodPost, "/users/", `{"firstName":"c","lastName":"d","email":"c@d.com","organization":"sales","managerId":2}`).Code, http.StatusOK, t)
+	compareStatusCode(serve(http.MethodPut, "/users/1", `{"managerId":3}`).Code, http.StatusInternalServerError, t)
+	compareStatusCode(serve(http.MethodPut, "/users/1", `{"managerId":9}`).Code, http.StatusInternalServerError, t)
+
+	compareGotWant(listIDs("/users/1/reports"), []int{2}, t)
+	compareGotWant(listIDs("/users/1/reports?transitive=true"), []int{2, 3}, t)
+	compareGotWant(listIDs("/users/3/chain"), []int{2, 1}, t)
+
+	var chart []*ChartNode
+	json.NewDecoder(serve(http.MethodGet, "/users/orgchart", "").Body).Decode(&chart)
+	if len(chart) != 1 || chart[0].ID != 1 || len(chart[0].Reports) != 1 || chart[0].Reports[0].Reports[0].ID != 3 {
+		t.Errorf("got chart %+v want 1 -> 2 -> 3", chart)
+	}
+	rec := serve(http.MethodGet, "/users/orgchart?format=dot", "")
+	compareGotWant(rec.Header().Get("Content-Type"), "text/vnd.graphviz", t)
+	for _, want := range []string{"digraph orgchart {", `1 [label="test testLn\nmarketing"];`, "1 -> 2;", "2 -> 3;"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("DOT output %q is missing %q", rec.Body.String(), want)
+		}
+	}
+
+	//managers with reports can't be deleted unless reports are reassigned.
+	compareStatusCode(serve(http.MethodDelete, "/users/2", "").Code, http.StatusConflict, t)
+	mockEnv.ManagerDeletion = model.ManagerDeleteReassign
+	compareStatusCode(serve(http.MethodDelete, "/users/2", "").Code, http.StatusOK, t)
+	compareGotWant(listIDs("/users/1/reports"), []int{3}, t)
+	compareStatusCode(serve(http.MethodDelete, "/users/1", "").Code, http.StatusOK, t)
+	compareGotWant(listIDs("/users/3/chain"), []int{}, t)
+}
+
+func TestSearch(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, body := range []string{
+		`{"firstName":"Jane","lastName":"Doe","email":"jane@example.com","organization":"sales"}`,
+		`{"firstName":"Janet","lastName":"Smith","email":"janet@example.com","organization":"marketing"}`,
+	} {
+		req, _ := http.NewRequest(http.MethodPost, "/users/", strings.NewReader(body))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	tests := []struct {
+		path string
+		want []int
+	}{
+		{"/users/search?q=jane", []int{3, 4}},
+		{"/users/search?q=jane&limit=1", []int{3}},
+		{"/users/search?q=smtih", []int{4}},
+		{"/users/search?q=employee", []int{2}},
+		{"/users/search?q=xyz", []int{}},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.path)
+		compareStatusCode(rec.Code, http.StatusOK, t)
+		var found []model.User
+		json.NewDecoder(rec.Body).Decode(&found)
+		ids := make([]int, 0)
+		for _, u := range found {
+			ids = append(ids, u.ID)
+		}
+		compareGotWant(ids, tt.want, t)
+	}
+
+	rec := serve("/users/search?limit=0")
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+	var errs validation.UserErrors
+	json.NewDecoder(rec.Body).Decode(&errs)
+	compareGotWant(len(errs.ErrorList), 2, t)
+}
+
+func TestSuggest(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.Suggestions = suggest.NewIndex(suggest.DefaultConfig())
+	store, err := suggest.NewStore(context.Background(), mockEnv.Datastore, mockEnv.Suggestions, mockEnv.ErrorLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockEnv.Datastore = store
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	suggestions := func(path string) []suggest.Suggestion {
+		rec := serve(http.MethodGet, path, "")
+		compareStatusCode(rec.Code, http.StatusOK, t)
+		var got []suggest.Suggestion
+		json.NewDecoder(rec.Body).Decode(&got)
+		return got
+	}
+
+	compareGotWant(suggestions("/users/suggest?field=organization&prefix=sa"), []suggest.Suggestion{{Value: "sales", Count: 1}}, t)
+	serve(http.MethodPost, "/users/", `{"firstName":"Sam","lastName":"Sanders","email":"sam@email.com","organization":"Sandbox"}`)
+	compareGotWant(suggestions("/users/suggest?field=organization&prefix=sa&limit=1"), []suggest.Suggestion{{Value: "sales", Count: 1}}, t)
+	compareGotWant(suggestions("/users/suggest?field=user&prefix=sand"), []suggest.Suggestion{{Value: "Sam Sanders", ID: 3, Organization: "Sandbox"}}, t)
+
+	rec := serve(http.MethodGet, "/users/suggest?field=email&prefix=s", "")
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+	var errs validation.UserErrors
+	json.NewDecoder(rec.Body).Decode(&errs)
+	if len(errs.ErrorList) != 1 || errs.ErrorList[0].Code != validation.CodeNotAllowed {
+		t.Errorf("got %+v want one %v error", errs.ErrorList, validation.CodeNotAllowed)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	dir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(dir)
+	dispatcher, err := webhook.Open(dir, nil, mockEnv.ErrorLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockEnv.Webhooks = dispatcher
+	mockEnv.Datastore = events.NewStore(mockEnv.Datastore, dispatcher, mockEnv.ErrorLog)
+	hookHandler := http.HandlerFunc(config.MakeHandler(ProcessWebhookRequest, &mockEnv))
+	userHandler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	received := make(chan events.Event, 1)
+	var secret string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !webhook.Verify(secret, r.Header, body, time.Minute) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var e events.Event
+		json.Unmarshal(body, &e)
+		received <- e
+	}))
+	defer receiver.Close()
+
+	serve := func(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(hookHandler, http.MethodPost, "/webhooks", `{"url":"`+receiver.URL+`","events":["user.deleted"]}`)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	var ep webhook.Endpoint
+	json.NewDecoder(rec.Body).Decode(&ep)
+	secret = ep.Secret
+	if ep.ID != 1 || secret == "" {
+		t.Fatalf("got %+v want endpoint 1 with its secret", ep)
+	}
+	compareStatusCode(serve(hookHandler, http.MethodPost, "/webhooks", `{"url":"not a url"}`).Code, http.StatusInternalServerError, t)
+	compareStatusCode(serve(hookHandler, http.MethodGet, "/webhooks/9", "").Code, http.StatusInternalServerError, t)
+
+	var listed []webhook.Endpoint
+	json.NewDecoder(serve(hookHandler, http.MethodGet, "/webhooks/", "").Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].Secret != "" {
+		t.Errorf("got %+v want one endpoint without its secret", listed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Run(ctx)
+
+	compareStatusCode(serve(userHandler, http.MethodDelete, "/users/2", "").Code, http.StatusOK, t)
+	select {
+	case e := <-received:
+		compareGotWant(e.Type, events.UserDeleted, t)
+		compareGotWant(e.User.ID, 2, t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the webhook")
+	}
+
+	compareStatusCode(serve(hookHandler, http.MethodPut, "/webhooks/1", `{"events":["user.moved"]}`).Code, http.StatusInternalServerError, t)
+	compareStatusCode(serve(hookHandler, http.MethodDelete, "/webhooks/1", "").Code, http.StatusOK, t)
+	compareStatusCode(serve(hookHandler, http.MethodGet, "/webhooks/1/deliveries", "").Code, http.StatusInternalServerError, t)
+}
+
+func TestEventStream(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	mockEnv.Events = events.NewBroker(10, 10)
+	mockEnv.Datastore = events.NewStore(mockEnv.Datastore, mockEnv.Events, mockEnv.ErrorLog)
+	server := httptest.NewServer(http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv)))
+	defer server.Close()
+
+	type message struct {
+		id, event string
+		data      events.Event
+	}
+	//subscribe opens the stream and returns a channel of its messages.
+	subscribe := func(ctx context.Context, lastID string) <-chan message {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/users/events", nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareStatusCode(resp.StatusCode, http.StatusOK, t)
+		compareGotWant(resp.Header.Get("Content-Type"), "text/event-stream", t)
+
+		messages := make(chan message, 10)
+		go func() {
+			defer resp.Body.Close()
+			defer close(messages)
+			var m message
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				switch {
+				case line == "":
+					messages <- m
+					m = message{}
+				case strings.HasPrefix(line, "id: "):
+					m.id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "event: "):
+					m.event = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &m.data)
+				}
+			}
+		}()
+		return messages
+	}
+	next := func(messages <-chan message) message {
+		select {
+		case m := <-messages:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+		return message{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := subscribe(ctx, "")
+	for mockEnv.Events.Subscribers() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/users/2", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	compareStatusCode(resp.StatusCode, http.StatusOK, t)
+
+	m := next(messages)
+	compareGotWant(m.id, "1", t)
+	compareGotWant(m.event, events.UserDeleted, t)
+	compareGotWant(m.data.User.ID, 2, t)
+	cancel()
+	for mockEnv.Events.Subscribers() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/users/2/restore", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	//resuming replays what was missed, an unknown ID asks the client to reload.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	m = next(subscribe(ctx, "1"))
+	compareGotWant(m.id, "2", t)
+	compareGotWant(m.event, events.UserCreated, t)
+	compareGotWant(next(subscribe(ctx, "42")).event, resetEvent, t)
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/users/events", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	compareStatusCode(resp.StatusCode, http.StatusMethodNotAllowed, t)
+}
+
+func TestPresence(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	mockEnv.Presence = presence.NewHub(10)
+	mockEnv.Datastore = events.NewStore(mockEnv.Datastore, mockEnv.Presence, mockEnv.ErrorLog)
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	ann, bob := mockEnv.Presence.Connect("ann", nil), mockEnv.Presence.Connect("bob", nil)
+	defer mockEnv.Presence.Disconnect(ann)
+	defer mockEnv.Presence.Disconnect(bob)
+	mockEnv.Presence.Watch(ann, 1)
+	mockEnv.Presence.Watch(bob, 1)
+	drain := func(s *presence.Session) {
+		for len(s.C) > 0 {
+			<-s.C
+		}
+	}
+	drain(ann)
+	drain(bob)
+
+	save := func(subject, session, firstName string) {
+		req, _ := http.NewRequest(http.MethodPut, "/users/1", strings.NewReader(`{"firstName":"`+firstName+`"}`))
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Subject: subject, Roles: []string{"admin"}}))
+		req.Header.Set(presence.SessionHeader, session)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		compareStatusCode(rec.Code, http.StatusOK, t)
+	}
+
+	//bob names ann's session, but it isn't his so ann still hears about his save.
+	save("bob", ann.ID, "Bob's")
+	if len(ann.C) != 1 {
+		t.Errorf("got %v messages for ann want bob's save", len(ann.C))
+	}
+	drain(ann)
+	drain(bob)
+
+	//ann saves through the API, bob hears about it and ann doesn't.
+	save("ann", ann.ID, "Edited")
+
+	if len(ann.C) != 0 {
+		t.Errorf("got %+v want ann not sent their own save", <-ann.C)
+	}
+	select {
+	case m := <-bob.C:
+		compareGotWant(m.Type, presence.TypeChanged, t)
+		compareGotWant(m.Event.Type, events.UserUpdated, t)
+		compareGotWant(m.Event.User.FirstName, "Edited", t)
+		compareGotWant(m.Event.Previous.FirstName, "Bob's", t)
+	default:
+		t.Fatal("bob wasn't told about the save")
+	}
+
+	//changes are masked for each editor as the API masks users for them, editing doesn't imply unmasking.
+	mockEnv.Policy = authz.DefaultPolicy()
+	mockEnv.Sensitivity = sensitivity.Default()
+	viewer := auth.WithIdentity(context.Background(), auth.Identity{Subject: "carol", Organization: "marketing", Roles: []string{"viewer"}})
+	carol := mockEnv.Presence.Connect("carol", func(ev events.Event) events.Event {
+		return maskEvent(viewer, &mockEnv, ev)
+	})
+	defer mockEnv.Presence.Disconnect(carol)
+	mockEnv.Presence.Watch(carol, 1)
+	drain(carol)
+	drain(bob)
+	save("admin", "", "Masked")
+	if m := <-carol.C; m.Event.User.FirstName != "M***" || m.Event.Previous.FirstName != "E***" {
+		t.Errorf("got %+v then %+v want both masked for carol", m.Event.Previous, m.Event.User)
+	}
+	if m := <-bob.C; m.Event.User.FirstName != "Masked" {
+		t.Errorf("got %q want the change unmasked for bob's session", m.Event.User.FirstName)
+	}
+	mockEnv.Policy, mockEnv.Sensitivity = nil, nil
+
+	compareGotWant(canEdit(context.Background(), &mockEnv, 1), nil, t)
+	if err := canEdit(context.Background(), &mockEnv, 99); err == nil || err.Error() != model.CouldNotFind {
+		t.Errorf("got %v want %v", err, model.CouldNotFind)
+	}
+
+	//a plain request to the endpoint isn't a websocket handshake.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/presence", nil))
+	compareStatusCode(rec.Code, http.StatusBadRequest, t)
+}
+
+//makeFileEnv returns an environment backed by a real file datastore holding the base mock data.
+func makeFileEnv(t *testing.T) (config.Env, func()) {
+	dir, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(path, []byte(baseMockData), 0600)
+
+	mockEnv := makeMockEnv()
+	mockEnv.Datastore = &fileusermodel.FileUserModel{Filepath: path}
+	return mockEnv, func() { os.RemoveAll(dir) }
+}
+
+func TestAdminBackup(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessAdminRequest, &mockEnv))
+	serve := func(method string, id auth.Identity) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/admin/backup", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), id))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	admin := auth.Identity{Subject: "admin", Roles: []string{"admin"}}
+
+	rec := serve(http.MethodPost, admin)
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+	compareGotWant(rec.Body.String(), BackupsDisabled, t)
+
+	mockEnv.Backups = mockEnv.Datastore.(model.Backuper)
+	mockEnv.Policy = authz.DefaultPolicy()
+	compareStatusCode(serve(http.MethodPost, auth.Identity{Subject: "ed", Organization: "sales", Roles: []string{"editor"}}).Code, http.StatusForbidden, t)
+	compareStatusCode(serve(http.MethodGet, admin).Code, http.StatusMethodNotAllowed, t)
+
+	rec = serve(http.MethodPost, admin)
+	compareStatusCode(rec.Code, http.StatusOK, t)
+	compareGotWant(rec.Header().Get("Content-Type"), "application/gzip", t)
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment; filename=") {
+		t.Errorf("got Content-Disposition %q want an attachment", rec.Header().Get("Content-Disposition"))
+	}
+	archive, err := backup.Read(rec.Body, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Users) != 2 || archive.Users[0].Email != "test@email.com" {
+		t.Errorf("got %+v want both users", archive.Users)
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	listIDs := func(path string) []int {
+		var list []model.User
+		json.NewDecoder(serve(http.MethodGet, path).Body).Decode(&list)
+		ids := make([]int, 0)
+		for _, u := range list {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+
+	compareStatusCode(serve(http.MethodDelete, "/users/1").Code, http.StatusOK, t)
+
+	compareGotWant(fmt.Sprint(listIDs("/users/")), "[2]", t)
+	compareGotWant(fmt.Sprint(listIDs("/users/?include=deleted")), "[1 2]", t)
+
+	compareStatusCode(serve(http.MethodGet, "/users/1/restore").Code, http.StatusMethodNotAllowed, t)
+	compareStatusCode(serve(http.MethodPost, "/users/1/restore").Code, http.StatusOK, t)
+	compareGotWant(fmt.Sprint(listIDs("/users/")), "[1 2]", t)
+
+	rec := serve(http.MethodPost, "/users/1/restore")
+	compareStatusCode(rec.Code, http.StatusInternalServerError, t)
+	compareGotWant(rec.Body.String(), model.NotDeleted, t)
+}
+
+func TestPurgeExpired(t *testing.T) {
+	mockEnv, cleanup := makeFileEnv(t)
+	defer cleanup()
+	mockEnv.Retention = time.Hour
+
+	ctx := context.Background()
+	mockEnv.Datastore.Delete(ctx, 2)
+
+	purgeExpired(ctx, &mockEnv, time.Now())
+	if deleted, _ := mockEnv.Datastore.GetDeleted(ctx); len(deleted) != 1 {
+		t.Errorf("user deleted within the retention window should be kept, got %v", deleted)
+	}
+
+	purgeExpired(ctx, &mockEnv, time.Now().Add(2*time.Hour))
+	if deleted, _ := mockEnv.Datastore.GetDeleted(ctx); len(deleted) != 0 {
+		t.Errorf("user deleted before the retention window should be purged, got %v", deleted)
+	}
+}
+
+//slowUsers is a datastore whose reads never finish on their own, used to check that deadlines are honored.
+type slowUsers struct {
+	model.UserDataStore
+}
+
+func (su *slowUsers) GetAll(ctx context.Context) ([]model.User, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGetTimeout(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.Datastore = &slowUsers{mockEnv.Datastore}
+	mockEnv.Timeouts.Get = 10 * time.Millisecond
+
+	req, err := http.NewRequest(http.MethodGet, "/users/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(config.MakeHandler(ProcessRequestByType, &mockEnv))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	compareStatusCode(rec.Code, http.StatusGatewayTimeout, t)
+	compareGotWant(rec.Body.String(), RequestTimedOut, t)
+}
+
 func compareGotWant(got interface{}, want interface{}, t *testing.T) {
-	if got != want {
+	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
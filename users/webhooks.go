@@ -0,0 +1,117 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/nmalensek/go-user-form/authz"
+	"github.com/nmalensek/go-user-form/config"
+	"github.com/nmalensek/go-user-form/validation"
+	"github.com/nmalensek/go-user-form/webhook"
+)
+
+//WebhooksDisabled is returned when the server has no webhook dispatcher.
+const WebhooksDisabled = "Webhooks are not enabled on this server."
+
+var webhookPatt = regexp.MustCompile(`^/webhooks/?([0-9]*)(/deliveries)?$`)
+
+//ProcessWebhookRequest manages webhook endpoints: GET /webhooks lists them and POST registers one, returning its
+//signing secret, GET, PUT and DELETE /webhooks/{id} read, change and remove one, and GET /webhooks/{id}/deliveries
+//lists its pending and recent deliveries. Endpoint URLs and deliveries can reveal internal systems, so every
+//request requires the webhooks action.
+func ProcessWebhookRequest(w http.ResponseWriter, r *http.Request, e *config.Env) {
+	ctx := r.Context()
+	m := webhookPatt.FindStringSubmatch(r.URL.EscapedPath())
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id, _ := strconv.Atoi(m[1])
+	deliveries := m[2] != ""
+
+	if e.Webhooks == nil {
+		handleLogError(ctx, w, errors.New(WebhooksDisabled), e.ErrorLog)
+		return
+	}
+	if err := e.Policy.Authorize(ctx, authz.ActionManageWebhooks, ""); err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+		return
+	}
+
+	var resp interface{}
+	var err error
+	switch {
+	case deliveries && r.Method == http.MethodGet:
+		resp, err = e.Webhooks.Deliveries(id)
+	case deliveries:
+		methodNotAllowed(w, http.MethodGet)
+		return
+	case id == 0 && r.Method == http.MethodGet:
+		resp = e.Webhooks.Endpoints()
+	case id == 0 && r.Method == http.MethodPost:
+		resp, err = processPostWebhook(ctx, r, e)
+	case id == 0:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	case r.Method == http.MethodGet:
+		resp, err = e.Webhooks.Endpoint(id)
+	case r.Method == http.MethodPut:
+		err = processPutWebhook(r, e, id)
+	case r.Method == http.MethodDelete:
+		err = e.Webhooks.RemoveEndpoint(id)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+		return
+	}
+
+	if err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+		return
+	}
+	if resp == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		handleLogError(ctx, w, err, e.ErrorLog)
+		return
+	}
+	writeJSON(w, body)
+}
+
+//processPostWebhook registers an endpoint and returns it with its ID and secret.
+func processPostWebhook(ctx context.Context, r *http.Request, e *config.Env) (*webhook.Endpoint, error) {
+	ep, err := webhookFromBody(r, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.Webhooks.AddEndpoint(ep); err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+//processPutWebhook changes the given fields of an endpoint.
+func processPutWebhook(r *http.Request, e *config.Env, id int) error {
+	ep, err := webhookFromBody(r, true)
+	if err != nil {
+		return err
+	}
+	return e.Webhooks.UpdateEndpoint(*ep, id)
+}
+
+//webhookFromBody decodes and validates an endpoint from the request body.
+func webhookFromBody(r *http.Request, partial bool) (*webhook.Endpoint, error) {
+	ep := webhook.Endpoint{}
+	json.NewDecoder(r.Body).Decode(&ep)
+	ep.ID = 0
+	if errs := ep.Validate(partial); len(errs) > 0 {
+		return nil, validation.UserErrors{Message: InvalidInput, Code: CodeInvalidInput, ErrorList: errs}
+	}
+	return &ep, nil
+}
@@ -0,0 +1,106 @@
+package validation
+
+import (
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nmalensek/go-user-form/schema"
+)
+
+//ValidateAttributes checks custom attribute values against the schema. Complete input (a create) must have every
+//required attribute; partial input (an edit) only has the attributes being changed, where an empty value removes
+//the attribute, so required attributes may not be emptied.
+func ValidateAttributes(s schema.Schema, attrs map[string]string, isComplete bool) []UserError {
+	errs := make([]UserError, 0)
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := s.Find(name); !ok {
+			errs = append(errs, NewError(attributeProp(name), attrs[name], name, Problem{Code: CodeUnknownAttribute}))
+		}
+	}
+
+	for i := range s.Attributes {
+		a := &s.Attributes[i]
+		val, present := attrs[a.Name]
+		if val == "" {
+			if a.Required && (isComplete || present) {
+				errs = append(errs, NewError(attributeProp(a.Name), val, a.Label(), Problem{Code: CodeRequired}))
+			}
+			continue
+		}
+		if p := checkAttributeValue(a, val); !p.OK() {
+			errs = append(errs, NewError(attributeProp(a.Name), val, a.Label(), p))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//checkAttributeValue returns the problem with val if it doesn't satisfy the attribute definition, or the zero Problem if it does.
+func checkAttributeValue(a *schema.Attribute, val string) Problem {
+	switch a.Type {
+	case schema.TypeString:
+		if len(a.Enum) > 0 && !contains(a.Enum, val) {
+			return Problem{Code: CodeNotAllowed}
+		}
+		if re := a.Regexp(); re != nil && !re.MatchString(val) {
+			return Problem{Code: CodeFormat}
+		}
+		if !inRange(float64(utf8.RuneCountInString(val)), a.Min, a.Max) {
+			return lengthProblem(a.Min, a.Max)
+		}
+	case schema.TypeNumber, schema.TypeInteger:
+		var n float64
+		var err error
+		if a.Type == schema.TypeInteger {
+			var i int64
+			i, err = strconv.ParseInt(val, 10, 64)
+			n = float64(i)
+		} else {
+			n, err = strconv.ParseFloat(val, 64)
+		}
+		if err != nil {
+			return Problem{Code: CodeFormat}
+		}
+		if !inRange(n, a.Min, a.Max) {
+			return rangeProblem(a.Min, a.Max)
+		}
+	case schema.TypeBoolean:
+		if _, err := strconv.ParseBool(val); err != nil {
+			return Problem{Code: CodeFormat}
+		}
+	case schema.TypeDate:
+		if _, err := time.Parse(schema.DateLayout, val); err != nil {
+			return Problem{Code: CodeFormat}
+		}
+	}
+	return Problem{}
+}
+
+func inRange(n float64, min, max *float64) bool {
+	return (min == nil || n >= *min) && (max == nil || n <= *max)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func attributeProp(name string) string {
+	return "attributes." + name
+}
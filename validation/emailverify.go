@@ -0,0 +1,187 @@
+package validation
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//Resolver looks up the mail servers of a domain, *net.Resolver satisfies it.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+//DefaultDisposableDomains are well known throwaway email providers, rejected when an EmailVerifier has
+//CheckDisposable set.
+var DefaultDisposableDomains = []string{
+	"10minutemail.com",
+	"discard.email",
+	"dispostable.com",
+	"getnada.com",
+	"guerrillamail.com",
+	"mailinator.com",
+	"maildrop.cc",
+	"sharklasers.com",
+	"temp-mail.org",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+//EmailVerifier checks that an address can plausibly receive mail, beyond its syntax. Domain lists match the
+//domain itself and all of its subdomains. Every check is optional: empty lists and a nil Resolver are skipped.
+type EmailVerifier struct {
+	//Allowed, if not empty, is the only domains addresses may use.
+	Allowed []string
+	Blocked []string
+	//Disposable domains are rejected with their own message so users know to use a permanent address.
+	Disposable []string
+	//Resolver, if set, is used to check that the domain has mail servers.
+	Resolver Resolver
+	//Timeout bounds each MX lookup, lookups that fail for any reason other than the domain not existing
+	//don't reject the address, so a DNS outage doesn't stop users from being saved.
+	Timeout time.Duration
+}
+
+//Verify returns the problem with email, or the zero Problem if the address passed every check.
+func (v *EmailVerifier) Verify(ctx context.Context, email string) Problem {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return Problem{}
+	}
+	domain := strings.TrimSuffix(strings.ToLower(email[at+1:]), ".")
+
+	if len(v.Allowed) > 0 && !domainListed(v.Allowed, domain) {
+		return Problem{Code: CodeBlockedDomain}
+	}
+	if domainListed(v.Blocked, domain) {
+		return Problem{Code: CodeBlockedDomain}
+	}
+	if domainListed(v.Disposable, domain) {
+		return Problem{Code: CodeDisposableDomain}
+	}
+
+	if v.Resolver != nil {
+		if v.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, v.Timeout)
+			defer cancel()
+		}
+		records, err := v.Resolver.LookupMX(ctx, domain)
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			return Problem{Code: CodeNoMailServer}
+		case err != nil:
+			return Problem{}
+		case !acceptsMail(records):
+			return Problem{Code: CodeNoMailServer}
+		}
+	}
+	return Problem{}
+}
+
+//acceptsMail reports whether the records name a mail server. A single "." record is a null MX (RFC 7505),
+//which means the domain doesn't accept mail.
+func acceptsMail(records []*net.MX) bool {
+	for _, mx := range records {
+		if mx.Host != "." && mx.Host != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func domainListed(list []string, domain string) bool {
+	for _, d := range list {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" && (domain == d || strings.HasSuffix(domain, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+//Rule returns a rule that verifies the email address of creates and of updates that change it, looking up mail servers
+//within ctx. The lookups can be slow, so the rule is meant for validating a request before it reaches the datastore,
+//it isn't part of the default rules.
+func (v *EmailVerifier) Rule(ctx context.Context) Rule {
+	return RuleFunc(func(u *model.User, op Operation) []UserError {
+		//syntax errors are reported by the field rules.
+		if u.Email == "" || !EmailPattern.MatchString(u.Email) {
+			return nil
+		}
+		if p := v.Verify(ctx, u.Email); !p.OK() {
+			return []UserError{NewError("Email", u.Email, "Email", p)}
+		}
+		return nil
+	})
+}
+
+//EmailVerifierConfig is the "email" section of a rule file.
+type EmailVerifierConfig struct {
+	AllowedDomains []string `json:"allowedDomains"`
+	BlockedDomains []string `json:"blockedDomains"`
+	//CheckDisposable rejects DefaultDisposableDomains, plus the domains in DisposableList if given.
+	CheckDisposable bool `json:"checkDisposable"`
+	//DisposableList is the path to a file of extra disposable domains, one per line, "#" starts a comment.
+	DisposableList string `json:"disposableList"`
+	CheckMX        bool   `json:"checkMX"`
+	//MXTimeout is a duration such as "2s", it defaults to 3 seconds.
+	MXTimeout string `json:"mxTimeout"`
+}
+
+//Verifier builds the configured verifier, using resolver for MX lookups if they're turned on.
+func (c EmailVerifierConfig) Verifier(resolver Resolver) (*EmailVerifier, error) {
+	v := &EmailVerifier{Allowed: c.AllowedDomains, Blocked: c.BlockedDomains, Timeout: 3 * time.Second}
+
+	if c.CheckDisposable || c.DisposableList != "" {
+		v.Disposable = append(v.Disposable, DefaultDisposableDomains...)
+	}
+	if c.DisposableList != "" {
+		extra, err := readDomainList(c.DisposableList)
+		if err != nil {
+			return nil, err
+		}
+		v.Disposable = append(v.Disposable, extra...)
+	}
+
+	if c.CheckMX {
+		v.Resolver = resolver
+	}
+	if c.MXTimeout != "" {
+		d, err := time.ParseDuration(c.MXTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("validation: mxTimeout: %w", err)
+		}
+		v.Timeout = d
+	}
+	return v, nil
+}
+
+func readDomainList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	domains := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			domains = append(domains, line)
+		}
+	}
+	return domains, scanner.Err()
+}
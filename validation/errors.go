@@ -1,12 +1,20 @@
 package validation
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/nmalensek/go-user-form/i18n"
+)
 
 //UserError contains details about validation errors for a user object.
 type UserError struct {
 	PropName  string `json:"name"`
 	PropValue string `json:"value"`
 	Message   string `json:"msg"`
+	//Code identifies the kind of error and doesn't depend on the language of the message.
+	Code string `json:"code,omitempty"`
+	//Params are the values in the message, e.g. the friendly field name and length limits.
+	Params map[string]string `json:"params,omitempty"`
 }
 
 //Error returns a JSONified version of a UserError.
@@ -18,12 +26,45 @@ func (u *UserError) Error() string {
 	return string(res)
 }
 
+//Localize returns a copy of the error with its message and field name in lang. Errors without a code are left alone.
+func (u UserError) Localize(lang string) UserError {
+	if u.Code == "" {
+		return u
+	}
+	params := make(map[string]string, len(u.Params))
+	for k, v := range u.Params {
+		params[k] = v
+	}
+	if u.PropName != "" {
+		params["field"] = i18n.Default.Field(lang, u.PropName, u.Params["field"])
+	}
+	u.Params = params
+	u.Message = i18n.Default.Message(lang, u.Code, params)
+	return u
+}
+
 //UserErrors contains details about what error occurred and a slice of specific errors.
 type UserErrors struct {
 	Message   string
+	Code      string      `json:"code,omitempty"`
 	ErrorList []UserError `json:"errors"`
 }
 
 func (u UserErrors) Error() string {
 	return u.Message
 }
+
+//Localize returns a copy of the errors with every message in lang.
+func (u UserErrors) Localize(lang string) UserErrors {
+	if u.Code != "" {
+		u.Message = i18n.Default.Message(lang, u.Code, nil)
+	}
+	if u.ErrorList != nil {
+		list := make([]UserError, len(u.ErrorList))
+		for i, e := range u.ErrorList {
+			list[i] = e.Localize(lang)
+		}
+		u.ErrorList = list
+	}
+	return u
+}
@@ -0,0 +1,165 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/nmalensek/go-user-form/i18n"
+)
+
+//Error codes identify each kind of validation error. They're part of the API and don't change between releases
+//or languages, so clients can react to them instead of parsing messages.
+const (
+	CodeRequired            = "required"
+	CodeFormat              = "format"
+	CodeNotAllowed          = "not_allowed"
+	CodeUnknownAttribute    = "unknown_attribute"
+	CodeRangeBetween        = "range_between"
+	CodeRangeMin            = "range_min"
+	CodeRangeMax            = "range_max"
+	CodeLengthBetween       = "length_between"
+	CodeLengthMin           = "length_min"
+	CodeLengthMax           = "length_max"
+	CodeInvalidCharacters   = "invalid_characters"
+	CodeEmailDomain         = "email_domain"
+	CodeBlockedDomain       = "blocked_domain"
+	CodeDisposableDomain    = "disposable_domain"
+	CodeNoMailServer        = "no_mail_server"
+	CodeMissingAllProps     = "missing_all_props"
+	CodeUnknownOrganization = "unknown_organization"
+)
+
+//englishMessages are the templates for the validation codes, other languages are in the i18n package.
+var englishMessages = map[string]string{
+	CodeRequired:            "{field} is required.",
+	CodeFormat:              "{field} is not in the correct format.",
+	CodeNotAllowed:          "{field} is not one of the allowed values.",
+	CodeUnknownAttribute:    "{field} is not a recognized attribute.",
+	CodeRangeBetween:        "{field} must be between {min} and {max}.",
+	CodeRangeMin:            "{field} must be at least {min}.",
+	CodeRangeMax:            "{field} must be at most {max}.",
+	CodeLengthBetween:       "{field} must be between {min} and {max} characters long.",
+	CodeLengthMin:           "{field} must be at least {min} characters long.",
+	CodeLengthMax:           "{field} must be at most {max} characters long.",
+	CodeInvalidCharacters:   "{field} contains characters that aren't allowed.",
+	CodeEmailDomain:         "{field} must be an address at one of {org}'s domains ({domains}).",
+	CodeBlockedDomain:       "{field} uses a domain that isn't allowed.",
+	CodeDisposableDomain:    "{field} can't be a disposable address.",
+	CodeNoMailServer:        "{field}'s domain can't receive email.",
+	CodeMissingAllProps:     MissingAllProps,
+	CodeUnknownOrganization: "{field} is not an existing organization.",
+}
+
+func init() {
+	i18n.Default.Add(i18n.DefaultLanguage, englishMessages)
+}
+
+//Problem is a validation failure before it's attached to a property: its code and the values its message needs,
+//other than the field name. The zero Problem means there's nothing wrong.
+type Problem struct {
+	Code   string
+	Params map[string]string
+}
+
+//OK reports whether p is the zero Problem.
+func (p Problem) OK() bool {
+	return p.Code == ""
+}
+
+//NewError returns the error for a property with the given label (its English friendly name), with the message in English.
+func NewError(prop, value, label string, p Problem) UserError {
+	params := map[string]string{"field": label}
+	for k, v := range p.Params {
+		params[k] = v
+	}
+	return UserError{PropName: prop, PropValue: value, Code: p.Code, Params: params, Message: i18n.Default.Message(i18n.DefaultLanguage, p.Code, params)}
+}
+
+//FormatError returns the standard error for a property that's formatted incorrectly, e.g. a query parameter.
+func FormatError(prop, value string) UserError {
+	return NewError(prop, value, prop, Problem{Code: CodeFormat})
+}
+
+func boundsProblem(between, atLeast, atMost string, min, max *float64) Problem {
+	params := make(map[string]string)
+	if min != nil {
+		params["min"] = fmt.Sprint(*min)
+	}
+	if max != nil {
+		params["max"] = fmt.Sprint(*max)
+	}
+	switch {
+	case min != nil && max != nil:
+		return Problem{Code: between, Params: params}
+	case min != nil:
+		return Problem{Code: atLeast, Params: params}
+	default:
+		return Problem{Code: atMost, Params: params}
+	}
+}
+
+func rangeProblem(min, max *float64) Problem {
+	return boundsProblem(CodeRangeBetween, CodeRangeMin, CodeRangeMax, min, max)
+}
+
+func lengthProblem(min, max *float64) Problem {
+	return boundsProblem(CodeLengthBetween, CodeLengthMin, CodeLengthMax, min, max)
+}
+
+func message(label string, p Problem) string {
+	return NewError("", "", label, p).Message
+}
+
+//IncorrectFormatMessage returns the standard error message for a property that's formatted incorrectly.
+func IncorrectFormatMessage(prop string) string {
+	return message(prop, Problem{Code: CodeFormat})
+}
+
+//RequiredMessage returns the standard error message for a property that is required but is missing.
+func RequiredMessage(prop string) string {
+	return message(prop, Problem{Code: CodeRequired})
+}
+
+//UnknownAttributeMessage returns the standard error message for an attribute that isn't in the schema.
+func UnknownAttributeMessage(name string) string {
+	return message(name, Problem{Code: CodeUnknownAttribute})
+}
+
+//NotAllowedMessage returns the standard error message for a value that isn't one of the allowed choices.
+func NotAllowedMessage(prop string) string {
+	return message(prop, Problem{Code: CodeNotAllowed})
+}
+
+//RangeMessage returns the standard error message for a number outside its allowed range.
+func RangeMessage(prop string, min, max *float64) string {
+	return message(prop, rangeProblem(min, max))
+}
+
+//LengthMessage returns the standard error message for text that is too short or too long.
+func LengthMessage(prop string, min, max *float64) string {
+	return message(prop, lengthProblem(min, max))
+}
+
+//InvalidCharactersMessage returns the standard error message for text containing control or other non-printable characters.
+func InvalidCharactersMessage(prop string) string {
+	return message(prop, Problem{Code: CodeInvalidCharacters})
+}
+
+//DomainMessage returns the standard error message for an email address outside the organization's domains.
+func DomainMessage(prop, org string, domains []string) string {
+	return message(prop, domainProblem(org, domains))
+}
+
+//BlockedDomainMessage returns the standard error message for an address at a domain that isn't allowed.
+func BlockedDomainMessage(prop string) string {
+	return message(prop, Problem{Code: CodeBlockedDomain})
+}
+
+//DisposableDomainMessage returns the standard error message for an address from a throwaway email provider.
+func DisposableDomainMessage(prop string) string {
+	return message(prop, Problem{Code: CodeDisposableDomain})
+}
+
+//NoMailServerMessage returns the standard error message for an address at a domain that doesn't receive email.
+func NoMailServerMessage(prop string) string {
+	return message(prop, Problem{Code: CodeNoMailServer})
+}
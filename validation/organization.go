@@ -0,0 +1,76 @@
+package validation
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/model"
+	"golang.org/x/text/unicode/norm"
+)
+
+//maxOrganizationName matches the length limit on a user's organization, which holds a copy of the name.
+const maxOrganizationName = 100
+
+//ValidateOrganization normalizes the organization's name like a user's organization and checks it.
+func ValidateOrganization(o *model.Organization) []UserError {
+	o.Name = norm.NFC.String(strings.TrimSpace(o.Name))
+	if o.Name == "" {
+		return []UserError{NewError("Name", o.Name, "Name", Problem{Code: CodeRequired})}
+	}
+	if p := printableCheck(o.Name); !p.OK() {
+		return []UserError{NewError("Name", o.Name, "Name", p)}
+	}
+	max := float64(maxOrganizationName)
+	if len([]rune(o.Name)) > maxOrganizationName {
+		return []UserError{NewError("Name", o.Name, "Name", lengthProblem(nil, &max))}
+	}
+	return nil
+}
+
+//OrganizationRule checks that a user's organization, given by ID or by name, is one of the listed organizations.
+//As a normalizer it fills in the OrganizationID and canonical name of a matching organization before the other rules
+//run, so a user may give either one.
+type OrganizationRule struct {
+	Organizations []model.Organization
+}
+
+//Normalize fills in the organization a user refers to.
+func (r *OrganizationRule) Normalize(u *model.User) {
+	if o, ok := r.find(u); ok {
+		u.OrganizationID = o.ID
+		u.Organization = o.Name
+	}
+}
+
+//Check reports an organization that doesn't exist.
+func (r *OrganizationRule) Check(u *model.User, op Operation) []UserError {
+	if u.OrganizationID == 0 && u.Organization == "" {
+		return nil
+	}
+	if _, ok := r.find(u); ok {
+		return nil
+	}
+	if u.OrganizationID != 0 {
+		return []UserError{NewError("OrganizationID", strconv.Itoa(u.OrganizationID), "Organization", Problem{Code: CodeUnknownOrganization})}
+	}
+	return []UserError{NewError("Organization", u.Organization, "Organization", Problem{Code: CodeUnknownOrganization})}
+}
+
+func (r *OrganizationRule) find(u *model.User) (model.Organization, bool) {
+	if u.OrganizationID != 0 {
+		return model.FindOrganization(r.Organizations, u.OrganizationID)
+	}
+	if u.Organization == "" {
+		return model.Organization{}, false
+	}
+	return model.FindOrganizationByName(r.Organizations, u.Organization)
+}
+
+//UnmanagedOrganizationRule is used when organizations aren't managed, so a user's organization is only a name: it
+//reports any organization ID, as there are no organizations for it to refer to.
+var UnmanagedOrganizationRule Rule = RuleFunc(func(u *model.User, op Operation) []UserError {
+	if u.OrganizationID == 0 {
+		return nil
+	}
+	return []UserError{NewError("OrganizationID", strconv.Itoa(u.OrganizationID), "Organization", Problem{Code: CodeUnknownOrganization})}
+})
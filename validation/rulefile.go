@@ -0,0 +1,109 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//RuleFile is the format of a validation rule file. Field rules are added after the built-in struct tag rules,
+//so they can tighten the defaults but not loosen them.
+type RuleFile struct {
+	Fields []FieldRuleConfig `json:"fields"`
+	//EmailDomains lists the email domains allowed for each organization, see EmailDomainRule.
+	EmailDomains map[string][]string `json:"emailDomains"`
+	//Email configures the email verifier, it isn't part of the ruleset, see EmailVerifier.Rule.
+	Email *EmailVerifierConfig `json:"email"`
+}
+
+//FieldRuleConfig holds the rules for one field, e.g. {"field": "firstName", "rules": ["maxlen=50"], "on": "create"}.
+type FieldRuleConfig struct {
+	//Field is the user field's JSON or Go name.
+	Field string   `json:"field"`
+	Label string   `json:"label"`
+	Rules []string `json:"rules"`
+	//On is "create", "update" or empty for both.
+	On string `json:"on"`
+}
+
+//ReadRuleFile reads and parses a rule file.
+func ReadRuleFile(path string) (RuleFile, error) {
+	var f RuleFile
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("ReadRuleFile: %w", err)
+	}
+	return f, nil
+}
+
+//LoadRules reads a rule file and returns the default rules extended with the file's rules.
+func LoadRules(path string) (*Ruleset, error) {
+	f, err := ReadRuleFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Ruleset()
+}
+
+//Ruleset builds the default rules extended with the file's rules.
+func (f RuleFile) Ruleset() (*Ruleset, error) {
+	s := DefaultRules()
+	for _, c := range f.Fields {
+		ops, err := parseOperation(c.On)
+		if err != nil {
+			return nil, err
+		}
+		name, label, ok := lookupField(c.Field)
+		if !ok {
+			return nil, fmt.Errorf("validation: unknown field %q", c.Field)
+		}
+		if c.Label != "" {
+			label = c.Label
+		}
+		r, err := NewFieldRule(name, label, c.Rules)
+		if err != nil {
+			return nil, err
+		}
+		s.Add(ops, r)
+	}
+	if len(f.EmailDomains) > 0 {
+		s.Add(AnyOperation, EmailDomainRule(f.EmailDomains))
+	}
+	return s, nil
+}
+
+func parseOperation(on string) (Operation, error) {
+	switch strings.ToLower(on) {
+	case "":
+		return AnyOperation, nil
+	case "create":
+		return Create, nil
+	case "update":
+		return Update, nil
+	}
+	return 0, fmt.Errorf("validation: unknown operation %q, use create, update or leave it empty", on)
+}
+
+//lookupField finds a user field by its Go or JSON name and returns the Go name and the label from its struct tag.
+func lookupField(name string) (string, string, bool) {
+	t := reflect.TypeOf(model.User{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Name == name || jsonName == name {
+			label := f.Tag.Get("label")
+			if label == "" {
+				label = f.Name
+			}
+			return f.Name, label, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,413 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nmalensek/go-user-form/i18n"
+	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/schema"
+	"golang.org/x/text/unicode/norm"
+)
+
+//Operation is the kind of change being validated, rules are added for one or both operations.
+type Operation int
+
+//Operations that rules can apply to.
+const (
+	//Create validates a complete user, so required fields must be filled out.
+	Create Operation = 1 << iota
+	//Update validates a partial user where empty fields are left unchanged, so only the given values are checked.
+	Update
+	//AnyOperation applies a rule to both creates and updates.
+	AnyOperation = Create | Update
+)
+
+//Rule checks a user for the given operation and returns the problems it finds.
+type Rule interface {
+	Check(u *model.User, op Operation) []UserError
+}
+
+//RuleFunc adapts an ordinary function to a Rule, e.g. for a custom cross-field check.
+type RuleFunc func(u *model.User, op Operation) []UserError
+
+//Check calls f(u, op).
+func (f RuleFunc) Check(u *model.User, op Operation) []UserError {
+	return f(u, op)
+}
+
+//Check validates a single non-empty field value. It returns the problem with it, or the zero Problem if the value is ok.
+//Custom checks should add English messages for their codes to i18n.Default.
+type Check func(value string) Problem
+
+//Normalizer rewrites a field value into its canonical form before it's checked, e.g. trimming whitespace.
+type Normalizer func(value string) string
+
+//CheckFactory builds a Check from the argument of a rule definition, the text after "=" (e.g. "50" in "maxlen=50").
+type CheckFactory func(arg string) (Check, error)
+
+var (
+	registryMu sync.RWMutex
+	checks     = map[string]CheckFactory{
+		"minlen":  lengthCheck(true),
+		"maxlen":  lengthCheck(false),
+		"pattern": patternCheck,
+		"enum":    enumCheck,
+		"printable": func(string) (Check, error) {
+			return printableCheck, nil
+		},
+	}
+	normalizers = map[string]Normalizer{
+		"trim": strings.TrimSpace,
+		//nfc composes characters so e.g. "é" typed as "e" plus a combining accent is stored the same as "é".
+		"nfc": norm.NFC.String,
+	}
+	patterns = map[string]*regexp.Regexp{
+		"email": EmailPattern,
+	}
+)
+
+//requiredRule is handled by FieldRule itself because it's the only rule that looks at empty values.
+const requiredRule = "required"
+
+//RegisterCheck makes a custom check available to struct tags and rule files under the given name.
+//Registering an existing name replaces it.
+func RegisterCheck(name string, f CheckFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	checks[name] = f
+}
+
+//RegisterNormalizer makes a custom normalizer available to struct tags and rule files under the given name.
+func RegisterNormalizer(name string, n Normalizer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	normalizers[name] = n
+}
+
+//RegisterPattern names a regular expression so "pattern=<name>" can refer to it instead of spelling it out.
+func RegisterPattern(name string, re *regexp.Regexp) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	patterns[name] = re
+}
+
+func lengthCheck(isMin bool) CheckFactory {
+	return func(arg string) (Check, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("length must be a non-negative integer, got %q", arg)
+		}
+		bound := float64(n)
+		return func(value string) Problem {
+			count := utf8.RuneCountInString(value)
+			if isMin && count < n {
+				return lengthProblem(&bound, nil)
+			}
+			if !isMin && count > n {
+				return lengthProblem(nil, &bound)
+			}
+			return Problem{}
+		}, nil
+	}
+}
+
+//patternCheck accepts the name of a registered pattern or a regular expression.
+func patternCheck(arg string) (Check, error) {
+	registryMu.RLock()
+	re, ok := patterns[arg]
+	registryMu.RUnlock()
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(arg); err != nil {
+			return nil, err
+		}
+	}
+	return func(value string) Problem {
+		if !re.MatchString(value) {
+			return Problem{Code: CodeFormat}
+		}
+		return Problem{}
+	}, nil
+}
+
+//printableCheck rejects control characters (including newlines and tabs) and other non-printable runes
+//such as invalid UTF-8, which don't belong in names and addresses.
+func printableCheck(value string) Problem {
+	for _, r := range value {
+		if r == utf8.RuneError || !unicode.IsPrint(r) && r != ' ' {
+			return Problem{Code: CodeInvalidCharacters}
+		}
+	}
+	return Problem{}
+}
+
+//enumCheck accepts the allowed values separated by "|".
+func enumCheck(arg string) (Check, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("enum needs at least one value")
+	}
+	allowed := strings.Split(arg, "|")
+	return func(value string) Problem {
+		if !contains(allowed, value) {
+			return Problem{Code: CodeNotAllowed}
+		}
+		return Problem{}
+	}, nil
+}
+
+//FieldRule validates one string field of a user. The value is normalized first, then required fields must be
+//filled out on create, other checks only run against non-empty values and the first failing check is reported.
+type FieldRule struct {
+	//Field is the name of the model.User field, it's also used as the PropName of the errors.
+	Field       string
+	Label       string
+	Required    bool
+	Normalizers []Normalizer
+	Checks      []Check
+}
+
+//NewFieldRule builds a rule for field from definitions like "required", "trim", "maxlen=50" or "pattern=email".
+func NewFieldRule(field, label string, defs []string) (*FieldRule, error) {
+	f, ok := reflect.TypeOf(model.User{}).FieldByName(field)
+	if !ok || f.Type.Kind() != reflect.String {
+		return nil, fmt.Errorf("validation: %v is not a text field of a user", field)
+	}
+	if label == "" {
+		label = field
+	}
+
+	r := &FieldRule{Field: field, Label: label}
+	for _, def := range defs {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		if def == requiredRule {
+			r.Required = true
+			continue
+		}
+
+		name, arg := def, ""
+		if i := strings.Index(def, "="); i >= 0 {
+			name, arg = def[:i], def[i+1:]
+		}
+		registryMu.RLock()
+		normalizer, isNormalizer := normalizers[name]
+		factory, ok := checks[name]
+		registryMu.RUnlock()
+		if isNormalizer && arg == "" {
+			r.Normalizers = append(r.Normalizers, normalizer)
+			continue
+		}
+		if !ok {
+			return nil, fmt.Errorf("validation: unknown rule %q on %v", name, field)
+		}
+		c, err := factory(arg)
+		if err != nil {
+			return nil, fmt.Errorf("validation: rule %q on %v: %w", def, field, err)
+		}
+		r.Checks = append(r.Checks, c)
+	}
+	return r, nil
+}
+
+//Normalize rewrites the field's value in u with the rule's normalizers.
+func (r *FieldRule) Normalize(u *model.User) {
+	if len(r.Normalizers) == 0 {
+		return
+	}
+	f := reflect.ValueOf(u).Elem().FieldByName(r.Field)
+	val := f.String()
+	for _, n := range r.Normalizers {
+		val = n(val)
+	}
+	f.SetString(val)
+}
+
+//Check validates the field's normalized value in u.
+func (r *FieldRule) Check(u *model.User, op Operation) []UserError {
+	r.Normalize(u)
+	val := reflect.ValueOf(u).Elem().FieldByName(r.Field).String()
+	if val == "" {
+		if r.Required && op == Create {
+			return []UserError{NewError(r.Field, val, r.Label, Problem{Code: CodeRequired})}
+		}
+		return nil
+	}
+	for _, c := range r.Checks {
+		if p := c(val); !p.OK() {
+			return []UserError{NewError(r.Field, val, r.Label, p)}
+		}
+	}
+	return nil
+}
+
+//TagRules builds field rules from the `validate` and `label` struct tags of model.User, in field order.
+func TagRules() ([]*FieldRule, error) {
+	t := reflect.TypeOf(model.User{})
+	rules := make([]*FieldRule, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+		r, err := NewFieldRule(f.Name, f.Tag.Get("label"), strings.Split(tag, ","))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+//RequireAny reports MissingAllProps if none of the fields (or custom attributes, or the manager) are filled out.
+func RequireAny(fields []*FieldRule) Rule {
+	return RuleFunc(func(u *model.User, op Operation) []UserError {
+		if len(u.Attributes) > 0 || u.ManagerID != 0 {
+			return nil
+		}
+		v := reflect.ValueOf(u).Elem()
+		for _, f := range fields {
+			if v.FieldByName(f.Field).String() != "" {
+				return nil
+			}
+		}
+		return []UserError{{PropName: "", PropValue: "", Code: CodeMissingAllProps, Message: MissingAllProps}}
+	})
+}
+
+//EmailDomainRule requires the email address of users in the listed organizations to be at one of the
+//organization's domains. Organization names are matched case-insensitively. Updates are only checked
+//when they change both the email and the organization.
+func EmailDomainRule(domains map[string][]string) Rule {
+	byOrg := make(map[string][]string, len(domains))
+	for org, d := range domains {
+		byOrg[strings.ToLower(org)] = d
+	}
+
+	return RuleFunc(func(u *model.User, op Operation) []UserError {
+		if u.Email == "" || u.Organization == "" {
+			return nil
+		}
+		allowed, ok := byOrg[strings.ToLower(u.Organization)]
+		if !ok {
+			return nil
+		}
+		at := strings.LastIndex(u.Email, "@")
+		if at < 0 {
+			//malformed addresses are reported by the email format rule.
+			return nil
+		}
+		domain := u.Email[at+1:]
+		for _, d := range allowed {
+			if strings.EqualFold(d, domain) {
+				return nil
+			}
+		}
+		return []UserError{NewError("Email", u.Email, "Email", domainProblem(u.Organization, allowed))}
+	})
+}
+
+func domainProblem(org string, domains []string) Problem {
+	return Problem{Code: CodeEmailDomain, Params: map[string]string{"org": org, "domains": strings.Join(domains, ", ")}}
+}
+
+//AttributeRule checks custom attributes against the schema, see ValidateAttributes.
+func AttributeRule(s schema.Schema) Rule {
+	return RuleFunc(func(u *model.User, op Operation) []UserError {
+		return ValidateAttributes(s, u.Attributes, op == Create)
+	})
+}
+
+//Ruleset is an ordered list of rules, each applying to creates, updates or both.
+type Ruleset struct {
+	rules []boundRule
+}
+
+type boundRule struct {
+	ops  Operation
+	rule Rule
+	halt bool
+}
+
+//Add appends a rule for the given operations and returns the ruleset so calls can be chained.
+func (s *Ruleset) Add(ops Operation, r Rule) *Ruleset {
+	s.rules = append(s.rules, boundRule{ops: ops, rule: r})
+	return s
+}
+
+//AddHalting appends a rule that stops validation when it fails, for preconditions the other rules rely on.
+func (s *Ruleset) AddHalting(ops Operation, r Rule) *Ruleset {
+	s.rules = append(s.rules, boundRule{ops: ops, rule: r, halt: true})
+	return s
+}
+
+//With returns a copy of the ruleset with r added, leaving the original untouched.
+func (s *Ruleset) With(ops Operation, r Rule) *Ruleset {
+	c := &Ruleset{rules: make([]boundRule, len(s.rules), len(s.rules)+1)}
+	copy(c.rules, s.rules)
+	return c.Add(ops, r)
+}
+
+//normalizingRule is implemented by rules that rewrite values into a canonical form.
+type normalizingRule interface {
+	Normalize(u *model.User)
+}
+
+//Validate runs the rules for op against a copy of u and returns all of the errors, or nil if there aren't any.
+func (s *Ruleset) Validate(u model.User, op Operation) []UserError {
+	return s.Apply(&u, op)
+}
+
+//Apply normalizes u in place, then runs the rules for op against it and returns all of the errors, or nil if
+//there aren't any. Normalizing everything first means cross-field rules and checks see the values that get saved.
+func (s *Ruleset) Apply(u *model.User, op Operation) []UserError {
+	for _, b := range s.rules {
+		if n, ok := b.rule.(normalizingRule); ok && b.ops&op != 0 {
+			n.Normalize(u)
+		}
+	}
+
+	var errs []UserError
+	for _, b := range s.rules {
+		if b.ops&op == 0 {
+			continue
+		}
+		found := b.rule.Check(u, op)
+		errs = append(errs, found...)
+		if b.halt && len(found) > 0 {
+			break
+		}
+	}
+	return errs
+}
+
+var defaultFields []*FieldRule
+
+func init() {
+	var err error
+	if defaultFields, err = TagRules(); err != nil {
+		panic(err)
+	}
+	for _, f := range defaultFields {
+		i18n.Default.AddField(i18n.DefaultLanguage, f.Field, f.Label)
+	}
+}
+
+//DefaultRules returns the built-in rules: the struct tag rules of model.User, plus an update must change something.
+func DefaultRules() *Ruleset {
+	s := &Ruleset{}
+	s.AddHalting(Update, RequireAny(defaultFields))
+	for _, f := range defaultFields {
+		s.Add(AnyOperation, f)
+	}
+	return s
+}
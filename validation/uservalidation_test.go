@@ -1,9 +1,15 @@
 package validation
 
 import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nmalensek/go-user-form/model"
+	"github.com/nmalensek/go-user-form/schema"
 )
 
 func TestValidEmails(t *testing.T) {
@@ -103,3 +109,409 @@ func checkType(e error) string {
 		return string(e.Error())
 	}
 }
+
+func TestValidateAttributes(t *testing.T) {
+	one, ten := 1.0, 10.0
+	s := schema.Schema{Attributes: []schema.Attribute{
+		{Name: "title", Type: schema.TypeString, Required: true, Max: &ten},
+		{Name: "level", Type: schema.TypeInteger, Min: &one, Max: &ten},
+		{Name: "team", Type: schema.TypeString, Enum: []string{"red", "blue"}},
+		{Name: "startDate", FriendlyName: "Start Date", Type: schema.TypeDate},
+		{Name: "remote", Type: schema.TypeBoolean},
+	}}
+
+	cases := []struct {
+		name       string
+		attrs      map[string]string
+		isComplete bool
+		wantProps  []string
+	}{
+		{"valid", map[string]string{"title": "Engineer", "level": "3", "team": "red", "startDate": "2020-02-01", "remote": "true"}, true, nil},
+		{"missing required", map[string]string{"level": "3"}, true, []string{"attributes.title"}},
+		{"partial without required", map[string]string{"level": "3"}, false, nil},
+		{"partial clears required", map[string]string{"title": ""}, false, []string{"attributes.title"}},
+		{"too long", map[string]string{"title": "Senior Principal Engineer"}, true, []string{"attributes.title"}},
+		{"out of range", map[string]string{"title": "x", "level": "11"}, true, []string{"attributes.level"}},
+		{"not an integer", map[string]string{"title": "x", "level": "2.5"}, true, []string{"attributes.level"}},
+		{"not in enum", map[string]string{"title": "x", "team": "green"}, true, []string{"attributes.team"}},
+		{"bad date", map[string]string{"title": "x", "startDate": "02/01/2020"}, true, []string{"attributes.startDate"}},
+		{"bad boolean", map[string]string{"title": "x", "remote": "sometimes"}, true, []string{"attributes.remote"}},
+		{"unknown", map[string]string{"title": "x", "shoeSize": "9"}, true, []string{"attributes.shoeSize"}},
+	}
+
+	for _, c := range cases {
+		errs := ValidateAttributes(s, c.attrs, c.isComplete)
+		if len(errs) != len(c.wantProps) {
+			t.Errorf("%v: got errors %v, want errors for %v", c.name, errs, c.wantProps)
+			continue
+		}
+		for i, e := range errs {
+			if e.PropName != c.wantProps[i] {
+				t.Errorf("%v: got error for %v, want %v", c.name, e.PropName, c.wantProps[i])
+			}
+		}
+	}
+}
+
+func TestPartialInputChecksEmail(t *testing.T) {
+	errs := ValidatePartialInput(model.User{Email: "not-an-email"})
+	if len(errs) != 1 || errs[0].PropName != "Email" || errs[0].Message != IncorrectFormatMessage("Email") {
+		t.Errorf("Got %v, wanted an Email format error", errs)
+	}
+
+	errs = ValidatePartialInput(model.User{})
+	if len(errs) != 1 || errs[0].Message != MissingAllProps {
+		t.Errorf("Got %v, wanted %v", errs, MissingAllProps)
+	}
+}
+
+func TestFieldRules(t *testing.T) {
+	valid := model.User{FirstName: "Ann", LastName: "Lee", Email: "ann@example.com", Organization: "Sales"}
+	long := strings.Repeat("a", 101)
+
+	cases := []struct {
+		field   string
+		value   string
+		wantMsg string
+	}{
+		{"FirstName", "", RequiredMessage("First Name")},
+		{"FirstName", "   ", RequiredMessage("First Name")},
+		{"FirstName", long, LengthMessage("First Name", nil, float(100))},
+		{"FirstName", "Ann\x00", InvalidCharactersMessage("First Name")},
+		{"FirstName", "Ann\nMarie", InvalidCharactersMessage("First Name")},
+		{"FirstName", "\xff", InvalidCharactersMessage("First Name")},
+		{"FirstName", "Zoë", ""},
+		{"LastName", "", RequiredMessage("Last Name")},
+		{"LastName", long, LengthMessage("Last Name", nil, float(100))},
+		{"LastName", "Lee\t", ""},
+		{"LastName", "Le\te", InvalidCharactersMessage("Last Name")},
+		{"LastName", "O'Brien-Smith", ""},
+		{"Email", "", RequiredMessage("Email")},
+		{"Email", "not-an-email", IncorrectFormatMessage("Email")},
+		{"Email", "a@b.", IncorrectFormatMessage("Email")},
+		{"Email", strings.Repeat("a", 250) + "@b.co", LengthMessage("Email", nil, float(254))},
+		{"Email", " ann@example.com ", ""},
+		{"Organization", "", RequiredMessage("Organization")},
+		{"Organization", long, LengthMessage("Organization", nil, float(100))},
+		{"Organization", "Sales\u0007", InvalidCharactersMessage("Organization")},
+		{"Organization", "Research & Development", ""},
+	}
+
+	for _, c := range cases {
+		for _, op := range []Operation{Create, Update} {
+			var u model.User
+			if op == Create {
+				u = valid
+			}
+			setField(&u, c.field, c.value)
+			if op == Update && strings.TrimSpace(c.value) == "" {
+				//an empty value in an update leaves the field unchanged, so there's nothing else to check.
+				u.LastName = "Lee"
+			}
+
+			want := c.wantMsg
+			if op == Update && want == RequiredMessage(fieldLabel(c.field)) {
+				want = ""
+			}
+
+			errs := DefaultRules().Validate(u, op)
+			var got string
+			for _, e := range errs {
+				if e.PropName == c.field {
+					got = e.Message
+				} else {
+					t.Errorf("%v %q (op %v): unexpected error %v", c.field, c.value, op, e)
+				}
+			}
+			if got != want {
+				t.Errorf("%v %q (op %v): got %q, want %q", c.field, c.value, op, got, want)
+			}
+		}
+	}
+}
+
+func TestApplyNormalizes(t *testing.T) {
+	u := model.User{FirstName: " Jose\u0301 ", LastName: "Lee", Email: " ann@example.com\t", Organization: "Sales "}
+
+	if errs := DefaultRules().Apply(&u, Create); len(errs) > 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	want := model.User{FirstName: "Jos\u00e9", LastName: "Lee", Email: "ann@example.com", Organization: "Sales"}
+	if u.FirstName != want.FirstName || u.Email != want.Email || u.Organization != want.Organization {
+		t.Errorf("Got %+v, want %+v", u, want)
+	}
+}
+
+func setField(u *model.User, field, value string) {
+	switch field {
+	case "FirstName":
+		u.FirstName = value
+	case "LastName":
+		u.LastName = value
+	case "Email":
+		u.Email = value
+	case "Organization":
+		u.Organization = value
+	}
+}
+
+func fieldLabel(field string) string {
+	_, label, _ := lookupField(field)
+	return label
+}
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestRuleFile(t *testing.T) {
+	RegisterCheck("nodigits", func(arg string) (Check, error) {
+		return func(value string) Problem {
+			if strings.ContainsAny(value, "0123456789") {
+				return Problem{Code: CodeFormat}
+			}
+			return Problem{}
+		}, nil
+	})
+
+	rules, err := RuleFile{
+		Fields: []FieldRuleConfig{
+			{Field: "firstName", Rules: []string{"maxlen=5", "nodigits"}},
+			{Field: "Organization", Rules: []string{"enum=Sales|Support"}, On: "create"},
+		},
+		EmailDomains: map[string][]string{"sales": {"example.com"}},
+	}.Ruleset()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		u         model.User
+		op        Operation
+		wantProps []string
+	}{
+		{"valid", model.User{FirstName: "Ann", LastName: "L", Email: "ann@example.com", Organization: "Sales"}, Create, nil},
+		{"too long", model.User{FirstName: "Annabelle", LastName: "L", Email: "ann@example.com", Organization: "Sales"}, Create, []string{"FirstName"}},
+		{"custom check", model.User{FirstName: "Ann2", LastName: "L", Email: "ann@example.com", Organization: "Sales"}, Create, []string{"FirstName"}},
+		{"not in enum", model.User{FirstName: "Ann", LastName: "L", Email: "ann@example.com", Organization: "Legal"}, Create, []string{"Organization"}},
+		{"enum is create only", model.User{Organization: "Legal"}, Update, nil},
+		{"wrong domain", model.User{FirstName: "Ann", LastName: "L", Email: "ann@Elsewhere.com", Organization: "Sales"}, Create, []string{"Email"}},
+		{"partial domain change", model.User{Email: "ann@elsewhere.com", Organization: "Sales"}, Update, []string{"Email"}},
+		{"partial without organization", model.User{Email: "ann@elsewhere.com"}, Update, nil},
+		{"update length", model.User{FirstName: "Annabelle"}, Update, []string{"FirstName"}},
+	}
+
+	for _, c := range cases {
+		errs := rules.Validate(c.u, c.op)
+		if len(errs) != len(c.wantProps) {
+			t.Errorf("%v: got errors %v, want errors for %v", c.name, errs, c.wantProps)
+			continue
+		}
+		for i, e := range errs {
+			if e.PropName != c.wantProps[i] {
+				t.Errorf("%v: got error for %v, want %v", c.name, e.PropName, c.wantProps[i])
+			}
+		}
+	}
+}
+
+func TestBadRuleDefinitions(t *testing.T) {
+	bad := []FieldRuleConfig{
+		{Field: "shoeSize", Rules: []string{"required"}},
+		{Field: "firstName", Rules: []string{"nosuchrule"}},
+		{Field: "firstName", Rules: []string{"maxlen=lots"}},
+		{Field: "firstName", Rules: []string{"pattern=("}},
+		{Field: "firstName", Rules: []string{"required"}, On: "sometimes"},
+		{Field: "ID", Rules: []string{"required"}},
+	}
+	for _, c := range bad {
+		if _, err := (RuleFile{Fields: []FieldRuleConfig{c}}).Ruleset(); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
+
+//fakeDNS answers MX queries over UDP from a fixed table. Domains that aren't in the table don't exist,
+//and "broken.test" always fails with SERVFAIL.
+type fakeDNS struct {
+	conn net.PacketConn
+	mx   map[string][]string
+}
+
+func startFakeDNS(t *testing.T, mx map[string][]string) *fakeDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeDNS{conn: conn, mx: mx}
+	go s.serve()
+	return s
+}
+
+func (s *fakeDNS) resolver() *net.Resolver {
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+	}}
+}
+
+func (s *fakeDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(buf[:n]); resp != nil {
+			s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *fakeDNS) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	//the question is the name's labels followed by the type and class.
+	end := 12
+	labels := make([]string, 0)
+	for end < len(query) && query[end] != 0 {
+		l := int(query[end])
+		if end+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[end+1:end+1+l]))
+		end += 1 + l
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	hosts, found := s.mx[name]
+	rcode := uint16(0)
+	switch {
+	case name == "broken.test":
+		rcode = 2
+	case !found:
+		rcode = 3
+	}
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	binary.BigEndian.PutUint16(resp[2:], 0x8180|rcode)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(hosts)))
+	resp = append(resp, query[12:end]...)
+	for i, h := range hosts {
+		rdata := make([]byte, 2)
+		binary.BigEndian.PutUint16(rdata, uint16(10*(i+1)))
+		rdata = append(rdata, encodeName(h)...)
+
+		rr := []byte{0xc0, 12, 0, 15, 0, 1, 0, 0, 1, 0, 0, 0}
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+		resp = append(resp, rr...)
+		resp = append(resp, rdata...)
+	}
+	return resp
+}
+
+func encodeName(name string) []byte {
+	b := make([]byte, 0)
+	for _, l := range strings.Split(strings.Trim(name, "."), ".") {
+		if l == "" {
+			continue
+		}
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+//resolverFunc is a Resolver backed by a function.
+type resolverFunc func(ctx context.Context, name string) ([]*net.MX, error)
+
+func (f resolverFunc) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return f(ctx, name)
+}
+
+func TestEmailVerifier(t *testing.T) {
+	dns := startFakeDNS(t, map[string][]string{
+		"good.test":       {"mail.good.test."},
+		"sub.good.test":   {"mail.good.test."},
+		"nomx.test":       {},
+		"null.test":       {"."},
+		"mailinator.com":  {"mail.mailinator.com."},
+		"blocked.test":    {"mail.blocked.test."},
+		"partner.example": {"mx.partner.example."},
+	})
+	defer dns.conn.Close()
+
+	v := &EmailVerifier{
+		Blocked:    []string{"blocked.test"},
+		Disposable: DefaultDisposableDomains,
+		Resolver:   dns.resolver(),
+		Timeout:    2 * time.Second,
+	}
+
+	cases := []struct {
+		email string
+		want  string
+	}{
+		{"a@good.test", ""},
+		{"a@Sub.Good.Test", ""},
+		{"a@blocked.test", CodeBlockedDomain},
+		{"a@mail.blocked.test", CodeBlockedDomain},
+		{"a@mailinator.com", CodeDisposableDomain},
+		{"a@missing.test", CodeNoMailServer},
+		{"a@nomx.test", CodeNoMailServer},
+		{"a@null.test", CodeNoMailServer},
+		{"a@broken.test", ""},
+	}
+	for _, c := range cases {
+		if got := v.Verify(context.Background(), c.email).Code; got != c.want {
+			t.Errorf("%v: got %q, want %q", c.email, got, c.want)
+		}
+	}
+
+	allowOnly := &EmailVerifier{Allowed: []string{"partner.example"}}
+	if got := allowOnly.Verify(context.Background(), "a@good.test"); got.Code != CodeBlockedDomain {
+		t.Errorf("domains outside the allowed list should be blocked, got %q", got)
+	}
+	if got := allowOnly.Verify(context.Background(), "a@partner.example"); !got.OK() {
+		t.Errorf("allowed domain should pass, got %q", got)
+	}
+
+	rules := DefaultRules().With(AnyOperation, v.Rule(context.Background()))
+	errs := rules.Validate(model.User{FirstName: "a", LastName: "b", Email: "a@mailinator.com", Organization: "Sales"}, Create)
+	if len(errs) != 1 || errs[0].PropName != "Email" || errs[0].Message != DisposableDomainMessage("Email") {
+		t.Errorf("complete input should be verified, got %v", errs)
+	}
+	errs = rules.Validate(model.User{Email: "a@missing.test"}, Update)
+	if len(errs) != 1 || errs[0].Message != NoMailServerMessage("Email") {
+		t.Errorf("changed addresses should be verified, got %v", errs)
+	}
+	errs = rules.Validate(model.User{FirstName: "a", LastName: "b", Email: "not-an-email", Organization: "Sales"}, Create)
+	if len(errs) != 1 || errs[0].Message != IncorrectFormatMessage("Email") {
+		t.Errorf("malformed addresses should only get the format error, got %v", errs)
+	}
+	//the default rules, which the datastores check, never look addresses up.
+	if errs := ValidateCompleteInput(model.User{FirstName: "a", LastName: "b", Email: "a@missing.test", Organization: "Sales"}); len(errs) != 0 {
+		t.Errorf("got %v want no errors from the default rules", errs)
+	}
+
+	//lookups use the context of the validation, so they end with the request.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var seen context.Context
+	ctxCheck := &EmailVerifier{Resolver: resolverFunc(func(ctx context.Context, name string) ([]*net.MX, error) {
+		seen = ctx
+		return nil, ctx.Err()
+	})}
+	ctxCheck.Rule(cancelled).Check(&model.User{Email: "a@good.test"}, Create)
+	if seen == nil || seen.Err() == nil {
+		t.Error("the lookup should use the context given to Rule")
+	}
+}
@@ -0,0 +1,536 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/events"
+)
+
+//Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+//Files kept in the dispatcher's directory.
+const (
+	endpointsFile = "endpoints.json"
+	queueFile     = "queue.json"
+)
+
+//recentLimit is the number of finished deliveries kept for the deliveries API.
+const recentLimit = 100
+
+//Delivery is an event on its way to an endpoint.
+type Delivery struct {
+	ID          string       `json:"id"`
+	EndpointID  int          `json:"endpointId"`
+	Event       events.Event `json:"event"`
+	Status      string       `json:"status"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"nextAttempt,omitempty"`
+	//LastError describes why the last attempt failed, e.g. the response status or a network error.
+	LastError string    `json:"lastError,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+//Dispatcher is an events.Publisher that queues an event for each endpoint subscribed to it, and Run delivers them.
+//Each endpoint is delivered to by its own worker, so a slow or failing endpoint doesn't hold up the others.
+//Endpoints and the queue are saved as JSON files in Dir after every change.
+type Dispatcher struct {
+	Dir string
+	//Keys, if set, seals the files in Dir: the endpoints' secrets and the queued events' user details.
+	Keys   *encryption.Keyring
+	Client *http.Client
+	//MaxAttempts is how many times a delivery is tried before it's marked as failed.
+	MaxAttempts int
+	//BaseDelay is the wait before the first retry, it doubles with every attempt up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	ErrorLog  *log.Logger
+
+	now func() time.Time
+	//wake tells Run the endpoints have changed.
+	wake chan struct{}
+
+	mu        sync.Mutex
+	endpoints []Endpoint
+	pending   []Delivery
+	recent    []Delivery
+	//workers holds the wake channel of each endpoint's worker while Run is running.
+	workers map[int]chan struct{}
+}
+
+type endpointState struct {
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+type queueState struct {
+	Pending []Delivery `json:"pending"`
+	Recent  []Delivery `json:"recent"`
+}
+
+//Open returns a dispatcher keeping its state in dir, creating dir if needed, with the endpoints and queued
+//deliveries saved there by a previous run. The directory holds the endpoints' secrets and users' details, so only the
+//owner may use it. If keys are given the files are sealed with them, files that aren't sealed yet or are sealed with an
+//older key are sealed again with the primary key.
+func Open(dir string, keys *encryption.Keyring, errorLog *log.Logger) (*Dispatcher, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	//MkdirAll leaves the permissions of an existing directory as they are.
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+	d := &Dispatcher{
+		Dir:         dir,
+		Keys:        keys,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 8,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Hour,
+		ErrorLog:    errorLog,
+		now:         time.Now,
+		wake:        make(chan struct{}, 1),
+	}
+
+	var es endpointState
+	if err := d.readState(filepath.Join(dir, endpointsFile), &es); err != nil {
+		return nil, err
+	}
+	var qs queueState
+	if err := d.readState(filepath.Join(dir, queueFile), &qs); err != nil {
+		return nil, err
+	}
+	d.endpoints, d.pending, d.recent = es.Endpoints, qs.Pending, qs.Recent
+	for _, p := range append([]Delivery(nil), d.pending...) {
+		if _, ok := d.find(p.EndpointID); !ok {
+			//no worker will pick it up, the endpoint was removed without its queue being saved.
+			d.finish(p.ID, func(p *Delivery) { p.Status, p.LastError = StatusFailed, EndpointNotFound })
+		}
+	}
+	if keys != nil {
+		if err := d.saveEndpoints(); err != nil {
+			return nil, err
+		}
+		if err := d.saveQueue(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+//Publish queues e for every endpoint subscribed to its type.
+func (d *Dispatcher) Publish(ctx context.Context, e events.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	queued := false
+	now := d.now().UTC()
+	for _, ep := range d.endpoints {
+		if ep.Wants(e.Type) {
+			d.pending = append(d.pending, Delivery{ID: events.NewID(), EndpointID: ep.ID, Event: e, Status: StatusPending, NextAttempt: now, UpdatedAt: now})
+			signal(d.workers[ep.ID])
+			queued = true
+		}
+	}
+	if !queued {
+		return
+	}
+	if err := d.saveQueue(); err != nil {
+		d.logf("webhook: could not save the delivery queue for event %v: %v", e.ID, err)
+	}
+}
+
+//Run delivers queued events until ctx is cancelled, starting a worker for each endpoint as it's added and stopping
+//it when it's removed.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	stops := make(map[int]context.CancelFunc)
+	defer func() {
+		d.mu.Lock()
+		d.workers = nil
+		d.mu.Unlock()
+		for _, stop := range stops {
+			stop()
+		}
+		wg.Wait()
+	}()
+
+	for {
+		d.mu.Lock()
+		if d.workers == nil {
+			d.workers = make(map[int]chan struct{})
+		}
+		running := make(map[int]bool)
+		for _, ep := range d.endpoints {
+			running[ep.ID] = true
+			if _, ok := stops[ep.ID]; ok {
+				continue
+			}
+			workerCtx, stop := context.WithCancel(ctx)
+			wake := make(chan struct{}, 1)
+			stops[ep.ID], d.workers[ep.ID] = stop, wake
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				d.work(workerCtx, id, wake)
+			}(ep.ID)
+		}
+		for id, stop := range stops {
+			if !running[id] {
+				stop()
+				delete(stops, id)
+				delete(d.workers, id)
+			}
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		}
+	}
+}
+
+//work delivers the queued events for the endpoint with the given ID one at a time in the order they're due, until
+//ctx is cancelled.
+func (d *Dispatcher) work(ctx context.Context, endpointID int, wake <-chan struct{}) {
+	for {
+		next, wait, ok := d.due(endpointID)
+		if ok {
+			d.deliver(ctx, next)
+			continue
+		}
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+//due returns the endpoint's pending delivery that's due first if it's due now, otherwise how long until it is.
+//The wait is zero when nothing is pending.
+func (d *Dispatcher) due(endpointID int) (Delivery, time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var first *Delivery
+	for i := range d.pending {
+		p := &d.pending[i]
+		if p.EndpointID == endpointID && (first == nil || p.NextAttempt.Before(first.NextAttempt)) {
+			first = p
+		}
+	}
+	if first == nil {
+		return Delivery{}, 0, false
+	}
+	if wait := first.NextAttempt.Sub(d.now()); wait > 0 {
+		return Delivery{}, wait, false
+	}
+	return *first, 0, true
+}
+
+//deliver makes one attempt at sending dl and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, dl Delivery) {
+	d.mu.Lock()
+	ep, ok := d.find(dl.EndpointID)
+	d.mu.Unlock()
+	if !ok {
+		//the endpoint was removed after the event was queued.
+		d.finish(dl.ID, func(p *Delivery) { p.Status, p.LastError = StatusFailed, EndpointNotFound })
+		return
+	}
+
+	err := d.send(ctx, ep, dl)
+	if ctx.Err() != nil {
+		//shutting down, the delivery stays queued for the next run.
+		return
+	}
+	d.finish(dl.ID, func(p *Delivery) {
+		p.Attempts++
+		switch {
+		case err == nil:
+			p.Status, p.LastError = StatusDelivered, ""
+		case p.Attempts >= d.MaxAttempts:
+			p.Status, p.LastError = StatusFailed, err.Error()
+		default:
+			p.LastError = err.Error()
+			p.NextAttempt = d.now().UTC().Add(d.backoff(p.Attempts))
+		}
+	})
+}
+
+//send posts the delivery's event to the endpoint, any response other than 2xx is an error.
+func (d *Dispatcher) send(ctx context.Context, ep Endpoint, dl Delivery) error {
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.Event.Type)
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set(DeliveryHeader, dl.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(ep.Secret, timestamp, dl.ID, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %v", resp.Status)
+	}
+	return nil
+}
+
+//backoff returns the wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.BaseDelay
+	for i := 1; i < attempts && wait < d.MaxDelay; i++ {
+		wait *= 2
+	}
+	if wait > d.MaxDelay {
+		wait = d.MaxDelay
+	}
+	return wait
+}
+
+//finish updates the pending delivery with the given ID, moving it to the recent deliveries once it's no longer pending.
+func (d *Dispatcher) finish(id string, update func(*Delivery)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.pending {
+		if d.pending[i].ID != id {
+			continue
+		}
+		p := &d.pending[i]
+		update(p)
+		p.UpdatedAt = d.now().UTC()
+		if p.Status != StatusPending {
+			p.NextAttempt = time.Time{}
+			d.recent = append(d.recent, *p)
+			if len(d.recent) > recentLimit {
+				d.recent = d.recent[len(d.recent)-recentLimit:]
+			}
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+		}
+		if err := d.saveQueue(); err != nil {
+			d.logf("webhook: could not save the delivery queue after delivery %v: %v", id, err)
+		}
+		return
+	}
+}
+
+//Endpoints returns the registered endpoints, without their secrets.
+func (d *Dispatcher) Endpoints() []Endpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]Endpoint, 0, len(d.endpoints))
+	for _, ep := range d.endpoints {
+		ep.Secret = ""
+		list = append(list, ep)
+	}
+	return list
+}
+
+//Endpoint returns the endpoint with the given ID, without its secret.
+func (d *Dispatcher) Endpoint(id int) (Endpoint, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ep, ok := d.find(id)
+	if !ok {
+		return Endpoint{}, errors.New(EndpointNotFound)
+	}
+	ep.Secret = ""
+	return ep, nil
+}
+
+//AddEndpoint registers ep, assigning its ID and, if it doesn't have one, a random secret.
+func (d *Dispatcher) AddEndpoint(ep *Endpoint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ep.ID = 1
+	for _, existing := range d.endpoints {
+		if existing.ID >= ep.ID {
+			ep.ID = existing.ID + 1
+		}
+	}
+	if ep.Secret == "" {
+		ep.Secret = newSecret()
+	}
+	ep.CreatedAt = d.now().UTC()
+	d.endpoints = append(d.endpoints, *ep)
+	signal(d.wake)
+	return d.saveEndpoints()
+}
+
+//UpdateEndpoint changes the URL, events and secret of the endpoint with the given ID to those given in changes,
+//empty values leave the current ones.
+func (d *Dispatcher) UpdateEndpoint(changes Endpoint, id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.endpoints {
+		if d.endpoints[i].ID != id {
+			continue
+		}
+		ep := &d.endpoints[i]
+		if changes.URL != "" {
+			ep.URL = changes.URL
+		}
+		if changes.Events != nil {
+			ep.Events = changes.Events
+		}
+		if changes.Secret != "" {
+			ep.Secret = changes.Secret
+		}
+		return d.saveEndpoints()
+	}
+	return errors.New(EndpointNotFound)
+}
+
+//RemoveEndpoint unregisters the endpoint with the given ID, its queued deliveries are dropped.
+func (d *Dispatcher) RemoveEndpoint(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.endpoints {
+		if d.endpoints[i].ID != id {
+			continue
+		}
+		d.endpoints = append(d.endpoints[:i], d.endpoints[i+1:]...)
+		pending := make([]Delivery, 0, len(d.pending))
+		for _, p := range d.pending {
+			if p.EndpointID != id {
+				pending = append(pending, p)
+			}
+		}
+		d.pending = pending
+		signal(d.wake)
+		if err := d.saveEndpoints(); err != nil {
+			return err
+		}
+		return d.saveQueue()
+	}
+	return errors.New(EndpointNotFound)
+}
+
+//Deliveries returns the pending and recently finished deliveries to the endpoint with the given ID, newest first.
+func (d *Dispatcher) Deliveries(id int) ([]Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.find(id); !ok {
+		return nil, errors.New(EndpointNotFound)
+	}
+	list := make([]Delivery, 0)
+	for _, dl := range append(append([]Delivery(nil), d.recent...), d.pending...) {
+		if dl.EndpointID == id {
+			list = append(list, dl)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Event.Time.After(list[j].Event.Time) })
+	return list, nil
+}
+
+func (d *Dispatcher) find(id int) (Endpoint, bool) {
+	for _, ep := range d.endpoints {
+		if ep.ID == id {
+			return ep, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+//signal wakes whoever waits on wake without blocking, a nil channel is ignored.
+func signal(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) saveEndpoints() error {
+	return d.writeState(filepath.Join(d.Dir, endpointsFile), endpointState{Endpoints: d.endpoints})
+}
+
+func (d *Dispatcher) saveQueue() error {
+	return d.writeState(filepath.Join(d.Dir, queueFile), queueState{Pending: d.pending, Recent: d.recent})
+}
+
+func (d *Dispatcher) logf(format string, v ...interface{}) {
+	if d.ErrorLog != nil {
+		d.ErrorLog.Printf(format, v...)
+	}
+}
+
+//readState loads a JSON state file into v, opening it with Keys if it's sealed. A missing file leaves v unchanged.
+func (d *Dispatcher) readState(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if encryption.IsSealed(data) {
+		if d.Keys == nil {
+			return fmt.Errorf("webhook: %v is encrypted, but no keys were given", filepath.Base(path))
+		}
+		if data, err = d.Keys.Open(data); err != nil {
+			return fmt.Errorf("webhook: %v: %w", filepath.Base(path), err)
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+//writeState saves v as JSON, sealed with Keys if they're set, writing to a temporary file first so a crash can't
+//leave a partial file behind.
+func (d *Dispatcher) writeState(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if d.Keys != nil {
+		if data, err = d.Keys.Seal(data); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,114 @@
+//Package webhook delivers user events to registered HTTP endpoints. Each payload is signed with the endpoint's
+//secret, failed deliveries are retried with exponential backoff, and undelivered events are kept on disk so
+//they're still sent after a restart.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nmalensek/go-user-form/events"
+	"github.com/nmalensek/go-user-form/validation"
+)
+
+//Webhook error message constants.
+const (
+	EndpointNotFound = "Could not find the specified webhook."
+)
+
+//Headers sent with every delivery.
+const (
+	//SignatureHeader holds "sha256=" and the hex HMAC-SHA256, keyed with the endpoint's secret, of the
+	//TimestampHeader, the DeliveryHeader and the request body joined with dots.
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	//DeliveryHeader holds the delivery's ID, it's the same for every attempt at the delivery.
+	DeliveryHeader = "X-Webhook-Delivery"
+	//TimestampHeader holds the Unix time the attempt was sent at.
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+//Endpoint is a URL that's sent the events it subscribes to, all events if Events is empty.
+//Secret is only returned when the endpoint is created.
+type Endpoint struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//Wants reports whether the endpoint subscribes to the event type.
+func (ep Endpoint) Wants(eventType string) bool {
+	if len(ep.Events) == 0 {
+		return true
+	}
+	for _, t := range ep.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+//Validate normalizes the endpoint and checks that its URL is an absolute http or https URL and that it only
+//subscribes to known events. If partial is set, an empty URL is allowed so only the given fields are changed.
+func (ep *Endpoint) Validate(partial bool) []validation.UserError {
+	errs := make([]validation.UserError, 0)
+	ep.URL = strings.TrimSpace(ep.URL)
+	switch u, err := url.Parse(ep.URL); {
+	case ep.URL == "" && partial:
+	case ep.URL == "":
+		errs = append(errs, validation.NewError("URL", "", "URL", validation.Problem{Code: validation.CodeRequired}))
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		errs = append(errs, validation.FormatError("URL", ep.URL))
+	}
+	for _, t := range ep.Events {
+		known := false
+		for _, k := range events.Types {
+			known = known || t == k
+		}
+		if !known {
+			errs = append(errs, validation.NewError("Events", t, "Events", validation.Problem{Code: validation.CodeNotAllowed}))
+		}
+	}
+	return errs
+}
+
+//Sign returns the value of the SignatureHeader for body sent to an endpoint with the given secret, as the delivery
+//with the given ID at the given Unix timestamp.
+func Sign(secret, timestamp, deliveryID string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + deliveryID + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//Verify reports whether header signs body for secret and was sent no more than maxAge ago, receivers can use it
+//to check that a delivery came from this server. To refuse replays within maxAge, receivers should also remember
+//the DeliveryHeader values they've accepted for that long.
+func Verify(secret string, header http.Header, body []byte, maxAge time.Duration) bool {
+	timestamp := header.Get(TimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(sent, 0)); age > maxAge || age < -maxAge {
+		return false
+	}
+	signature := Sign(secret, timestamp, header.Get(DeliveryHeader), body)
+	return hmac.Equal([]byte(signature), []byte(header.Get(SignatureHeader)))
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
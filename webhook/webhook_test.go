@@ -0,0 +1,296 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmalensek/go-user-form/encryption"
+	"github.com/nmalensek/go-user-form/events"
+	"github.com/nmalensek/go-user-form/model"
+)
+
+//receiver is an httptest endpoint that fails the first failures requests and records the rest.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	bodies   [][]byte
+	headers  []http.Header
+	received chan struct{}
+}
+
+func newReceiver(failures int) (*receiver, *httptest.Server) {
+	rc := &receiver{failures: failures, received: make(chan struct{}, 10)}
+	return rc, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		if rc.failures > 0 {
+			rc.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rc.bodies = append(rc.bodies, body)
+		rc.headers = append(rc.headers, r.Header)
+		rc.received <- struct{}{}
+	}))
+}
+
+func (rc *receiver) wait(t *testing.T) {
+	select {
+	case <-rc.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+	}
+}
+
+func openTestDispatcher(t *testing.T, dir string) *Dispatcher {
+	d, err := Open(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.BaseDelay = time.Millisecond
+	d.MaxDelay = 4 * time.Millisecond
+	return d
+}
+
+func testEvent(eventType string) events.Event {
+	return events.Event{ID: events.NewID(), Type: eventType, Time: time.Now().UTC(), User: model.User{ID: 1, FirstName: "Ann"}}
+}
+
+func TestDeliverSignedWithRetries(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(dir)
+	rc, server := newReceiver(2)
+	defer server.Close()
+
+	d := openTestDispatcher(t, dir)
+	ep := Endpoint{URL: server.URL, Events: []string{events.UserCreated}}
+	if err := d.AddEndpoint(&ep); err != nil {
+		t.Fatal(err)
+	}
+	if ep.Secret == "" {
+		t.Fatal("a secret should be generated for the endpoint")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Publish(ctx, testEvent(events.UserUpdated))
+	d.Publish(ctx, testEvent(events.UserCreated))
+	rc.wait(t)
+
+	rc.mu.Lock()
+	body, header := rc.bodies[0], rc.headers[0]
+	rc.mu.Unlock()
+	if !Verify(ep.Secret, header, body, time.Minute) {
+		t.Errorf("signature %q doesn't match the body", header.Get(SignatureHeader))
+	}
+	if header.Get(EventHeader) != events.UserCreated {
+		t.Errorf("got event header %q want %q", header.Get(EventHeader), events.UserCreated)
+	}
+
+	if dl := waitForStatus(t, d, ep.ID, StatusDelivered); dl.Attempts != 3 {
+		t.Errorf("got %+v want it delivered on the third attempt", dl)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("got directory %v, %v want it only readable by its owner", info.Mode(), err)
+	}
+}
+
+func TestVerifyRefusesReplays(t *testing.T) {
+	body := []byte(`{"type":"user.created"}`)
+	signed := func(sent time.Time, id string) http.Header {
+		h := http.Header{}
+		timestamp := strconv.FormatInt(sent.Unix(), 10)
+		h.Set(TimestampHeader, timestamp)
+		h.Set(DeliveryHeader, id)
+		h.Set(SignatureHeader, Sign("secret", timestamp, id, body))
+		return h
+	}
+
+	if !Verify("secret", signed(time.Now(), "d1"), body, time.Minute) {
+		t.Error("a fresh delivery should verify")
+	}
+	if Verify("secret", signed(time.Now().Add(-time.Hour), "d1"), body, time.Minute) {
+		t.Error("a delivery sent an hour ago should be refused")
+	}
+	moved := signed(time.Now(), "d1")
+	moved.Set(TimestampHeader, strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10))
+	if Verify("secret", moved, body, time.Minute) {
+		t.Error("a delivery with a changed timestamp should be refused")
+	}
+	renamed := signed(time.Now(), "d1")
+	renamed.Set(DeliveryHeader, "d2")
+	if Verify("secret", renamed, body, time.Minute) {
+		t.Error("a delivery with a changed ID should be refused")
+	}
+	if Verify("other", signed(time.Now(), "d1"), body, time.Minute) {
+		t.Error("a delivery signed with another secret should be refused")
+	}
+}
+
+func TestSlowEndpointDoesNotBlockOthers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(dir)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	rc, fast := newReceiver(0)
+	defer fast.Close()
+
+	d := openTestDispatcher(t, dir)
+	d.AddEndpoint(&Endpoint{URL: slow.URL})
+	d.AddEndpoint(&Endpoint{URL: fast.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Publish(ctx, testEvent(events.UserCreated))
+	d.Publish(ctx, testEvent(events.UserUpdated))
+	rc.wait(t)
+	rc.wait(t)
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(dir)
+	rc, server := newReceiver(0)
+	defer server.Close()
+
+	//the event is queued while nothing is running, as if the server stopped before delivering it.
+	d := openTestDispatcher(t, dir)
+	ep := Endpoint{URL: server.URL}
+	d.AddEndpoint(&ep)
+	d.Publish(context.Background(), testEvent(events.UserDeleted))
+
+	restarted := openTestDispatcher(t, dir)
+	if got := restarted.Endpoints(); len(got) != 1 || got[0].URL != server.URL || got[0].Secret != "" {
+		t.Errorf("got endpoints %+v want the saved endpoint without its secret", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go restarted.Run(ctx)
+	rc.wait(t)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !Verify(ep.Secret, rc.headers[0], rc.bodies[0], time.Minute) {
+		t.Errorf("the restarted dispatcher should sign with the saved secret")
+	}
+}
+
+func TestStateSealed(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(dir)
+	//a directory others can read is closed off when it's opened.
+	os.Chmod(dir, 0755)
+	key, _ := encryption.GenerateKey()
+	keys, _ := encryption.NewKeyring("k", map[string][]byte{"k": key})
+
+	//state saved before the keys were set is sealed as soon as they are.
+	plain := openTestDispatcher(t, dir)
+	plain.AddEndpoint(&Endpoint{URL: "https://example.com/hook"})
+	d, err := Open(dir, keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("got directory %v, %v want it only usable by its owner", info.Mode(), err)
+	}
+	d.Publish(context.Background(), testEvent(events.UserCreated))
+
+	for _, name := range []string{endpointsFile, queueFile} {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		if !encryption.IsSealed(data) || strings.Contains(string(data), "Ann") || strings.Contains(string(data), "example.com") {
+			t.Errorf("%v should be sealed, got %s", name, data)
+		}
+	}
+
+	reopened, err := Open(dir, keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reopened.Deliveries(reopened.Endpoints()[0].ID); len(got) != 1 || got[0].Event.User.FirstName != "Ann" {
+		t.Errorf("got deliveries %+v want the queued event", got)
+	}
+	if _, err := Open(dir, nil, nil); err == nil {
+		t.Error("opening sealed state without keys should fail")
+	}
+}
+
+func TestGiveUpAfterMaxAttempts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(dir)
+	_, server := newReceiver(100)
+	defer server.Close()
+
+	d := openTestDispatcher(t, dir)
+	d.MaxAttempts = 3
+	ep := Endpoint{URL: server.URL}
+	d.AddEndpoint(&ep)
+	d.Publish(context.Background(), testEvent(events.UserCreated))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	if dl := waitForStatus(t, d, ep.ID, StatusFailed); dl.Attempts != 3 || dl.LastError == "" {
+		t.Errorf("got %+v want 3 attempts and the last error", dl)
+	}
+}
+
+//waitForStatus waits for the endpoint's only delivery to reach status and returns it.
+func waitForStatus(t *testing.T, d *Dispatcher, endpointID int, status string) Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, _ := d.Deliveries(endpointID)
+		if len(deliveries) == 1 && deliveries[0].Status == status {
+			return deliveries[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("the delivery never became %v", status)
+	return Delivery{}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := d.backoff(i + 1); got != w {
+			t.Errorf("backoff(%v) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		ep      Endpoint
+		partial bool
+		errs    int
+	}{
+		{Endpoint{URL: " https://payroll.example.com/hook "}, false, 0},
+		{Endpoint{URL: "ftp://payroll.example.com"}, false, 1},
+		{Endpoint{}, false, 1},
+		{Endpoint{}, true, 0},
+		{Endpoint{URL: "http://x.example", Events: []string{"user.created", "user.moved"}}, false, 1},
+	}
+	for _, tt := range tests {
+		if errs := tt.ep.Validate(tt.partial); len(errs) != tt.errs {
+			t.Errorf("Validate(%+v) = %v, want %v errors", tt.ep, errs, tt.errs)
+		}
+	}
+}